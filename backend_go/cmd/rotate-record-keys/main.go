@@ -0,0 +1,49 @@
+// Command rotate-record-keys re-wraps the data keys of medical records with
+// the current master key.
+//
+// To rotate, set RECORD_ENCRYPTION_KEY to the new key, move the old one to
+// RECORD_ENCRYPTION_PREVIOUS_KEYS, deploy, and run this command. Once it
+// reports no remaining keys, the old key can be removed from
+// RECORD_ENCRYPTION_PREVIOUS_KEYS. Stored objects are not re-encrypted.
+package main
+
+import (
+	"context"
+	"log"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/database"
+	"healthcare_backend/pkg/encryption"
+)
+
+func main() {
+	cfg := config.Load()
+
+	master, err := encryption.ParseMasterKeys(cfg.RecordEncryptionKey, cfg.RecordEncryptionPreviousKeys)
+	if err != nil {
+		log.Fatalf("Record encryption is misconfigured: %v", err)
+	}
+	if master == nil {
+		log.Fatal("RECORD_ENCRYPTION_KEY is not set")
+	}
+
+	db, err := database.Initialize(cfg)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	rewrapped, err := encryption.NewKeyring(db, master).Rewrap(ctx)
+	if err != nil {
+		log.Fatalf("Rotation stopped after re-wrapping %d data keys: %v", rewrapped, err)
+	}
+
+	var remaining int
+	if err := db.QueryRow(ctx,
+		"SELECT COUNT(*) FROM record_data_keys WHERE master_key_id <> $1",
+		master.CurrentID()).Scan(&remaining); err != nil {
+		log.Fatalf("Failed to count remaining data keys: %v", err)
+	}
+	log.Printf("Re-wrapped %d data keys with master key %q; %d keys remain on other master keys", rewrapped, master.CurrentID(), remaining)
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 
 	"healthcare_backend/pkg/config"
 	"healthcare_backend/pkg/database"
 	"healthcare_backend/pkg/routes"
+	medicalrecords "healthcare_backend/pkg/services/medical-records"
+	"healthcare_backend/pkg/storage"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,9 +23,17 @@ func main() {
 	}
 	defer db.Close()
 
+	// One store for the whole process, so record data keys are cached once.
+	store := storage.New(cfg, db)
+
+	go medicalrecords.NewPreviewService(db, cfg, store).Run(context.Background())
+	go medicalrecords.NewDocumentSearchService(db, cfg, store).Run(context.Background())
+	go medicalrecords.NewIngestionService(db, cfg, store).Run(context.Background())
+	go medicalrecords.NewImportService(db, cfg, store).Run(context.Background())
+
 	router := gin.Default()
 
-	routes.SetupRoutes(router, db, cfg)
+	routes.SetupRoutes(router, db, cfg, store)
 
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -17,12 +19,41 @@ type Config struct {
 	DatabaseAutoMigrate bool
 
 	ServerPort string
+	// AllowedOrigins are the browser origins allowed to call the API and to
+	// open WebSocket connections.
+	AllowedOrigins []string
 
 	S3BucketName string
 	AWSRegion    string
 	AWSAccessKey string
 	AWSSecretKey string
 
+	StorageBackend  string
+	LocalStorageDir string
+
+	// RecordEncryptionKey enables encryption of record blobs when set. See
+	// encryption.ParseMasterKeys for the format. The RAG ingestion service
+	// cannot read encrypted blobs from the bucket, so it is then sent links
+	// under IngestionDocumentBaseURL to download decrypted documents from.
+	RecordEncryptionKey          string
+	RecordEncryptionPreviousKeys string
+
+	UploadMaxMBPatient      int
+	UploadMaxMBDoctor       int
+	UploadMaxMBReceptionist int
+	UploadScanner           string
+	UploadSignatureFile     string
+	ImportMaxMB             int
+
+	StorageQuotaMBPatient      int
+	StorageQuotaMBDoctor       int
+	StorageQuotaMBReceptionist int
+
+	PreviewPollSeconds      int
+	SearchIndexPollSeconds  int
+	RAGIngestionPollSeconds int
+	ImportPollSeconds       int
+
 	SMTPEmail    string
 	SMTPPassword string
 	SMTPHost     string
@@ -33,6 +64,9 @@ type Config struct {
 	AppEnv string
 
 	PythonAPIBaseURL string
+	// IngestionDocumentBaseURL is where the RAG ingestion service reaches
+	// this API to download encrypted documents.
+	IngestionDocumentBaseURL string
 }
 
 func Load() *Config {
@@ -50,12 +84,39 @@ func Load() *Config {
 		DatabaseAutoMigrate: getEnvBool("DATABASE_AUTO_MIGRATE", false),
 
 		ServerPort: getEnv("SERVER_PORT", ""),
+		AllowedOrigins: getEnvList("ALLOWED_ORIGINS", []string{
+			"http://localhost:3000",
+			"http://10.188.27.252:3000",
+			"http://192.168.1.239:3000",
+		}),
 
 		S3BucketName: getEnv("S3_BUCKET_NAME", ""),
 		AWSRegion:    getEnv("AWS_REGION", ""),
 		AWSAccessKey: getEnv("AWS_ACCESS_KEY", ""),
 		AWSSecretKey: getEnv("AWS_SECRET_KEY", ""),
 
+		StorageBackend:  getEnv("STORAGE_BACKEND", ""),
+		LocalStorageDir: getEnv("LOCAL_STORAGE_DIR", "storage"),
+
+		RecordEncryptionKey:          getEnv("RECORD_ENCRYPTION_KEY", ""),
+		RecordEncryptionPreviousKeys: getEnv("RECORD_ENCRYPTION_PREVIOUS_KEYS", ""),
+
+		UploadMaxMBPatient:      getEnvInt("UPLOAD_MAX_MB_PATIENT", 50),
+		UploadMaxMBDoctor:       getEnvInt("UPLOAD_MAX_MB_DOCTOR", 500),
+		UploadMaxMBReceptionist: getEnvInt("UPLOAD_MAX_MB_RECEPTIONIST", 100),
+		UploadScanner:           getEnv("UPLOAD_SCANNER", "signature"),
+		UploadSignatureFile:     getEnv("UPLOAD_SIGNATURE_FILE", ""),
+		ImportMaxMB:             getEnvInt("IMPORT_MAX_MB", 4096),
+
+		StorageQuotaMBPatient:      getEnvInt("STORAGE_QUOTA_MB_PATIENT", 2048),
+		StorageQuotaMBDoctor:       getEnvInt("STORAGE_QUOTA_MB_DOCTOR", 51200),
+		StorageQuotaMBReceptionist: getEnvInt("STORAGE_QUOTA_MB_RECEPTIONIST", 5120),
+
+		PreviewPollSeconds:      getEnvInt("PREVIEW_POLL_SECONDS", 10),
+		SearchIndexPollSeconds:  getEnvInt("SEARCH_INDEX_POLL_SECONDS", 10),
+		RAGIngestionPollSeconds: getEnvInt("RAG_INGESTION_POLL_SECONDS", 15),
+		ImportPollSeconds:       getEnvInt("IMPORT_POLL_SECONDS", 5),
+
 		SMTPEmail:    getEnv("SMTP_EMAIL", ""),
 		SMTPPassword: getEnv("SMTP_EMAIL_PASSWORD", ""),
 		SMTPHost:     getEnv("SMTP_HOST", ""),
@@ -65,7 +126,8 @@ func Load() *Config {
 
 		AppEnv: getEnv("APP_ENV", ""),
 
-		PythonAPIBaseURL: getEnv("PYTHON_API_BASE_URL", "http://localhost:8000"),
+		PythonAPIBaseURL:         getEnv("PYTHON_API_BASE_URL", "http://localhost:8000"),
+		IngestionDocumentBaseURL: getEnv("INGESTION_DOCUMENT_BASE_URL", "http://localhost:8080"),
 	}
 }
 
@@ -76,6 +138,18 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvInt(key string, fallback int) int {
+	if value := os.Getenv(key); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Invalid integer for %s: %q, using %d", key, value, fallback)
+			return fallback
+		}
+		return parsed
+	}
+	return fallback
+}
+
 func getEnvBool(key string, fallback bool) bool {
 	if value := os.Getenv(key); value != "" {
 		switch value {
@@ -89,3 +163,17 @@ func getEnvBool(key string, fallback bool) bool {
 	}
 	return fallback
 }
+
+func getEnvList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
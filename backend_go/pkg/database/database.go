@@ -386,6 +386,258 @@ func createTables(conn *pgxpool.Pool) error {
 		)`,
 
 		`CREATE INDEX IF NOT EXISTS idx_folder_file_info_patient_id ON folder_file_info(patient_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_folder_file_info_user_id ON folder_file_info(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_folder_file_info_parent_id ON folder_file_info(parent_id)`,
+
+		`ALTER TABLE folder_file_info ALTER COLUMN size TYPE BIGINT`,
+
+		`CREATE TABLE IF NOT EXISTS chunked_uploads (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			user_type VARCHAR(50) NOT NULL,
+			file_name VARCHAR(255) NOT NULL,
+			file_type VARCHAR(50) NOT NULL,
+			extension VARCHAR(50),
+			content_type VARCHAR(255) NOT NULL,
+			total_size BIGINT NOT NULL CHECK (total_size > 0),
+			chunk_size BIGINT NOT NULL CHECK (chunk_size > 0),
+			total_chunks INTEGER NOT NULL CHECK (total_chunks > 0),
+			storage_key TEXT NOT NULL,
+			storage_upload_id TEXT NOT NULL,
+			parent_id UUID,
+			folder_type VARCHAR(20) NOT NULL DEFAULT 'PERSONAL' CHECK (folder_type IN ('PERSONAL', 'CLINICAL')),
+			category VARCHAR(50),
+			body_part VARCHAR(50),
+			study_date TIMESTAMP WITH TIME ZONE,
+			patient_id UUID,
+			status VARCHAR(20) NOT NULL DEFAULT 'in_progress' CHECK (status IN ('in_progress', 'completed', 'aborted')),
+			file_id UUID,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_chunked_uploads_user ON chunked_uploads(user_id, status)`,
+
+		`ALTER TABLE chunked_uploads DROP CONSTRAINT IF EXISTS chunked_uploads_parent_id_fkey`,
+
+		`CREATE TABLE IF NOT EXISTS record_imports (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			user_type VARCHAR(50) NOT NULL,
+			parent_id UUID REFERENCES folder_file_info(id) ON DELETE CASCADE,
+			archive_name VARCHAR(255) NOT NULL,
+			archive_sha256 CHAR(64) NOT NULL,
+			total_entries INTEGER NOT NULL DEFAULT 0,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'interrupted')),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			completed_at TIMESTAMP WITH TIME ZONE
+		)`,
+
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_record_imports_archive
+			ON record_imports(user_id, archive_sha256, COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'::uuid))`,
+
+		`ALTER TABLE record_imports ADD COLUMN IF NOT EXISTS archive_key TEXT`,
+
+		`ALTER TABLE record_imports ALTER COLUMN status SET DEFAULT 'pending'`,
+
+		`ALTER TABLE record_imports DROP CONSTRAINT IF EXISTS record_imports_status_check`,
+
+		`ALTER TABLE record_imports ADD CONSTRAINT record_imports_status_check CHECK (status IN ('pending', 'running', 'completed', 'interrupted'))`,
+
+		`CREATE INDEX IF NOT EXISTS idx_record_imports_pending ON record_imports(updated_at) WHERE status IN ('pending', 'running')`,
+
+		`CREATE TABLE IF NOT EXISTS record_import_entries (
+			import_id UUID NOT NULL REFERENCES record_imports(id) ON DELETE CASCADE,
+			entry_path TEXT NOT NULL,
+			kind VARCHAR(10) NOT NULL CHECK (kind IN ('folder', 'file')),
+			status VARCHAR(20) NOT NULL CHECK (status IN ('imported', 'failed', 'skipped')),
+			item_id UUID,
+			stored_name VARCHAR(255),
+			category VARCHAR(50),
+			error TEXT,
+			attempts INTEGER NOT NULL DEFAULT 1,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (import_id, entry_path)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS clinical_upload_templates (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			doctor_id UUID NOT NULL REFERENCES doctor_info(doctor_id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			category VARCHAR(50) NOT NULL CHECK (category IN ('LAB_RESULTS', 'IMAGING_CT', 'IMAGING_XRAY', 'IMAGING_US', 'IMAGING_MAMMO', 'IMAGING_MRI', 'IMAGING_PET', 'CLINICAL_REPORT', 'DISCHARGE', 'OTHER')),
+			folder_name VARCHAR(100),
+			body_part VARCHAR(50),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			UNIQUE (doctor_id, name)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS chunked_upload_parts (
+			upload_id UUID NOT NULL REFERENCES chunked_uploads(id) ON DELETE CASCADE,
+			chunk_number INTEGER NOT NULL CHECK (chunk_number > 0),
+			size BIGINT NOT NULL,
+			sha256 CHAR(64) NOT NULL,
+			etag TEXT NOT NULL,
+			received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (upload_id, chunk_number)
+		)`,
+
+		`ALTER TABLE folder_file_info ALTER COLUMN path TYPE TEXT`,
+
+		`CREATE TABLE IF NOT EXISTS dicom_studies (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			user_id UUID NOT NULL,
+			patient_id UUID REFERENCES patient_info(patient_id),
+			folder_id UUID NOT NULL REFERENCES folder_file_info(id) ON DELETE CASCADE,
+			study_instance_uid VARCHAR(64) NOT NULL,
+			modality VARCHAR(16),
+			category VARCHAR(50),
+			body_part VARCHAR(50),
+			study_date TIMESTAMP WITH TIME ZONE,
+			description TEXT,
+			referring_physician VARCHAR(255),
+			accession_number VARCHAR(64),
+			dicom_patient_id VARCHAR(64),
+			dicom_patient_name VARCHAR(255),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			UNIQUE (user_id, study_instance_uid)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_dicom_studies_patient ON dicom_studies(patient_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_dicom_studies_folder ON dicom_studies(folder_id)`,
+
+		`CREATE TABLE IF NOT EXISTS dicom_series (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			study_id UUID NOT NULL REFERENCES dicom_studies(id) ON DELETE CASCADE,
+			folder_id UUID NOT NULL REFERENCES folder_file_info(id) ON DELETE CASCADE,
+			series_instance_uid VARCHAR(64) NOT NULL,
+			modality VARCHAR(16),
+			series_number INTEGER,
+			description TEXT,
+			body_part VARCHAR(50),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			UNIQUE (study_id, series_instance_uid)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS dicom_instances (
+			file_id UUID PRIMARY KEY REFERENCES folder_file_info(id) ON DELETE CASCADE,
+			series_id UUID NOT NULL REFERENCES dicom_series(id) ON DELETE CASCADE,
+			sop_instance_uid VARCHAR(64) NOT NULL,
+			instance_number INTEGER,
+			UNIQUE (series_id, sop_instance_uid)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS file_previews (
+			file_id UUID PRIMARY KEY REFERENCES folder_file_info(id) ON DELETE CASCADE,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'ready', 'failed', 'unsupported')),
+			width INTEGER,
+			height INTEGER,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_file_previews_pending ON file_previews(next_attempt_at) WHERE status IN ('pending', 'processing')`,
+
+		`CREATE TABLE IF NOT EXISTS document_text_index (
+			file_id UUID PRIMARY KEY REFERENCES folder_file_info(id) ON DELETE CASCADE,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'indexed', 'failed', 'unsupported')),
+			language REGCONFIG NOT NULL DEFAULT 'simple',
+			content TEXT,
+			search_vector TSVECTOR,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_document_text_index_pending ON document_text_index(next_attempt_at) WHERE status IN ('pending', 'processing')`,
+
+		`CREATE INDEX IF NOT EXISTS idx_document_text_index_search ON document_text_index USING GIN(search_vector)`,
+
+		`CREATE TABLE IF NOT EXISTS rag_ingestion_jobs (
+			file_id UUID PRIMARY KEY REFERENCES folder_file_info(id) ON DELETE CASCADE,
+			patient_id VARCHAR(255) NOT NULL,
+			doctor_id VARCHAR(255) NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'succeeded', 'failed', 'dead_letter')),
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			succeeded_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_rag_ingestion_jobs_due ON rag_ingestion_jobs(next_attempt_at) WHERE status IN ('pending', 'processing', 'failed')`,
+
+		`CREATE INDEX IF NOT EXISTS idx_rag_ingestion_jobs_patient ON rag_ingestion_jobs(patient_id, doctor_id)`,
+
+		`CREATE TABLE IF NOT EXISTS ingestion_document_links (
+			token_hash CHAR(64) PRIMARY KEY,
+			storage_key TEXT NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_ingestion_document_links_expires_at ON ingestion_document_links(expires_at)`,
+
+		`ALTER TABLE folder_file_info ADD COLUMN IF NOT EXISTS ai_consent BOOLEAN NOT NULL DEFAULT TRUE`,
+
+		`ALTER TABLE rag_ingestion_jobs ADD COLUMN IF NOT EXISTS operation VARCHAR(10) NOT NULL DEFAULT 'ingest' CHECK (operation IN ('ingest', 'remove'))`,
+
+		`ALTER TABLE rag_ingestion_jobs DROP CONSTRAINT IF EXISTS rag_ingestion_jobs_status_check`,
+
+		`ALTER TABLE rag_ingestion_jobs ADD CONSTRAINT rag_ingestion_jobs_status_check CHECK (status IN ('pending', 'processing', 'succeeded', 'failed', 'dead_letter', 'withdrawn'))`,
+
+		`CREATE TABLE IF NOT EXISTS patient_ai_consent (
+			patient_id UUID PRIMARY KEY REFERENCES patient_info(patient_id) ON DELETE CASCADE,
+			rag_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS ai_consent_audit (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			patient_id UUID NOT NULL,
+			file_id UUID,
+			file_name VARCHAR(255),
+			old_value BOOLEAN NOT NULL,
+			new_value BOOLEAN NOT NULL,
+			changed_by_id UUID NOT NULL,
+			changed_by_type VARCHAR(20) NOT NULL,
+			ip_address VARCHAR(64),
+			user_agent TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_ai_consent_audit_patient ON ai_consent_audit(patient_id, created_at DESC)`,
+
+		`CREATE OR REPLACE FUNCTION prevent_ai_consent_audit_mutation() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'ai_consent_audit is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+
+		`DROP TRIGGER IF EXISTS ai_consent_audit_append_only ON ai_consent_audit`,
+
+		`CREATE TRIGGER ai_consent_audit_append_only
+			BEFORE UPDATE OR DELETE ON ai_consent_audit
+			FOR EACH ROW EXECUTE FUNCTION prevent_ai_consent_audit_mutation()`,
+
+		`CREATE TABLE IF NOT EXISTS record_data_keys (
+			owner_id UUID PRIMARY KEY,
+			wrapped_key BYTEA NOT NULL,
+			master_key_id VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			rotated_at TIMESTAMP WITH TIME ZONE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_record_data_keys_master_key ON record_data_keys(master_key_id)`,
 
 		`CREATE TABLE IF NOT EXISTS shared_items (
 			id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -401,6 +653,12 @@ func createTables(conn *pgxpool.Pool) error {
 			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 		)`,
 
+		`ALTER TABLE chats ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'direct' CHECK (kind IN ('direct', 'group'))`,
+
+		`ALTER TABLE chats ADD COLUMN IF NOT EXISTS name VARCHAR(100)`,
+
+		`ALTER TABLE chats ADD COLUMN IF NOT EXISTS created_by uuid`,
+
 		`CREATE TABLE IF NOT EXISTS participants (
 			id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
 			chat_id uuid NOT NULL REFERENCES chats(id),
@@ -412,6 +670,14 @@ func createTables(conn *pgxpool.Pool) error {
 
 		`CREATE INDEX IF NOT EXISTS idx_participants_chat_id ON participants(chat_id)`,
 
+		`ALTER TABLE participants ADD COLUMN IF NOT EXISTS last_delivered_at TIMESTAMP WITH TIME ZONE`,
+
+		`ALTER TABLE participants ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMP WITH TIME ZONE`,
+
+		`ALTER TABLE participants ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member'))`,
+
+		`ALTER TABLE participants ADD COLUMN IF NOT EXISTS user_type VARCHAR(20)`,
+
 		`CREATE TABLE IF NOT EXISTS messages (
 			id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
 			chat_id uuid NOT NULL REFERENCES chats(id),
@@ -424,6 +690,99 @@ func createTables(conn *pgxpool.Pool) error {
 
 		`CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id)`,
 
+		`DROP INDEX IF EXISTS idx_messages_chat_created`,
+
+		`CREATE INDEX IF NOT EXISTS idx_messages_chat_created_id ON messages(chat_id, created_at, id)`,
+
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP WITH TIME ZONE`,
+
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE`,
+
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS system_event JSONB`,
+
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+			to_tsvector('simple'::regconfig, COALESCE(content, '')) ||
+			to_tsvector('english'::regconfig, COALESCE(content, '')) ||
+			to_tsvector('french'::regconfig, COALESCE(content, '')) ||
+			to_tsvector('arabic'::regconfig, COALESCE(content, ''))
+		) STORED`,
+
+		`CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN(search_vector)`,
+
+		`CREATE TABLE IF NOT EXISTS message_edits (
+			id BIGSERIAL PRIMARY KEY,
+			message_id uuid NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			content TEXT,
+			edited_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_message_edits_message_id ON message_edits(message_id)`,
+
+		`CREATE TABLE IF NOT EXISTS message_hidden (
+			message_id uuid NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			user_id uuid NOT NULL,
+			hidden_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (message_id, user_id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS message_reactions (
+			message_id uuid NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			user_id uuid NOT NULL,
+			emoji VARCHAR(32) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (message_id, user_id, emoji)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS chat_attachments (
+			id uuid PRIMARY KEY,
+			chat_id uuid NOT NULL REFERENCES chats(id),
+			uploader_id uuid NOT NULL,
+			file_name TEXT NOT NULL,
+			content_type VARCHAR(100) NOT NULL,
+			size BIGINT NOT NULL,
+			storage_key TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			deleted_at TIMESTAMP WITH TIME ZONE
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_chat_attachments_chat_id ON chat_attachments(chat_id)`,
+
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS attachment_id uuid REFERENCES chat_attachments(id)`,
+
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_attachment_id ON messages(attachment_id) WHERE attachment_id IS NOT NULL`,
+
+		`CREATE TABLE IF NOT EXISTS chat_attachment_links (
+			token_hash CHAR(64) PRIMARY KEY,
+			attachment_id uuid NOT NULL REFERENCES chat_attachments(id) ON DELETE CASCADE,
+			user_id uuid NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_chat_attachment_links_expires_at ON chat_attachment_links(expires_at)`,
+
+		`CREATE TABLE IF NOT EXISTS realtime_event_payloads (
+			id BIGSERIAL PRIMARY KEY,
+			payload TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS user_presence (
+			user_id uuid PRIMARY KEY,
+			status VARCHAR(20) NOT NULL DEFAULT 'online' CHECK (status IN ('online', 'away')),
+			last_seen_at TIMESTAMP WITH TIME ZONE,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS presence_connections (
+			user_id uuid NOT NULL,
+			instance_id TEXT NOT NULL,
+			heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (user_id, instance_id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_presence_connections_heartbeat ON presence_connections(heartbeat_at)`,
+
 		`CREATE TABLE IF NOT EXISTS verification_tokens (
 			id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
 			email VARCHAR(255) NOT NULL,
@@ -522,6 +881,31 @@ func createTables(conn *pgxpool.Pool) error {
 		`CREATE INDEX IF NOT EXISTS idx_medical_reports_doctor_id ON medical_reports(doctor_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_medical_reports_patient_id ON medical_reports(patient_id)`,
 
+		`CREATE TABLE IF NOT EXISTS lab_observations (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			patient_id UUID NOT NULL REFERENCES patient_info(patient_id) ON DELETE CASCADE,
+			doctor_id UUID NOT NULL REFERENCES doctor_info(doctor_id),
+			report_id UUID REFERENCES medical_reports(report_id) ON DELETE SET NULL,
+			file_id UUID REFERENCES folder_file_info(id) ON DELETE SET NULL,
+			test_code VARCHAR(64) NOT NULL,
+			test_name VARCHAR(255),
+			value NUMERIC,
+			value_text VARCHAR(255),
+			unit VARCHAR(32),
+			reference_low NUMERIC,
+			reference_high NUMERIC,
+			reference_text VARCHAR(255),
+			abnormal_flag VARCHAR(2) CHECK (abnormal_flag IN ('N', 'L', 'H', 'LL', 'HH', 'A')),
+			collected_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			entered_by_id UUID NOT NULL,
+			entered_by_role VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			CHECK (value IS NOT NULL OR value_text IS NOT NULL)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_lab_observations_series ON lab_observations(patient_id, test_code, collected_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_lab_observations_report_id ON lab_observations(report_id) WHERE report_id IS NOT NULL`,
+
 		`CREATE TABLE IF NOT EXISTS public.medications(
 			medication_id uuid NOT NULL DEFAULT gen_random_uuid(),
 			patient_id uuid NOT NULL REFERENCES patient_info(patient_id),
@@ -725,6 +1109,41 @@ func createTables(conn *pgxpool.Pool) error {
 
 		`CREATE INDEX IF NOT EXISTS idx_file_folder_history_item_id ON file_folder_history(item_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_file_folder_history_created_at ON file_folder_history(created_at DESC)`,
+
+		`CREATE TABLE IF NOT EXISTS record_access_log (
+			id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+			item_id UUID NOT NULL,
+			item_name VARCHAR(255) NOT NULL,
+			owner_id UUID NOT NULL,
+			accessed_by_id UUID NOT NULL,
+			accessed_by_type VARCHAR(20) NOT NULL,
+			action VARCHAR(20) NOT NULL CHECK (action IN ('view', 'download')),
+			access_path VARCHAR(50) NOT NULL,
+			ip_address VARCHAR(64),
+			user_agent TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_record_access_log_owner ON record_access_log(owner_id, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_record_access_log_accessed_by ON record_access_log(accessed_by_id, created_at DESC)`,
+
+		`CREATE OR REPLACE FUNCTION prevent_record_access_log_mutation() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'record_access_log is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+
+		`DROP TRIGGER IF EXISTS record_access_log_append_only ON record_access_log`,
+
+		`CREATE TRIGGER record_access_log_append_only
+			BEFORE UPDATE OR DELETE ON record_access_log
+			FOR EACH ROW EXECUTE FUNCTION prevent_record_access_log_mutation()`,
+
+		`DROP TRIGGER IF EXISTS record_access_log_no_truncate ON record_access_log`,
+
+		`CREATE TRIGGER record_access_log_no_truncate
+			BEFORE TRUNCATE ON record_access_log
+			FOR EACH STATEMENT EXECUTE FUNCTION prevent_record_access_log_mutation()`,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -749,3 +1168,9 @@ func createTables(conn *pgxpool.Pool) error {
 
 	return nil
 }
+
+// CreateTables runs the schema migrations on conn. Initialize runs them when
+// DatabaseAutoMigrate is set; integration tests build their schema with it.
+func CreateTables(conn *pgxpool.Pool) error {
+	return createTables(conn)
+}
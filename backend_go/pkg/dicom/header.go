@@ -0,0 +1,129 @@
+package dicom
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header holds the identifying attributes of one DICOM instance. String
+// values are stored as found in the file with padding removed.
+type Header struct {
+	TransferSyntaxUID      string
+	SOPInstanceUID         string
+	StudyInstanceUID       string
+	SeriesInstanceUID      string
+	Modality               string
+	BodyPartExamined       string
+	StudyDate              string
+	StudyDescription       string
+	SeriesDescription      string
+	SeriesNumber           int
+	InstanceNumber         int
+	AccessionNumber        string
+	ReferringPhysicianName string
+	PatientName            string
+	PatientID              string
+	PatientBirthDate       string
+	PatientSex             string
+}
+
+func (h *Header) wants(t tag) bool {
+	switch t {
+	case tagTransferSyntaxUID, tagSOPInstanceUID, tagStudyDate, tagAccessionNumber, tagModality,
+		tagReferringPhysicianName, tagStudyDescription, tagSeriesDescription, tagPatientName,
+		tagPatientID, tagPatientBirthDate, tagPatientSex, tagBodyPartExamined, tagStudyInstanceUID,
+		tagSeriesInstanceUID, tagSeriesNumber, tagInstanceNumber:
+		return true
+	}
+	return false
+}
+
+func (h *Header) set(t tag, value string) {
+	switch t {
+	case tagTransferSyntaxUID:
+		h.TransferSyntaxUID = value
+	case tagSOPInstanceUID:
+		h.SOPInstanceUID = value
+	case tagStudyDate:
+		h.StudyDate = value
+	case tagAccessionNumber:
+		h.AccessionNumber = value
+	case tagModality:
+		h.Modality = strings.ToUpper(value)
+	case tagReferringPhysicianName:
+		h.ReferringPhysicianName = value
+	case tagStudyDescription:
+		h.StudyDescription = value
+	case tagSeriesDescription:
+		h.SeriesDescription = value
+	case tagPatientName:
+		h.PatientName = value
+	case tagPatientID:
+		h.PatientID = value
+	case tagPatientBirthDate:
+		h.PatientBirthDate = value
+	case tagPatientSex:
+		h.PatientSex = value
+	case tagBodyPartExamined:
+		h.BodyPartExamined = strings.ToUpper(value)
+	case tagStudyInstanceUID:
+		h.StudyInstanceUID = value
+	case tagSeriesInstanceUID:
+		h.SeriesInstanceUID = value
+	case tagSeriesNumber:
+		h.SeriesNumber, _ = strconv.Atoi(strings.TrimSpace(value))
+	case tagInstanceNumber:
+		h.InstanceNumber, _ = strconv.Atoi(strings.TrimSpace(value))
+	}
+}
+
+// ParseDate parses a DA value (YYYYMMDD). Older files sometimes use
+// YYYY.MM.DD, which is accepted too.
+func ParseDate(value string) (time.Time, bool) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ".", "")
+	if len(value) != 8 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// PersonName splits a PN value into family and given names. Only the
+// alphabetic representation (before the first "=") is used.
+func PersonName(value string) (family, given string) {
+	if i := strings.Index(value, "="); i >= 0 {
+		value = value[:i]
+	}
+	parts := strings.Split(value, "^")
+	family = strings.TrimSpace(parts[0])
+	if len(parts) > 1 {
+		given = strings.TrimSpace(parts[1])
+	}
+	return family, given
+}
+
+// FormatPersonName renders a PN value the way names are shown elsewhere in
+// the app, e.g. "Smith^John^^Dr" becomes "Dr John Smith".
+func FormatPersonName(value string) string {
+	if i := strings.Index(value, "="); i >= 0 {
+		value = value[:i]
+	}
+	parts := strings.Split(value, "^")
+	for len(parts) < 5 {
+		parts = append(parts, "")
+	}
+	// PN components: family, given, middle, prefix, suffix.
+	ordered := []string{parts[3], parts[1], parts[2], parts[0], parts[4]}
+
+	var words []string
+	for _, part := range ordered {
+		if part = strings.TrimSpace(part); part != "" {
+			words = append(words, part)
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,290 @@
+// Package dicom reads the header of DICOM Part 10 files. It only decodes the
+// handful of attributes medical records need and stops before pixel data, so
+// large studies can be inspected without loading images into memory.
+package dicom
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotDICOM    = errors.New("not a DICOM file")
+	ErrUnsupported = errors.New("unsupported DICOM encoding")
+)
+
+const (
+	preambleSize = 128
+	magic        = "DICM"
+
+	undefinedLength = 0xFFFFFFFF
+
+	// Values of the attributes we keep are short strings; anything larger
+	// than this is a malformed or hostile file.
+	maxValueLength = 64 << 10
+	maxNesting     = 16
+)
+
+const (
+	implicitVRLittleEndian = "1.2.840.10008.1.2"
+	explicitVRBigEndian    = "1.2.840.10008.1.2.2"
+	deflatedExplicitVRLE   = "1.2.840.10008.1.2.1.99"
+)
+
+type tag struct {
+	group, element uint16
+}
+
+var (
+	tagTransferSyntaxUID      = tag{0x0002, 0x0010}
+	tagSOPInstanceUID         = tag{0x0008, 0x0018}
+	tagStudyDate              = tag{0x0008, 0x0020}
+	tagAccessionNumber        = tag{0x0008, 0x0050}
+	tagModality               = tag{0x0008, 0x0060}
+	tagReferringPhysicianName = tag{0x0008, 0x0090}
+	tagStudyDescription       = tag{0x0008, 0x1030}
+	tagSeriesDescription      = tag{0x0008, 0x103E}
+	tagPatientName            = tag{0x0010, 0x0010}
+	tagPatientID              = tag{0x0010, 0x0020}
+	tagPatientBirthDate       = tag{0x0010, 0x0030}
+	tagPatientSex             = tag{0x0010, 0x0040}
+	tagBodyPartExamined       = tag{0x0018, 0x0015}
+	tagStudyInstanceUID       = tag{0x0020, 0x000D}
+	tagSeriesInstanceUID      = tag{0x0020, 0x000E}
+	tagSeriesNumber           = tag{0x0020, 0x0011}
+	tagInstanceNumber         = tag{0x0020, 0x0013}
+	tagPixelData              = tag{0x7FE0, 0x0010}
+
+	tagItem                 = tag{0xFFFE, 0xE000}
+	tagItemDelimitation     = tag{0xFFFE, 0xE00D}
+	tagSequenceDelimitation = tag{0xFFFE, 0xE0DD}
+)
+
+// IsDICOM reports whether data starts with a Part 10 preamble and prefix.
+func IsDICOM(data []byte) bool {
+	return len(data) >= preambleSize+len(magic) && string(data[preambleSize:preambleSize+len(magic)]) == magic
+}
+
+// Parse reads the file meta information and top-level dataset attributes of a
+// DICOM file, stopping at pixel data.
+func Parse(r io.Reader) (*Header, error) {
+	br := bufio.NewReader(r)
+
+	prefix := make([]byte, preambleSize+len(magic))
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, ErrNotDICOM
+	}
+	if !IsDICOM(prefix) {
+		return nil, ErrNotDICOM
+	}
+
+	header := &Header{}
+
+	// File meta information is always explicit VR little endian.
+	meta := &decoder{r: br, order: binary.LittleEndian, explicit: true}
+	for {
+		group, err := br.Peek(2)
+		if err != nil {
+			return nil, fmt.Errorf("%w: truncated file meta information", ErrNotDICOM)
+		}
+		if binary.LittleEndian.Uint16(group) != 0x0002 {
+			break
+		}
+		if _, err := meta.readElement(header, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	dataset := &decoder{r: br, order: binary.LittleEndian, explicit: true}
+	switch header.TransferSyntaxUID {
+	case implicitVRLittleEndian:
+		dataset.explicit = false
+	case explicitVRBigEndian:
+		dataset.order = binary.BigEndian
+	case deflatedExplicitVRLE:
+		dataset.r = bufio.NewReader(flate.NewReader(br))
+	}
+
+	for {
+		done, err := dataset.readElement(header, 0)
+		if err == io.EOF {
+			return header, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return header, nil
+		}
+	}
+}
+
+type decoder struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	explicit bool
+}
+
+// readElement reads one data element. Top-level attributes we care about are
+// stored on header; everything else, including nested sequences, is skipped.
+// It reports done once pixel data is reached.
+func (d *decoder) readElement(header *Header, depth int) (bool, error) {
+	t, err := d.readTag()
+	if err != nil {
+		return false, err
+	}
+	if depth == 0 && (t == tagPixelData || t.group > tagPixelData.group) {
+		return true, nil
+	}
+
+	length, err := d.readLength(t)
+	if err != nil {
+		return false, err
+	}
+
+	if length == undefinedLength {
+		if err := d.skipSequence(depth + 1); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	if depth == 0 && header.wants(t) {
+		if length > maxValueLength {
+			return false, fmt.Errorf("%w: element (%04X,%04X) is %d bytes", ErrUnsupported, t.group, t.element, length)
+		}
+		value := make([]byte, length)
+		if _, err := io.ReadFull(d.r, value); err != nil {
+			return false, unexpectedEOF(err)
+		}
+		header.set(t, trimValue(value))
+		return false, nil
+	}
+
+	if _, err := io.CopyN(io.Discard, d.r, int64(length)); err != nil {
+		return false, unexpectedEOF(err)
+	}
+	return false, nil
+}
+
+func (d *decoder) readTag() (tag, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return tag{}, unexpectedEOF(err)
+		}
+		return tag{}, err
+	}
+	return tag{d.order.Uint16(buf[0:2]), d.order.Uint16(buf[2:4])}, nil
+}
+
+func (d *decoder) readLength(t tag) (uint32, error) {
+	// Item and delimitation tags never carry a VR.
+	if !d.explicit || t.group == 0xFFFE {
+		var buf [4]byte
+		if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		return d.order.Uint32(buf[:]), nil
+	}
+
+	var vr [2]byte
+	if _, err := io.ReadFull(d.r, vr[:]); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+
+	switch string(vr[:]) {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+		var buf [6]byte
+		if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		return d.order.Uint32(buf[2:]), nil
+	default:
+		var buf [2]byte
+		if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		return uint32(d.order.Uint16(buf[:])), nil
+	}
+}
+
+// skipSequence discards the items of an undefined-length sequence up to and
+// including its delimitation item.
+func (d *decoder) skipSequence(depth int) error {
+	if depth > maxNesting {
+		return fmt.Errorf("%w: sequences nested too deeply", ErrUnsupported)
+	}
+
+	for {
+		t, err := d.readTag()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		length, err := d.readLength(t)
+		if err != nil {
+			return err
+		}
+
+		switch t {
+		case tagSequenceDelimitation:
+			return nil
+		case tagItem:
+			if length != undefinedLength {
+				if _, err := io.CopyN(io.Discard, d.r, int64(length)); err != nil {
+					return unexpectedEOF(err)
+				}
+				continue
+			}
+			if err := d.skipItem(depth); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: unexpected element (%04X,%04X) in sequence", ErrUnsupported, t.group, t.element)
+		}
+	}
+}
+
+// skipItem discards the elements of an undefined-length item.
+func (d *decoder) skipItem(depth int) error {
+	for {
+		t, err := d.readTag()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		length, err := d.readLength(t)
+		if err != nil {
+			return err
+		}
+
+		if t == tagItemDelimitation {
+			return nil
+		}
+		if length == undefinedLength {
+			if err := d.skipSequence(depth + 1); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := io.CopyN(io.Discard, d.r, int64(length)); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: file is truncated", ErrNotDICOM)
+	}
+	return err
+}
+
+func trimValue(value []byte) string {
+	return strings.TrimRight(string(bytes.TrimRight(value, "\x00")), " ")
+}
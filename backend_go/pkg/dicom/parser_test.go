@@ -0,0 +1,154 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fileBuilder struct {
+	buf      bytes.Buffer
+	explicit bool
+}
+
+func newFileBuilder(transferSyntax string) *fileBuilder {
+	b := &fileBuilder{explicit: true}
+	b.buf.Write(make([]byte, preambleSize))
+	b.buf.WriteString(magic)
+	b.element(0x0002, 0x0010, "UI", padUID(transferSyntax))
+	b.explicit = transferSyntax != implicitVRLittleEndian
+	return b
+}
+
+func padUID(uid string) string {
+	if len(uid)%2 == 1 {
+		return uid + "\x00"
+	}
+	return uid
+}
+
+func (b *fileBuilder) header(group, element uint16, vr string, length uint32) {
+	binary.Write(&b.buf, binary.LittleEndian, group)
+	binary.Write(&b.buf, binary.LittleEndian, element)
+	if !b.explicit || group == 0xFFFE {
+		binary.Write(&b.buf, binary.LittleEndian, length)
+		return
+	}
+	b.buf.WriteString(vr)
+	switch vr {
+	case "OB", "OW", "SQ", "UN", "UT":
+		b.buf.Write([]byte{0, 0})
+		binary.Write(&b.buf, binary.LittleEndian, length)
+	default:
+		binary.Write(&b.buf, binary.LittleEndian, uint16(length))
+	}
+}
+
+func (b *fileBuilder) element(group, element uint16, vr, value string) *fileBuilder {
+	if len(value)%2 == 1 {
+		value += " "
+	}
+	b.header(group, element, vr, uint32(len(value)))
+	b.buf.WriteString(value)
+	return b
+}
+
+// nestedSequence writes an undefined-length sequence whose item holds an
+// attribute that must not leak into the top-level header.
+func (b *fileBuilder) nestedSequence() *fileBuilder {
+	b.header(0x0008, 0x1110, "SQ", undefinedLength)
+	b.header(0xFFFE, 0xE000, "", undefinedLength)
+	b.element(0x0010, 0x0010, "PN", "Wrong^Patient")
+	b.header(0xFFFE, 0xE00D, "", 0)
+	b.header(0xFFFE, 0xE000, "", 4)
+	b.buf.Write([]byte{1, 2, 3, 4})
+	b.header(0xFFFE, 0xE0DD, "", 0)
+	return b
+}
+
+func (b *fileBuilder) pixelData() []byte {
+	b.header(0x7FE0, 0x0010, "OW", 8)
+	b.buf.Write(make([]byte, 8))
+	return b.buf.Bytes()
+}
+
+func sampleFile(transferSyntax string) []byte {
+	return newFileBuilder(transferSyntax).
+		element(0x0008, 0x0018, "UI", "1.2.3.4.5.6").
+		element(0x0008, 0x0020, "DA", "20240315").
+		element(0x0008, 0x0060, "CS", "CT").
+		element(0x0008, 0x0090, "PN", "House^Gregory^^Dr").
+		nestedSequence().
+		element(0x0008, 0x1030, "LO", "CT CHEST W/O CONTRAST").
+		element(0x0010, 0x0010, "PN", "Doe^Jane").
+		element(0x0010, 0x0020, "LO", "MRN-0042").
+		element(0x0010, 0x0030, "DA", "19800101").
+		element(0x0018, 0x0015, "CS", "chest").
+		element(0x0020, 0x000D, "UI", "1.2.3.4").
+		element(0x0020, 0x000E, "UI", "1.2.3.4.5").
+		element(0x0020, 0x0011, "IS", "3").
+		element(0x0020, 0x0013, "IS", "12").
+		pixelData()
+}
+
+func TestParse_ExplicitVRLittleEndian(t *testing.T) {
+	header, err := Parse(bytes.NewReader(sampleFile("1.2.840.10008.1.2.1")))
+	require.NoError(t, err)
+
+	assert.Equal(t, "1.2.840.10008.1.2.1", header.TransferSyntaxUID)
+	assert.Equal(t, "CT", header.Modality)
+	assert.Equal(t, "CHEST", header.BodyPartExamined)
+	assert.Equal(t, "20240315", header.StudyDate)
+	assert.Equal(t, "CT CHEST W/O CONTRAST", header.StudyDescription)
+	assert.Equal(t, "House^Gregory^^Dr", header.ReferringPhysicianName)
+	assert.Equal(t, "Doe^Jane", header.PatientName, "Names inside sequences must not override the patient")
+	assert.Equal(t, "MRN-0042", header.PatientID)
+	assert.Equal(t, "1.2.3.4", header.StudyInstanceUID)
+	assert.Equal(t, "1.2.3.4.5", header.SeriesInstanceUID)
+	assert.Equal(t, "1.2.3.4.5.6", header.SOPInstanceUID)
+	assert.Equal(t, 3, header.SeriesNumber)
+	assert.Equal(t, 12, header.InstanceNumber)
+}
+
+func TestParse_ImplicitVRLittleEndian(t *testing.T) {
+	header, err := Parse(bytes.NewReader(sampleFile(implicitVRLittleEndian)))
+	require.NoError(t, err)
+
+	assert.Equal(t, "CT", header.Modality)
+	assert.Equal(t, "Doe^Jane", header.PatientName)
+	assert.Equal(t, "1.2.3.4.5", header.SeriesInstanceUID)
+}
+
+func TestParse_RejectsNonDICOM(t *testing.T) {
+	_, err := Parse(bytes.NewReader([]byte("%PDF-1.7 not an image")))
+	assert.ErrorIs(t, err, ErrNotDICOM)
+
+	data := sampleFile("1.2.840.10008.1.2.1")
+	_, err = Parse(bytes.NewReader(data[:200]))
+	assert.ErrorIs(t, err, ErrNotDICOM, "Truncated headers should be rejected")
+}
+
+func TestParseDate(t *testing.T) {
+	date, ok := ParseDate("20240315")
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), date)
+
+	_, ok = ParseDate("2024.03.15")
+	assert.True(t, ok)
+
+	_, ok = ParseDate("")
+	assert.False(t, ok)
+}
+
+func TestPersonName(t *testing.T) {
+	family, given := PersonName("Doe^Jane^Q=ドウ^ジェーン")
+	assert.Equal(t, "Doe", family)
+	assert.Equal(t, "Jane", given)
+
+	assert.Equal(t, "Dr Gregory House", FormatPersonName("House^Gregory^^Dr"))
+	assert.Equal(t, "Smith", FormatPersonName("Smith"))
+}
@@ -0,0 +1,153 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, DataKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func encrypt(t *testing.T, key, plain []byte) []byte {
+	reader, err := NewEncryptReader(key, bytes.NewReader(plain), int64(len(plain)))
+	require.NoError(t, err)
+	sealed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return sealed
+}
+
+func TestStream_RoundTrip(t *testing.T) {
+	key := testKey(t)
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 17} {
+		plain := make([]byte, size)
+		_, err := rand.Read(plain)
+		require.NoError(t, err)
+
+		sealed := encrypt(t, key, plain)
+		assert.Equal(t, EncryptedSize(int64(size)), int64(len(sealed)), "size %d", size)
+		assert.True(t, IsEncrypted(sealed))
+
+		opened, err := io.ReadAll(NewDecryptReader(key, bytes.NewReader(sealed)))
+		require.NoError(t, err, "size %d", size)
+		assert.True(t, bytes.Equal(plain, opened), "size %d", size)
+	}
+}
+
+func TestStream_ConcatenatedStreams(t *testing.T) {
+	key := testKey(t)
+	first := bytes.Repeat([]byte("a"), segmentSize+5)
+	second := []byte("tail")
+
+	sealed := append(encrypt(t, key, first), encrypt(t, key, second)...)
+	opened, err := io.ReadAll(NewDecryptReader(key, bytes.NewReader(sealed)))
+	require.NoError(t, err)
+	assert.Equal(t, append(first, second...), opened)
+}
+
+func TestStream_DetectsTampering(t *testing.T) {
+	key := testKey(t)
+	sealed := encrypt(t, key, bytes.Repeat([]byte("x"), 2*segmentSize))
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[HeaderSize+10] ^= 1
+	_, err := io.ReadAll(NewDecryptReader(key, bytes.NewReader(flipped)))
+	assert.ErrorIs(t, err, ErrCorrupted)
+
+	_, err = io.ReadAll(NewDecryptReader(key, bytes.NewReader(sealed[:len(sealed)-1])))
+	assert.ErrorIs(t, err, ErrTruncated)
+
+	// Dropping the last segment leaves a stream that still ends on a
+	// segment boundary, but the declared length catches it.
+	_, err = io.ReadAll(NewDecryptReader(key, bytes.NewReader(sealed[:HeaderSize+segmentSize+tagSize])))
+	assert.ErrorIs(t, err, ErrTruncated)
+
+	_, err = io.ReadAll(NewDecryptReader(testKey(t), bytes.NewReader(sealed)))
+	assert.ErrorIs(t, err, ErrCorrupted, "Another owner's key cannot decrypt")
+}
+
+func TestStream_EnforcesDeclaredSize(t *testing.T) {
+	key := testKey(t)
+
+	reader, err := NewEncryptReader(key, bytes.NewReader([]byte("short")), 10)
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	assert.Error(t, err)
+
+	reader, err = NewEncryptReader(key, bytes.NewReader([]byte("longer than declared")), 4)
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	assert.Error(t, err)
+
+	_, err = NewEncryptReader(key, bytes.NewReader(nil), -1)
+	assert.Error(t, err)
+}
+
+func TestStream_PlaintextPassesThrough(t *testing.T) {
+	opened, err := io.ReadAll(NewDecryptReader(testKey(t), bytes.NewReader([]byte("%PDF-1.7 legacy"))))
+	require.NoError(t, err)
+	assert.Equal(t, "%PDF-1.7 legacy", string(opened))
+}
+
+func masterKey(t *testing.T, id string) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(testKey(t))
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	keys, err := ParseMasterKeys("", "")
+	require.NoError(t, err)
+	assert.Nil(t, keys, "No key leaves encryption disabled")
+
+	keys, err = ParseMasterKeys(masterKey(t, "2026-10"), masterKey(t, "2025-01")+", "+masterKey(t, "2024-01"))
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10", keys.CurrentID())
+	assert.Len(t, keys.keys, 3)
+
+	for name, pair := range map[string][2]string{
+		"previous only": {"", masterKey(t, "old")},
+		"no id":         {base64.StdEncoding.EncodeToString(testKey(t)), ""},
+		"short key":     {"k1:" + base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		"bad base64":    {"k1:not base64!", ""},
+		"duplicate id":  {masterKey(t, "k1"), masterKey(t, "k1")},
+	} {
+		_, err := ParseMasterKeys(pair[0], pair[1])
+		assert.Error(t, err, name)
+	}
+}
+
+func TestMasterKeys_WrapAndRotate(t *testing.T) {
+	oldEntry := masterKey(t, "old")
+	old, err := ParseMasterKeys(oldEntry, "")
+	require.NoError(t, err)
+
+	dataKey := testKey(t)
+	wrapped, id, err := old.Wrap("owner-1", dataKey)
+	require.NoError(t, err)
+	assert.Equal(t, "old", id)
+
+	_, err = old.Unwrap("owner-2", wrapped, id)
+	assert.Error(t, err, "A wrapped key is bound to its owner")
+
+	rotated, err := ParseMasterKeys(masterKey(t, "new"), oldEntry)
+	require.NoError(t, err)
+	unwrapped, err := rotated.Unwrap("owner-1", wrapped, id)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	rewrapped, newID, err := rotated.Wrap("owner-1", unwrapped)
+	require.NoError(t, err)
+	assert.Equal(t, "new", newID)
+
+	_, err = old.Unwrap("owner-1", rewrapped, newID)
+	assert.True(t, errors.Is(err, ErrUnknownMasterKey), "got %v", err)
+}
@@ -0,0 +1,118 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const DataKeySize = 32
+
+var ErrUnknownMasterKey = errors.New("data key is wrapped by an unknown master key")
+
+// MasterKeys wraps the per-owner data keys. New data keys are wrapped by the
+// current key; previous keys are kept to unwrap data keys that have not been
+// re-wrapped yet.
+type MasterKeys struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// ParseMasterKeys reads the current key and a comma-separated list of
+// previous keys, each written as "<id>:<base64 of 32 bytes>". It returns nil
+// when no current key is configured, which leaves encryption disabled.
+func ParseMasterKeys(current, previous string) (*MasterKeys, error) {
+	if strings.TrimSpace(current) == "" {
+		if strings.TrimSpace(previous) != "" {
+			return nil, errors.New("previous record encryption keys are set without a current key")
+		}
+		return nil, nil
+	}
+
+	keys := &MasterKeys{keys: make(map[string][]byte)}
+	id, err := keys.add(current)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record encryption key: %v", err)
+	}
+	keys.currentID = id
+
+	for _, entry := range strings.Split(previous, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		if _, err := keys.add(entry); err != nil {
+			return nil, fmt.Errorf("invalid previous record encryption key: %v", err)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MasterKeys) add(entry string) (string, error) {
+	id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+	if !ok || id == "" {
+		return "", errors.New(`expected "<id>:<base64 key>"`)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("key %s is not valid base64", id)
+	}
+	if len(key) != DataKeySize {
+		return "", fmt.Errorf("key %s must be %d bytes, got %d", id, DataKeySize, len(key))
+	}
+	if _, dup := m.keys[id]; dup {
+		return "", fmt.Errorf("key %s is listed twice", id)
+	}
+	m.keys[id] = key
+	return id, nil
+}
+
+// CurrentID is the ID of the key new data keys are wrapped with.
+func (m *MasterKeys) CurrentID() string {
+	return m.currentID
+}
+
+// Wrap encrypts a data key with the current master key. The owner is bound
+// to the result, so a wrapped key cannot be moved to another owner.
+func (m *MasterKeys) Wrap(ownerID string, dataKey []byte) ([]byte, string, error) {
+	aead, err := newGCM(m.keys[m.currentID])
+	if err != nil {
+		return nil, "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(ownerID)), m.currentID, nil
+}
+
+// Unwrap decrypts a data key wrapped by the master key with the given ID.
+func (m *MasterKeys) Unwrap(ownerID string, wrapped []byte, masterKeyID string) ([]byte, error) {
+	key, ok := m.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownMasterKey, masterKeyID)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrCorrupted
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(ownerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key of %s: %v", ownerID, err)
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
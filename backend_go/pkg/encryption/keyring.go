@@ -0,0 +1,163 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Keyring hands out the data key of each owner of records, creating it on
+// first use. Data keys are stored wrapped in record_data_keys and cached
+// unwrapped in memory. One Keyring is meant to be shared by everything that
+// reads or writes records, so each key is loaded once per process.
+type Keyring struct {
+	db     *pgxpool.Pool
+	master *MasterKeys
+
+	mu      sync.Mutex
+	cache   map[string][]byte
+	loading map[string]*keyLoad
+}
+
+// keyLoad is a data key being loaded. Callers asking for the same owner's
+// key meanwhile wait for it instead of querying again.
+type keyLoad struct {
+	done chan struct{}
+	key  []byte
+	err  error
+}
+
+func NewKeyring(db *pgxpool.Pool, master *MasterKeys) *Keyring {
+	return &Keyring{
+		db:      db,
+		master:  master,
+		cache:   make(map[string][]byte),
+		loading: make(map[string]*keyLoad),
+	}
+}
+
+// DataKey returns the owner's data key. Only requests for the same owner
+// wait on each other while a key is loaded.
+func (k *Keyring) DataKey(ctx context.Context, ownerID string) ([]byte, error) {
+	k.mu.Lock()
+	if key, ok := k.cache[ownerID]; ok {
+		k.mu.Unlock()
+		return key, nil
+	}
+	if load, ok := k.loading[ownerID]; ok {
+		k.mu.Unlock()
+		select {
+		case <-load.done:
+			return load.key, load.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	load := &keyLoad{done: make(chan struct{})}
+	k.loading[ownerID] = load
+	k.mu.Unlock()
+
+	load.key, load.err = k.loadDataKey(ctx, ownerID)
+
+	k.mu.Lock()
+	if load.err == nil {
+		k.cache[ownerID] = load.key
+	}
+	delete(k.loading, ownerID)
+	k.mu.Unlock()
+	close(load.done)
+	return load.key, load.err
+}
+
+// loadDataKey reads the owner's data key, creating it if there is none yet.
+func (k *Keyring) loadDataKey(ctx context.Context, ownerID string) ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	wrapped, masterKeyID, err := k.master.Wrap(ownerID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Another instance may create the owner's key at the same time; whichever
+	// insert lands first is the key everyone uses.
+	_, err = k.db.Exec(ctx,
+		`INSERT INTO record_data_keys (owner_id, wrapped_key, master_key_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (owner_id) DO NOTHING`,
+		ownerID, wrapped, masterKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store data key: %v", err)
+	}
+	err = k.db.QueryRow(ctx,
+		"SELECT wrapped_key, master_key_id FROM record_data_keys WHERE owner_id = $1",
+		ownerID).Scan(&wrapped, &masterKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data key: %v", err)
+	}
+
+	return k.master.Unwrap(ownerID, wrapped, masterKeyID)
+}
+
+// Rewrap re-wraps every data key not wrapped by the current master key, so
+// previous master keys can be retired. Objects are not re-encrypted. It
+// returns the number of keys re-wrapped.
+func (k *Keyring) Rewrap(ctx context.Context) (int, error) {
+	rows, err := k.db.Query(ctx,
+		`SELECT owner_id::text, wrapped_key, master_key_id
+		FROM record_data_keys
+		WHERE master_key_id <> $1`,
+		k.master.CurrentID())
+	if err != nil {
+		return 0, fmt.Errorf("failed to list data keys: %v", err)
+	}
+	type stale struct {
+		ownerID     string
+		wrapped     []byte
+		masterKeyID string
+	}
+	var keys []stale
+	for rows.Next() {
+		var s stale
+		if err := rows.Scan(&s.ownerID, &s.wrapped, &s.masterKeyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read data key: %v", err)
+		}
+		keys = append(keys, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list data keys: %v", err)
+	}
+
+	rewrapped := 0
+	for _, s := range keys {
+		key, err := k.master.Unwrap(s.ownerID, s.wrapped, s.masterKeyID)
+		if err != nil {
+			return rewrapped, err
+		}
+		wrapped, masterKeyID, err := k.master.Wrap(s.ownerID, key)
+		if err != nil {
+			return rewrapped, err
+		}
+		tag, err := k.db.Exec(ctx,
+			`UPDATE record_data_keys
+			SET wrapped_key = $3, master_key_id = $4, rotated_at = CURRENT_TIMESTAMP
+			WHERE owner_id = $1 AND master_key_id = $2`,
+			s.ownerID, s.masterKeyID, wrapped, masterKeyID)
+		if err != nil {
+			return rewrapped, fmt.Errorf("failed to re-wrap data key of %s: %v", s.ownerID, err)
+		}
+		if tag.RowsAffected() == 1 {
+			rewrapped++
+		} else {
+			log.Printf("Data key of %s changed during rotation, skipping", s.ownerID)
+		}
+	}
+	return rewrapped, nil
+}
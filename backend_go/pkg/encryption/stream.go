@@ -0,0 +1,263 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted objects are a sequence of streams, each made of a header and
+// AES-256-GCM segments of segmentSize plaintext bytes:
+//
+//	magic (8) | salt (16) | nonce prefix (7) | plaintext length (8)
+//
+// Every stream has its own key, derived from the data key and the salt, and
+// the header is authenticated with each segment. A segment's nonce is the
+// prefix, the segment number and a flag set on the last segment, so segments
+// cannot be reordered, dropped or truncated unnoticed. Objects assembled
+// from multipart uploads are one stream per part.
+const (
+	segmentSize     = 64 << 10
+	saltSize        = 16
+	noncePrefixSize = 7
+	tagSize         = 16
+	HeaderSize      = len(streamMagic) + saltSize + noncePrefixSize + 8
+)
+
+const streamMagic = "\x89HCREC\x01\n"
+
+var (
+	ErrCorrupted = errors.New("encrypted object is corrupted or was modified")
+	ErrTruncated = errors.New("encrypted object is truncated")
+)
+
+// EncryptedSize is the size of a stream holding size plaintext bytes.
+func EncryptedSize(size int64) int64 {
+	segments := (size + segmentSize - 1) / segmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	return int64(HeaderSize) + size + segments*tagSize
+}
+
+// IsEncrypted reports whether data, the start of an object, is encrypted.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(streamMagic))
+}
+
+func streamCipher(dataKey, salt []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, dataKey, salt, "healthcare record stream", 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive stream key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, segment uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], segment)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	left    int64
+	segment uint32
+	plain   []byte
+	sealed  []byte
+	out     []byte
+	done    bool
+}
+
+// NewEncryptReader encrypts exactly size bytes read from src with dataKey.
+// Reading fails if src holds fewer or more bytes than size.
+func NewEncryptReader(dataKey []byte, src io.Reader, size int64) (io.Reader, error) {
+	if size < 0 {
+		return nil, errors.New("plaintext size must be known to encrypt")
+	}
+	header := make([]byte, HeaderSize)
+	copy(header, streamMagic)
+	random := header[len(streamMagic) : len(streamMagic)+saltSize+noncePrefixSize]
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	binary.BigEndian.PutUint64(header[HeaderSize-8:], uint64(size))
+
+	aead, err := streamCipher(dataKey, random[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		src:    src,
+		aead:   aead,
+		header: header,
+		prefix: random[saltSize:],
+		left:   size,
+		plain:  make([]byte, segmentSize),
+		sealed: make([]byte, 0, segmentSize+tagSize),
+		out:    header,
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.nextSegment(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *encryptReader) nextSegment() error {
+	n := int64(segmentSize)
+	if r.left < n {
+		n = r.left
+	}
+	plain := r.plain[:n]
+	if _, err := io.ReadFull(r.src, plain); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return errors.New("content is shorter than its declared size")
+		}
+		return err
+	}
+	r.left -= n
+
+	last := r.left == 0
+	if last {
+		var extra [1]byte
+		if m, _ := r.src.Read(extra[:]); m > 0 {
+			return errors.New("content is longer than its declared size")
+		}
+		r.done = true
+	}
+	r.out = r.aead.Seal(r.sealed[:0], segmentNonce(r.prefix, r.segment, last), plain, r.header)
+	r.segment++
+	return nil
+}
+
+type decryptReader struct {
+	src      *bufio.Reader
+	dataKey  []byte
+	aead     cipher.AEAD
+	header   []byte
+	prefix   []byte
+	left     int64
+	segment  uint32
+	inStream bool
+	buf      []byte
+	out      []byte
+	err      error
+}
+
+// NewDecryptReader decrypts an object read from src. Objects stored before
+// encryption was enabled are passed through unchanged.
+func NewDecryptReader(dataKey []byte, src io.Reader) io.Reader {
+	br := bufio.NewReaderSize(src, segmentSize+tagSize)
+	start, _ := br.Peek(len(streamMagic))
+	if !IsEncrypted(start) {
+		return br
+	}
+	return &decryptReader{src: br, dataKey: dataKey, buf: make([]byte, segmentSize+tagSize)}
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *decryptReader) next() error {
+	if !r.inStream {
+		return r.readHeader()
+	}
+
+	n := int64(segmentSize)
+	if r.left < n {
+		n = r.left
+	}
+	sealed := r.buf[:n+tagSize]
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	r.left -= n
+	last := r.left == 0
+
+	plain, err := r.aead.Open(sealed[:0], segmentNonce(r.prefix, r.segment, last), sealed, r.header)
+	if err != nil {
+		return ErrCorrupted
+	}
+	r.segment++
+	if last {
+		r.inStream = false
+	}
+	r.out = plain
+	return nil
+}
+
+func (r *decryptReader) readHeader() error {
+	header := make([]byte, HeaderSize)
+	n, err := io.ReadFull(r.src, header)
+	if n == 0 && err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	if !IsEncrypted(header) {
+		return ErrCorrupted
+	}
+
+	salt := header[len(streamMagic) : len(streamMagic)+saltSize]
+	aead, err := streamCipher(r.dataKey, salt)
+	if err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint64(header[HeaderSize-8:])
+	if size > 1<<50 {
+		return ErrCorrupted
+	}
+
+	r.aead = aead
+	r.header = header
+	r.prefix = header[len(streamMagic)+saltSize : HeaderSize-8]
+	r.left = int64(size)
+	r.segment = 0
+	r.inStream = true
+	return nil
+}
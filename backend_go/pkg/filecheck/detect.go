@@ -0,0 +1,112 @@
+package filecheck
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"strings"
+
+	"healthcare_backend/pkg/models"
+)
+
+// sniffLength covers http.DetectContentType's 512 bytes and the DICOM prefix.
+const sniffLength = 512
+
+const (
+	TypePDF   = "application/pdf"
+	TypeJPEG  = "image/jpeg"
+	TypePNG   = "image/png"
+	TypeGIF   = "image/gif"
+	TypeWebP  = "image/webp"
+	TypeTIFF  = "image/tiff"
+	TypeHEIC  = "image/heic"
+	TypeDICOM = "application/dicom"
+	TypeZip   = "application/zip"
+	TypeText  = "text/plain"
+)
+
+var (
+	imagingTypes  = []string{TypeDICOM, TypeZip, TypeJPEG, TypePNG, TypeTIFF, TypePDF}
+	labTypes      = []string{TypePDF, TypeJPEG, TypePNG, TypeTIFF, TypeText}
+	documentTypes = []string{TypePDF, TypeJPEG, TypePNG, TypeText}
+	personalTypes = []string{TypePDF, TypeJPEG, TypePNG, TypeGIF, TypeWebP, TypeTIFF, TypeHEIC, TypeDICOM, TypeZip, TypeText}
+
+	// ChatImageTypes are the formats browsers can render inline in a chat.
+	ChatImageTypes = []string{TypeJPEG, TypePNG, TypeGIF, TypeWebP}
+	// ChatAttachmentTypes are the files that may be shared in a chat: images
+	// and the documents a care team exchanges, such as lab result PDFs.
+	ChatAttachmentTypes = []string{TypePDF, TypeJPEG, TypePNG, TypeGIF, TypeWebP, TypeTIFF, TypeHEIC, TypeText}
+)
+
+// AllowedForCategory returns the content types accepted for a medical record
+// category. Uncategorized uploads get the broad personal-records list.
+func AllowedForCategory(category *models.Category) []string {
+	if category == nil {
+		return personalTypes
+	}
+	if category.IsImagingCategory() {
+		return imagingTypes
+	}
+	switch *category {
+	case models.CategoryLabResults:
+		return labTypes
+	case models.CategoryClinicalReport, models.CategoryDischarge:
+		return documentTypes
+	}
+	return personalTypes
+}
+
+// Detect identifies a file from its first bytes.
+func Detect(head []byte) string {
+	switch {
+	case len(head) >= 132 && string(head[128:132]) == "DICM":
+		return TypeDICOM
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return TypeTIFF
+	case len(head) >= 12 && string(head[4:8]) == "ftyp" && isHEICBrand(string(head[8:12])):
+		return TypeHEIC
+	}
+
+	detected := http.DetectContentType(head)
+	if i := strings.Index(detected, ";"); i >= 0 {
+		detected = detected[:i]
+	}
+	return detected
+}
+
+func isHEICBrand(brand string) bool {
+	switch brand {
+	case "heic", "heix", "hevc", "hevx", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+// typesForExtension lists the content types a file with this extension may
+// have. Unknown extensions return nil and are only checked against the
+// allowlist.
+func typesForExtension(name string) []string {
+	switch strings.ToLower(strings.TrimPrefix(path.Ext(name), ".")) {
+	case "pdf":
+		return []string{TypePDF}
+	case "jpg", "jpeg", "jpe":
+		return []string{TypeJPEG}
+	case "png":
+		return []string{TypePNG}
+	case "gif":
+		return []string{TypeGIF}
+	case "webp":
+		return []string{TypeWebP}
+	case "tif", "tiff":
+		return []string{TypeTIFF}
+	case "heic", "heif":
+		return []string{TypeHEIC}
+	case "dcm", "dicom":
+		return []string{TypeDICOM}
+	case "zip":
+		return []string{TypeZip}
+	case "txt", "csv":
+		return []string{TypeText}
+	}
+	return nil
+}
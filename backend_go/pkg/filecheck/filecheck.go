@@ -0,0 +1,209 @@
+// Package filecheck validates user uploads before they are stored: the
+// filename is sanitized, the size is checked against the uploader's role,
+// the type is sniffed from the content rather than trusted from the client,
+// and the bytes are passed through a pluggable scanner.
+package filecheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"healthcare_backend/pkg/config"
+)
+
+type RejectionCode string
+
+const (
+	RejectEmptyFile         RejectionCode = "empty_file"
+	RejectFileTooLarge      RejectionCode = "file_too_large"
+	RejectTypeNotAllowed    RejectionCode = "type_not_allowed"
+	RejectExtensionMismatch RejectionCode = "extension_mismatch"
+	RejectMalwareDetected   RejectionCode = "malware_detected"
+)
+
+// Rejection is returned when an upload fails validation. It is safe to send
+// to the client as-is.
+type Rejection struct {
+	Code         RejectionCode `json:"code"`
+	Reason       string        `json:"reason"`
+	FileName     string        `json:"file_name,omitempty"`
+	DetectedType string        `json:"detected_type,omitempty"`
+	AllowedTypes []string      `json:"allowed_types,omitempty"`
+	MaxSize      int64         `json:"max_size,omitempty"`
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("upload rejected (%s): %s", r.Code, r.Reason)
+}
+
+// Upload describes one file to validate. Content is rewound after checking
+// so the caller can store it.
+type Upload struct {
+	Name    string
+	Size    int64
+	Role    string
+	Allowed []string
+	// MaxSize lowers the role limit for a specific purpose; 0 keeps it.
+	MaxSize int64
+	Content io.ReadSeeker
+}
+
+// Result is what the caller should store: the sanitized name and the content
+// type detected from the bytes.
+type Result struct {
+	Name        string
+	ContentType string
+}
+
+type Validator struct {
+	roleLimits map[string]int64
+	scanner    Scanner
+}
+
+const defaultMaxSize int64 = 50 << 20
+
+func NewValidator(cfg *config.Config) *Validator {
+	return &Validator{
+		roleLimits: map[string]int64{
+			"patient":      int64(cfg.UploadMaxMBPatient) << 20,
+			"doctor":       int64(cfg.UploadMaxMBDoctor) << 20,
+			"receptionist": int64(cfg.UploadMaxMBReceptionist) << 20,
+		},
+		scanner: NewScanner(cfg),
+	}
+}
+
+// MaxSizeForRole returns the largest upload a role may send.
+func (v *Validator) MaxSizeForRole(role string) int64 {
+	if limit, ok := v.roleLimits[role]; ok && limit > 0 {
+		return limit
+	}
+	return defaultMaxSize
+}
+
+func (v *Validator) Check(ctx context.Context, upload Upload) (*Result, error) {
+	name := SanitizeFilename(upload.Name)
+	if err := v.CheckSize(upload); err != nil {
+		return nil, err
+	}
+	detected, err := v.CheckType(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := upload.Content.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind upload: %v", err)
+	}
+	if err := v.Scan(ctx, name, upload.Content); err != nil {
+		return nil, err
+	}
+	if _, err := upload.Content.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind upload: %v", err)
+	}
+
+	return &Result{Name: name, ContentType: detected}, nil
+}
+
+// The steps of Check are also available on their own for uploads that
+// arrive in pieces: the size is known up front, the type from the first
+// piece, and the whole content only once it is stored.
+
+// CheckSize rejects an empty upload or one larger than the role allows.
+// Content is not read.
+func (v *Validator) CheckSize(upload Upload) error {
+	name := SanitizeFilename(upload.Name)
+
+	maxSize := v.MaxSizeForRole(upload.Role)
+	if upload.MaxSize > 0 && upload.MaxSize < maxSize {
+		maxSize = upload.MaxSize
+	}
+	if upload.Size <= 0 {
+		return &Rejection{Code: RejectEmptyFile, Reason: "the file is empty", FileName: name}
+	}
+	if upload.Size > maxSize {
+		return &Rejection{
+			Code:     RejectFileTooLarge,
+			Reason:   fmt.Sprintf("the file is %d bytes, the limit for %s uploads is %d bytes", upload.Size, roleLabel(upload.Role), maxSize),
+			FileName: name,
+			MaxSize:  maxSize,
+		}
+	}
+	return nil
+}
+
+// CheckType sniffs the type from the start of Content and returns it when it
+// is allowed and matches the file extension. Content is not rewound.
+func (v *Validator) CheckType(upload Upload) (string, error) {
+	name := SanitizeFilename(upload.Name)
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(upload.Content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read upload: %v", err)
+	}
+	detected := Detect(head[:n])
+
+	if !contains(upload.Allowed, detected) {
+		return "", &Rejection{
+			Code:         RejectTypeNotAllowed,
+			Reason:       fmt.Sprintf("%s files are not accepted here", detected),
+			FileName:     name,
+			DetectedType: detected,
+			AllowedTypes: upload.Allowed,
+		}
+	}
+	if expected := typesForExtension(name); len(expected) > 0 && !contains(expected, detected) {
+		return "", &Rejection{
+			Code:         RejectExtensionMismatch,
+			Reason:       fmt.Sprintf("the file extension does not match its content (%s)", detected),
+			FileName:     name,
+			DetectedType: detected,
+		}
+	}
+	return detected, nil
+}
+
+// Scan passes content through the malware scanner.
+func (v *Validator) Scan(ctx context.Context, name string, content io.Reader) error {
+	if err := v.scanner.Scan(ctx, name, content); err != nil {
+		if _, ok := err.(*Rejection); ok {
+			log.Printf("Upload %q rejected by scanner: %v", name, err)
+			return err
+		}
+		return fmt.Errorf("failed to scan upload: %v", err)
+	}
+	return nil
+}
+
+func roleLabel(role string) string {
+	if role == "" {
+		return "anonymous"
+	}
+	return role
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPStatus maps a rejection to the response status handlers should use.
+func (r *Rejection) HTTPStatus() int {
+	switch r.Code {
+	case RejectFileTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case RejectTypeNotAllowed, RejectExtensionMismatch:
+		return http.StatusUnsupportedMediaType
+	case RejectMalwareDetected:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
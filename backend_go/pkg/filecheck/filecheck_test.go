@@ -0,0 +1,198 @@
+package filecheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	pdfBytes = []byte("%PDF-1.7\n1 0 obj\n<<>>\nendobj\n")
+	pngBytes = append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...)
+)
+
+func testValidator() *Validator {
+	return NewValidator(&config.Config{
+		UploadMaxMBPatient: 1,
+		UploadMaxMBDoctor:  10,
+		UploadScanner:      "signature",
+	})
+}
+
+func check(t *testing.T, name string, content []byte, role string, allowed []string) (*Result, *Rejection) {
+	t.Helper()
+	result, err := testValidator().Check(context.Background(), Upload{
+		Name:    name,
+		Size:    int64(len(content)),
+		Role:    role,
+		Allowed: allowed,
+		Content: bytes.NewReader(content),
+	})
+	if err == nil {
+		return result, nil
+	}
+	var rejection *Rejection
+	require.True(t, errors.As(err, &rejection), "unexpected error: %v", err)
+	return nil, rejection
+}
+
+func TestCheck_AcceptsAllowedContentAndRewinds(t *testing.T) {
+	content := bytes.NewReader(pdfBytes)
+	result, err := testValidator().Check(context.Background(), Upload{
+		Name:    "../../lab/results.pdf",
+		Size:    int64(len(pdfBytes)),
+		Role:    "patient",
+		Allowed: AllowedForCategory(nil),
+		Content: content,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "results.pdf", result.Name)
+	assert.Equal(t, TypePDF, result.ContentType)
+
+	stored, err := io.ReadAll(content)
+	require.NoError(t, err)
+	assert.Equal(t, pdfBytes, stored, "Content should be rewound for storage")
+}
+
+func TestCheck_RejectsTypeOutsideCategoryAllowlist(t *testing.T) {
+	category := models.CategoryClinicalReport
+	_, rejection := check(t, "report.zip", []byte("PK\x03\x04rest-of-archive"), "doctor", AllowedForCategory(&category))
+
+	require.NotNil(t, rejection)
+	assert.Equal(t, RejectTypeNotAllowed, rejection.Code)
+	assert.Equal(t, TypeZip, rejection.DetectedType)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rejection.HTTPStatus())
+}
+
+func TestCheck_RejectsSpoofedExtension(t *testing.T) {
+	_, rejection := check(t, "photo.png", pdfBytes, "patient", AllowedForCategory(nil))
+
+	require.NotNil(t, rejection)
+	assert.Equal(t, RejectExtensionMismatch, rejection.Code)
+}
+
+func TestCheck_RejectsHTMLDisguisedAsImage(t *testing.T) {
+	_, rejection := check(t, "avatar.png", []byte("<html><script>alert(1)</script></html>"), "patient", ChatImageTypes)
+
+	require.NotNil(t, rejection)
+	assert.Equal(t, RejectTypeNotAllowed, rejection.Code)
+}
+
+func TestCheck_EnforcesRoleLimits(t *testing.T) {
+	big := append(append([]byte{}, pngBytes...), make([]byte, 2<<20)...)
+
+	_, rejection := check(t, "scan.png", big, "patient", AllowedForCategory(nil))
+	require.NotNil(t, rejection)
+	assert.Equal(t, RejectFileTooLarge, rejection.Code)
+	assert.Equal(t, int64(1<<20), rejection.MaxSize)
+
+	_, rejection = check(t, "scan.png", big, "doctor", AllowedForCategory(nil))
+	assert.Nil(t, rejection, "Doctors have a larger limit")
+
+	_, rejection = check(t, "empty.pdf", nil, "doctor", AllowedForCategory(nil))
+	require.NotNil(t, rejection)
+	assert.Equal(t, RejectEmptyFile, rejection.Code)
+}
+
+func TestCheck_SignatureScannerFindsEICAR(t *testing.T) {
+	content := append(append([]byte{}, pdfBytes...), eicarSignature.Pattern...)
+
+	_, rejection := check(t, "infected.pdf", content, "doctor", AllowedForCategory(nil))
+	require.NotNil(t, rejection)
+	assert.Equal(t, RejectMalwareDetected, rejection.Code)
+	assert.Contains(t, rejection.Reason, "EICAR")
+}
+
+func TestValidator_ChecksUploadsInPieces(t *testing.T) {
+	v := testValidator()
+
+	err := v.CheckSize(Upload{Name: "study.dcm", Size: 2 << 20, Role: "patient"})
+	var rejection *Rejection
+	require.True(t, errors.As(err, &rejection))
+	assert.Equal(t, RejectFileTooLarge, rejection.Code)
+	assert.NoError(t, v.CheckSize(Upload{Name: "study.dcm", Size: 2 << 20, Role: "doctor"}))
+
+	detected, err := v.CheckType(Upload{Name: "report.pdf", Allowed: AllowedForCategory(nil), Content: bytes.NewReader(pdfBytes)})
+	require.NoError(t, err)
+	assert.Equal(t, TypePDF, detected)
+	_, err = v.CheckType(Upload{Name: "photo.png", Allowed: AllowedForCategory(nil), Content: bytes.NewReader(pdfBytes)})
+	require.True(t, errors.As(err, &rejection))
+	assert.Equal(t, RejectExtensionMismatch, rejection.Code)
+
+	err = v.Scan(context.Background(), "report.pdf", bytes.NewReader(append(append([]byte{}, pdfBytes...), eicarSignature.Pattern...)))
+	require.True(t, errors.As(err, &rejection))
+	assert.Equal(t, RejectMalwareDetected, rejection.Code)
+}
+
+func TestSignatureScanner_MatchesAcrossReadBoundaries(t *testing.T) {
+	scanner := NewSignatureScanner([]Signature{{Name: "Test", Pattern: []byte("needle")}})
+
+	content := append(bytes.Repeat([]byte("x"), 64<<10-3), []byte("needle")...)
+	err := scanner.Scan(context.Background(), "file.bin", bytes.NewReader(content))
+	assert.Error(t, err)
+
+	err = scanner.Scan(context.Background(), "file.bin", strings.NewReader("haystack"))
+	assert.NoError(t, err)
+}
+
+func TestNoopScanner(t *testing.T) {
+	v := NewValidator(&config.Config{UploadMaxMBDoctor: 10, UploadScanner: "none"})
+	content := append(append([]byte{}, pdfBytes...), eicarSignature.Pattern...)
+
+	_, err := v.Check(context.Background(), Upload{
+		Name: "test.pdf", Size: int64(len(content)), Role: "doctor",
+		Allowed: AllowedForCategory(nil), Content: bytes.NewReader(content),
+	})
+	assert.NoError(t, err)
+}
+
+func TestLoadSignatures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signatures.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# comment\n\nMarker:6e6565646c65\n"), 0o600))
+
+	signatures, err := LoadSignatures(path)
+	require.NoError(t, err)
+	require.Len(t, signatures, 1)
+	assert.Equal(t, "Marker", signatures[0].Name)
+	assert.Equal(t, []byte("needle"), signatures[0].Pattern)
+
+	require.NoError(t, os.WriteFile(path, []byte("broken line\n"), 0o600))
+	_, err = LoadSignatures(path)
+	assert.Error(t, err)
+}
+
+func TestDetect(t *testing.T) {
+	dicom := append(make([]byte, 128), []byte("DICM")...)
+	assert.Equal(t, TypeDICOM, Detect(dicom))
+	assert.Equal(t, TypePDF, Detect(pdfBytes))
+	assert.Equal(t, TypePNG, Detect(pngBytes))
+	assert.Equal(t, TypeTIFF, Detect([]byte("II*\x00\x08\x00\x00\x00")))
+	assert.Equal(t, TypeText, Detect([]byte("glucose,5.4,mmol/L\n")))
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	assert.Equal(t, "passwd", SanitizeFilename("../../etc/passwd"))
+	assert.Equal(t, "report.pdf", SanitizeFilename(`C:\Users\me\report.pdf`))
+	assert.Equal(t, "bad_name_.pdf", SanitizeFilename("bad:name?.pdf"))
+	assert.Equal(t, "htaccess", SanitizeFilename(".htaccess"))
+	assert.Equal(t, "a b.pdf", SanitizeFilename("a\x00\t\nb.pdf"))
+	assert.Equal(t, "file", SanitizeFilename(".."))
+	assert.Equal(t, "تحليل الدم.pdf", SanitizeFilename("تحليل الدم.pdf"))
+
+	long := SanitizeFilename(strings.Repeat("é", 300) + ".pdf")
+	assert.LessOrEqual(t, len(long), maxFilenameBytes)
+	assert.True(t, strings.HasSuffix(long, ".pdf"))
+}
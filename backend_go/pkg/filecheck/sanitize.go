@@ -0,0 +1,49 @@
+package filecheck
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxFilenameBytes = 200
+
+// SanitizeFilename reduces a client-supplied name to a single safe path
+// segment: directories are dropped, control and reserved characters removed,
+// leading dots stripped and the length capped while keeping the extension.
+func SanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case r == utf8.RuneError, unicode.IsControl(r):
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.TrimLeft(name, ". ")
+
+	if len(name) > maxFilenameBytes {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		stem := strings.TrimSuffix(name, ext)
+		for len(stem)+len(ext) > maxFilenameBytes {
+			_, size := utf8.DecodeLastRuneInString(stem)
+			stem = stem[:len(stem)-size]
+		}
+		name = strings.TrimSpace(stem) + ext
+	}
+
+	if name == "" || name == path.Ext(name) {
+		return "file" + name
+	}
+	return name
+}
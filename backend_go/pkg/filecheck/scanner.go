@@ -0,0 +1,144 @@
+package filecheck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"healthcare_backend/pkg/config"
+)
+
+// Scanner inspects upload content for malware. Implementations return a
+// *Rejection when the content must not be stored and any other error when
+// the scan itself failed.
+type Scanner interface {
+	Scan(ctx context.Context, name string, content io.Reader) error
+}
+
+// NewScanner picks the scanner named by UPLOAD_SCANNER: "none" disables
+// scanning, anything else uses the local signature scanner.
+func NewScanner(cfg *config.Config) Scanner {
+	if cfg.UploadScanner == "none" {
+		return NoopScanner{}
+	}
+
+	scanner := NewSignatureScanner(nil)
+	if cfg.UploadSignatureFile != "" {
+		signatures, err := LoadSignatures(cfg.UploadSignatureFile)
+		if err != nil {
+			log.Printf("Warning: failed to load upload signatures, using built-in list only: %v", err)
+		} else {
+			scanner = NewSignatureScanner(signatures)
+		}
+	}
+	return scanner
+}
+
+type NoopScanner struct{}
+
+func (NoopScanner) Scan(ctx context.Context, name string, content io.Reader) error {
+	return nil
+}
+
+// Signature is a named byte pattern that marks a file as malicious.
+type Signature struct {
+	Name    string
+	Pattern []byte
+}
+
+// eicarSignature is the industry-standard antivirus test file, so the scanner
+// path can be exercised without real malware.
+var eicarSignature = Signature{
+	Name:    "EICAR-Test-File",
+	Pattern: []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`),
+}
+
+// SignatureScanner looks for known byte patterns anywhere in the content.
+// It is a lightweight local check, not a replacement for a real antivirus.
+type SignatureScanner struct {
+	signatures []Signature
+	maxLen     int
+}
+
+func NewSignatureScanner(extra []Signature) *SignatureScanner {
+	signatures := append([]Signature{eicarSignature}, extra...)
+	maxLen := 0
+	for _, sig := range signatures {
+		if len(sig.Pattern) > maxLen {
+			maxLen = len(sig.Pattern)
+		}
+	}
+	return &SignatureScanner{signatures: signatures, maxLen: maxLen}
+}
+
+func (s *SignatureScanner) Scan(ctx context.Context, name string, content io.Reader) error {
+	const blockSize = 64 << 10
+
+	// Keep the tail of the previous block so patterns spanning two reads
+	// are still found.
+	window := make([]byte, 0, blockSize+s.maxLen)
+	block := make([]byte, blockSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := content.Read(block)
+		if n > 0 {
+			window = append(window, block[:n]...)
+			for _, sig := range s.signatures {
+				if bytes.Contains(window, sig.Pattern) {
+					return &Rejection{
+						Code:     RejectMalwareDetected,
+						Reason:   fmt.Sprintf("the file matches the malware signature %s", sig.Name),
+						FileName: name,
+					}
+				}
+			}
+			if keep := s.maxLen - 1; len(window) > keep {
+				window = append(window[:0], window[len(window)-keep:]...)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// LoadSignatures reads "name:hexpattern" lines; blank lines and lines
+// starting with # are ignored.
+func LoadSignatures(filename string) ([]Signature, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var signatures []Signature
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, pattern, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected name:hexpattern", line)
+		}
+		decoded, err := hex.DecodeString(strings.TrimSpace(pattern))
+		if err != nil || len(decoded) == 0 {
+			return nil, fmt.Errorf("line %d: invalid hex pattern", line)
+		}
+		signatures = append(signatures, Signature{Name: strings.TrimSpace(name), Pattern: decoded})
+	}
+	return signatures, scanner.Err()
+}
@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"healthcare_backend/pkg/filecheck"
+	"healthcare_backend/pkg/models"
+	chatService "healthcare_backend/pkg/services/chat"
+
+	"github.com/gin-gonic/gin"
+)
+
+// attachmentDownloadPath is where DownloadAttachment is routed; link tokens
+// are appended to it.
+const attachmentDownloadPath = "/api/v1/chats/attachments/"
+
+// UploadAttachment stores a file to send in the chat. The returned ID goes
+// in the attachmentId of the message that carries it.
+func (h *ChatHandler) UploadAttachment(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file from request"})
+		return
+	}
+	defer file.Close()
+
+	attachment, err := h.chatService.UploadAttachment(c.Param("chatId"), userID, c.GetString("userType"), file, header)
+	if err != nil {
+		var rejection *filecheck.Rejection
+		if errors.As(err, &rejection) {
+			c.JSON(rejection.HTTPStatus(), gin.H{"error": "Upload rejected", "rejection": rejection})
+			return
+		}
+		respondAttachmentError(c, "upload attachment", err)
+		return
+	}
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// CreateAttachmentLink returns a short-lived download URL of an attachment
+// for the caller.
+func (h *ChatHandler) CreateAttachmentLink(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	token, expiresAt, err := h.chatService.CreateAttachmentLink(c.Param("chatId"), c.Param("attachmentId"), userID)
+	if err != nil {
+		respondAttachmentError(c, "create attachment link", err)
+		return
+	}
+	c.JSON(http.StatusOK, models.AttachmentLink{
+		URL:       attachmentDownloadPath + token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// DownloadAttachment streams the attachment a link token was issued for.
+// Images are shown inline, everything else is downloaded.
+func (h *ChatHandler) DownloadAttachment(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	attachment, content, err := h.chatService.OpenAttachment(c.Param("token"), userID)
+	if err != nil {
+		respondAttachmentError(c, "download attachment", err)
+		return
+	}
+	defer content.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		disposition = "inline"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, attachment.FileName))
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, content, nil)
+}
+
+func respondAttachmentError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, chatService.ErrNotChatParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not a participant of this chat"})
+	case errors.Is(err, chatService.ErrAttachmentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, chatService.ErrAttachmentLinkExpired):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Failed to %s: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action})
+	}
+}
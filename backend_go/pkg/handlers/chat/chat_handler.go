@@ -1,30 +1,39 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/realtime"
 	chatService "healthcare_backend/pkg/services/chat"
+	"healthcare_backend/pkg/storage"
 	"healthcare_backend/pkg/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 type ChatHandler struct {
 	chatService *chatService.ChatService
 	config      *config.Config
-	wsClients   map[string]*utils.WSClient
+	hub         *realtime.Hub
+	upgrader    *websocket.Upgrader
 }
 
-func NewChatHandler(db *pgxpool.Pool, cfg *config.Config, wsClients map[string]*utils.WSClient) *ChatHandler {
+func NewChatHandler(db *pgxpool.Pool, cfg *config.Config, store storage.Storage, hub *realtime.Hub) *ChatHandler {
 	return &ChatHandler{
-		chatService: chatService.NewChatService(db, cfg),
+		chatService: chatService.NewChatService(db, cfg, store, hub),
 		config:      cfg,
-		wsClients:   wsClients,
+		hub:         hub,
+		upgrader:    utils.NewUpgrader(cfg.AllowedOrigins),
 	}
 }
 
@@ -53,10 +62,10 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 	}
 
 	var request struct {
-		ChatID      string  `json:"chatId" binding:"required"`
-		RecipientID string  `json:"recipientId" binding:"required"`
-		Content     string  `json:"content" binding:"required"`
-		Key         *string `json:"key"`
+		ChatID       string `json:"chatId" binding:"required"`
+		RecipientID  string `json:"recipientId"`
+		Content      string `json:"content"`
+		AttachmentID string `json:"attachmentId"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -64,89 +73,72 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if request.Content == "" && request.AttachmentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content or attachmentId is required"})
+		return
+	}
 
 	message := chatService.CombinedMessage{
-		ChatID:      request.ChatID,
-		SenderID:    userID,
-		RecipientID: request.RecipientID,
-		Content:     request.Content,
-		CreatedAt:   time.Now(),
-		Key:         request.Key,
+		ChatID:       request.ChatID,
+		SenderID:     userID,
+		RecipientID:  request.RecipientID,
+		Content:      request.Content,
+		CreatedAt:    time.Now(),
+		AttachmentID: request.AttachmentID,
 	}
 
 	if err := h.chatService.SendMessage(message); err != nil {
-		log.Printf("Error sending message: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
-		return
-	}
-
-	if wsClient, exists := h.wsClients[request.RecipientID]; exists {
-		wsMessage := gin.H{
-			"type":         "new_message",
-			"chat_id":      request.ChatID,
-			"sender_id":    userID,
-			"recipient_id": request.RecipientID,
-			"content":      request.Content,
-			"created_at":   message.CreatedAt,
-		}
-
-		if messageBytes, err := json.Marshal(wsMessage); err == nil {
-			select {
-			case wsClient.Send <- messageBytes:
-			default:
-				close(wsClient.Send)
-				delete(h.wsClients, request.RecipientID)
-			}
+		switch {
+		case errors.Is(err, chatService.ErrNotChatParticipant):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, chatService.ErrAttachmentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, chatService.ErrAttachmentAlreadySent):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error sending message: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
 		}
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Message sent successfully"})
 }
 
-func (h *ChatHandler) UploadImage(c *gin.Context) {
+// MarkChatRead moves the caller's read marker to the message given as
+// messageId, or to the newest message of the chat when the body is empty.
+func (h *ChatHandler) MarkChatRead(c *gin.Context) {
 	userID := c.GetString("userId")
 	if userID == "" {
-		log.Printf("User ID not found in context")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
 		return
 	}
 
-	file, header, err := c.Request.FormFile("image")
-	if err != nil {
-		file, header, err = c.Request.FormFile("file")
-		if err != nil {
-			log.Printf("Error getting file from request: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get image from request"})
+	var request struct {
+		MessageID string `json:"messageId"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 	}
-	defer file.Close()
-
-	contentType := header.Header.Get("Content-Type")
-	if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/jpg" {
-		log.Println("Invalid image type:", contentType)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Only JPEG and PNG images are allowed"})
-		return
-	}
-
-	if header.Size > 5*1024*1024 {
-		log.Println("File size exceeds 5MB limit")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File size exceeds 5MB limit"})
-		return
-	}
 
-	presignedURL, key, err := h.chatService.UploadImage(file, header)
+	readAt, err := h.chatService.MarkChatRead(c.Param("chatId"), userID, request.MessageID)
 	if err != nil {
-		log.Printf("Error uploading image: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload image"})
+		switch {
+		case errors.Is(err, chatService.ErrNotChatParticipant):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, chatService.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error marking chat %s read: %v", c.Param("chatId"), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark chat as read"})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"presigned_url": presignedURL,
-		"key":           key,
-		"s3_key":        key,
-	})
+	c.JSON(http.StatusOK, gin.H{"chatId": c.Param("chatId"), "readAt": readAt})
 }
 
 func (h *ChatHandler) SearchUsers(c *gin.Context) {
@@ -191,16 +183,37 @@ func (h *ChatHandler) GetMessagesForChat(c *gin.Context) {
 		return
 	}
 
-	messages, err := h.chatService.GetMessagesForChat(chatID)
+	query := chatService.MessagePageQuery{Before: c.Query("before"), After: c.Query("after"), Around: c.Query("around")}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		query.Limit = limit
+	}
+
+	page, err := h.chatService.GetMessagesForChat(chatID, userID, query)
 	if err != nil {
-		log.Printf("Error getting messages for chat %s: %v", chatID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
+		switch {
+		case errors.Is(err, chatService.ErrInvalidCursor):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, chatService.ErrNotChatParticipant):
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a participant of this chat"})
+		case errors.Is(err, chatService.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error getting messages for chat %s: %v", chatID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"messages": messages,
-	})
+	if err := h.chatService.MarkChatDelivered(chatID, userID); err != nil {
+		log.Printf("Error marking chat %s delivered: %v", chatID, err)
+	}
+
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *ChatHandler) FindOrCreateChatWithUser(c *gin.Context) {
@@ -308,6 +321,9 @@ func (h *ChatHandler) FindOrCreateChatWithUserLegacy(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"chats": chats})
 }
 
+// WebSocketHandler opens the caller's event connection. The route sits
+// behind AuthMiddleware, so the user comes from the token or cookie, never
+// from the request.
 func (h *ChatHandler) WebSocketHandler(c *gin.Context) {
 	userID := c.GetString("userId")
 	if userID == "" {
@@ -315,78 +331,112 @@ func (h *ChatHandler) WebSocketHandler(c *gin.Context) {
 		return
 	}
 
-	conn, err := utils.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
 		return
 	}
 
-	client := &utils.WSClient{
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
-		UserID: userID,
-	}
-
-	h.wsClients[userID] = client
+	h.chatService.UserConnected(userID)
+	defer h.chatService.UserDisconnected(userID)
+	realtime.NewClient(h.hub, userID, conn).Run(h.handleWebSocketMessage)
+}
 
-	go h.handleWebSocketMessages(client)
-	go h.handleWebSocketWrites(client)
+// RunPresence keeps presence up to date until ctx is done.
+func (h *ChatHandler) RunPresence(ctx context.Context) {
+	h.chatService.RunPresence(ctx)
 }
 
-func (h *ChatHandler) handleWebSocketMessages(client *utils.WSClient) {
-	defer func() {
-		delete(h.wsClients, client.UserID)
-		client.Conn.Close()
-	}()
+// handleWebSocketMessage dispatches a client event. Malformed or unknown
+// events are answered with an error event on the same connection.
+func (h *ChatHandler) handleWebSocketMessage(client *realtime.Client, message []byte) {
+	var header realtime.Header
+	if err := json.Unmarshal(message, &header); err != nil {
+		sendWebSocketError(client, realtime.ErrorInvalidEvent, "event must be a JSON object")
+		return
+	}
+	if !header.Supported() {
+		sendWebSocketError(client, realtime.ErrorUnsupportedVersion,
+			fmt.Sprintf("protocol version %d is not supported", header.Version))
+		return
+	}
 
-	for {
-		_, message, err := client.Conn.ReadMessage()
-		if err != nil {
-			log.Printf("WebSocket read error: %v", err)
-			break
-		}
+	switch header.Type {
+	case realtime.EventPing:
+		sendWebSocketEvent(client, realtime.NewHeader(realtime.EventPong))
 
-		var wsMessage map[string]interface{}
-		if err := json.Unmarshal(message, &wsMessage); err != nil {
-			log.Printf("Error unmarshaling WebSocket message: %v", err)
-			continue
+	case realtime.EventMarkRead, realtime.EventMarkDelivered, realtime.EventTypingStart, realtime.EventTypingStop:
+		var event realtime.ChatEvent
+		if err := json.Unmarshal(message, &event); err != nil || event.ChatID == "" {
+			sendWebSocketError(client, realtime.ErrorInvalidEvent, "chat_id is required")
+			return
+		}
+		var err error
+		switch header.Type {
+		case realtime.EventMarkRead:
+			_, err = h.chatService.MarkChatRead(event.ChatID, client.UserID, event.MessageID)
+		case realtime.EventMarkDelivered:
+			err = h.chatService.MarkChatDelivered(event.ChatID, client.UserID)
+		default:
+			err = h.chatService.SendTyping(event.ChatID, client.UserID, header.Type == realtime.EventTypingStart)
+		}
+		if errors.Is(err, chatService.ErrNotChatParticipant) {
+			sendWebSocketError(client, realtime.ErrorForbidden, "not a participant of this chat")
+		} else if err != nil {
+			log.Printf("Error handling %s for chat %s: %v", header.Type, event.ChatID, err)
 		}
 
-		messageType, ok := wsMessage["type"].(string)
-		if !ok {
-			continue
+	case realtime.EventPresenceSet:
+		var event realtime.PresenceSetEvent
+		if err := json.Unmarshal(message, &event); err != nil {
+			sendWebSocketError(client, realtime.ErrorInvalidEvent, err.Error())
+			return
+		}
+		if err := h.chatService.SetPresence(client.UserID, event.Status); err != nil {
+			sendWebSocketError(client, realtime.ErrorInvalidEvent, err.Error())
 		}
 
-		switch messageType {
-		case "ping":
-			pongMessage := map[string]string{"type": "pong"}
-			if pongBytes, err := json.Marshal(pongMessage); err == nil {
-				select {
-				case client.Send <- pongBytes:
-				default:
-					close(client.Send)
-					return
-				}
+	case realtime.EventPresenceSubscribe, realtime.EventPresenceUnsubscribe:
+		var event realtime.PresenceSubscribeEvent
+		if err := json.Unmarshal(message, &event); err != nil {
+			sendWebSocketError(client, realtime.ErrorInvalidEvent, err.Error())
+			return
+		}
+		if header.Type == realtime.EventPresenceUnsubscribe {
+			for _, userID := range event.UserIDs {
+				client.Unsubscribe(chatService.PresenceTopic(userID))
 			}
+			return
+		}
+		// Only contacts are returned, so only their presence is subscribed.
+		presence, err := h.chatService.GetPresence(client.UserID, event.UserIDs)
+		if err != nil {
+			log.Printf("Error loading presence for user %s: %v", client.UserID, err)
+			return
 		}
+		for _, p := range presence {
+			client.Subscribe(chatService.PresenceTopic(p.UserID))
+			sendWebSocketEvent(client, p)
+		}
+
+	default:
+		sendWebSocketError(client, realtime.ErrorUnknownType, fmt.Sprintf("unknown event type %q", header.Type))
 	}
 }
 
-func (h *ChatHandler) handleWebSocketWrites(client *utils.WSClient) {
-	defer client.Conn.Close()
-
-	for {
-		select {
-		case message, ok := <-client.Send:
-			if !ok {
-				client.Conn.WriteMessage(1, []byte{})
-				return
-			}
-
-			if err := client.Conn.WriteMessage(1, message); err != nil {
-				log.Printf("WebSocket write error: %v", err)
-				return
-			}
-		}
+func sendWebSocketEvent(client *realtime.Client, event interface{}) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding WebSocket event: %v", err)
+		return
 	}
+	client.Send(encoded)
+}
+
+func sendWebSocketError(client *realtime.Client, code, message string) {
+	sendWebSocketEvent(client, realtime.ErrorEvent{
+		Header:  realtime.NewHeader(realtime.EventError),
+		Code:    code,
+		Message: message,
+	})
 }
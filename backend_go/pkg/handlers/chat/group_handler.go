@@ -0,0 +1,160 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"healthcare_backend/pkg/models"
+	chatService "healthcare_backend/pkg/services/chat"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateGroupChat starts a care team chat owned by the calling doctor or
+// receptionist.
+func (h *ChatHandler) CreateGroupChat(c *gin.Context) {
+	userID := c.GetString("userId")
+	userType := c.GetString("userType")
+	if userID == "" || userType == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User credentials not found"})
+		return
+	}
+
+	var request struct {
+		Name    string                    `json:"name" binding:"required"`
+		Members []chatService.GroupMember `json:"members" binding:"dive"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	group, err := h.chatService.CreateGroupChat(userID, userType, request.Name, request.Members)
+	if err != nil {
+		respondGroupError(c, "create group chat", err)
+		return
+	}
+	c.JSON(http.StatusCreated, group)
+}
+
+// GetGroupChat returns a group chat with its participants and their roles.
+func (h *ChatHandler) GetGroupChat(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	group, err := h.chatService.GetGroupChat(c.Param("chatId"), userID)
+	if err != nil {
+		respondGroupError(c, "load group chat", err)
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// RenameGroupChat changes the name of a group chat.
+func (h *ChatHandler) RenameGroupChat(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var request struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.chatService.RenameGroupChat(c.Param("chatId"), userID, request.Name); err != nil {
+		respondGroupError(c, "rename group chat", err)
+		return
+	}
+	h.GetGroupChat(c)
+}
+
+// AddParticipant adds a care team member to a group chat.
+func (h *ChatHandler) AddParticipant(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var member chatService.GroupMember
+	if err := c.ShouldBindJSON(&member); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.chatService.AddParticipant(c.Param("chatId"), userID, member); err != nil {
+		respondGroupError(c, "add participant", err)
+		return
+	}
+	h.GetGroupChat(c)
+}
+
+// UpdateParticipant changes a participant's role.
+func (h *ChatHandler) UpdateParticipant(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var request struct {
+		Role models.ParticipantRole `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.chatService.SetParticipantRole(c.Param("chatId"), userID, c.Param("userId"), request.Role); err != nil {
+		respondGroupError(c, "change participant role", err)
+		return
+	}
+	h.GetGroupChat(c)
+}
+
+// RemoveParticipant removes a participant from a group chat, or lets the
+// caller leave it when the user in the path is themselves.
+func (h *ChatHandler) RemoveParticipant(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	if err := h.chatService.RemoveParticipant(c.Param("chatId"), userID, c.Param("userId")); err != nil {
+		respondGroupError(c, "remove participant", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func respondGroupError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, chatService.ErrNotChatParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not a participant of this chat"})
+	case errors.Is(err, chatService.ErrGroupCreatorNotAllowed),
+		errors.Is(err, chatService.ErrNotGroupManager),
+		errors.Is(err, chatService.ErrParticipantNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, chatService.ErrParticipantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, chatService.ErrAlreadyParticipant),
+		errors.Is(err, chatService.ErrTooManyParticipants):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, chatService.ErrNotGroupChat),
+		errors.Is(err, chatService.ErrInvalidGroup):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Failed to %s: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action})
+	}
+}
@@ -0,0 +1,127 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	chatService "healthcare_backend/pkg/services/chat"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EditMessage replaces the text of the caller's own message.
+func (h *ChatHandler) EditMessage(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	var request struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	content := strings.TrimSpace(request.Content)
+	if content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content is required"})
+		return
+	}
+
+	message, err := h.chatService.EditMessage(c.Param("chatId"), c.Param("messageId"), userID, content)
+	if err != nil {
+		respondMessageChangeError(c, "edit message", err)
+		return
+	}
+	c.JSON(http.StatusOK, message)
+}
+
+// GetMessageEdits lists the earlier versions of an edited message.
+func (h *ChatHandler) GetMessageEdits(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	edits, err := h.chatService.GetMessageEdits(c.Param("chatId"), c.Param("messageId"), userID)
+	if err != nil {
+		respondMessageChangeError(c, "load message history", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"edits": edits})
+}
+
+// DeleteMessage deletes a message for the caller, or with scope=everyone
+// for all participants.
+func (h *ChatHandler) DeleteMessage(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	scope := chatService.DeleteScope(c.DefaultQuery("scope", string(chatService.DeleteForMe)))
+	if err := h.chatService.DeleteMessage(c.Param("chatId"), c.Param("messageId"), userID, scope); err != nil {
+		respondMessageChangeError(c, "delete message", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// AddReaction reacts to a message with the emoji in the path.
+func (h *ChatHandler) AddReaction(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	if err := h.chatService.AddReaction(c.Param("chatId"), c.Param("messageId"), userID, c.Param("emoji")); err != nil {
+		respondMessageChangeError(c, "add reaction", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveReaction takes the caller's emoji off a message.
+func (h *ChatHandler) RemoveReaction(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	if err := h.chatService.RemoveReaction(c.Param("chatId"), c.Param("messageId"), userID, c.Param("emoji")); err != nil {
+		respondMessageChangeError(c, "remove reaction", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func respondMessageChangeError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, chatService.ErrNotChatParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not a participant of this chat"})
+	case errors.Is(err, chatService.ErrNotMessageSender),
+		errors.Is(err, chatService.ErrSystemMessage):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, chatService.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, chatService.ErrEditWindowClosed),
+		errors.Is(err, chatService.ErrDeleteWindowClosed),
+		errors.Is(err, chatService.ErrMessageDeleted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, chatService.ErrMessageNotEditable),
+		errors.Is(err, chatService.ErrInvalidReaction),
+		errors.Is(err, chatService.ErrInvalidDeleteScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Failed to %s in chat %s: %v", action, c.Param("chatId"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action})
+	}
+}
@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"healthcare_backend/pkg/models"
+	chatService "healthcare_backend/pkg/services/chat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxSearchQueryLength = 200
+
+// SearchMessages runs a full-text search over the messages of the caller's
+// chats. Results can be narrowed to one chat, one sender and a date range;
+// each comes with a highlighted snippet, and its context can be loaded with
+// GET /chats/:chatId/messages?around=<id>.
+func (h *ChatHandler) SearchMessages(c *gin.Context) {
+	userID := c.GetString("userId")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	req := models.MessageSearchRequest{
+		Query:    c.Query("q"),
+		ChatID:   c.Query("chatId"),
+		SenderID: c.Query("senderId"),
+	}
+	if req.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+	if len(req.Query) > maxSearchQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is too long"})
+		return
+	}
+	if req.ChatID != "" {
+		if _, err := uuid.Parse(req.ChatID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+			return
+		}
+	}
+	if req.SenderID != "" {
+		if _, err := uuid.Parse(req.SenderID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sender ID"})
+			return
+		}
+	}
+
+	var ok bool
+	if req.From, ok = parseSearchDate(c, "from"); !ok {
+		return
+	}
+	if req.To, ok = parseSearchDate(c, "to"); !ok {
+		return
+	}
+	if req.To != nil {
+		// The end date is inclusive, so the range runs to the next midnight.
+		next := req.To.AddDate(0, 0, 1)
+		req.To = &next
+	}
+
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if req.Limit, err = strconv.Atoi(limit); err != nil || req.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+	if offset := c.Query("offset"); offset != "" {
+		if req.Offset, err = strconv.Atoi(offset); err != nil || req.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	results, err := h.chatService.SearchMessages(userID, req)
+	if err != nil {
+		if errors.Is(err, chatService.ErrNotChatParticipant) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a participant of this chat"})
+			return
+		}
+		log.Printf("Error searching messages: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func parseSearchDate(c *gin.Context, param string) (*time.Time, bool) {
+	value := c.Query(param)
+	if value == "" {
+		return nil, true
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " date, expected YYYY-MM-DD"})
+		return nil, false
+	}
+	return &date, true
+}
@@ -0,0 +1,138 @@
+package medicalrecords
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"healthcare_backend/pkg/config"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type AccessLogHandler struct {
+	accessLogService *medicalRecordsService.AccessLogService
+	config           *config.Config
+}
+
+func NewAccessLogHandler(db *pgxpool.Pool, cfg *config.Config) *AccessLogHandler {
+	return &AccessLogHandler{
+		accessLogService: medicalRecordsService.NewAccessLogService(db),
+		config:           cfg,
+	}
+}
+
+func (h *AccessLogHandler) GetWhoViewedMyRecords(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if c.GetString("userType") != "patient" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only patients can view who accessed their records"})
+		return
+	}
+
+	limit := 100
+	offset := 0
+	if v := c.Query("limit"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			limit = parsed
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			offset = parsed
+		}
+	}
+	includeSelf := c.Query("include_self") == "true"
+
+	entries, err := h.accessLogService.GetAccessReportForOwner(callerUserID.(string), includeSelf, limit, offset)
+	if err != nil {
+		log.Printf("Error getting access report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve access report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+func (h *AccessLogHandler) ExportMyAccessTrail(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if c.GetString("userType") != "doctor" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors can export their access trail"})
+		return
+	}
+
+	var from, to time.Time
+	if v := c.Query("from"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		from = parsed
+	}
+	if v := c.Query("to"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+
+	entries, err := h.accessLogService.GetAccessTrail(callerUserID.(string), from, to)
+	if err != nil {
+		log.Printf("Error exporting access trail: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export access trail"})
+		return
+	}
+
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+
+	filename := fmt.Sprintf("access_trail_%s.csv", time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Header("Content-Type", "text/csv")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"accessed_at", "action", "access_path", "item_id", "item_name", "owner_id", "owner_name", "ip_address", "user_agent"})
+	for _, entry := range entries {
+		var ipAddress, userAgent string
+		if entry.IPAddress != nil {
+			ipAddress = *entry.IPAddress
+		}
+		if entry.UserAgent != nil {
+			userAgent = *entry.UserAgent
+		}
+		writer.Write([]string{
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			string(entry.Action),
+			entry.AccessPath,
+			entry.ItemID,
+			entry.ItemName,
+			entry.OwnerID,
+			entry.OwnerName,
+			ipAddress,
+			userAgent,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Error writing access trail CSV: %v", err)
+	}
+}
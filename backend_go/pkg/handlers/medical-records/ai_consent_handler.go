@@ -0,0 +1,154 @@
+package medicalrecords
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"healthcare_backend/pkg/config"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type AIConsentHandler struct {
+	consentService *medicalRecordsService.AIConsentService
+	config         *config.Config
+}
+
+func NewAIConsentHandler(db *pgxpool.Pool, cfg *config.Config) *AIConsentHandler {
+	return &AIConsentHandler{
+		consentService: medicalRecordsService.NewAIConsentService(db),
+		config:         cfg,
+	}
+}
+
+type aiConsentRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// GetAIConsent returns whether the AI assistant may use the patient's
+// clinical documents, and which documents they excluded.
+func (h *AIConsentHandler) GetAIConsent(c *gin.Context) {
+	patientID, ok := consentPatient(c)
+	if !ok {
+		return
+	}
+
+	settings, err := h.consentService.GetConsent(patientID)
+	if err != nil {
+		log.Printf("Error getting AI consent: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve AI consent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// SetAIConsent turns AI use of all the patient's documents on or off.
+func (h *AIConsentHandler) SetAIConsent(c *gin.Context) {
+	patientID, ok := consentPatient(c)
+	if !ok {
+		return
+	}
+
+	var request aiConsentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Printf("Error parsing JSON: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := h.consentService.SetPatientConsent(patientID, *request.Enabled, newAccessContext(c, "")); err != nil {
+		log.Printf("Error setting AI consent: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update AI consent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "AI consent updated", "rag_enabled": *request.Enabled})
+}
+
+// SetDocumentAIConsent includes or excludes one clinical document.
+func (h *AIConsentHandler) SetDocumentAIConsent(c *gin.Context) {
+	patientID, ok := consentPatient(c)
+	if !ok {
+		return
+	}
+
+	itemID := c.Param("itemId")
+	if _, err := uuid.Parse(itemID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
+		return
+	}
+
+	var request aiConsentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Printf("Error parsing JSON: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	err := h.consentService.SetDocumentConsent(patientID, itemID, *request.Enabled, newAccessContext(c, ""))
+	if err != nil {
+		switch {
+		case errors.Is(err, medicalRecordsService.ErrItemNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		case errors.Is(err, medicalRecordsService.ErrNotClinical):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only clinical documents are used by the AI assistant"})
+		default:
+			log.Printf("Error setting document AI consent: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update AI consent"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "AI consent updated", "item_id": itemID, "enabled": *request.Enabled})
+}
+
+// GetAIConsentAudit lists the patient's consent changes.
+func (h *AIConsentHandler) GetAIConsentAudit(c *gin.Context) {
+	patientID, ok := consentPatient(c)
+	if !ok {
+		return
+	}
+
+	limit := 100
+	offset := 0
+	if v := c.Query("limit"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			limit = parsed
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			offset = parsed
+		}
+	}
+
+	changes, err := h.consentService.GetAuditTrail(patientID, limit, offset)
+	if err != nil {
+		log.Printf("Error getting AI consent audit trail: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve AI consent history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"changes": changes})
+}
+
+// consentPatient returns the calling patient. Consent is the patient's own
+// decision, so no other role can change it.
+func consentPatient(c *gin.Context) (string, bool) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return "", false
+	}
+	if c.GetString("userType") != "patient" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only patients can manage AI consent"})
+		return "", false
+	}
+	return callerUserID.(string), true
+}
@@ -0,0 +1,162 @@
+package medicalrecords
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"healthcare_backend/pkg/filecheck"
+	"healthcare_backend/pkg/models"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SaveChatAttachment files an attachment from a chat in a patient's records.
+// Doctors and receptionists add it to the patient's medical records as a
+// clinical document, with the same metadata and relationship rules as a
+// clinical upload. Patients save it to their own records, optionally into
+// one of their folders.
+func (h *ClinicalRecordsHandler) SaveChatAttachment(c *gin.Context) {
+	callerUserID := c.GetString("userId")
+	if callerUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	callerUserType := c.GetString("userType")
+
+	var request struct {
+		PatientID      string `json:"patientId"`
+		ParentFolderID string `json:"parentFolderId"`
+		TemplateID     string `json:"templateId"`
+		Category       string `json:"category"`
+		FolderName     string `json:"folderName"`
+		BodyPart       string `json:"bodyPart"`
+		StudyDate      string `json:"studyDate"`
+		CollectionDate string `json:"collectionDate"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var fileInfo models.FileFolder
+	var folderName string
+	uploadedByRole := callerUserType
+	fileInfo.UploadedByUserID = &callerUserID
+	fileInfo.UploadedByRole = &uploadedByRole
+
+	switch callerUserType {
+	case "patient":
+		if request.PatientID != "" && request.PatientID != callerUserID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Patients can only save to their own records"})
+			return
+		}
+		if request.ParentFolderID != "" {
+			if !h.ownsFolder(c, callerUserID, request.ParentFolderID) {
+				return
+			}
+			fileInfo.ParentID = &request.ParentFolderID
+		}
+		fileInfo.UserID = callerUserID
+		fileInfo.UserType = "patient"
+		fileInfo.FolderType = models.FolderTypePersonal
+
+	case "doctor", "receptionist":
+		if request.PatientID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Patient ID is required for clinical documents"})
+			return
+		}
+		effectiveDoctorID, err := h.medicalRecordsService.CareTeamDoctor(callerUserID, callerUserType, request.PatientID)
+		if respondCareTeamError(c, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("SaveChatAttachment: failed to check the care team: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate doctor-patient relationship"})
+			return
+		}
+
+		metadata, err := h.medicalRecordsService.ResolveClinicalUpload(effectiveDoctorID, medicalRecordsService.ClinicalUploadForm{
+			TemplateID:     request.TemplateID,
+			Category:       request.Category,
+			FolderName:     request.FolderName,
+			BodyPart:       request.BodyPart,
+			StudyDate:      request.StudyDate,
+			CollectionDate: request.CollectionDate,
+		})
+		if err != nil {
+			var metadataErr *medicalRecordsService.MetadataError
+			if errors.As(err, &metadataErr) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document metadata", "fields": metadataErr.Fields})
+				return
+			}
+			log.Printf("SaveChatAttachment: failed to resolve upload metadata: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save chat attachment"})
+			return
+		}
+		category := metadata.Category
+		folderName = metadata.FolderName
+
+		fileInfo.FolderType = models.FolderTypeClinical
+		fileInfo.Category = &category
+		fileInfo.BodyPart = metadata.BodyPart
+		fileInfo.StudyDate = metadata.StudyDate
+		fileInfo.PatientID = &request.PatientID
+		fileInfo.OwnerUserID = &request.PatientID
+		fileInfo.UserID = request.PatientID
+		fileInfo.UserType = "patient"
+
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	if err := h.medicalRecordsService.SaveChatAttachment(c.Param("attachmentId"), &fileInfo, folderName); err != nil {
+		if respondQuotaExceeded(c, err) || respondInvalidMetadata(c, err) {
+			return
+		}
+		var rejection *filecheck.Rejection
+		switch {
+		case errors.Is(err, medicalRecordsService.ErrChatAttachmentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.As(err, &rejection):
+			c.JSON(rejection.HTTPStatus(), gin.H{"error": "Upload rejected", "rejection": rejection})
+		default:
+			log.Printf("Error saving chat attachment %s: %v", c.Param("attachmentId"), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save chat attachment"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Chat attachment saved to medical records",
+		"documentId": fileInfo.ID,
+		"patientId":  fileInfo.UserID,
+	})
+}
+
+// ownsFolder checks that folderID is one of the caller's own folders, and
+// answers the request when it is not.
+func (h *ClinicalRecordsHandler) ownsFolder(c *gin.Context, callerUserID, folderID string) bool {
+	if _, err := uuid.Parse(folderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parentFolderId"})
+		return false
+	}
+	var ownerID string
+	var sharedByID sql.NullString
+	err := h.db.QueryRow(context.Background(),
+		"SELECT user_id, shared_by_id FROM folder_file_info WHERE id = $1 AND type = 'folder'", folderID).Scan(&ownerID, &sharedByID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parentFolderId"})
+		return false
+	}
+	if ownerID != callerUserID || sharedByID.Valid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,273 @@
+package medicalrecords
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/filecheck"
+	"healthcare_backend/pkg/models"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type ChunkedUploadHandler struct {
+	db                   *pgxpool.Pool
+	chunkedUploadService *medicalRecordsService.ChunkedUploadService
+	config               *config.Config
+}
+
+func NewChunkedUploadHandler(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *ChunkedUploadHandler {
+	return &ChunkedUploadHandler{
+		db:                   db,
+		chunkedUploadService: medicalRecordsService.NewChunkedUploadService(db, cfg, store),
+		config:               cfg,
+	}
+}
+
+type initiateUploadRequest struct {
+	FileName       string `json:"file_name" binding:"required"`
+	FileType       string `json:"file_type"`
+	FileExt        string `json:"file_ext"`
+	ContentType    string `json:"content_type"`
+	TotalSize      int64  `json:"total_size" binding:"required"`
+	ChunkSize      int64  `json:"chunk_size"`
+	ParentFolderID string `json:"parent_folder_id"`
+	FolderType     string `json:"folder_type"`
+	Category       string `json:"category"`
+	BodyPart       string `json:"body_part"`
+	StudyDate      string `json:"study_date"`
+	PatientID      string `json:"patient_id"`
+	TemplateID     string `json:"template_id"`
+	FolderName     string `json:"folder_name"`
+	CollectionDate string `json:"collection_date"`
+}
+
+func (h *ChunkedUploadHandler) InitiateUpload(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	callerUserType := c.GetString("userType")
+
+	if callerUserType == "receptionist" {
+		var assignedDoctorID sql.NullString
+		err := h.db.QueryRow(context.Background(), "SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1", callerUserID.(string)).Scan(&assignedDoctorID)
+		if err != nil {
+			log.Printf("InitiateUpload: failed to verify receptionist assignment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify receptionist assignment"})
+			return
+		}
+		if !assignedDoctorID.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Receptionist has no assigned doctor"})
+			return
+		}
+	}
+
+	var req initiateUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if callerUserType == "patient" {
+		if models.FolderType(req.FolderType) == models.FolderTypeClinical {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and receptionists can upload clinical documents"})
+			return
+		}
+		if req.PatientID != "" && req.PatientID != callerUserID.(string) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Patients can only upload to their own records"})
+			return
+		}
+	}
+
+	upload := models.ChunkedUpload{
+		UserID:      callerUserID.(string),
+		UserType:    callerUserType,
+		FileName:    req.FileName,
+		FileType:    req.FileType,
+		Ext:         &req.FileExt,
+		ContentType: req.ContentType,
+		TotalSize:   req.TotalSize,
+		ChunkSize:   req.ChunkSize,
+		FolderType:  models.FolderType(req.FolderType),
+	}
+
+	if req.ParentFolderID != "" {
+		if _, err := uuid.Parse(req.ParentFolderID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent_folder_id"})
+			return
+		}
+		var parentOwnerID string
+		var sharedByID sql.NullString
+		perr := h.db.QueryRow(context.Background(), "SELECT user_id, shared_by_id FROM folder_file_info WHERE id = $1", req.ParentFolderID).Scan(&parentOwnerID, &sharedByID)
+		if perr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent_folder_id"})
+			return
+		}
+		if parentOwnerID != callerUserID.(string) || sharedByID.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		upload.ParentID = &req.ParentFolderID
+	}
+
+	if req.PatientID != "" {
+		if _, err := uuid.Parse(req.PatientID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient_id"})
+			return
+		}
+		upload.PatientID = &req.PatientID
+	}
+
+	// Clinical metadata is resolved and validated by the service, as for
+	// direct clinical uploads.
+	var clinical *medicalRecordsService.ClinicalUploadForm
+	if upload.FolderType == models.FolderTypeClinical {
+		clinical = &medicalRecordsService.ClinicalUploadForm{
+			TemplateID:     req.TemplateID,
+			Category:       req.Category,
+			FolderName:     req.FolderName,
+			BodyPart:       req.BodyPart,
+			StudyDate:      req.StudyDate,
+			CollectionDate: req.CollectionDate,
+		}
+	} else {
+		if req.Category != "" {
+			category := models.Category(req.Category)
+			upload.Category = &category
+		}
+		if req.BodyPart != "" {
+			upload.BodyPart = &req.BodyPart
+		}
+		if req.StudyDate != "" {
+			studyDate, err := time.Parse("2006-01-02", req.StudyDate)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid study_date, expected YYYY-MM-DD"})
+				return
+			}
+			upload.StudyDate = &studyDate
+		}
+	}
+
+	if err := h.chunkedUploadService.InitiateUpload(&upload, clinical); err != nil {
+		h.respondUploadError(c, "Failed to start upload", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, upload)
+}
+
+// UploadChunk accepts the raw bytes of one chunk. Clients must send the
+// chunk's hex SHA-256 in X-Chunk-SHA256 so corrupted chunks can be re-sent.
+func (h *ChunkedUploadHandler) UploadChunk(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	chunkNumber, err := strconv.Atoi(c.Param("chunkNumber"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chunk number"})
+		return
+	}
+
+	checksum := c.GetHeader("X-Chunk-SHA256")
+	if len(checksum) != 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-Chunk-SHA256 header with the chunk's hex SHA-256 is required"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, 100<<20)
+	defer body.Close()
+
+	chunk, err := h.chunkedUploadService.UploadChunk(c.Param("uploadId"), callerUserID.(string), chunkNumber, body, checksum)
+	if err != nil {
+		h.respondUploadError(c, "Failed to upload chunk", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, chunk)
+}
+
+func (h *ChunkedUploadHandler) GetUploadStatus(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	upload, err := h.chunkedUploadService.GetUpload(c.Param("uploadId"), callerUserID.(string))
+	if err != nil {
+		h.respondUploadError(c, "Failed to retrieve upload", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, upload)
+}
+
+func (h *ChunkedUploadHandler) CompleteUpload(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	file, err := h.chunkedUploadService.CompleteUpload(c.Param("uploadId"), callerUserID.(string))
+	if err != nil {
+		h.respondUploadError(c, "Failed to complete upload", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "File uploaded successfully", "file": file})
+}
+
+func (h *ChunkedUploadHandler) AbortUpload(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.chunkedUploadService.AbortUpload(c.Param("uploadId"), callerUserID.(string)); err != nil {
+		h.respondUploadError(c, "Failed to abort upload", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload aborted"})
+}
+
+func (h *ChunkedUploadHandler) respondUploadError(c *gin.Context, message string, err error) {
+	if respondQuotaExceeded(c, err) || respondInvalidMetadata(c, err) || respondCareTeamError(c, err) {
+		return
+	}
+
+	var rejection *filecheck.Rejection
+	switch {
+	case errors.As(err, &rejection):
+		c.JSON(rejection.HTTPStatus(), gin.H{"error": "Upload rejected", "rejection": rejection})
+	case errors.Is(err, medicalRecordsService.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+	case errors.Is(err, medicalRecordsService.ErrUploadClosed),
+		errors.Is(err, medicalRecordsService.ErrUploadIncomplete):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, medicalRecordsService.ErrInvalidUpload),
+		errors.Is(err, medicalRecordsService.ErrInvalidChunk),
+		errors.Is(err, medicalRecordsService.ErrChunkChecksumMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -3,13 +3,16 @@ package medicalrecords
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 
 	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/filecheck"
 	"healthcare_backend/pkg/models"
 	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+	"healthcare_backend/pkg/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -17,13 +20,15 @@ import (
 
 type ClinicalRecordsHandler struct {
 	medicalRecordsService *medicalRecordsService.MedicalRecordsService
+	accessLogService      *medicalRecordsService.AccessLogService
 	config                *config.Config
 	db                    *pgxpool.Pool
 }
 
-func NewClinicalRecordsHandler(db *pgxpool.Pool, cfg *config.Config) *ClinicalRecordsHandler {
+func NewClinicalRecordsHandler(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *ClinicalRecordsHandler {
 	return &ClinicalRecordsHandler{
-		medicalRecordsService: medicalRecordsService.NewMedicalRecordsService(db, cfg),
+		medicalRecordsService: medicalRecordsService.NewMedicalRecordsService(db, cfg, store),
+		accessLogService:      medicalRecordsService.NewAccessLogService(db),
 		config:                cfg,
 		db:                    db,
 	}
@@ -52,6 +57,16 @@ func (h *ClinicalRecordsHandler) GetMedicalRecordsByCategory(c *gin.Context) {
 		return
 	}
 
+	recordIDs := make([]string, 0, len(records))
+	for _, record := range records {
+		recordIDs = append(recordIDs, record.ID)
+	}
+	if err := h.accessLogService.RecordAccess(recordIDs, models.AccessActionView, newAccessContext(c, models.AccessPathCategoryListing)); err != nil {
+		log.Printf("Error recording medical records listing access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve medical records"})
+		return
+	}
+
 	c.JSON(http.StatusOK, records)
 }
 
@@ -121,22 +136,6 @@ func (h *ClinicalRecordsHandler) UploadAndShareClinicalDocument(c *gin.Context)
 		return
 	}
 
-	effectiveDoctorID := callerUserID.(string)
-	if callerUserType == "receptionist" {
-		var assignedDoctorID sql.NullString
-		err := h.db.QueryRow(context.Background(), "SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1", callerUserID.(string)).Scan(&assignedDoctorID)
-		if err != nil {
-			log.Printf("UploadAndShareClinicalDocument: failed to verify receptionist assignment: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify receptionist assignment"})
-			return
-		}
-		if !assignedDoctorID.Valid {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Receptionist has no assigned doctor"})
-			return
-		}
-		effectiveDoctorID = assignedDoctorID.String
-	}
-
 	err := c.Request.ParseMultipartForm(10 << 20)
 	if err != nil {
 		log.Printf("Error parsing multipart form: %v", err)
@@ -153,39 +152,41 @@ func (h *ClinicalRecordsHandler) UploadAndShareClinicalDocument(c *gin.Context)
 	defer file.Close()
 
 	patientID := c.Request.FormValue("patient_id")
-	category := c.Request.FormValue("category")
-	folderName := c.Request.FormValue("folder_name")
-
 	if patientID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Patient ID is required for clinical documents"})
 		return
 	}
 
-	if category == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Category is required for clinical documents"})
+	effectiveDoctorID, err := h.medicalRecordsService.CareTeamDoctor(callerUserID.(string), callerUserType, patientID)
+	if respondCareTeamError(c, err) {
 		return
 	}
-
-	var hasRelationship bool
-	err = h.db.QueryRow(
-		context.Background(),
-		`SELECT EXISTS(
-			SELECT 1
-			FROM appointments
-			WHERE doctor_id = $1 AND patient_id = $2 AND COALESCE(is_doctor_patient, false) = false
-		)`,
-		effectiveDoctorID,
-		patientID,
-	).Scan(&hasRelationship)
 	if err != nil {
-		log.Printf("UploadAndShareClinicalDocument: failed to validate doctor-patient relationship: %v", err)
+		log.Printf("UploadAndShareClinicalDocument: failed to check the care team: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate doctor-patient relationship"})
 		return
 	}
-	if !hasRelationship {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+
+	metadata, err := h.medicalRecordsService.ResolveClinicalUpload(effectiveDoctorID, medicalRecordsService.ClinicalUploadForm{
+		TemplateID:     c.Request.FormValue("template_id"),
+		Category:       c.Request.FormValue("category"),
+		FolderName:     c.Request.FormValue("folder_name"),
+		BodyPart:       c.Request.FormValue("body_part"),
+		StudyDate:      c.Request.FormValue("study_date"),
+		CollectionDate: c.Request.FormValue("collection_date"),
+	})
+	if err != nil {
+		var metadataErr *medicalRecordsService.MetadataError
+		if errors.As(err, &metadataErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document metadata", "fields": metadataErr.Fields})
+			return
+		}
+		log.Printf("UploadAndShareClinicalDocument: failed to resolve upload metadata: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload clinical document"})
 		return
 	}
+	category := metadata.Category
+	folderName := metadata.FolderName
 
 	uploadedByUserID := callerUserID.(string)
 	uploadedByRole := callerUserType
@@ -206,8 +207,9 @@ func (h *ClinicalRecordsHandler) UploadAndShareClinicalDocument(c *gin.Context)
 	fileInfo.Ext = &ext
 
 	fileInfo.FolderType = models.FolderTypeClinical
-	categoryEnum := models.Category(category)
-	fileInfo.Category = &categoryEnum
+	fileInfo.Category = &category
+	fileInfo.BodyPart = metadata.BodyPart
+	fileInfo.StudyDate = metadata.StudyDate
 	fileInfo.PatientID = &patientID
 	fileInfo.OwnerUserID = &patientID
 	fileInfo.UploadedByUserID = &uploadedByUserID
@@ -216,6 +218,14 @@ func (h *ClinicalRecordsHandler) UploadAndShareClinicalDocument(c *gin.Context)
 	fileInfo.UserType = "patient"
 
 	if err := h.medicalRecordsService.ShareDocumentToPatient(&fileInfo, file, folderName, handler.Size, handler.Header.Get("Content-Type")); err != nil {
+		if respondInvalidMetadata(c, err) {
+			return
+		}
+		var rejection *filecheck.Rejection
+		if errors.As(err, &rejection) {
+			c.JSON(rejection.HTTPStatus(), gin.H{"error": "Upload rejected", "rejection": rejection})
+			return
+		}
 		log.Printf("Error uploading clinical document: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload clinical document"})
 		return
@@ -232,17 +242,28 @@ func (h *ClinicalRecordsHandler) UploadAndShareClinicalDocument(c *gin.Context)
 func (h *ClinicalRecordsHandler) GetCategoriesForRole(c *gin.Context) {
 	userType := c.Query("user_type")
 
-	categories := []map[string]string{
-		{"value": string(models.CategoryLabResults), "label": models.CategoryLabResults.GetDisplayName()},
-		{"value": string(models.CategoryImagingCT), "label": models.CategoryImagingCT.GetDisplayName()},
-		{"value": string(models.CategoryImagingXray), "label": models.CategoryImagingXray.GetDisplayName()},
-		{"value": string(models.CategoryImagingUS), "label": models.CategoryImagingUS.GetDisplayName()},
-		{"value": string(models.CategoryImagingMammo), "label": models.CategoryImagingMammo.GetDisplayName()},
-		{"value": string(models.CategoryImagingMRI), "label": models.CategoryImagingMRI.GetDisplayName()},
-		{"value": string(models.CategoryImagingPET), "label": models.CategoryImagingPET.GetDisplayName()},
-		{"value": string(models.CategoryClinicalReport), "label": models.CategoryClinicalReport.GetDisplayName()},
-		{"value": string(models.CategoryDischarge), "label": models.CategoryDischarge.GetDisplayName()},
-		{"value": string(models.CategoryOther), "label": models.CategoryOther.GetDisplayName()},
+	categories := []gin.H{}
+	for _, category := range []models.Category{
+		models.CategoryLabResults,
+		models.CategoryImagingCT,
+		models.CategoryImagingXray,
+		models.CategoryImagingUS,
+		models.CategoryImagingMammo,
+		models.CategoryImagingMRI,
+		models.CategoryImagingPET,
+		models.CategoryClinicalReport,
+		models.CategoryDischarge,
+		models.CategoryOther,
+	} {
+		requiredFields := medicalRecordsService.RequiredUploadFields(category)
+		if requiredFields == nil {
+			requiredFields = []string{}
+		}
+		categories = append(categories, gin.H{
+			"value":          string(category),
+			"label":          category.GetDisplayName(),
+			"requiredFields": requiredFields,
+		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -250,3 +271,17 @@ func (h *ClinicalRecordsHandler) GetCategoriesForRole(c *gin.Context) {
 		"userType":   userType,
 	})
 }
+
+// respondCareTeamError answers 403 when err denies the caller access to a
+// patient's records, and reports whether it did.
+func respondCareTeamError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, medicalRecordsService.ErrNoAssignedDoctor):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Receptionist has no assigned doctor"})
+	case errors.Is(err, medicalRecordsService.ErrNotCareTeam):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,208 @@
+package medicalrecords
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/filecheck"
+	"healthcare_backend/pkg/models"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type DicomHandler struct {
+	db               *pgxpool.Pool
+	dicomService     *medicalRecordsService.DicomService
+	accessLogService *medicalRecordsService.AccessLogService
+	config           *config.Config
+}
+
+func NewDicomHandler(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *DicomHandler {
+	return &DicomHandler{
+		db:               db,
+		dicomService:     medicalRecordsService.NewDicomService(db, cfg, store),
+		accessLogService: medicalRecordsService.NewAccessLogService(db),
+		config:           cfg,
+	}
+}
+
+// ImportStudy accepts a DICOM file or zipped study as multipart "file", or
+// the file_id of an already uploaded archive, and files it into study and
+// series folders.
+func (h *DicomHandler) ImportStudy(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	callerUserType := c.GetString("userType")
+
+	if callerUserType == "receptionist" {
+		var assignedDoctorID sql.NullString
+		err := h.db.QueryRow(context.Background(), "SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1", callerUserID.(string)).Scan(&assignedDoctorID)
+		if err != nil {
+			log.Printf("ImportStudy: failed to verify receptionist assignment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify receptionist assignment"})
+			return
+		}
+		if !assignedDoctorID.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Receptionist has no assigned doctor"})
+			return
+		}
+	}
+
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		log.Printf("Error parsing multipart form: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse multipart form"})
+		return
+	}
+
+	target := medicalRecordsService.DicomImportTarget{
+		UserID:     callerUserID.(string),
+		UserType:   callerUserType,
+		FolderType: models.FolderTypePersonal,
+	}
+
+	if callerUserType == "patient" {
+		target.PatientID = &target.UserID
+	} else if patientID := c.Request.FormValue("patient_id"); patientID != "" {
+		if _, err := uuid.Parse(patientID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient_id"})
+			return
+		}
+		target.PatientID = &patientID
+	}
+
+	if folderType := c.Request.FormValue("folder_type"); folderType != "" {
+		target.FolderType = models.FolderType(folderType)
+		if target.FolderType != models.FolderTypePersonal && target.FolderType != models.FolderTypeClinical {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder_type"})
+			return
+		}
+		if target.FolderType == models.FolderTypeClinical && callerUserType == "patient" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and receptionists can upload clinical documents"})
+			return
+		}
+	}
+
+	if parentFolderID := c.Request.FormValue("parentFolderId"); parentFolderID != "" {
+		if _, err := uuid.Parse(parentFolderID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parentFolderId"})
+			return
+		}
+		var parentOwnerID, parentType string
+		var sharedByID sql.NullString
+		perr := h.db.QueryRow(context.Background(), "SELECT user_id, type, shared_by_id FROM folder_file_info WHERE id = $1", parentFolderID).Scan(&parentOwnerID, &parentType, &sharedByID)
+		if perr != nil || parentType != "folder" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parentFolderId"})
+			return
+		}
+		if parentOwnerID != target.UserID || sharedByID.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		target.ParentID = &parentFolderID
+	}
+
+	var result *models.DicomImportResult
+	var err error
+	if fileID := c.Request.FormValue("file_id"); fileID != "" {
+		if _, perr := uuid.Parse(fileID); perr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file_id"})
+			return
+		}
+		result, err = h.dicomService.ImportStoredFile(target, fileID)
+	} else {
+		file, header, ferr := c.Request.FormFile("file")
+		if ferr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Either file or file_id is required"})
+			return
+		}
+		defer file.Close()
+		result, err = h.dicomService.Import(target, header.Filename, file, header.Size)
+	}
+
+	if err != nil {
+		if respondQuotaExceeded(c, err) || respondInvalidMetadata(c, err) || respondCareTeamError(c, err) {
+			return
+		}
+		var rejection *filecheck.Rejection
+		switch {
+		case errors.As(err, &rejection):
+			c.JSON(rejection.HTTPStatus(), gin.H{"error": "Upload rejected", "rejection": rejection})
+		case errors.Is(err, medicalRecordsService.ErrDicomPatientRequired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, medicalRecordsService.ErrNoDicomInstances):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		case errors.Is(err, medicalRecordsService.ErrDicomPatientMismatch):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, medicalRecordsService.ErrDicomPatientNotFound),
+			errors.Is(err, medicalRecordsService.ErrDicomSourceNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error importing DICOM study: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import DICOM study"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetStudy returns study-level metadata and its series. The study can be
+// addressed by its ID or by the ID of its study or series folder.
+func (h *DicomHandler) GetStudy(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	studyID := c.Param("studyId")
+	if folderID := c.Param("folderId"); folderID != "" {
+		var err error
+		studyID, err = h.dicomService.GetStudyIDByFolder(folderID)
+		if err != nil {
+			h.respondStudyError(c, err)
+			return
+		}
+	}
+
+	study, err := h.dicomService.GetStudy(studyID)
+	if err != nil {
+		h.respondStudyError(c, err)
+		return
+	}
+
+	isOwner := study.UserID == callerUserID.(string)
+	isPatient := study.PatientID != nil && *study.PatientID == callerUserID.(string)
+	if !isOwner && !isPatient {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	if err := h.accessLogService.RecordAccess([]string{study.FolderID}, models.AccessActionView, newAccessContext(c, models.AccessPathDicomStudy)); err != nil {
+		log.Printf("Error recording view of DICOM study %s: %v", study.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve study"})
+		return
+	}
+
+	c.JSON(http.StatusOK, study)
+}
+
+func (h *DicomHandler) respondStudyError(c *gin.Context, err error) {
+	if errors.Is(err, medicalRecordsService.ErrDicomStudyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Study not found"})
+		return
+	}
+	log.Printf("Error retrieving DICOM study: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve study"})
+}
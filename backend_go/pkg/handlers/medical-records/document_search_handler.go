@@ -0,0 +1,131 @@
+package medicalrecords
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/models"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const maxSearchQueryLength = 200
+
+type DocumentSearchHandler struct {
+	searchService    *medicalRecordsService.DocumentSearchService
+	accessLogService *medicalRecordsService.AccessLogService
+	config           *config.Config
+}
+
+func NewDocumentSearchHandler(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *DocumentSearchHandler {
+	return &DocumentSearchHandler{
+		searchService:    medicalRecordsService.NewDocumentSearchService(db, cfg, store),
+		accessLogService: medicalRecordsService.NewAccessLogService(db),
+		config:           cfg,
+	}
+}
+
+// SearchDocuments runs a full-text query over the text of the caller's
+// documents. Optional filters: patient_id, category, body_part, and from/to
+// dates (YYYY-MM-DD, inclusive) matched against the study date or, without
+// one, the upload date.
+func (h *DocumentSearchHandler) SearchDocuments(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	callerUserType := c.GetString("userType")
+
+	req := models.DocumentSearchRequest{
+		Query:     c.Query("q"),
+		PatientID: c.Query("patient_id"),
+		Category:  c.Query("category"),
+		BodyPart:  c.Query("body_part"),
+	}
+	if req.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+	if len(req.Query) > maxSearchQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is too long"})
+		return
+	}
+	if req.PatientID != "" {
+		if _, err := uuid.Parse(req.PatientID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+			return
+		}
+		if callerUserType == "patient" && req.PatientID != callerUserID.(string) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Patients can only search their own medical records"})
+			return
+		}
+	}
+
+	var ok bool
+	if req.From, ok = parseSearchDate(c, "from"); !ok {
+		return
+	}
+	if req.To, ok = parseSearchDate(c, "to"); !ok {
+		return
+	}
+	if req.To != nil {
+		// The end date is inclusive, so the range runs to the next midnight.
+		next := req.To.AddDate(0, 0, 1)
+		req.To = &next
+	}
+
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if req.Limit, err = strconv.Atoi(limit); err != nil || req.Limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+	if offset := c.Query("offset"); offset != "" {
+		if req.Offset, err = strconv.Atoi(offset); err != nil || req.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	results, err := h.searchService.Search(callerUserID.(string), callerUserType, req)
+	if err != nil {
+		log.Printf("Error searching documents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search documents"})
+		return
+	}
+
+	// Snippets disclose document content, so results count as views.
+	resultIDs := make([]string, 0, len(results))
+	for _, result := range results {
+		resultIDs = append(resultIDs, result.ID)
+	}
+	if err := h.accessLogService.RecordAccess(resultIDs, models.AccessActionView, newAccessContext(c, models.AccessPathSearch)); err != nil {
+		log.Printf("Error recording search access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search documents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func parseSearchDate(c *gin.Context, param string) (*time.Time, bool) {
+	value := c.Query(param)
+	if value == "" {
+		return nil, true
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " date, expected YYYY-MM-DD"})
+		return nil, false
+	}
+	return &date, true
+}
@@ -0,0 +1,166 @@
+package medicalrecords
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"healthcare_backend/pkg/config"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type ImportHandler struct {
+	db            *pgxpool.Pool
+	importService *medicalRecordsService.ImportService
+	config        *config.Config
+}
+
+func NewImportHandler(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *ImportHandler {
+	return &ImportHandler{
+		db:            db,
+		importService: medicalRecordsService.NewImportService(db, cfg, store),
+		config:        cfg,
+	}
+}
+
+// ImportArchive queues the import of the zip sent as the "file" form field
+// under the optional parentFolderId. The archive is stored and imported in
+// the background; the response is the pending import, whose progress and
+// per-entry report are polled with GetImport. Sending the same archive to the
+// same folder again resumes an interrupted import.
+func (h *ImportHandler) ImportArchive(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	callerUserType := c.GetString("userType")
+
+	if callerUserType == "receptionist" {
+		var assignedDoctorID sql.NullString
+		err := h.db.QueryRow(context.Background(), "SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1", callerUserID.(string)).Scan(&assignedDoctorID)
+		if err != nil {
+			log.Printf("ImportArchive: failed to verify receptionist assignment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify receptionist assignment"})
+			return
+		}
+		if !assignedDoctorID.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Receptionist has no assigned doctor"})
+			return
+		}
+	}
+
+	maxArchiveSize := int64(h.config.ImportMaxMB) << 20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxArchiveSize+1<<20)
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Archive is too large"})
+			return
+		}
+		log.Printf("Error parsing multipart form: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse multipart form"})
+		return
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		log.Printf("Error retrieving file from request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not get file from request"})
+		return
+	}
+	defer file.Close()
+	if header.Size > maxArchiveSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Archive is too large"})
+		return
+	}
+
+	var parentID *string
+	if parentFolderID := c.Request.FormValue("parentFolderId"); parentFolderID != "" {
+		if _, err := uuid.Parse(parentFolderID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parentFolderId"})
+			return
+		}
+		var parentOwnerID, parentType string
+		var sharedByID sql.NullString
+		err := h.db.QueryRow(context.Background(), "SELECT user_id, type, shared_by_id FROM folder_file_info WHERE id = $1", parentFolderID).Scan(&parentOwnerID, &parentType, &sharedByID)
+		if err != nil || parentType != "folder" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parentFolderId"})
+			return
+		}
+		if parentOwnerID != callerUserID.(string) || sharedByID.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		parentID = &parentFolderID
+	}
+
+	report, err := h.importService.Queue(callerUserID.(string), callerUserType, parentID, header.Filename, file, header.Size)
+	if err != nil {
+		switch {
+		case errors.Is(err, medicalRecordsService.ErrInvalidArchive):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, medicalRecordsService.ErrImportInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": "This archive is already being imported into this folder"})
+		default:
+			log.Printf("Error queuing archive import: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import archive"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, report)
+}
+
+// ListImports returns the caller's recent imports without their entries.
+func (h *ImportHandler) ListImports(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	imports, err := h.importService.List(callerUserID.(string))
+	if err != nil {
+		log.Printf("Error listing imports: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve imports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imports": imports})
+}
+
+// GetImport returns one of the caller's imports with its per-entry report.
+func (h *ImportHandler) GetImport(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	importID := c.Param("importId")
+	if _, err := uuid.Parse(importID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import ID"})
+		return
+	}
+
+	report, err := h.importService.Get(callerUserID.(string), importID)
+	if err != nil {
+		if errors.Is(err, medicalRecordsService.ErrImportNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+			return
+		}
+		log.Printf("Error getting import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve import"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
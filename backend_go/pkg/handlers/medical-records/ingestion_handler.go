@@ -0,0 +1,167 @@
+package medicalrecords
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/models"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type IngestionHandler struct {
+	db               *pgxpool.Pool
+	ingestionService *medicalRecordsService.IngestionService
+	accessLogService *medicalRecordsService.AccessLogService
+	config           *config.Config
+}
+
+func NewIngestionHandler(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *IngestionHandler {
+	return &IngestionHandler{
+		db:               db,
+		ingestionService: medicalRecordsService.NewIngestionService(db, cfg, store),
+		accessLogService: medicalRecordsService.NewAccessLogService(db),
+		config:           cfg,
+	}
+}
+
+// GetIngestionStatus lists the RAG ingestion status of the clinical
+// documents the calling doctor shared with a patient.
+func (h *IngestionHandler) GetIngestionStatus(c *gin.Context) {
+	doctorID, patientID, ok := h.authorizePatient(c)
+	if !ok {
+		return
+	}
+
+	jobs, err := h.ingestionService.ListJobs(doctorID, patientID)
+	if err != nil {
+		log.Printf("Error listing ingestion jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve ingestion status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// RetryIngestion re-queues the patient's failed and dead-lettered ingestion
+// jobs, or only the one named by file_id in the body.
+func (h *IngestionHandler) RetryIngestion(c *gin.Context) {
+	doctorID, patientID, ok := h.authorizePatient(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		FileID string `json:"file_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.FileID != "" {
+		if _, err := uuid.Parse(req.FileID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+			return
+		}
+	}
+
+	queued, err := h.ingestionService.Retry(doctorID, patientID, req.FileID)
+	if err != nil {
+		log.Printf("Error retrying ingestion jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry ingestion"})
+		return
+	}
+	if req.FileID != "" && queued == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No failed ingestion job for this file"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ingestion retry queued", "queued": queued})
+}
+
+// DownloadDocument streams a document to the ingestion service through a
+// link it was sent with an ingestion request. The route is not behind user
+// authentication: the link token is the credential.
+func (h *IngestionHandler) DownloadDocument(c *gin.Context) {
+	content, document, err := h.ingestionService.OpenLinkedDocument(c.Param("token"))
+	if err != nil {
+		if errors.Is(err, medicalRecordsService.ErrIngestionLinkNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		log.Printf("Error opening ingestion document: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read document"})
+		return
+	}
+	defer content.Close()
+
+	// The ingestion service reads the document on behalf of the doctor who
+	// requested its ingestion.
+	actx := medicalRecordsService.AccessContext{
+		UserID:     document.DoctorID,
+		UserType:   "doctor",
+		AccessPath: models.AccessPathIngestion,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+	if err := h.accessLogService.RecordAccess([]string{document.FileID}, models.AccessActionDownload, actx); err != nil {
+		log.Printf("Error recording ingestion download of file ID %s: %v", document.FileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read document"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", content, nil)
+}
+
+// authorizePatient checks that the caller is a doctor treating the patient
+// in the route.
+func (h *IngestionHandler) authorizePatient(c *gin.Context) (string, string, bool) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return "", "", false
+	}
+	if c.GetString("userType") != "doctor" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors can manage RAG ingestion"})
+		return "", "", false
+	}
+
+	patientID := c.Param("patientId")
+	if _, err := uuid.Parse(patientID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return "", "", false
+	}
+
+	doctorID := callerUserID.(string)
+	var hasRelationship bool
+	err := h.db.QueryRow(
+		context.Background(),
+		`SELECT EXISTS(
+			SELECT 1
+			FROM appointments
+			WHERE doctor_id = $1 AND patient_id = $2 AND COALESCE(is_doctor_patient, false) = false
+		)`,
+		doctorID,
+		patientID,
+	).Scan(&hasRelationship)
+	if err != nil {
+		log.Printf("Ingestion: failed to validate doctor-patient relationship: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate doctor-patient relationship"})
+		return "", "", false
+	}
+	if !hasRelationship {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return "", "", false
+	}
+
+	return doctorID, patientID, true
+}
@@ -0,0 +1,268 @@
+package medicalrecords
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/models"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const maxLabCSVSize = 5 << 20
+
+type LabObservationHandler struct {
+	db         *pgxpool.Pool
+	labService *medicalRecordsService.LabObservationService
+	config     *config.Config
+}
+
+func NewLabObservationHandler(db *pgxpool.Pool, cfg *config.Config) *LabObservationHandler {
+	return &LabObservationHandler{
+		db:         db,
+		labService: medicalRecordsService.NewLabObservationService(db),
+		config:     cfg,
+	}
+}
+
+// CreateObservations stores lab results a doctor entered for a patient.
+func (h *LabObservationHandler) CreateObservations(c *gin.Context) {
+	patientID, doctorID, ok := h.authorizeLabAccess(c, true)
+	if !ok {
+		return
+	}
+
+	var request models.CreateLabObservationsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Printf("Error parsing JSON: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !validOptionalUUID(c, request.ReportID, "report") || !validOptionalUUID(c, request.FileID, "file") {
+		return
+	}
+
+	observations, err := h.labService.AddObservations(patientID, doctorID, c.GetString("userId"), c.GetString("userType"), request)
+	if err != nil {
+		respondLabError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"observations": observations})
+}
+
+// ImportObservations stores the lab results of a CSV export uploaded as the
+// "file" form field. The optional report_id and file_id form fields link
+// them to a medical report and to the uploaded lab document.
+func (h *LabObservationHandler) ImportObservations(c *gin.Context) {
+	patientID, doctorID, ok := h.authorizeLabAccess(c, true)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxLabCSVSize+1<<20)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		log.Printf("Error retrieving file from request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not get file from request"})
+		return
+	}
+	defer file.Close()
+	if header.Size > maxLabCSVSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "CSV file is too large"})
+		return
+	}
+
+	var reportID, fileID *string
+	if v := c.Request.FormValue("report_id"); v != "" {
+		reportID = &v
+	}
+	if v := c.Request.FormValue("file_id"); v != "" {
+		fileID = &v
+	}
+	if !validOptionalUUID(c, reportID, "report") || !validOptionalUUID(c, fileID, "file") {
+		return
+	}
+
+	observations, err := h.labService.ImportCSV(patientID, doctorID, c.GetString("userId"), c.GetString("userType"), reportID, fileID, file)
+	if err != nil {
+		respondLabError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"imported": len(observations), "observations": observations})
+}
+
+// GetObservations lists a patient's lab results, most recent first.
+// Optional filters: test_code, limit and offset.
+func (h *LabObservationHandler) GetObservations(c *gin.Context) {
+	patientID, _, ok := h.authorizeLabAccess(c, false)
+	if !ok {
+		return
+	}
+
+	limit, offset := 0, 0
+	var err error
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	observations, err := h.labService.ListObservations(patientID, c.Query("test_code"), limit, offset)
+	if err != nil {
+		log.Printf("Error listing lab observations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lab results"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"observations": observations})
+}
+
+// GetSeries returns the history of one test, given as test_code, for
+// charting. Points outside their reference range have out_of_range set.
+// Optional from/to dates (YYYY-MM-DD, inclusive) limit the period.
+func (h *LabObservationHandler) GetSeries(c *gin.Context) {
+	patientID, _, ok := h.authorizeLabAccess(c, false)
+	if !ok {
+		return
+	}
+
+	testCode := c.Query("test_code")
+	if testCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "test_code is required"})
+		return
+	}
+	from, ok := parseSearchDate(c, "from")
+	if !ok {
+		return
+	}
+	to, ok := parseSearchDate(c, "to")
+	if !ok {
+		return
+	}
+	if to != nil {
+		next := to.AddDate(0, 0, 1)
+		to = &next
+	}
+
+	series, err := h.labService.GetSeries(patientID, testCode, from, to)
+	if err != nil {
+		log.Printf("Error getting lab series: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lab results"})
+		return
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+// authorizeLabAccess resolves the patient in the route and the doctor whose
+// care the request falls under. Patients may read their own results;
+// doctors and their receptionists may read the results of the doctor's
+// patients; only doctors may enter results.
+func (h *LabObservationHandler) authorizeLabAccess(c *gin.Context, write bool) (string, string, bool) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return "", "", false
+	}
+	callerUserType := c.GetString("userType")
+
+	patientID := c.Param("patientId")
+	if _, err := uuid.Parse(patientID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient ID"})
+		return "", "", false
+	}
+
+	if write && callerUserType != "doctor" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors can enter lab results"})
+		return "", "", false
+	}
+
+	var doctorID string
+	switch callerUserType {
+	case "patient":
+		if patientID != callerUserID.(string) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Patients can only view their own lab results"})
+			return "", "", false
+		}
+		return patientID, "", true
+	case "doctor":
+		doctorID = callerUserID.(string)
+	case "receptionist":
+		var assignedDoctorID sql.NullString
+		err := h.db.QueryRow(context.Background(), "SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1", callerUserID.(string)).Scan(&assignedDoctorID)
+		if err != nil || !assignedDoctorID.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Receptionist has no assigned doctor"})
+			return "", "", false
+		}
+		doctorID = assignedDoctorID.String
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return "", "", false
+	}
+
+	var hasRelationship bool
+	err := h.db.QueryRow(
+		context.Background(),
+		`SELECT EXISTS(
+			SELECT 1
+			FROM appointments
+			WHERE doctor_id = $1 AND patient_id = $2 AND COALESCE(is_doctor_patient, false) = false
+		)`,
+		doctorID,
+		patientID,
+	).Scan(&hasRelationship)
+	if err != nil {
+		log.Printf("Lab results: failed to validate doctor-patient relationship: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate doctor-patient relationship"})
+		return "", "", false
+	}
+	if !hasRelationship {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return "", "", false
+	}
+
+	return patientID, doctorID, true
+}
+
+func validOptionalUUID(c *gin.Context, id *string, what string) bool {
+	if id == nil {
+		return true
+	}
+	if _, err := uuid.Parse(*id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + what + " ID"})
+		return false
+	}
+	return true
+}
+
+func respondLabError(c *gin.Context, err error) {
+	var importErr *medicalRecordsService.LabImportError
+	switch {
+	case errors.As(err, &importErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lab results file", "rows": importErr.Rows})
+	case errors.Is(err, medicalRecordsService.ErrInvalidLabObservation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, medicalRecordsService.ErrLabSourceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report or document not found for this patient"})
+	default:
+		log.Printf("Error storing lab observations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store lab results"})
+	}
+}
@@ -3,14 +3,18 @@ package medicalrecords
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strings"
 
 	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/filecheck"
 	"healthcare_backend/pkg/models"
 	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+	"healthcare_backend/pkg/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -20,14 +24,20 @@ import (
 type MedicalRecordsHandler struct {
 	medicalRecordsService *medicalRecordsService.MedicalRecordsService
 	historyService        *medicalRecordsService.HistoryService
+	accessLogService      *medicalRecordsService.AccessLogService
+	quotaService          *medicalRecordsService.QuotaService
+	previewService        *medicalRecordsService.PreviewService
 	config                *config.Config
 	db                    *pgxpool.Pool
 }
 
-func NewMedicalRecordsHandler(db *pgxpool.Pool, cfg *config.Config) *MedicalRecordsHandler {
+func NewMedicalRecordsHandler(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *MedicalRecordsHandler {
 	return &MedicalRecordsHandler{
-		medicalRecordsService: medicalRecordsService.NewMedicalRecordsService(db, cfg),
+		medicalRecordsService: medicalRecordsService.NewMedicalRecordsService(db, cfg, store),
 		historyService:        medicalRecordsService.NewHistoryService(db),
+		accessLogService:      medicalRecordsService.NewAccessLogService(db),
+		quotaService:          medicalRecordsService.NewQuotaService(db, cfg),
+		previewService:        medicalRecordsService.NewPreviewService(db, cfg, store),
 		config:                cfg,
 		db:                    db,
 	}
@@ -184,6 +194,14 @@ func (h *MedicalRecordsHandler) UploadFile(c *gin.Context) {
 	fileInfo.UploadedByRole = &uploadedByRole
 
 	if err := h.medicalRecordsService.UploadFile(&fileInfo, file, handler.Size, handler.Header.Get("Content-Type")); err != nil {
+		var rejection *filecheck.Rejection
+		if errors.As(err, &rejection) {
+			c.JSON(rejection.HTTPStatus(), gin.H{"error": "Upload rejected", "rejection": rejection})
+			return
+		}
+		if respondQuotaExceeded(c, err) {
+			return
+		}
 		log.Printf("Error uploading file: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
 		return
@@ -192,6 +210,82 @@ func (h *MedicalRecordsHandler) UploadFile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "File uploaded successfully"})
 }
 
+// respondQuotaExceeded answers 413 with the caller's usage when err is a
+// quota rejection, and reports whether it did.
+func respondQuotaExceeded(c *gin.Context, err error) bool {
+	var quotaErr *medicalRecordsService.QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Storage quota exceeded", "quota": quotaErr})
+	return true
+}
+
+// respondInvalidMetadata answers 400 with the offending fields when err is a
+// clinical metadata error, and reports whether it did.
+func respondInvalidMetadata(c *gin.Context, err error) bool {
+	var metadataErr *medicalRecordsService.MetadataError
+	if !errors.As(err, &metadataErr) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document metadata", "fields": metadataErr.Fields})
+	return true
+}
+
+// GetStorageUsage returns the caller's usage against their quota, broken
+// down by record category.
+func (h *MedicalRecordsHandler) GetStorageUsage(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	usage, err := h.quotaService.Usage(callerUserID.(string), c.GetString("userType"))
+	if err != nil {
+		log.Printf("Error retrieving storage usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve storage usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// GetFolderUsage returns the recursive size of one of the caller's folders.
+func (h *MedicalRecordsHandler) GetFolderUsage(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	folderID := c.Param("folderId")
+	if _, err := uuid.Parse(folderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return
+	}
+
+	var ownerID string
+	err := h.db.QueryRow(context.Background(), "SELECT user_id FROM folder_file_info WHERE id = $1", folderID).Scan(&ownerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if ownerID != callerUserID.(string) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	usage, err := h.quotaService.FolderUsage([]string{folderID})
+	if err != nil {
+		log.Printf("Error retrieving folder usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve folder usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage[folderID])
+}
+
 func (h *MedicalRecordsHandler) DownloadFile(c *gin.Context) {
 	fileID := c.Param("fileId")
 	if fileID == "" {
@@ -208,6 +302,16 @@ func (h *MedicalRecordsHandler) DownloadFile(c *gin.Context) {
 	}
 	defer fileReader.Close()
 
+	accessPath := models.AccessPathFileDownload
+	if file.Type == "folder" {
+		accessPath = models.AccessPathFolderZip
+	}
+	if err := h.accessLogService.RecordAccess([]string{file.ID}, models.AccessActionDownload, newAccessContext(c, accessPath)); err != nil {
+		log.Printf("Error recording download of file ID %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not download file"})
+		return
+	}
+
 	if file.Name == "" {
 		log.Printf("Warning: File name is empty for ID %s", fileID)
 		file.Name = "download"
@@ -263,6 +367,12 @@ func (h *MedicalRecordsHandler) DownloadMultipleFiles(c *gin.Context) {
 	}
 	defer zipReader.Close()
 
+	if err := h.accessLogService.RecordAccess(request.FileIDs, models.AccessActionDownload, newAccessContext(c, models.AccessPathMultipleZip)); err != nil {
+		log.Printf("Error recording download of multiple files: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not download files"})
+		return
+	}
+
 	var filename string
 	if request.FolderName != "" {
 		filename = fmt.Sprintf("%s.zip", request.FolderName)
@@ -286,6 +396,16 @@ func (h *MedicalRecordsHandler) DownloadMultipleFiles(c *gin.Context) {
 	c.DataFromReader(http.StatusOK, -1, contentType, zipReader, nil)
 }
 
+func newAccessContext(c *gin.Context, accessPath string) medicalRecordsService.AccessContext {
+	return medicalRecordsService.AccessContext{
+		UserID:     c.GetString("userId"),
+		UserType:   c.GetString("userType"),
+		AccessPath: accessPath,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+}
+
 func getContentTypeFromExtension(ext *string) string {
 	if ext == nil {
 		return "application/octet-stream"
@@ -457,6 +577,131 @@ func (h *MedicalRecordsHandler) RenameFileOrFolder(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Item renamed successfully"})
 }
 
+// MoveItem moves a file or folder, with its contents, into another of the
+// caller's personal folders, or to the top level when target_folder_id is
+// omitted.
+func (h *MedicalRecordsHandler) MoveItem(c *gin.Context) {
+	itemID, targetFolderID, ok := h.bindTransferRequest(c, false)
+	if !ok {
+		return
+	}
+
+	item, err := h.medicalRecordsService.MoveItem(itemID, targetFolderID, c.GetString("userId"), c.GetString("userType"))
+	if err != nil {
+		if respondTransferError(c, err) {
+			return
+		}
+		log.Printf("Error moving item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item moved successfully", "item": item})
+}
+
+// CopyItem copies a file or folder, with its contents, into one of the
+// caller's personal folders, or to the top level when target_folder_id is
+// omitted. Items shared with the caller can be copied too.
+func (h *MedicalRecordsHandler) CopyItem(c *gin.Context) {
+	itemID, targetFolderID, ok := h.bindTransferRequest(c, true)
+	if !ok {
+		return
+	}
+
+	item, err := h.medicalRecordsService.CopyItem(itemID, targetFolderID, c.GetString("userId"), c.GetString("userType"))
+	if err != nil {
+		if respondTransferError(c, err) || respondQuotaExceeded(c, err) {
+			return
+		}
+		log.Printf("Error copying item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy item"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Item copied successfully", "item": item})
+}
+
+// bindTransferRequest checks the caller may move or copy the item in the
+// path and reads the target folder from the body. Items shared with the
+// caller are only accepted when allowShared is set.
+func (h *MedicalRecordsHandler) bindTransferRequest(c *gin.Context, allowShared bool) (string, string, bool) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return "", "", false
+	}
+	callerUserType := c.GetString("userType")
+
+	if callerUserType == "receptionist" {
+		var assignedDoctorID sql.NullString
+		err := h.db.QueryRow(context.Background(), "SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1", callerUserID.(string)).Scan(&assignedDoctorID)
+		if err != nil {
+			log.Printf("bindTransferRequest: failed to verify receptionist assignment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify receptionist assignment"})
+			return "", "", false
+		}
+		if !assignedDoctorID.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Receptionist has no assigned doctor"})
+			return "", "", false
+		}
+	}
+
+	itemID := c.Param("itemId")
+	if _, err := uuid.Parse(itemID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item id"})
+		return "", "", false
+	}
+
+	var request struct {
+		TargetFolderID string `json:"target_folder_id"`
+	}
+	// An empty body targets the top level.
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error parsing JSON: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return "", "", false
+	}
+	if request.TargetFolderID != "" {
+		if _, err := uuid.Parse(request.TargetFolderID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target_folder_id"})
+			return "", "", false
+		}
+	}
+
+	var ownerID string
+	var sharedByID sql.NullString
+	err := h.db.QueryRow(context.Background(), "SELECT user_id, shared_by_id FROM folder_file_info WHERE id = $1", itemID).Scan(&ownerID, &sharedByID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return "", "", false
+	}
+	if ownerID != callerUserID.(string) || (sharedByID.Valid && !allowShared) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return "", "", false
+	}
+
+	return itemID, request.TargetFolderID, true
+}
+
+// respondTransferError answers the move and copy errors callers can fix, and
+// reports whether it did.
+func respondTransferError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, medicalRecordsService.ErrItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+	case errors.Is(err, medicalRecordsService.ErrInvalidTarget):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target must be one of your personal folders"})
+	case errors.Is(err, medicalRecordsService.ErrNotMovable),
+		errors.Is(err, medicalRecordsService.ErrTargetInsideItem):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, medicalRecordsService.ErrNameConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
 func (h *MedicalRecordsHandler) GetBreadcrumbs(c *gin.Context) {
 	folderID := c.Param("folderId")
 	if folderID == "" {
@@ -528,3 +773,56 @@ func (h *MedicalRecordsHandler) GetFileHistory(c *gin.Context) {
 
 	c.JSON(http.StatusOK, history)
 }
+
+// GetFilePreview serves the JPEG preview of a file to the people who can
+// see the file itself.
+func (h *MedicalRecordsHandler) GetFilePreview(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	fileID := c.Param("fileId")
+	if _, err := uuid.Parse(fileID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	var canView bool
+	err := h.db.QueryRow(context.Background(),
+		`SELECT EXISTS(
+			SELECT 1 FROM folder_file_info
+			WHERE id = $1 AND (user_id::text = $2 OR patient_id::text = $2 OR owner_user_id::text = $2 OR uploaded_by_user_id::text = $2)
+		)`, fileID, callerUserID.(string)).Scan(&canView)
+	if err != nil {
+		log.Printf("GetFilePreview: failed to verify access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify access"})
+		return
+	}
+	if !canView {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preview not available"})
+		return
+	}
+
+	reader, err := h.previewService.GetPreview(fileID)
+	if err != nil {
+		if errors.Is(err, medicalRecordsService.ErrPreviewNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Preview not available"})
+			return
+		}
+		log.Printf("Error retrieving preview for %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve preview"})
+		return
+	}
+	defer reader.Close()
+
+	if err := h.accessLogService.RecordAccess([]string{fileID}, models.AccessActionView, newAccessContext(c, models.AccessPathFilePreview)); err != nil {
+		log.Printf("Error recording preview of file ID %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve preview"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.DataFromReader(http.StatusOK, -1, "image/jpeg", reader, nil)
+}
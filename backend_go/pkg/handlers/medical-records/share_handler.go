@@ -3,28 +3,32 @@ package medicalrecords
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 
 	"healthcare_backend/pkg/config"
 	"healthcare_backend/pkg/models"
 	shareService "healthcare_backend/pkg/services/medical-records"
+	"healthcare_backend/pkg/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 type ShareHandler struct {
-	shareService *shareService.ShareService
-	config       *config.Config
-	db           *pgxpool.Pool
+	shareService     *shareService.ShareService
+	accessLogService *shareService.AccessLogService
+	config           *config.Config
+	db               *pgxpool.Pool
 }
 
-func NewShareHandler(db *pgxpool.Pool, cfg *config.Config) *ShareHandler {
+func NewShareHandler(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *ShareHandler {
 	return &ShareHandler{
-		shareService: shareService.NewShareService(db, cfg),
-		config:       cfg,
-		db:           db,
+		shareService:     shareService.NewShareService(db, cfg, store),
+		accessLogService: shareService.NewAccessLogService(db),
+		config:           cfg,
+		db:               db,
 	}
 }
 
@@ -215,6 +219,10 @@ func (h *ShareHandler) ShareItems(c *gin.Context) {
 	}
 
 	err = h.shareService.ShareItems(req)
+	if errors.Is(err, shareService.ErrQuotaExceeded) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Storage quota exceeded: the recipient does not have enough space for these items"})
+		return
+	}
 	if err != nil {
 		log.Printf("Error sharing items: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -239,6 +247,19 @@ func (h *ShareHandler) GetSharedWithMe(c *gin.Context) {
 		return
 	}
 
+	// The listing shows the shared files but not what shared folders hold.
+	fileIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Type != "folder" {
+			fileIDs = append(fileIDs, item.ID)
+		}
+	}
+	if err := h.accessLogService.RecordAccess(fileIDs, models.AccessActionView, newAccessContext(c, models.AccessPathSharedWithMe)); err != nil {
+		log.Printf("Error recording shared items listing access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shared items"})
+		return
+	}
+
 	if len(items) == 0 {
 		c.JSON(http.StatusOK, []models.FileFolder{})
 	} else {
@@ -261,6 +282,19 @@ func (h *ShareHandler) GetSharedByMe(c *gin.Context) {
 		return
 	}
 
+	// The listing shows the shared files but not what shared folders hold.
+	fileIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Type != "folder" {
+			fileIDs = append(fileIDs, item.ID)
+		}
+	}
+	if err := h.accessLogService.RecordAccess(fileIDs, models.AccessActionView, newAccessContext(c, models.AccessPathSharedWithMe)); err != nil {
+		log.Printf("Error recording shared items listing access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shared items"})
+		return
+	}
+
 	if len(items) == 0 {
 		c.JSON(http.StatusOK, []models.FileFolder{})
 	} else {
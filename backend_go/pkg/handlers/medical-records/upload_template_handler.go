@@ -0,0 +1,168 @@
+package medicalrecords
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/models"
+	medicalRecordsService "healthcare_backend/pkg/services/medical-records"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type UploadTemplateHandler struct {
+	db              *pgxpool.Pool
+	templateService *medicalRecordsService.UploadTemplateService
+	config          *config.Config
+}
+
+func NewUploadTemplateHandler(db *pgxpool.Pool, cfg *config.Config) *UploadTemplateHandler {
+	return &UploadTemplateHandler{
+		db:              db,
+		templateService: medicalRecordsService.NewUploadTemplateService(db),
+		config:          cfg,
+	}
+}
+
+// ListTemplates returns the caller's upload templates, or for receptionists
+// those of their assigned doctor.
+func (h *UploadTemplateHandler) ListTemplates(c *gin.Context) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	doctorID := callerUserID.(string)
+	switch c.GetString("userType") {
+	case "doctor":
+	case "receptionist":
+		var assignedDoctorID sql.NullString
+		err := h.db.QueryRow(context.Background(), "SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1", doctorID).Scan(&assignedDoctorID)
+		if err != nil {
+			log.Printf("ListTemplates: failed to verify receptionist assignment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify receptionist assignment"})
+			return
+		}
+		if !assignedDoctorID.Valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Receptionist has no assigned doctor"})
+			return
+		}
+		doctorID = assignedDoctorID.String
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors and receptionists can use upload templates"})
+		return
+	}
+
+	templates, err := h.templateService.ListTemplates(doctorID)
+	if err != nil {
+		log.Printf("Error listing upload templates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve upload templates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+func (h *UploadTemplateHandler) CreateTemplate(c *gin.Context) {
+	doctorID, ok := templateDoctor(c)
+	if !ok {
+		return
+	}
+
+	var request models.UploadTemplateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Printf("Error parsing JSON: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	template, err := h.templateService.CreateTemplate(doctorID, request)
+	if err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, template)
+}
+
+func (h *UploadTemplateHandler) UpdateTemplate(c *gin.Context) {
+	doctorID, ok := templateDoctor(c)
+	if !ok {
+		return
+	}
+	templateID := c.Param("templateId")
+	if _, err := uuid.Parse(templateID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	var request models.UploadTemplateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Printf("Error parsing JSON: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	template, err := h.templateService.UpdateTemplate(doctorID, templateID, request)
+	if err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+func (h *UploadTemplateHandler) DeleteTemplate(c *gin.Context) {
+	doctorID, ok := templateDoctor(c)
+	if !ok {
+		return
+	}
+	templateID := c.Param("templateId")
+	if _, err := uuid.Parse(templateID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	if err := h.templateService.DeleteTemplate(doctorID, templateID); err != nil {
+		respondTemplateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload template deleted"})
+}
+
+// templateDoctor allows only doctors to change upload templates.
+func templateDoctor(c *gin.Context) (string, bool) {
+	callerUserID, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return "", false
+	}
+	if c.GetString("userType") != "doctor" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only doctors can manage upload templates"})
+		return "", false
+	}
+	return callerUserID.(string), true
+}
+
+func respondTemplateError(c *gin.Context, err error) {
+	var metadataErr *medicalRecordsService.MetadataError
+	switch {
+	case errors.As(err, &metadataErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload template", "fields": metadataErr.Fields})
+	case errors.Is(err, medicalRecordsService.ErrUploadTemplateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload template not found"})
+	case errors.Is(err, medicalRecordsService.ErrDuplicateTemplateName):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error saving upload template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save upload template"})
+	}
+}
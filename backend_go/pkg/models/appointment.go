@@ -68,6 +68,7 @@ type MedicalReport struct {
 	DiagnosisName             *string            `json:"diagnosisName"`
 	DiagnosisDetails          *string            `json:"diagnosisDetails"`
 	Medications               []ReportMedication `json:"medications"`
+	LabObservations           []LabObservation   `json:"labObservations,omitempty"`
 	ReferralNeeded            bool               `json:"referralNeeded"`
 	ReferralSpecialty         *string            `json:"referralSpecialty"`
 	ReferralDoctorID          *uuid.UUID         `json:"referralDoctorId,omitempty"`
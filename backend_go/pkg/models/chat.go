@@ -5,7 +5,12 @@ import (
 )
 
 type Chat struct {
-	ID                   string     `json:"id"`
+	ID string `json:"id"`
+	// Type is direct for one-to-one chats and group for care team chats.
+	// Group chats have a name and no recipient.
+	Type                 ChatType   `json:"type"`
+	Name                 *string    `json:"name,omitempty"`
+	ParticipantCount     int        `json:"participantCount"`
 	SenderUserID         string     `json:"senderUserId"`
 	RecipientUserID      string     `json:"recipientUserId"`
 	FirstNameSender      string     `json:"firstNameSender"`
@@ -19,30 +24,163 @@ type Chat struct {
 	LastMessageCreatedAt *time.Time `json:"latestMessageTime"`
 	RecipientImageURL    string     `json:"recipientImageUrl"`
 	RecipientUserType    string     `json:"recipientUserType"`
+	UnreadCount          int        `json:"unreadCount"`
 }
 
+// ChatList is the caller's chats with their unread message counts.
+type ChatList struct {
+	Chats       []Chat `json:"chats"`
+	UnreadTotal int    `json:"unreadTotal"`
+}
+
+type ChatType string
+
+const (
+	ChatDirect ChatType = "direct"
+	ChatGroup  ChatType = "group"
+)
+
+// ParticipantRole is what a participant may do in a group chat. The owner
+// and admins manage members; only the owner changes roles.
+type ParticipantRole string
+
+const (
+	RoleOwner  ParticipantRole = "owner"
+	RoleAdmin  ParticipantRole = "admin"
+	RoleMember ParticipantRole = "member"
+)
+
 type Participant struct {
-	ID        string `json:"id"`
-	ChatID    string `json:"chatId"`
-	UserID    string `json:"userId"`
-	UserType  string `json:"userType"`
-	JoinedAt  string `json:"joinedAt"`
-	CreatedAt string `json:"createdAt"`
-	UpdatedAt string `json:"updatedAt"`
-	DeletedAt string `json:"deletedAt"`
+	ChatID      string          `json:"chatId"`
+	UserID      string          `json:"userId"`
+	UserType    string          `json:"userType"`
+	Role        ParticipantRole `json:"role"`
+	FirstName   string          `json:"firstName"`
+	FirstNameAr string          `json:"firstNameAr"`
+	LastName    string          `json:"lastName"`
+	LastNameAr  string          `json:"lastNameAr"`
+	JoinedAt    time.Time       `json:"joinedAt"`
 }
 
+// GroupChat is a care team chat with its participants.
+type GroupChat struct {
+	ID           string        `json:"id"`
+	Name         string        `json:"name"`
+	CreatedBy    string        `json:"createdBy"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	Participants []Participant `json:"participants"`
+}
+
+// ChatSystemEvent describes a membership change recorded in a group chat as
+// a system message. ActorID is the sender of the message.
+type ChatSystemEvent struct {
+	Action string          `json:"action"`
+	UserID string          `json:"userId,omitempty"`
+	Role   ParticipantRole `json:"role,omitempty"`
+	Name   string          `json:"name,omitempty"`
+}
+
+const (
+	SystemGroupCreated  = "group_created"
+	SystemGroupRenamed  = "group_renamed"
+	SystemMemberAdded   = "member_added"
+	SystemMemberLeft    = "member_left"
+	SystemMemberRemoved = "member_removed"
+	SystemRoleChanged   = "role_changed"
+)
+
 type Message struct {
-	ID        string    `json:"id"`
-	ChatID    string    `json:"chatId"`
-	SenderID  string    `json:"senderId"`
-	Content   *string   `json:"content"`
-	Key       *string   `json:"key"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
-	DeletedAt time.Time `json:"deletedAt"`
+	ID        string     `json:"id"`
+	ChatID    string     `json:"chatId"`
+	SenderID  string     `json:"senderId"`
+	Content   *string    `json:"content"`
+	Key       *string    `json:"key"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	EditedAt  *time.Time `json:"editedAt,omitempty"`
+	// DeletedAt is set on messages deleted for everyone. They are kept as
+	// tombstones without content.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Status tells the sender how far the message got: sent, delivered to
+	// the recipient's device, or read.
+	Status    MessageStatus     `json:"status,omitempty"`
+	Reactions []MessageReaction `json:"reactions,omitempty"`
+	// SystemEvent is set on system messages, which have no content.
+	SystemEvent *ChatSystemEvent `json:"systemEvent,omitempty"`
+	// Attachment is the file sent with the message. Its content is only
+	// served through a short-lived link.
+	Attachment *ChatAttachment `json:"attachment,omitempty"`
+}
+
+// ChatAttachment is a file shared in a chat, stored privately.
+type ChatAttachment struct {
+	ID          string    `json:"id"`
+	ChatID      string    `json:"chatId"`
+	UploaderID  string    `json:"uploaderId"`
+	FileName    string    `json:"fileName"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
 }
 
+// AttachmentLink is a download URL for a chat attachment that only works
+// for the user it was issued to, until it expires.
+type AttachmentLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// MessageReaction is one emoji on a message and who reacted with it.
+type MessageReaction struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"userIds"`
+}
+
+// MessageEdit is an earlier version of an edited message.
+type MessageEdit struct {
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"editedAt"`
+}
+
+// MessageSearchRequest is a full-text search of the messages in the
+// caller's chats. From and To bound the message time; To is exclusive.
+type MessageSearchRequest struct {
+	Query    string
+	ChatID   string
+	SenderID string
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+	Offset   int
+}
+
+// MessageSearchResult is a matching message with an excerpt of its text.
+// Matched terms in the snippet are wrapped in <mark> tags; the rest of the
+// snippet is HTML-escaped.
+type MessageSearchResult struct {
+	Message
+	ChatType ChatType `json:"chatType"`
+	ChatName *string  `json:"chatName,omitempty"`
+	Snippet  string   `json:"snippet"`
+	Rank     float32  `json:"rank"`
+}
+
+// MessagePage is a page of a chat's history, oldest message first.
+type MessagePage struct {
+	Messages      []Message `json:"messages"`
+	HasMoreBefore bool      `json:"hasMoreBefore"`
+	HasMoreAfter  bool      `json:"hasMoreAfter"`
+}
+
+type MessageStatus string
+
+const (
+	MessageSent      MessageStatus = "sent"
+	MessageDelivered MessageStatus = "delivered"
+	MessageRead      MessageStatus = "read"
+)
+
 type CombinedUser struct {
 	UserID      string `json:"userId"`
 	FirstName   string `json:"firstName"`
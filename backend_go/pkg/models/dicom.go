@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+type DicomSeries struct {
+	ID                string  `json:"series_id"`
+	SeriesInstanceUID string  `json:"series_instance_uid"`
+	FolderID          string  `json:"folder_id"`
+	Modality          string  `json:"modality"`
+	SeriesNumber      *int    `json:"series_number,omitempty"`
+	Description       string  `json:"description,omitempty"`
+	BodyPart          *string `json:"body_part,omitempty"`
+	InstanceCount     int     `json:"instance_count"`
+}
+
+type DicomStudy struct {
+	ID                 string        `json:"study_id"`
+	StudyInstanceUID   string        `json:"study_instance_uid"`
+	FolderID           string        `json:"folder_id"`
+	UserID             string        `json:"user_id"`
+	PatientID          *string       `json:"patient_id,omitempty"`
+	Modality           string        `json:"modality"`
+	Category           *Category     `json:"category,omitempty"`
+	BodyPart           *string       `json:"body_part,omitempty"`
+	StudyDate          *time.Time    `json:"study_date,omitempty"`
+	Description        string        `json:"description,omitempty"`
+	ReferringPhysician string        `json:"referring_physician,omitempty"`
+	AccessionNumber    string        `json:"accession_number,omitempty"`
+	DicomPatientID     string        `json:"dicom_patient_id,omitempty"`
+	DicomPatientName   string        `json:"dicom_patient_name,omitempty"`
+	SeriesCount        int           `json:"series_count"`
+	InstanceCount      int           `json:"instance_count"`
+	Series             []DicomSeries `json:"series,omitempty"`
+	CreatedAt          time.Time     `json:"created_at"`
+	UpdatedAt          time.Time     `json:"updated_at"`
+}
+
+type DicomSkippedFile struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+type DicomImportResult struct {
+	Studies            []DicomStudy       `json:"studies"`
+	ImportedInstances  int                `json:"imported_instances"`
+	DuplicateInstances int                `json:"duplicate_instances"`
+	Skipped            []DicomSkippedFile `json:"skipped,omitempty"`
+	Warnings           []string           `json:"warnings,omitempty"`
+}
@@ -28,33 +28,35 @@ const (
 )
 
 type FileFolder struct {
-	ID               string     `json:"folder_id"`
-	Name             string     `json:"name"`
-	CreatedAt        time.Time  `json:"created_at"`
-	UpdatedAt        time.Time  `json:"updated_at"`
-	Type             string     `json:"file_type"`
-	Size             int64      `json:"size"`
-	Ext              *string    `json:"extension"`
-	UserID           string     `json:"user_id"`
-	UserType         string     `json:"user_type"`
-	ParentID         *string    `json:"parent_id,omitempty"`
-	Path             string     `json:"path"`
-	SharedByID       *string    `json:"shared_by_id,omitempty"`
-	SharedByName     string     `json:"shared_by_name,omitempty"`
-	SharedByType     string     `json:"shared_by_type,omitempty"`
-	SharedWithID     string     `json:"shared_with_id,omitempty"`
-	SharedWithName   string     `json:"shared_with_name,omitempty"`
-	SharedWithType   string     `json:"shared_with_type,omitempty"`
-	FolderType       FolderType `json:"folder_type"`
-	Category         *Category  `json:"category,omitempty"`
-	BodyPart         *string    `json:"body_part,omitempty"`
-	StudyDate        *time.Time `json:"study_date,omitempty"`
-	DoctorName       *string    `json:"doctor_name,omitempty"`
-	OwnerUserID      *string    `json:"owner_user_id,omitempty"`
-	PatientID        *string    `json:"patient_id,omitempty"`
-	UploadedByUserID *string    `json:"uploaded_by_user_id,omitempty"`
-	UploadedByRole   *string    `json:"uploaded_by_role,omitempty"`
-	IncludedInRAG    bool       `json:"included_in_rag"`
+	ID               string        `json:"folder_id"`
+	Name             string        `json:"name"`
+	CreatedAt        time.Time     `json:"created_at"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+	Type             string        `json:"file_type"`
+	Size             int64         `json:"size"`
+	Ext              *string       `json:"extension"`
+	UserID           string        `json:"user_id"`
+	UserType         string        `json:"user_type"`
+	ParentID         *string       `json:"parent_id,omitempty"`
+	Path             string        `json:"path"`
+	SharedByID       *string       `json:"shared_by_id,omitempty"`
+	SharedByName     string        `json:"shared_by_name,omitempty"`
+	SharedByType     string        `json:"shared_by_type,omitempty"`
+	SharedWithID     string        `json:"shared_with_id,omitempty"`
+	SharedWithName   string        `json:"shared_with_name,omitempty"`
+	SharedWithType   string        `json:"shared_with_type,omitempty"`
+	FolderType       FolderType    `json:"folder_type"`
+	Category         *Category     `json:"category,omitempty"`
+	BodyPart         *string       `json:"body_part,omitempty"`
+	StudyDate        *time.Time    `json:"study_date,omitempty"`
+	DoctorName       *string       `json:"doctor_name,omitempty"`
+	OwnerUserID      *string       `json:"owner_user_id,omitempty"`
+	PatientID        *string       `json:"patient_id,omitempty"`
+	UploadedByUserID *string       `json:"uploaded_by_user_id,omitempty"`
+	UploadedByRole   *string       `json:"uploaded_by_role,omitempty"`
+	IncludedInRAG    bool          `json:"included_in_rag"`
+	PreviewStatus    PreviewStatus `json:"preview_status,omitempty"`
+	PreviewURL       string        `json:"preview_url,omitempty"`
 }
 
 func (c Category) GetDisplayName() string {
@@ -84,6 +86,10 @@ func (c Category) GetDisplayName() string {
 	}
 }
 
+func (c Category) IsValid() bool {
+	return c.GetDisplayName() != "Unknown"
+}
+
 func (c Category) IsImagingCategory() bool {
 	switch c {
 	case CategoryImagingCT, CategoryImagingXray, CategoryImagingUS,
@@ -121,6 +127,10 @@ const (
 	BodyPartOther    BodyPart = "OTHER"
 )
 
+func (bp BodyPart) IsValid() bool {
+	return bp.GetDisplayName() != "Unknown"
+}
+
 func (bp BodyPart) GetDisplayName() string {
 	switch bp {
 	case BodyPartHead:
@@ -179,9 +189,45 @@ const (
 	ActionTypeShare  HistoryActionType = "share"
 	ActionTypeRename HistoryActionType = "rename"
 	ActionTypeMove   HistoryActionType = "move"
+	ActionTypeCopy   HistoryActionType = "copy"
 	ActionTypeDelete HistoryActionType = "delete"
 )
 
+type AccessAction string
+
+const (
+	AccessActionView     AccessAction = "view"
+	AccessActionDownload AccessAction = "download"
+)
+
+const (
+	AccessPathFileDownload    = "file-download"
+	AccessPathFolderZip       = "folder-zip"
+	AccessPathMultipleZip     = "multiple-zip"
+	AccessPathCategoryListing = "category-listing"
+	AccessPathSearch          = "search"
+	AccessPathFilePreview     = "file-preview"
+	AccessPathDicomStudy      = "dicom-study"
+	AccessPathIngestion       = "ingestion-document"
+	AccessPathSharedWithMe    = "shared-with-me"
+)
+
+type RecordAccessEntry struct {
+	ID             string       `json:"id"`
+	ItemID         string       `json:"item_id"`
+	ItemName       string       `json:"item_name"`
+	OwnerID        string       `json:"owner_id"`
+	OwnerName      string       `json:"owner_name,omitempty"`
+	AccessedByID   string       `json:"accessed_by_id"`
+	AccessedByType string       `json:"accessed_by_type"`
+	AccessedByName string       `json:"accessed_by_name,omitempty"`
+	Action         AccessAction `json:"action"`
+	AccessPath     string       `json:"access_path"`
+	IPAddress      *string      `json:"ip_address,omitempty"`
+	UserAgent      *string      `json:"user_agent,omitempty"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
 type FileFolderHistory struct {
 	ID              string            `json:"id"`
 	ItemID          string            `json:"item_id"`
@@ -197,3 +243,189 @@ type FileFolderHistory struct {
 	Metadata        json.RawMessage   `json:"metadata,omitempty"`
 	CreatedAt       time.Time         `json:"created_at"`
 }
+
+type ChunkedUploadStatus string
+
+const (
+	ChunkedUploadInProgress ChunkedUploadStatus = "in_progress"
+	ChunkedUploadCompleted  ChunkedUploadStatus = "completed"
+	ChunkedUploadAborted    ChunkedUploadStatus = "aborted"
+)
+
+// ByteRange is an inclusive range of bytes, as in an HTTP Range header.
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type ChunkedUpload struct {
+	ID              string              `json:"upload_id"`
+	UserID          string              `json:"user_id"`
+	UserType        string              `json:"user_type"`
+	FileName        string              `json:"file_name"`
+	FileType        string              `json:"file_type"`
+	Ext             *string             `json:"extension"`
+	ContentType     string              `json:"content_type"`
+	TotalSize       int64               `json:"total_size"`
+	ChunkSize       int64               `json:"chunk_size"`
+	TotalChunks     int                 `json:"total_chunks"`
+	StorageKey      string              `json:"-"`
+	StorageUploadID string              `json:"-"`
+	ParentID        *string             `json:"parent_id,omitempty"`
+	FolderType      FolderType          `json:"folder_type"`
+	Category        *Category           `json:"category,omitempty"`
+	BodyPart        *string             `json:"body_part,omitempty"`
+	StudyDate       *time.Time          `json:"study_date,omitempty"`
+	PatientID       *string             `json:"patient_id,omitempty"`
+	Status          ChunkedUploadStatus `json:"status"`
+	FileID          *string             `json:"file_id,omitempty"`
+	ReceivedChunks  []int               `json:"received_chunks"`
+	ReceivedRanges  []ByteRange         `json:"received_ranges"`
+	MissingChunks   []int               `json:"missing_chunks"`
+	CreatedAt       time.Time           `json:"created_at"`
+	UpdatedAt       time.Time           `json:"updated_at"`
+	ExpiresAt       time.Time           `json:"expires_at"`
+}
+
+type UploadChunk struct {
+	UploadID   string    `json:"upload_id"`
+	Number     int       `json:"chunk_number"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	ETag       string    `json:"-"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type CategoryUsage struct {
+	Category    string `json:"category"`
+	DisplayName string `json:"display_name"`
+	FileCount   int    `json:"file_count"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// StorageUsage reports what a user stores against their quota. QuotaBytes is
+// 0 when the role has no quota.
+type StorageUsage struct {
+	UserID         string          `json:"user_id"`
+	UsedBytes      int64           `json:"used_bytes"`
+	ReservedBytes  int64           `json:"reserved_bytes"`
+	QuotaBytes     int64           `json:"quota_bytes"`
+	AvailableBytes *int64          `json:"available_bytes,omitempty"`
+	FileCount      int             `json:"file_count"`
+	Categories     []CategoryUsage `json:"categories"`
+}
+
+type FolderUsage struct {
+	FolderID  string `json:"folder_id"`
+	FileCount int    `json:"file_count"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type PreviewStatus string
+
+const (
+	PreviewPending     PreviewStatus = "pending"
+	PreviewProcessing  PreviewStatus = "processing"
+	PreviewReady       PreviewStatus = "ready"
+	PreviewFailed      PreviewStatus = "failed"
+	PreviewUnsupported PreviewStatus = "unsupported"
+)
+
+type TextIndexStatus string
+
+const (
+	TextIndexPending     TextIndexStatus = "pending"
+	TextIndexProcessing  TextIndexStatus = "processing"
+	TextIndexIndexed     TextIndexStatus = "indexed"
+	TextIndexFailed      TextIndexStatus = "failed"
+	TextIndexUnsupported TextIndexStatus = "unsupported"
+)
+
+type DocumentSearchRequest struct {
+	Query     string
+	PatientID string
+	Category  string
+	BodyPart  string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
+
+// DocumentSearchResult is a matching file with an excerpt of its text.
+// Matched terms in the snippet are wrapped in <mark> tags; the rest of the
+// snippet is HTML-escaped.
+type DocumentSearchResult struct {
+	FileFolder
+	Snippet string  `json:"snippet"`
+	Rank    float32 `json:"rank"`
+}
+
+// IngestionStatus tracks a clinical document through the RAG ingestion
+// queue. Failed jobs are retried with a growing delay; dead_letter jobs ran
+// out of attempts or were refused by the ingestion service.
+type IngestionStatus string
+
+const (
+	IngestionPending    IngestionStatus = "pending"
+	IngestionProcessing IngestionStatus = "processing"
+	IngestionSucceeded  IngestionStatus = "succeeded"
+	IngestionFailed     IngestionStatus = "failed"
+	IngestionDeadLetter IngestionStatus = "dead_letter"
+	// IngestionWithdrawn documents are kept out of the index because the
+	// patient withdrew consent.
+	IngestionWithdrawn IngestionStatus = "withdrawn"
+)
+
+type IngestionOperation string
+
+const (
+	IngestionOperationIngest IngestionOperation = "ingest"
+	IngestionOperationRemove IngestionOperation = "remove"
+)
+
+type IngestionJob struct {
+	FileID        string             `json:"file_id"`
+	FileName      string             `json:"file_name"`
+	Category      *Category          `json:"category,omitempty"`
+	PatientID     string             `json:"patient_id"`
+	DoctorID      string             `json:"doctor_id"`
+	Operation     IngestionOperation `json:"operation"`
+	Status        IngestionStatus    `json:"status"`
+	Attempts      int                `json:"attempts"`
+	LastError     *string            `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time         `json:"next_attempt_at,omitempty"`
+	SucceededAt   *time.Time         `json:"succeeded_at,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+}
+
+// AIConsentSettings is a patient's consent to the AI assistant using their
+// clinical documents. A document is only indexed when both the patient-wide
+// setting and the document's own setting allow it.
+type AIConsentSettings struct {
+	RAGEnabled        bool                `json:"rag_enabled"`
+	UpdatedAt         *time.Time          `json:"updated_at,omitempty"`
+	ExcludedDocuments []AIConsentDocument `json:"excluded_documents"`
+}
+
+type AIConsentDocument struct {
+	FileID   string    `json:"file_id"`
+	Name     string    `json:"name"`
+	Category *Category `json:"category,omitempty"`
+}
+
+// AIConsentChange is an entry of the consent audit trail. FileID is empty
+// for patient-wide changes.
+type AIConsentChange struct {
+	ID            string    `json:"id"`
+	PatientID     string    `json:"patient_id"`
+	FileID        *string   `json:"file_id,omitempty"`
+	FileName      *string   `json:"file_name,omitempty"`
+	OldValue      bool      `json:"old_value"`
+	NewValue      bool      `json:"new_value"`
+	ChangedByID   string    `json:"changed_by_id"`
+	ChangedByType string    `json:"changed_by_type"`
+	IPAddress     *string   `json:"ip_address,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+// AbnormalFlag interprets a lab value against its reference range, using the
+// HL7 v2 interpretation codes.
+type AbnormalFlag string
+
+const (
+	AbnormalFlagNormal       AbnormalFlag = "N"
+	AbnormalFlagLow          AbnormalFlag = "L"
+	AbnormalFlagHigh         AbnormalFlag = "H"
+	AbnormalFlagCriticalLow  AbnormalFlag = "LL"
+	AbnormalFlagCriticalHigh AbnormalFlag = "HH"
+	AbnormalFlagAbnormal     AbnormalFlag = "A"
+)
+
+// LabObservation is one result of a lab test. Numeric results have a Value;
+// others, such as "positive" or "<0.5", only a ValueText.
+type LabObservation struct {
+	ID            string        `json:"observation_id"`
+	PatientID     string        `json:"patient_id"`
+	DoctorID      string        `json:"doctor_id"`
+	ReportID      *string       `json:"report_id,omitempty"`
+	FileID        *string       `json:"file_id,omitempty"`
+	TestCode      string        `json:"test_code"`
+	TestName      *string       `json:"test_name,omitempty"`
+	Value         *float64      `json:"value,omitempty"`
+	ValueText     *string       `json:"value_text,omitempty"`
+	Unit          *string       `json:"unit,omitempty"`
+	ReferenceLow  *float64      `json:"reference_low,omitempty"`
+	ReferenceHigh *float64      `json:"reference_high,omitempty"`
+	ReferenceText *string       `json:"reference_text,omitempty"`
+	AbnormalFlag  *AbnormalFlag `json:"abnormal_flag,omitempty"`
+	OutOfRange    bool          `json:"out_of_range"`
+	CollectedAt   time.Time     `json:"collected_at"`
+	EnteredByID   string        `json:"entered_by_id"`
+	EnteredByRole string        `json:"entered_by_role"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+type LabObservationInput struct {
+	TestCode      string        `json:"test_code"`
+	TestName      *string       `json:"test_name,omitempty"`
+	Value         *float64      `json:"value,omitempty"`
+	ValueText     *string       `json:"value_text,omitempty"`
+	Unit          *string       `json:"unit,omitempty"`
+	ReferenceLow  *float64      `json:"reference_low,omitempty"`
+	ReferenceHigh *float64      `json:"reference_high,omitempty"`
+	ReferenceText *string       `json:"reference_text,omitempty"`
+	AbnormalFlag  *AbnormalFlag `json:"abnormal_flag,omitempty"`
+	CollectedAt   time.Time     `json:"collected_at"`
+}
+
+// CreateLabObservationsRequest enters results, optionally linking them to
+// the medical report and the uploaded document they come from.
+type CreateLabObservationsRequest struct {
+	ReportID     *string               `json:"report_id,omitempty"`
+	FileID       *string               `json:"file_id,omitempty"`
+	Observations []LabObservationInput `json:"observations"`
+}
+
+// LabSeries is the history of one test for a patient, oldest first.
+// MixedUnits warns that the points were not all reported in the same unit.
+type LabSeries struct {
+	TestCode   string           `json:"test_code"`
+	TestName   *string          `json:"test_name,omitempty"`
+	Unit       *string          `json:"unit,omitempty"`
+	MixedUnits bool             `json:"mixed_units"`
+	Points     []LabObservation `json:"points"`
+}
+
+type LabImportRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+type RecordImportStatus string
+
+const (
+	RecordImportPending     RecordImportStatus = "pending"
+	RecordImportRunning     RecordImportStatus = "running"
+	RecordImportCompleted   RecordImportStatus = "completed"
+	RecordImportInterrupted RecordImportStatus = "interrupted"
+)
+
+type ImportEntryStatus string
+
+const (
+	ImportEntryImported ImportEntryStatus = "imported"
+	ImportEntryFailed   ImportEntryStatus = "failed"
+	ImportEntrySkipped  ImportEntryStatus = "skipped"
+)
+
+// RecordImport is the import of one zip archive into a user's records. It is
+// pending until the import worker picks it up. An archive sent again to the
+// same folder resumes its import: imported entries are kept and failed ones
+// are retried.
+type RecordImport struct {
+	ID           string              `json:"import_id"`
+	ArchiveName  string              `json:"archive_name"`
+	ParentID     *string             `json:"parent_id,omitempty"`
+	Status       RecordImportStatus  `json:"status"`
+	TotalEntries int                 `json:"total_entries"`
+	Imported     int                 `json:"imported"`
+	Failed       int                 `json:"failed"`
+	Skipped      int                 `json:"skipped"`
+	Pending      int                 `json:"pending"`
+	CreatedAt    time.Time           `json:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at"`
+	CompletedAt  *time.Time          `json:"completed_at,omitempty"`
+	Entries      []RecordImportEntry `json:"entries,omitempty"`
+}
+
+// RecordImportEntry reports what became of one folder or file of the
+// archive. StoredName differs from the archive name when the file was
+// renamed to avoid overwriting an existing record.
+type RecordImportEntry struct {
+	Path       string            `json:"path"`
+	Kind       string            `json:"kind"`
+	Status     ImportEntryStatus `json:"status"`
+	ItemID     *string           `json:"item_id,omitempty"`
+	StoredName *string           `json:"stored_name,omitempty"`
+	Category   *Category         `json:"category,omitempty"`
+	Error      *string           `json:"error,omitempty"`
+	Attempts   int               `json:"attempts"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
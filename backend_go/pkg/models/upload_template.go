@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// UploadTemplate holds a doctor's defaults for clinical document uploads.
+// Values sent with an upload take precedence over the template's.
+type UploadTemplate struct {
+	ID         string    `json:"template_id"`
+	DoctorID   string    `json:"doctor_id"`
+	Name       string    `json:"name"`
+	Category   Category  `json:"category"`
+	FolderName *string   `json:"folder_name,omitempty"`
+	BodyPart   *BodyPart `json:"body_part,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type UploadTemplateRequest struct {
+	Name       string  `json:"name"`
+	Category   string  `json:"category"`
+	FolderName *string `json:"folder_name,omitempty"`
+	BodyPart   *string `json:"body_part,omitempty"`
+}
+
+// FieldError reports why one field of a request was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,513 @@
+// Package pdf is a minimal PDF reader built on the standard library: just
+// enough to find a document's pages, the raster images they draw and the
+// text they show. Objects are located by scanning for "N G obj" rather than
+// trusting the cross-reference table, which is often damaged in scanner
+// output, and compressed object streams are expanded.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+var (
+	// ErrUnsupported means the document uses a feature this reader does not
+	// handle, so retrying will not help.
+	ErrUnsupported = errors.New("unsupported PDF feature")
+
+	errPDFSyntax = errors.New("malformed PDF")
+)
+
+const (
+	// MaxImagePixels bounds the size of images FirstPageImage decodes.
+	MaxImagePixels = 40_000_000
+
+	maxPDFNesting   = 64
+	maxDecodedBytes = 3 * MaxImagePixels
+)
+
+type (
+	pdfName    string
+	pdfKeyword string
+	pdfDict    map[pdfName]interface{}
+	pdfRef     struct{ num, gen int }
+)
+
+type pdfStream struct {
+	dict pdfDict
+	raw  []byte
+}
+
+var pdfObjectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// Document is a parsed PDF file.
+type Document struct {
+	objects map[int]interface{}
+	order   []int
+	data    []byte
+}
+
+// IsPDF reports whether data starts like a PDF file. The header may follow
+// some junk, which readers tolerate.
+func IsPDF(data []byte) bool {
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	return bytes.Contains(head, []byte("%PDF-"))
+}
+
+// Parse reads the objects of a PDF file.
+func Parse(data []byte) (*Document, error) {
+	doc := &Document{objects: map[int]interface{}{}, data: data}
+
+	next := 0
+	for _, match := range pdfObjectHeader.FindAllSubmatchIndex(data, -1) {
+		// Skip matches inside the previous object, such as in binary stream
+		// data.
+		if match[0] < next {
+			continue
+		}
+		num, err := strconv.Atoi(string(data[match[2]:match[3]]))
+		if err != nil {
+			continue
+		}
+
+		lex := &pdfLexer{buf: data, pos: match[1]}
+		obj, err := lex.object(0)
+		if err != nil {
+			continue
+		}
+		if dict, ok := obj.(pdfDict); ok {
+			if raw, end, ok := lex.streamData(dict); ok {
+				obj = &pdfStream{dict: dict, raw: raw}
+				lex.pos = end
+			}
+		}
+
+		if _, seen := doc.objects[num]; !seen {
+			doc.order = append(doc.order, num)
+		}
+		doc.objects[num] = obj
+		next = lex.pos
+	}
+	if len(doc.objects) == 0 {
+		return nil, errPDFSyntax
+	}
+
+	doc.expandObjectStreams()
+	return doc, nil
+}
+
+// expandObjectStreams adds the objects packed into /Type /ObjStm streams.
+// Objects defined directly in the file take precedence.
+func (d *Document) expandObjectStreams() {
+	for _, num := range append([]int(nil), d.order...) {
+		stream, ok := d.objects[num].(*pdfStream)
+		if !ok || stream.dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		data, err := d.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+		count, _ := d.resolve(stream.dict["N"]).(int)
+		first, _ := d.resolve(stream.dict["First"]).(int)
+		if first <= 0 || first > len(data) {
+			continue
+		}
+
+		header := &pdfLexer{buf: data[:first]}
+		for i := 0; i < count; i++ {
+			objNum, err1 := header.object(0)
+			offset, err2 := header.object(0)
+			n, ok1 := objNum.(int)
+			off, ok2 := offset.(int)
+			if err1 != nil || err2 != nil || !ok1 || !ok2 {
+				break
+			}
+			if _, exists := d.objects[n]; exists || first+off >= len(data) {
+				continue
+			}
+			obj, err := (&pdfLexer{buf: data, pos: first + off}).object(0)
+			if err != nil {
+				continue
+			}
+			d.objects[n] = obj
+			d.order = append(d.order, n)
+		}
+	}
+}
+
+func (d *Document) resolve(value interface{}) interface{} {
+	for i := 0; i < maxPDFNesting; i++ {
+		ref, ok := value.(pdfRef)
+		if !ok {
+			return value
+		}
+		value = d.objects[ref.num]
+	}
+	return nil
+}
+
+func (d *Document) dict(value interface{}) pdfDict {
+	switch v := d.resolve(value).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.dict
+	}
+	return nil
+}
+
+// catalog prefers the /Root named by the last trailer or cross-reference
+// stream, falling back to any /Type /Catalog object.
+func (d *Document) catalog() pdfDict {
+	var root interface{}
+	for _, num := range d.order {
+		if stream, ok := d.objects[num].(*pdfStream); ok && stream.dict["Type"] == pdfName("XRef") {
+			if r, ok := stream.dict["Root"]; ok {
+				root = r
+			}
+		}
+	}
+	if i := bytes.LastIndex(d.data, []byte("trailer")); i >= 0 {
+		if trailer, err := (&pdfLexer{buf: d.data, pos: i + len("trailer")}).object(0); err == nil {
+			if dict, ok := trailer.(pdfDict); ok && dict["Root"] != nil {
+				root = dict["Root"]
+			}
+		}
+	}
+	if catalog := d.dict(root); catalog != nil {
+		return catalog
+	}
+
+	for _, num := range d.order {
+		if dict := d.dict(d.objects[num]); dict["Type"] == pdfName("Catalog") {
+			return dict
+		}
+	}
+	return nil
+}
+
+// pages lists the pages in document order. Without a usable page tree,
+// every /Type /Page object is taken in file order.
+func (d *Document) pages() ([]pdfDict, error) {
+	var pages []pdfDict
+	if catalog := d.catalog(); catalog != nil {
+		pages = d.collectPages(catalog["Pages"], map[int]bool{}, 0, pages)
+	}
+	if len(pages) == 0 {
+		for _, num := range d.order {
+			if dict := d.dict(d.objects[num]); dict["Type"] == pdfName("Page") {
+				pages = append(pages, dict)
+			}
+		}
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%w: no pages found", errPDFSyntax)
+	}
+	return pages, nil
+}
+
+func (d *Document) collectPages(node interface{}, visited map[int]bool, depth int, pages []pdfDict) []pdfDict {
+	if ref, ok := node.(pdfRef); ok {
+		if visited[ref.num] {
+			return pages
+		}
+		visited[ref.num] = true
+	}
+	dict := d.dict(node)
+	if dict == nil || depth > maxPDFNesting {
+		return pages
+	}
+	if dict["Type"] == pdfName("Page") {
+		return append(pages, dict)
+	}
+
+	kids, _ := d.resolve(dict["Kids"]).([]interface{})
+	for _, kid := range kids {
+		pages = d.collectPages(kid, visited, depth+1, pages)
+	}
+	return pages
+}
+
+// resources returns a page's resources, which may be inherited from an
+// ancestor in the page tree.
+func (d *Document) resources(page pdfDict) pdfDict {
+	node := page
+	for i := 0; node != nil && i < maxPDFNesting; i++ {
+		if resources := d.dict(node["Resources"]); resources != nil {
+			return resources
+		}
+		node = d.dict(node["Parent"])
+	}
+	return nil
+}
+
+// largestImage finds the biggest image XObject drawn through the resources,
+// looking into form XObjects as well.
+func (d *Document) largestImage(resources pdfDict, depth int) *pdfStream {
+	var best *pdfStream
+	var bestArea int64
+
+	xobjects := d.dict(resources["XObject"])
+	for _, value := range xobjects {
+		stream, ok := d.resolve(value).(*pdfStream)
+		if !ok {
+			continue
+		}
+
+		candidate := stream
+		switch stream.dict["Subtype"] {
+		case pdfName("Image"):
+			if mask, _ := stream.dict["ImageMask"].(bool); mask {
+				continue
+			}
+		case pdfName("Form"):
+			if depth >= 3 {
+				continue
+			}
+			candidate = d.largestImage(d.dict(stream.dict["Resources"]), depth+1)
+		default:
+			continue
+		}
+		if candidate == nil {
+			continue
+		}
+
+		width, _ := d.resolve(candidate.dict["Width"]).(int)
+		height, _ := d.resolve(candidate.dict["Height"]).(int)
+		if area := int64(width) * int64(height); area > bestArea {
+			best, bestArea = candidate, area
+		}
+	}
+	return best
+}
+
+// FirstPageImage decodes the largest raster image drawn on the first page,
+// which for scanned documents is the page itself.
+func (d *Document) FirstPageImage() (image.Image, error) {
+	pages, err := d.pages()
+	if err != nil {
+		return nil, err
+	}
+
+	stream := d.largestImage(d.resources(pages[0]), 0)
+	if stream == nil {
+		return nil, fmt.Errorf("%w: the first page has no embedded image", ErrUnsupported)
+	}
+	return d.decodeImage(stream)
+}
+
+func (d *Document) filters(stream *pdfStream) ([]pdfName, []pdfDict) {
+	var names []pdfName
+	var params []pdfDict
+	switch filter := d.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		names = []pdfName{filter}
+		params = []pdfDict{d.dict(stream.dict["DecodeParms"])}
+	case []interface{}:
+		paramList, _ := d.resolve(stream.dict["DecodeParms"]).([]interface{})
+		for i, f := range filter {
+			name, _ := d.resolve(f).(pdfName)
+			names = append(names, name)
+			var p pdfDict
+			if i < len(paramList) {
+				p = d.dict(paramList[i])
+			}
+			params = append(params, p)
+		}
+	}
+	return names, params
+}
+
+// decodeStream applies the stream's filters. Only FlateDecode is supported,
+// which is all object streams and most lossless images use.
+func (d *Document) decodeStream(stream *pdfStream) ([]byte, error) {
+	names, params := d.filters(stream)
+	return d.applyFilters(stream.raw, names, params)
+}
+
+func (d *Document) applyFilters(data []byte, names []pdfName, params []pdfDict) ([]byte, error) {
+	for i, name := range names {
+		if name != "FlateDecode" {
+			return nil, fmt.Errorf("%w: %s streams", ErrUnsupported, name)
+		}
+		reader, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errPDFSyntax, err)
+		}
+		decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBytes+1))
+		reader.Close()
+		if err != nil && len(decoded) == 0 {
+			return nil, fmt.Errorf("%w: %v", errPDFSyntax, err)
+		}
+		if len(decoded) > maxDecodedBytes {
+			return nil, fmt.Errorf("%w: stream too large", ErrUnsupported)
+		}
+		if data, err = d.unpredict(decoded, params[i]); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (d *Document) decodeImage(stream *pdfStream) (image.Image, error) {
+	width, _ := d.resolve(stream.dict["Width"]).(int)
+	height, _ := d.resolve(stream.dict["Height"]).(int)
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("%w: invalid image size %dx%d", errPDFSyntax, width, height)
+	}
+	if int64(width)*int64(height) > MaxImagePixels {
+		return nil, fmt.Errorf("%w: image is %dx%d pixels", ErrUnsupported, width, height)
+	}
+
+	names, params := d.filters(stream)
+	if n := len(names); n > 0 && names[n-1] == "DCTDecode" {
+		data, err := d.applyFilters(stream.raw, names[:n-1], params[:n-1])
+		if err != nil {
+			return nil, err
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode embedded JPEG: %v", err)
+		}
+		return img, nil
+	}
+
+	data, err := d.applyFilters(stream.raw, names, params)
+	if err != nil {
+		return nil, err
+	}
+	if bpc, _ := d.resolve(stream.dict["BitsPerComponent"]).(int); bpc != 8 {
+		return nil, fmt.Errorf("%w: %d bits per component", ErrUnsupported, bpc)
+	}
+	colors, err := d.colorComponents(stream.dict["ColorSpace"])
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < width*height*colors {
+		return nil, fmt.Errorf("%w: image data is truncated", errPDFSyntax)
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	switch colors {
+	case 1:
+		return &image.Gray{Pix: data, Stride: width, Rect: rect}, nil
+	case 3:
+		img := image.NewRGBA(rect)
+		for i, j := 0, 0; i < width*height*3; i, j = i+3, j+4 {
+			img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = data[i], data[i+1], data[i+2], 0xff
+		}
+		return img, nil
+	default:
+		return &image.CMYK{Pix: data, Stride: width * 4, Rect: rect}, nil
+	}
+}
+
+func (d *Document) colorComponents(space interface{}) (int, error) {
+	switch cs := d.resolve(space).(type) {
+	case pdfName:
+		switch cs {
+		case "DeviceGray", "CalGray", "G":
+			return 1, nil
+		case "DeviceRGB", "CalRGB", "RGB":
+			return 3, nil
+		case "DeviceCMYK", "CMYK":
+			return 4, nil
+		}
+		return 0, fmt.Errorf("%w: %s color space", ErrUnsupported, cs)
+	case []interface{}:
+		if len(cs) == 2 && d.resolve(cs[0]) == pdfName("ICCBased") {
+			if n, _ := d.resolve(d.dict(cs[1])["N"]).(int); n == 1 || n == 3 || n == 4 {
+				return n, nil
+			}
+		}
+		if len(cs) > 0 {
+			return 0, fmt.Errorf("%w: %v color space", ErrUnsupported, d.resolve(cs[0]))
+		}
+	}
+	return 0, fmt.Errorf("%w: missing color space", ErrUnsupported)
+}
+
+// unpredict reverses the PNG row predictors (/Predictor 10-15) used with
+// FlateDecode.
+func (d *Document) unpredict(data []byte, params pdfDict) ([]byte, error) {
+	predictor, _ := d.resolve(params["Predictor"]).(int)
+	if predictor < 2 {
+		return data, nil
+	}
+	if predictor < 10 {
+		return nil, fmt.Errorf("%w: TIFF predictor", ErrUnsupported)
+	}
+
+	colors := intOr(d.resolve(params["Colors"]), 1)
+	bpc := intOr(d.resolve(params["BitsPerComponent"]), 8)
+	columns := intOr(d.resolve(params["Columns"]), 1)
+	bpp := max(1, colors*bpc/8)
+	rowLen := (columns*colors*bpc + 7) / 8
+	if rowLen <= 0 {
+		return nil, errPDFSyntax
+	}
+
+	out := make([]byte, 0, len(data)/(rowLen+1)*rowLen)
+	prev := make([]byte, rowLen)
+	for len(data) >= rowLen+1 {
+		filter, row := data[0], data[1:rowLen+1]
+		data = data[rowLen+1:]
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch filter {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func intOr(value interface{}, fallback int) int {
+	if n, ok := value.(int); ok && n > 0 {
+		return n
+	}
+	return fallback
+}
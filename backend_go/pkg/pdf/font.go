@@ -0,0 +1,293 @@
+package pdf
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// maxCMapEntries bounds the character map built from one ToUnicode
+	// stream.
+	maxCMapEntries = 1 << 17
+)
+
+// font turns the character codes of a shown string into text. Codes are
+// looked up in the font's ToUnicode map when it has one; simple fonts fall
+// back to their byte encoding.
+type font struct {
+	cmap *cmap
+	// codeLen is the code width without a usable codespace: composite
+	// (Type0) fonts use two-byte codes.
+	codeLen  int
+	encoding [256]string
+}
+
+var defaultFont = &font{codeLen: 1, encoding: winAnsiEncoding}
+
+func (d *Document) loadFont(value interface{}) *font {
+	dict := d.dict(value)
+	if dict == nil {
+		return defaultFont
+	}
+
+	f := &font{codeLen: 1, encoding: winAnsiEncoding}
+	if dict["Subtype"] == pdfName("Type0") {
+		f.codeLen = 2
+	}
+	if encoding := d.dict(dict["Encoding"]); encoding != nil {
+		differences, _ := d.resolve(encoding["Differences"]).([]interface{})
+		code := -1
+		for _, entry := range differences {
+			switch v := d.resolve(entry).(type) {
+			case int:
+				code = v
+			case pdfName:
+				if code >= 0 && code < 256 {
+					if text, ok := glyphText(string(v)); ok {
+						f.encoding[code] = text
+					}
+					code++
+				}
+			}
+		}
+	}
+	if stream, ok := d.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := d.decodeStream(stream); err == nil {
+			f.cmap = parseCMap(data)
+		}
+	}
+	return f
+}
+
+func (f *font) decode(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		if f.cmap != nil {
+			n := f.cmap.codeLength(s[i:], f.codeLen)
+			var code uint32
+			for _, b := range []byte(s[i : i+n]) {
+				code = code<<8 | uint32(b)
+			}
+			if text, ok := f.cmap.chars[code]; ok {
+				out.WriteString(text)
+			} else if n == 1 {
+				out.WriteString(f.encoding[s[i]])
+			}
+			i += n
+			continue
+		}
+		if f.codeLen != 1 {
+			// Glyph IDs of a composite font carry no text without a map.
+			return out.String()
+		}
+		out.WriteString(f.encoding[s[i]])
+		i++
+	}
+	return out.String()
+}
+
+type codespace struct {
+	low, high string
+}
+
+type cmap struct {
+	codespaces []codespace
+	chars      map[uint32]string
+}
+
+// codeLength returns how many bytes the next code in s takes, following the
+// codespace ranges of the map.
+func (c *cmap) codeLength(s string, fallback int) int {
+	for _, space := range c.codespaces {
+		n := len(space.low)
+		if n == 0 || n > len(s) || len(space.high) != n {
+			continue
+		}
+		inside := true
+		for i := 0; i < n; i++ {
+			if s[i] < space.low[i] || s[i] > space.high[i] {
+				inside = false
+				break
+			}
+		}
+		if inside {
+			return n
+		}
+	}
+	return min(max(fallback, 1), len(s))
+}
+
+// parseCMap reads the codespace ranges and bfchar/bfrange mappings of a
+// ToUnicode CMap. Anything else in the PostScript is skipped.
+func parseCMap(data []byte) *cmap {
+	c := &cmap{chars: map[uint32]string{}}
+	lex := &pdfLexer{buf: data}
+
+	var operands []interface{}
+	for len(c.chars) < maxCMapEntries {
+		lex.skipSpace()
+		if lex.pos >= len(lex.buf) {
+			break
+		}
+		obj, err := lex.object(0)
+		if err != nil {
+			lex.pos++
+			operands = operands[:0]
+			continue
+		}
+		keyword, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch keyword {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				low, ok1 := operands[i].(string)
+				high, ok2 := operands[i+1].(string)
+				if ok1 && ok2 {
+					c.codespaces = append(c.codespaces, codespace{low: low, high: high})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(string)
+				dst, ok2 := operands[i+1].(string)
+				if ok1 && ok2 && len(src) <= 4 {
+					c.chars[codeValue(src)] = utf16Text(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, ok1 := operands[i].(string)
+				high, ok2 := operands[i+1].(string)
+				if !ok1 || !ok2 || len(low) > 4 || len(high) > 4 {
+					continue
+				}
+				c.addRange(codeValue(low), codeValue(high), operands[i+2])
+			}
+		}
+		operands = operands[:0]
+	}
+	return c
+}
+
+func (c *cmap) addRange(low, high uint32, dst interface{}) {
+	if high < low || high-low >= 1<<16 {
+		return
+	}
+	switch v := dst.(type) {
+	case string:
+		// The last UTF-16 unit of the destination is incremented through the
+		// range.
+		units := utf16Units(v)
+		if len(units) == 0 {
+			return
+		}
+		for code := low; code <= high && len(c.chars) < maxCMapEntries; code++ {
+			next := append([]uint16(nil), units...)
+			next[len(next)-1] += uint16(code - low)
+			c.chars[code] = string(utf16.Decode(next))
+		}
+	case []interface{}:
+		for i, entry := range v {
+			code := low + uint32(i)
+			if code > high {
+				break
+			}
+			if s, ok := entry.(string); ok {
+				c.chars[code] = utf16Text(s)
+			}
+		}
+	}
+}
+
+func codeValue(s string) uint32 {
+	var code uint32
+	for i := 0; i < len(s); i++ {
+		code = code<<8 | uint32(s[i])
+	}
+	return code
+}
+
+func utf16Units(s string) []uint16 {
+	units := make([]uint16, 0, (len(s)+1)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return units
+}
+
+func utf16Text(s string) string {
+	return string(utf16.Decode(utf16Units(s)))
+}
+
+// winAnsiEncoding is the Windows-1252 code page, which also stands in for
+// the Standard and MacRoman encodings of simple fonts: they agree on ASCII,
+// which is what matters for search.
+var winAnsiEncoding = func() [256]string {
+	var table [256]string
+	for i := 0x20; i < 0x7f; i++ {
+		table[i] = string(rune(i))
+	}
+	table['\t'], table['\n'], table['\r'] = " ", " ", " "
+	high := []rune{
+		0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+		0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+		0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+		0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+	}
+	for i, r := range high {
+		if r != 0 {
+			table[0x80+i] = string(r)
+		}
+	}
+	for i := 0xa0; i < 0x100; i++ {
+		table[i] = string(rune(i))
+	}
+	return table
+}()
+
+// glyphNames maps the glyph names used in /Differences arrays that are not
+// a single character, covering punctuation and accented Latin letters.
+var glyphNames = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#",
+	"dollar": "$", "percent": "%", "ampersand": "&", "quotesingle": "'",
+	"parenleft": "(", "parenright": ")", "asterisk": "*", "plus": "+",
+	"comma": ",", "hyphen": "-", "period": ".", "slash": "/",
+	"zero": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+	"five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+	"colon": ":", "semicolon": ";", "less": "<", "equal": "=", "greater": ">",
+	"question": "?", "at": "@", "bracketleft": "[", "backslash": "\\",
+	"bracketright": "]", "underscore": "_", "quoteleft": "‘",
+	"quoteright": "’", "quotedblleft": "“", "quotedblright": "”",
+	"endash": "–", "emdash": "—", "bullet": "•", "ellipsis": "…",
+	"degree": "°", "plusminus": "±", "mu": "µ",
+	"guillemotleft": "«", "guillemotright": "»",
+	"fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl",
+	"oe": "œ", "OE": "Œ", "ae": "æ", "AE": "Æ",
+	"agrave": "à", "acircumflex": "â", "ccedilla": "ç",
+	"eacute": "é", "egrave": "è", "ecircumflex": "ê", "edieresis": "ë",
+	"icircumflex": "î", "idieresis": "ï", "ocircumflex": "ô",
+	"ugrave": "ù", "ucircumflex": "û", "udieresis": "ü",
+	"Agrave": "À", "Acircumflex": "Â", "Ccedilla": "Ç",
+	"Eacute": "É", "Egrave": "È", "Ecircumflex": "Ê",
+	"Ocircumflex": "Ô", "Ugrave": "Ù",
+}
+
+func glyphText(name string) (string, bool) {
+	if len(name) == 1 {
+		return name, true
+	}
+	if text, ok := glyphNames[name]; ok {
+		return text, true
+	}
+	if len(name) == 7 && strings.HasPrefix(name, "uni") {
+		if code, err := strconv.ParseUint(name[3:], 16, 16); err == nil {
+			return string(rune(code)), true
+		}
+	}
+	return "", false
+}
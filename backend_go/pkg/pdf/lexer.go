@@ -0,0 +1,285 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"strconv"
+)
+
+type pdfLexer struct {
+	buf []byte
+	pos int
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.buf) {
+		c := l.buf[l.pos]
+		if isPDFSpace(c) {
+			l.pos++
+			continue
+		}
+		if c == '%' {
+			for l.pos < len(l.buf) && l.buf[l.pos] != '\n' && l.buf[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// regular reads a run of regular characters: a number or keyword.
+func (l *pdfLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.buf) && !isPDFSpace(l.buf[l.pos]) && !isPDFDelimiter(l.buf[l.pos]) {
+		l.pos++
+	}
+	return string(l.buf[start:l.pos])
+}
+
+func (l *pdfLexer) object(depth int) (interface{}, error) {
+	if depth > maxPDFNesting {
+		return nil, errPDFSyntax
+	}
+	l.skipSpace()
+	if l.pos >= len(l.buf) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	switch l.buf[l.pos] {
+	case '/':
+		l.pos++
+		return pdfName(decodeName(l.regular())), nil
+	case '(':
+		return l.literalString()
+	case '<':
+		if l.pos+1 < len(l.buf) && l.buf[l.pos+1] == '<' {
+			l.pos += 2
+			return l.dictionary(depth)
+		}
+		return l.hexString()
+	case '[':
+		l.pos++
+		array := []interface{}{}
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.buf) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if l.buf[l.pos] == ']' {
+				l.pos++
+				return array, nil
+			}
+			value, err := l.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+	}
+
+	token := l.regular()
+	switch token {
+	case "":
+		return nil, errPDFSyntax
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	if n, err := strconv.Atoi(token); err == nil {
+		// An integer may start an indirect reference "num gen R".
+		save := l.pos
+		l.skipSpace()
+		if gen, err := strconv.Atoi(l.regular()); err == nil {
+			l.skipSpace()
+			if l.regular() == "R" {
+				return pdfRef{num: n, gen: gen}, nil
+			}
+		}
+		l.pos = save
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(token, 64); err == nil {
+		return f, nil
+	}
+	return pdfKeyword(token), nil
+}
+
+func (l *pdfLexer) dictionary(depth int) (pdfDict, error) {
+	dict := pdfDict{}
+	for {
+		l.skipSpace()
+		if l.pos+1 >= len(l.buf) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if l.buf[l.pos] == '>' && l.buf[l.pos+1] == '>' {
+			l.pos += 2
+			return dict, nil
+		}
+
+		key, err := l.object(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return nil, errPDFSyntax
+		}
+		value, err := l.object(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		dict[name] = value
+	}
+}
+
+func (l *pdfLexer) literalString() (string, error) {
+	var out []byte
+	nesting := 0
+	l.pos++
+	for l.pos < len(l.buf) {
+		c := l.buf[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			nesting++
+		case ')':
+			if nesting == 0 {
+				return string(out), nil
+			}
+			nesting--
+		case '\\':
+			if l.pos >= len(l.buf) {
+				return "", io.ErrUnexpectedEOF
+			}
+			c = l.buf[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				if c == '\r' && l.pos < len(l.buf) && l.buf[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.buf) && l.buf[l.pos] >= '0' && l.buf[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.buf[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(value)
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return "", io.ErrUnexpectedEOF
+}
+
+func (l *pdfLexer) hexString() (string, error) {
+	end := bytes.IndexByte(l.buf[l.pos:], '>')
+	if end < 0 {
+		return "", io.ErrUnexpectedEOF
+	}
+	digits := make([]byte, 0, end)
+	for _, c := range l.buf[l.pos+1 : l.pos+end] {
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	l.pos += end + 1
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	decoded, err := hex.DecodeString(string(digits))
+	if err != nil {
+		return "", errPDFSyntax
+	}
+	return string(decoded), nil
+}
+
+// streamData returns the raw bytes of the stream following dict, if any, and
+// the position after "endstream". A direct /Length is trusted when it lines
+// up with "endstream"; otherwise the keyword is searched for.
+func (l *pdfLexer) streamData(dict pdfDict) ([]byte, int, bool) {
+	pos := l.pos
+	for pos < len(l.buf) && isPDFSpace(l.buf[pos]) {
+		pos++
+	}
+	if !bytes.HasPrefix(l.buf[pos:], []byte("stream")) {
+		return nil, 0, false
+	}
+	pos += len("stream")
+	if pos < len(l.buf) && l.buf[pos] == '\r' {
+		pos++
+	}
+	if pos < len(l.buf) && l.buf[pos] == '\n' {
+		pos++
+	}
+
+	if length, ok := dict["Length"].(int); ok && length >= 0 && pos+length <= len(l.buf) {
+		rest := bytes.TrimLeft(l.buf[pos+length:], "\x00\t\n\f\r ")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			end := len(l.buf) - len(rest) + len("endstream")
+			return l.buf[pos : pos+length], end, true
+		}
+	}
+
+	end := bytes.Index(l.buf[pos:], []byte("endstream"))
+	if end < 0 {
+		return nil, 0, false
+	}
+	raw := bytes.TrimSuffix(l.buf[pos:pos+end], []byte("\n"))
+	raw = bytes.TrimSuffix(raw, []byte("\r"))
+	return raw, pos + end + len("endstream"), true
+}
+
+// decodeName expands #xx escapes in a name.
+func decodeName(name string) string {
+	if !bytes.ContainsRune([]byte(name), '#') {
+		return name
+	}
+	var out []byte
+	for i := 0; i < len(name); i++ {
+		if name[i] == '#' && i+2 < len(name) {
+			if b, err := hex.DecodeString(name[i+1 : i+3]); err == nil {
+				out = append(out, b[0])
+				i += 2
+				continue
+			}
+		}
+		out = append(out, name[i])
+	}
+	return string(out)
+}
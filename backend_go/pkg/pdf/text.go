@@ -0,0 +1,307 @@
+package pdf
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+)
+
+// maxFormDepth bounds how deeply form XObjects are followed.
+const maxFormDepth = 3
+
+// Text returns the text shown on the document's pages, in page order, with
+// pages separated by form feeds and whitespace collapsed. Extraction stops
+// once about limit bytes have been collected. Scanned documents without a
+// text layer yield an empty string.
+func (d *Document) Text(limit int) (string, error) {
+	pages, err := d.pages()
+	if err != nil {
+		return "", err
+	}
+
+	w := &textWriter{limit: limit}
+	fonts := map[interface{}]*font{}
+	for _, page := range pages {
+		if w.full() {
+			break
+		}
+		d.showText(d.pageContent(page), d.resources(page), fonts, w, 0)
+		w.separate(pageBreak)
+	}
+	return w.String(), nil
+}
+
+// pageContent concatenates the page's content streams. Streams that cannot
+// be decoded are left out.
+func (d *Document) pageContent(page pdfDict) []byte {
+	var streams []interface{}
+	switch contents := d.resolve(page["Contents"]).(type) {
+	case *pdfStream:
+		streams = []interface{}{contents}
+	case []interface{}:
+		streams = contents
+	}
+
+	var content []byte
+	for _, value := range streams {
+		stream, ok := d.resolve(value).(*pdfStream)
+		if !ok {
+			continue
+		}
+		data, err := d.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+		content = append(content, data...)
+		content = append(content, '\n')
+	}
+	return content
+}
+
+// showText runs the text operators of a content stream. Positioning is only
+// used to decide where words and lines break.
+func (d *Document) showText(content []byte, resources pdfDict, fonts map[interface{}]*font, w *textWriter, depth int) {
+	fontDict := d.dict(resources["Font"])
+	current := defaultFont
+	var lastY float64
+	haveY := false
+
+	lex := &pdfLexer{buf: content}
+	var operands []interface{}
+	for !w.full() {
+		lex.skipSpace()
+		if lex.pos >= len(lex.buf) {
+			return
+		}
+		obj, err := lex.object(0)
+		if err != nil {
+			// Stray delimiters, such as the braces of PostScript
+			// functions, are skipped.
+			lex.pos++
+			operands = operands[:0]
+			continue
+		}
+		op, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) == 2 {
+				name, _ := operands[0].(pdfName)
+				current = d.fontFor(fontDict[name], fonts)
+			}
+		case "Tj":
+			if len(operands) == 1 {
+				w.write(current.decode(stringOperand(operands[0])))
+			}
+		case "'", "\"":
+			if len(operands) > 0 {
+				w.separate(lineBreak)
+				w.write(current.decode(stringOperand(operands[len(operands)-1])))
+			}
+		case "TJ":
+			if len(operands) == 1 {
+				parts, _ := operands[0].([]interface{})
+				for _, part := range parts {
+					if s, ok := part.(string); ok {
+						w.write(current.decode(s))
+					} else if number(part) < -200 {
+						// A wide negative adjustment is a gap between words.
+						w.separate(wordBreak)
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) == 2 {
+				if number(operands[1]) != 0 {
+					w.separate(lineBreak)
+				} else if number(operands[0]) != 0 {
+					w.separate(wordBreak)
+				}
+			}
+		case "T*":
+			w.separate(lineBreak)
+		case "Tm":
+			if len(operands) == 6 {
+				y := number(operands[5])
+				if haveY && y == lastY {
+					w.separate(wordBreak)
+				} else {
+					w.separate(lineBreak)
+				}
+				lastY, haveY = y, true
+			}
+		case "ET":
+			w.separate(wordBreak)
+		case "BI":
+			skipInlineImage(lex)
+		case "Do":
+			if len(operands) == 1 && depth < maxFormDepth {
+				name, _ := operands[0].(pdfName)
+				form, ok := d.resolve(d.dict(resources["XObject"])[name]).(*pdfStream)
+				if ok && form.dict["Subtype"] == pdfName("Form") {
+					if data, err := d.decodeStream(form); err == nil {
+						formResources := d.dict(form.dict["Resources"])
+						if formResources == nil {
+							formResources = resources
+						}
+						d.showText(data, formResources, fonts, w, depth+1)
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// fontFor loads a font resource once per document.
+func (d *Document) fontFor(value interface{}, fonts map[interface{}]*font) *font {
+	if value == nil {
+		return defaultFont
+	}
+	key := value
+	if _, ok := value.(pdfRef); !ok {
+		// Direct font dictionaries cannot be map keys.
+		return d.loadFont(value)
+	}
+	if f, ok := fonts[key]; ok {
+		return f
+	}
+	f := d.loadFont(value)
+	fonts[key] = f
+	return f
+}
+
+// skipInlineImage moves past the binary data of a BI ... ID ... EI inline
+// image, which would otherwise be read as operators.
+func skipInlineImage(lex *pdfLexer) {
+	start := bytes.Index(lex.buf[lex.pos:], []byte("ID"))
+	if start < 0 {
+		lex.pos = len(lex.buf)
+		return
+	}
+	pos := lex.pos + start + len("ID")
+	for {
+		end := bytes.Index(lex.buf[pos:], []byte("EI"))
+		if end < 0 {
+			lex.pos = len(lex.buf)
+			return
+		}
+		at := pos + end
+		after := at + len("EI")
+		if isPDFSpace(lex.buf[at-1]) && (after == len(lex.buf) || isPDFSpace(lex.buf[after])) {
+			lex.pos = after
+			return
+		}
+		pos = after
+	}
+}
+
+func stringOperand(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+func number(value interface{}) float64 {
+	switch n := value.(type) {
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+type textBreak int
+
+const (
+	noBreak textBreak = iota
+	wordBreak
+	lineBreak
+	pageBreak
+)
+
+// textWriter collects extracted text, collapsing runs of whitespace into the
+// strongest break among them.
+type textWriter struct {
+	buf     strings.Builder
+	limit   int
+	pending textBreak
+}
+
+func (w *textWriter) full() bool {
+	return w.limit > 0 && w.buf.Len() >= w.limit
+}
+
+func (w *textWriter) separate(b textBreak) {
+	if w.buf.Len() > 0 && b > w.pending {
+		w.pending = b
+	}
+}
+
+func (w *textWriter) write(s string) {
+	for _, r := range s {
+		if w.full() {
+			return
+		}
+		if unicode.IsSpace(r) {
+			w.separate(wordBreak)
+			continue
+		}
+		if !unicode.IsGraphic(r) {
+			continue
+		}
+
+		switch w.pending {
+		case wordBreak:
+			w.buf.WriteByte(' ')
+		case lineBreak:
+			w.buf.WriteByte('\n')
+		case pageBreak:
+			w.buf.WriteByte('\f')
+		}
+		w.pending = noBreak
+		w.buf.WriteString(foldPresentationForm(r))
+	}
+}
+
+func (w *textWriter) String() string {
+	return w.buf.String()
+}
+
+// arabicForms maps the contextual letter forms of Arabic Presentation
+// Forms-B, which ToUnicode maps of Arabic fonts often point at, back to the
+// letters that search indexes know.
+var arabicForms = []struct {
+	low, high rune
+	letters   string
+}{
+	{0xFE80, 0xFE80, "ء"}, {0xFE81, 0xFE82, "آ"}, {0xFE83, 0xFE84, "أ"},
+	{0xFE85, 0xFE86, "ؤ"}, {0xFE87, 0xFE88, "إ"}, {0xFE89, 0xFE8C, "ئ"},
+	{0xFE8D, 0xFE8E, "ا"}, {0xFE8F, 0xFE92, "ب"}, {0xFE93, 0xFE94, "ة"},
+	{0xFE95, 0xFE98, "ت"}, {0xFE99, 0xFE9C, "ث"}, {0xFE9D, 0xFEA0, "ج"},
+	{0xFEA1, 0xFEA4, "ح"}, {0xFEA5, 0xFEA8, "خ"}, {0xFEA9, 0xFEAA, "د"},
+	{0xFEAB, 0xFEAC, "ذ"}, {0xFEAD, 0xFEAE, "ر"}, {0xFEAF, 0xFEB0, "ز"},
+	{0xFEB1, 0xFEB4, "س"}, {0xFEB5, 0xFEB8, "ش"}, {0xFEB9, 0xFEBC, "ص"},
+	{0xFEBD, 0xFEC0, "ض"}, {0xFEC1, 0xFEC4, "ط"}, {0xFEC5, 0xFEC8, "ظ"},
+	{0xFEC9, 0xFECC, "ع"}, {0xFECD, 0xFED0, "غ"}, {0xFED1, 0xFED4, "ف"},
+	{0xFED5, 0xFED8, "ق"}, {0xFED9, 0xFEDC, "ك"}, {0xFEDD, 0xFEE0, "ل"},
+	{0xFEE1, 0xFEE4, "م"}, {0xFEE5, 0xFEE8, "ن"}, {0xFEE9, 0xFEEC, "ه"},
+	{0xFEED, 0xFEEE, "و"}, {0xFEEF, 0xFEF0, "ى"}, {0xFEF1, 0xFEF4, "ي"},
+	{0xFEF5, 0xFEF6, "لآ"}, {0xFEF7, 0xFEF8, "لأ"},
+	{0xFEF9, 0xFEFA, "لإ"}, {0xFEFB, 0xFEFC, "لا"},
+}
+
+func foldPresentationForm(r rune) string {
+	if r >= 0xFE80 && r <= 0xFEFC {
+		for _, form := range arabicForms {
+			if r >= form.low && r <= form.high {
+				return form.letters
+			}
+		}
+	}
+	return string(r)
+}
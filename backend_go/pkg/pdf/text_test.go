@@ -0,0 +1,137 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildDocument writes the objects as "1 0 obj" onwards, with object 1 as
+// the catalog, and a correct xref table.
+func buildDocument(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func stream(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func flate(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestText_SimpleFontsAcrossPages(t *testing.T) {
+	page1 := "BT /F1 12 Tf 72 720 Td (Lab report) Tj 0 -14 Td [(H\\351mo)-20(globine)-400(13.5 g/dL)] TJ ET"
+	page2 := "BT /F2 10 Tf 1 0 0 1 72 700 Tm <0102> Tj ET"
+	data := buildDocument(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 8 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /Encoding << /Differences [1 /eacute /fi] >> >>",
+		stream("/Filter /FlateDecode", flate([]byte(page1))),
+		stream("", []byte(page2)),
+	)
+
+	doc, err := Parse(data)
+	require.NoError(t, err)
+	text, err := doc.Text(0)
+	require.NoError(t, err)
+	assert.Equal(t, "Lab report\nHémoglobine 13.5 g/dL\féfi", text)
+}
+
+func TestText_ToUnicodeMap(t *testing.T) {
+	cmap := `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar
+<0003> <0020>
+<0010> <FEDF>
+endbfchar
+2 beginbfrange
+<0020> <0022> <0041>
+<0030> <0031> [<FE8E> <FEE3>]
+endbfrange
+endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end`
+	data := buildDocument(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 6 0 R >>",
+		"<< /Type /Font /Subtype /Type0 /BaseFont /Amiri /Encoding /Identity-H /ToUnicode 5 0 R >>",
+		stream("", []byte(cmap)),
+		stream("", []byte("BT /F1 11 Tf <002000210022> Tj <0003> Tj <001000300031> Tj ET")),
+	)
+
+	doc, err := Parse(data)
+	require.NoError(t, err)
+	text, err := doc.Text(0)
+	require.NoError(t, err)
+	assert.Equal(t, "ABC لام", text, "Presentation forms are folded to their letters")
+}
+
+func TestText_SkipsInlineImagesAndHonoursLimit(t *testing.T) {
+	content := "BI /W 2 /H 1 /BPC 8 /CS /G ID \x00(Tj\xff EI\nBT (first words here) Tj ET"
+	data := buildDocument(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+		stream("", []byte(content)),
+	)
+
+	doc, err := Parse(data)
+	require.NoError(t, err)
+	text, err := doc.Text(0)
+	require.NoError(t, err)
+	assert.Equal(t, "first words here", text)
+
+	text, err = doc.Text(5)
+	require.NoError(t, err)
+	assert.Equal(t, "first", text)
+}
+
+func TestText_CompositeFontWithoutMapYieldsNothing(t *testing.T) {
+	data := buildDocument(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 << /Subtype /Type0 >> >> >> /Contents 4 0 R >>",
+		stream("", []byte("BT /F1 9 Tf <00410042> Tj ET")),
+	)
+
+	doc, err := Parse(data)
+	require.NoError(t, err)
+	text, err := doc.Text(0)
+	require.NoError(t, err)
+	assert.Empty(t, text)
+}
+
+func TestIsPDF(t *testing.T) {
+	assert.True(t, IsPDF([]byte("%PDF-1.7\n")))
+	assert.True(t, IsPDF([]byte("\xef\xbb\xbf%PDF-1.4")))
+	assert.False(t, IsPDF([]byte("plain text")))
+}
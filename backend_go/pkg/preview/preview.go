@@ -0,0 +1,121 @@
+// Package preview renders small JPEG previews of record files using only the
+// standard library. Images are decoded and scaled down; PDFs are previewed
+// from the largest raster image on their first page, which covers scanned
+// documents, the bulk of what patients upload.
+package preview
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+
+	"healthcare_backend/pkg/pdf"
+
+	// Register the decoders image.Decode dispatches to.
+	_ "image/gif"
+	_ "image/png"
+)
+
+// ErrUnsupported means no preview can be made for the file. Callers should
+// record it rather than retry.
+var ErrUnsupported = errors.New("preview not supported")
+
+const (
+	// MaxDimension bounds the width and height of generated previews.
+	MaxDimension = 320
+
+	maxSourceSize = 64 << 20
+	maxPixels     = pdf.MaxImagePixels
+	jpegQuality   = 80
+)
+
+// Preview is an encoded JPEG preview.
+type Preview struct {
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// Supported reports whether files with this extension can have a preview.
+func Supported(ext string) bool {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "pdf", "jpg", "jpeg", "jpe", "png", "gif":
+		return true
+	}
+	return false
+}
+
+// Generate reads a PDF or image and returns a JPEG that fits within
+// MaxDimension on both sides.
+func Generate(content io.Reader) (*Preview, error) {
+	data, err := io.ReadAll(io.LimitReader(content, maxSourceSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	if len(data) > maxSourceSize {
+		return nil, fmt.Errorf("%w: file is larger than %d bytes", ErrUnsupported, maxSourceSize)
+	}
+
+	var img image.Image
+	if pdf.IsPDF(data) {
+		img, err = pdfFirstPageImage(data)
+	} else {
+		img, err = decodeImage(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := Thumbnail(img, MaxDimension)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode preview: %v", err)
+	}
+
+	return &Preview{Data: buf.Bytes(), Width: thumb.Bounds().Dx(), Height: thumb.Bounds().Dy()}, nil
+}
+
+func pdfFirstPageImage(data []byte) (image.Image, error) {
+	doc, err := pdf.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	img, err := doc.FirstPageImage()
+	if errors.Is(err, pdf.ErrUnsupported) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return img, err
+}
+
+func decodeImage(data []byte) (image.Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == image.ErrFormat {
+		return nil, fmt.Errorf("%w: unknown image format", ErrUnsupported)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %v", err)
+	}
+	if err := checkPixels(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s image: %v", format, err)
+	}
+	return img, nil
+}
+
+func checkPixels(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	if int64(width)*int64(height) > maxPixels {
+		return fmt.Errorf("%w: image is %dx%d pixels", ErrUnsupported, width, height)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package preview
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func solidImage(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// buildPDF assembles a one-page PDF whose page draws the given image
+// XObject, written with a correct xref table.
+func buildPDF(imageDict string, imageData []byte) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /XObject << /Im1 4 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 5 0 R >>",
+		fmt.Sprintf("%s /Length %d >>\nstream\n%s\nendstream", imageDict, len(imageData), imageData),
+		"<< /Length 33 >>\nstream\nq 612 0 0 792 0 0 cm /Im1 Do Q\nendstream",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func decodePreview(t *testing.T, p *Preview) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(p.Data))
+	require.NoError(t, err)
+	assert.Equal(t, p.Width, img.Bounds().Dx())
+	assert.Equal(t, p.Height, img.Bounds().Dy())
+	return img
+}
+
+func assertColorNear(t *testing.T, want color.RGBA, got color.Color) {
+	t.Helper()
+	r, g, b, _ := got.RGBA()
+	assert.InDelta(t, want.R, r>>8, 12)
+	assert.InDelta(t, want.G, g>>8, 12)
+	assert.InDelta(t, want.B, b>>8, 12)
+}
+
+func TestGenerate_ScalesImagesDown(t *testing.T) {
+	var src bytes.Buffer
+	require.NoError(t, png.Encode(&src, solidImage(1000, 500, color.RGBA{200, 30, 30, 255})))
+
+	p, err := Generate(&src)
+	require.NoError(t, err)
+	assert.Equal(t, MaxDimension, p.Width)
+	assert.Equal(t, MaxDimension/2, p.Height)
+	assertColorNear(t, color.RGBA{200, 30, 30, 255}, decodePreview(t, p).At(10, 10))
+}
+
+func TestGenerate_PDFWithEmbeddedJPEG(t *testing.T) {
+	var scan bytes.Buffer
+	require.NoError(t, jpeg.Encode(&scan, solidImage(640, 800, color.RGBA{20, 40, 220, 255}), nil))
+
+	pdf := buildPDF("<< /Type /XObject /Subtype /Image /Width 640 /Height 800 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode", scan.Bytes())
+	p, err := Generate(bytes.NewReader(pdf))
+	require.NoError(t, err)
+	assert.Equal(t, 256, p.Width)
+	assert.Equal(t, MaxDimension, p.Height)
+	assertColorNear(t, color.RGBA{20, 40, 220, 255}, decodePreview(t, p).At(5, 5))
+}
+
+func TestGenerate_PDFWithFlateImageAndPredictor(t *testing.T) {
+	const width, height = 4, 3
+	var rows []byte
+	for y := 0; y < height; y++ {
+		// PNG "Up" filter: the first row is raw, later rows are zero deltas.
+		rows = append(rows, 2)
+		for x := 0; x < width; x++ {
+			if y == 0 {
+				rows = append(rows, 10, 200, 90)
+			} else {
+				rows = append(rows, 0, 0, 0)
+			}
+		}
+	}
+
+	pdf := buildPDF(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /DecodeParms << /Predictor 15 /Colors 3 /Columns %d >>", width, height, width), deflate(rows))
+	p, err := Generate(bytes.NewReader(pdf))
+	require.NoError(t, err)
+	assert.Equal(t, width, p.Width)
+	assert.Equal(t, height, p.Height)
+	assertColorNear(t, color.RGBA{10, 200, 90, 255}, decodePreview(t, p).At(1, 2))
+}
+
+func TestGenerate_PDFObjectStreams(t *testing.T) {
+	var scan bytes.Buffer
+	require.NoError(t, jpeg.Encode(&scan, solidImage(50, 50, color.Gray{128}), nil))
+
+	// Catalog, page tree and page live in a compressed object stream.
+	packed := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /XObject << /Im1 4 0 R >> >> >>",
+	}
+	var header, body string
+	for i, obj := range packed {
+		header += fmt.Sprintf("%d %d ", i+1, len(body))
+		body += obj + " "
+	}
+	objStm := deflate([]byte(header + body))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.5\n")
+	fmt.Fprintf(&buf, "4 0 obj\n<< /Subtype /Image /Width 50 /Height 50 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n", scan.Len())
+	buf.Write(scan.Bytes())
+	buf.WriteString("\nendstream\nendobj\n")
+	fmt.Fprintf(&buf, "5 0 obj\n<< /Type /ObjStm /N 3 /First %d /Filter /FlateDecode /Length %d >>\nstream\n", len(header), len(objStm))
+	buf.Write(objStm)
+	buf.WriteString("\nendstream\nendobj\n%%EOF\n")
+
+	p, err := Generate(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 50, p.Width)
+	assertColorNear(t, color.RGBA{128, 128, 128, 255}, decodePreview(t, p).At(25, 25))
+}
+
+func TestGenerate_Unsupported(t *testing.T) {
+	textOnly := buildPDF("<< /Type /XObject /Subtype /Form /BBox [0 0 1 1]", []byte("0 0 m"))
+	_, err := Generate(bytes.NewReader(textOnly))
+	assert.True(t, errors.Is(err, ErrUnsupported), "got %v", err)
+
+	jpx := buildPDF("<< /Type /XObject /Subtype /Image /Width 10 /Height 10 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /JPXDecode", []byte("jp2"))
+	_, err = Generate(bytes.NewReader(jpx))
+	assert.True(t, errors.Is(err, ErrUnsupported), "got %v", err)
+
+	_, err = Generate(bytes.NewReader([]byte("plain text notes")))
+	assert.True(t, errors.Is(err, ErrUnsupported), "got %v", err)
+}
+
+func TestGenerate_CorruptImageIsNotUnsupported(t *testing.T) {
+	var src bytes.Buffer
+	require.NoError(t, png.Encode(&src, solidImage(20, 20, color.White)))
+
+	_, err := Generate(bytes.NewReader(src.Bytes()[:60]))
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrUnsupported))
+}
+
+func TestThumbnail_FlattensTransparencyOntoWhite(t *testing.T) {
+	thumb := Thumbnail(solidImage(8, 8, color.RGBA{0, 0, 0, 0}), 4)
+	assert.Equal(t, image.Rect(0, 0, 4, 4), thumb.Bounds())
+	assert.Equal(t, color.RGBA{255, 255, 255, 255}, thumb.RGBAAt(2, 2))
+}
+
+func TestFitWithin(t *testing.T) {
+	w, h := fitWithin(100, 50, 320)
+	assert.Equal(t, []int{100, 50}, []int{w, h}, "Small images are not enlarged")
+
+	w, h = fitWithin(3000, 10, 320)
+	assert.Equal(t, []int{320, 1}, []int{w, h})
+
+	w, h = fitWithin(600, 1200, 320)
+	assert.Equal(t, []int{160, 320}, []int{w, h})
+}
+
+func TestSupported(t *testing.T) {
+	assert.True(t, Supported("PDF"))
+	assert.True(t, Supported(".jpg"))
+	assert.False(t, Supported("dcm"))
+	assert.False(t, Supported("heic"))
+}
@@ -0,0 +1,71 @@
+package preview
+
+import "image"
+
+// maxSamples caps how many source pixels are averaged per axis for each
+// output pixel, keeping large scans cheap to shrink.
+const maxSamples = 4
+
+// Thumbnail scales src down to fit within maxDim on both sides, averaging
+// source pixels and flattening transparency onto white. Smaller images keep
+// their size.
+func Thumbnail(src image.Image, maxDim int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), maxDim)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := sourceSpan(y, height, bounds.Min.Y, bounds.Dy())
+		for x := 0; x < width; x++ {
+			x0, x1 := sourceSpan(x, width, bounds.Min.X, bounds.Dx())
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy += step(y1 - y0) {
+				for sx := x0; sx < x1; sx += step(x1 - x0) {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			r, g, b, a = r/n, g/n, b/n, a/n
+
+			// Colors are alpha-premultiplied, so compositing over white only
+			// adds the uncovered fraction.
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8((r + 0xffff - a) >> 8)
+			dst.Pix[i+1] = uint8((g + 0xffff - a) >> 8)
+			dst.Pix[i+2] = uint8((b + 0xffff - a) >> 8)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+
+	return dst
+}
+
+func fitWithin(width, height, maxDim int) (int, int) {
+	if width <= maxDim && height <= maxDim {
+		return width, height
+	}
+	if width >= height {
+		height = max(1, height*maxDim/width)
+		width = maxDim
+	} else {
+		width = max(1, width*maxDim/height)
+		height = maxDim
+	}
+	return width, height
+}
+
+// sourceSpan returns the source coordinates covered by output pixel i.
+func sourceSpan(i, outSize, origin, inSize int) (int, int) {
+	start := origin + i*inSize/outSize
+	end := origin + (i+1)*inSize/outSize
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
+
+func step(span int) int {
+	return max(1, span/maxSamples)
+}
@@ -0,0 +1,109 @@
+package realtime
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client is one WebSocket connection of a user.
+type Client struct {
+	UserID string
+
+	hub  *Hub
+	conn *websocket.Conn
+	send chan []byte
+	// topics this connection subscribed to; guarded by hub.mu.
+	topics map[string]struct{}
+}
+
+func NewClient(hub *Hub, userID string, conn *websocket.Conn) *Client {
+	return &Client{
+		UserID: userID,
+		hub:    hub,
+		conn:   conn,
+		send:   make(chan []byte, sendBufferSize),
+	}
+}
+
+// Run registers the connection and serves it until it closes, passing each
+// message the client sends to onMessage. Connections that stop answering
+// pings are closed after the pong wait.
+func (c *Client) Run(onMessage func(c *Client, message []byte)) {
+	c.hub.Register(c)
+	defer c.hub.Unregister(c)
+
+	go c.writePump()
+	c.readPump(onMessage)
+}
+
+// Send queues message on this connection only. It returns false when the
+// connection is closed or cannot keep up.
+func (c *Client) Send(message []byte) bool {
+	return c.hub.send(c, message)
+}
+
+// Subscribe makes this connection receive messages published to topics.
+func (c *Client) Subscribe(topics ...string) {
+	c.hub.subscribe(c, topics)
+}
+
+// Unsubscribe stops messages published to topics on this connection.
+func (c *Client) Unsubscribe(topics ...string) {
+	c.hub.unsubscribe(c, topics)
+}
+
+func (c *Client) readPump(onMessage func(c *Client, message []byte)) {
+	defer c.conn.Close()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("WebSocket read error for user %s: %v", c.UserID, err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
+		if onMessage != nil {
+			onMessage(c, message)
+		}
+	}
+}
+
+// writePump is the only writer of the connection, as gorilla/websocket
+// allows one concurrent writer. It stops when the hub closes the queue.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.hub.pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Printf("WebSocket write error for user %s: %v", c.UserID, err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package realtime
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProtocolVersion is the version of the WebSocket event protocol. Every
+// event carries it as v; clients that leave it out are treated as version 1.
+const ProtocolVersion = 1
+
+// EventType names an event. Client events are sent by the browser, server
+// events by the backend.
+type EventType string
+
+const (
+	// Client events.
+	EventPing                EventType = "ping"
+	EventMarkRead            EventType = "mark_read"
+	EventMarkDelivered       EventType = "mark_delivered"
+	EventTypingStart         EventType = "typing_start"
+	EventTypingStop          EventType = "typing_stop"
+	EventPresenceSet         EventType = "presence_set"
+	EventPresenceSubscribe   EventType = "presence_subscribe"
+	EventPresenceUnsubscribe EventType = "presence_unsubscribe"
+
+	// Server events. Typing events are relayed with the typist's user_id.
+	EventPong          EventType = "pong"
+	EventError         EventType = "error"
+	EventNewMessage    EventType = "new_message"
+	EventChatRead      EventType = "chat_read"
+	EventChatDelivered EventType = "chat_delivered"
+	EventPresence      EventType = "presence"
+
+	EventMessageEdited   EventType = "message_edited"
+	EventMessageDeleted  EventType = "message_deleted"
+	EventReactionAdded   EventType = "reaction_added"
+	EventReactionRemoved EventType = "reaction_removed"
+)
+
+// Error codes of ErrorEvent.
+const (
+	ErrorUnsupportedVersion = "unsupported_version"
+	ErrorUnknownType        = "unknown_type"
+	ErrorInvalidEvent       = "invalid_event"
+	ErrorForbidden          = "forbidden"
+)
+
+// Header starts every event.
+type Header struct {
+	Type    EventType `json:"type"`
+	Version int       `json:"v"`
+}
+
+// NewHeader returns the header of an event of the current version.
+func NewHeader(eventType EventType) Header {
+	return Header{Type: eventType, Version: ProtocolVersion}
+}
+
+// Supported reports whether the server understands the event's version.
+func (h Header) Supported() bool {
+	return h.Version <= ProtocolVersion
+}
+
+type ErrorEvent struct {
+	Header
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// ChatEvent names a chat and, for mark_read, optionally the last message
+// read. It is the body of mark_read, mark_delivered and the typing events.
+type ChatEvent struct {
+	Header
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+type TypingEvent struct {
+	Header
+	ChatID string `json:"chat_id"`
+	UserID string `json:"user_id"`
+}
+
+type NewMessageEvent struct {
+	Header
+	ID          string    `json:"id"`
+	ChatID      string    `json:"chat_id"`
+	SenderID    string    `json:"sender_id"`
+	RecipientID string    `json:"recipient_id"`
+	Content     string    `json:"content"`
+	CreatedAt   time.Time `json:"created_at"`
+	// SystemEvent is set on group membership messages, which have no
+	// content.
+	SystemEvent json.RawMessage `json:"system_event,omitempty"`
+	// Attachment describes the file sent with the message; clients ask for
+	// a download link to fetch it.
+	Attachment json.RawMessage `json:"attachment,omitempty"`
+}
+
+type MessageEditedEvent struct {
+	Header
+	ID       string    `json:"id"`
+	ChatID   string    `json:"chat_id"`
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+// MessageDeletedEvent removes a message: for everyone, leaving a tombstone,
+// or with scope "me" only from the user's own devices.
+type MessageDeletedEvent struct {
+	Header
+	ID        string    `json:"id"`
+	ChatID    string    `json:"chat_id"`
+	Scope     string    `json:"scope"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// ReactionEvent is reaction_added or reaction_removed.
+type ReactionEvent struct {
+	Header
+	MessageID string `json:"message_id"`
+	ChatID    string `json:"chat_id"`
+	UserID    string `json:"user_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// ReceiptEvent is chat_read or chat_delivered: UserID has read or received
+// the chat's messages up to ReadAt or DeliveredAt.
+type ReceiptEvent struct {
+	Header
+	ChatID      string     `json:"chat_id"`
+	UserID      string     `json:"user_id"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+type PresenceStatus string
+
+const (
+	PresenceOnline  PresenceStatus = "online"
+	PresenceAway    PresenceStatus = "away"
+	PresenceOffline PresenceStatus = "offline"
+)
+
+// PresenceEvent tells a subscriber a contact's status. LastSeenAt is when
+// the contact's last connection closed.
+type PresenceEvent struct {
+	Header
+	UserID     string         `json:"user_id"`
+	Status     PresenceStatus `json:"status"`
+	LastSeenAt *time.Time     `json:"last_seen_at,omitempty"`
+}
+
+// PresenceSetEvent is sent by a client to switch between online and away.
+type PresenceSetEvent struct {
+	Header
+	Status PresenceStatus `json:"status"`
+}
+
+// PresenceSubscribeEvent starts or stops presence updates for contacts.
+type PresenceSubscribeEvent struct {
+	Header
+	UserIDs []string `json:"user_ids"`
+}
@@ -0,0 +1,219 @@
+// Package realtime keeps track of the WebSocket connections of signed-in
+// users and delivers events to them. A user may be connected from several
+// devices at once; every connection receives the user's events. With a
+// Broker, events published on any server instance reach the users connected
+// to every instance.
+package realtime
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWriteWait  = 10 * time.Second
+	defaultPongWait   = 60 * time.Second
+	defaultPingPeriod = defaultPongWait * 9 / 10
+
+	maxMessageSize = 64 << 10
+	sendBufferSize = 256
+)
+
+// Publisher sends a message to users wherever they are connected.
+type Publisher interface {
+	Publish(ctx context.Context, userIDs []string, message []byte) error
+	// PublishTopic sends message only to the connections of the users that
+	// have subscribed to topic.
+	PublishTopic(ctx context.Context, topic string, userIDs []string, message []byte) error
+}
+
+// Broker carries published messages between server instances. Every
+// instance listens and delivers to its own connections. An empty topic
+// means every connection of the users.
+type Broker interface {
+	Publish(ctx context.Context, topic string, userIDs []string, message []byte) error
+	// Listen passes every message published on any instance to deliver
+	// until ctx is done.
+	Listen(ctx context.Context, deliver func(topic string, userIDs []string, message []byte)) error
+}
+
+// Hub owns the set of live connections. It is safe for concurrent use:
+// handlers may send while connections register and go away.
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[string]map[*Client]struct{}
+	broker  Broker
+
+	writeWait  time.Duration
+	pongWait   time.Duration
+	pingPeriod time.Duration
+}
+
+// NewHub returns a hub that publishes through broker, or only to its own
+// connections when broker is nil.
+func NewHub(broker Broker) *Hub {
+	return &Hub{
+		clients:    make(map[string]map[*Client]struct{}),
+		broker:     broker,
+		writeWait:  defaultWriteWait,
+		pongWait:   defaultPongWait,
+		pingPeriod: defaultPingPeriod,
+	}
+}
+
+// Run delivers messages published on any instance until ctx is done. It
+// returns at once for a hub without a broker.
+func (h *Hub) Run(ctx context.Context) {
+	if h.broker == nil {
+		return
+	}
+	if err := h.broker.Listen(ctx, h.deliver); err != nil && ctx.Err() == nil {
+		log.Printf("Realtime broker stopped: %v", err)
+	}
+}
+
+// Publish sends message to every connection of the users, on all instances
+// when the hub has a broker.
+func (h *Hub) Publish(ctx context.Context, userIDs []string, message []byte) error {
+	return h.PublishTopic(ctx, "", userIDs, message)
+}
+
+// PublishTopic sends message to the connections of the users that have
+// subscribed to topic, on all instances when the hub has a broker.
+func (h *Hub) PublishTopic(ctx context.Context, topic string, userIDs []string, message []byte) error {
+	if h.broker == nil {
+		h.deliver(topic, userIDs, message)
+		return nil
+	}
+	return h.broker.Publish(ctx, topic, userIDs, message)
+}
+
+func (h *Hub) deliver(topic string, userIDs []string, message []byte) {
+	for _, userID := range userIDs {
+		h.sendToUser(topic, userID, message)
+	}
+}
+
+// Register adds a connection to its user's set.
+func (h *Hub) Register(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	connections, ok := h.clients[client.UserID]
+	if !ok {
+		connections = make(map[*Client]struct{})
+		h.clients[client.UserID] = connections
+	}
+	connections[client] = struct{}{}
+	log.Printf("User %s connected (%d connections)", client.UserID, len(connections))
+}
+
+// Unregister removes a connection and closes its send queue, which stops its
+// writer. It may be called more than once for the same connection.
+func (h *Hub) Unregister(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	connections, ok := h.clients[client.UserID]
+	if !ok {
+		return
+	}
+	if _, ok := connections[client]; !ok {
+		return
+	}
+	delete(connections, client)
+	close(client.send)
+	if len(connections) == 0 {
+		delete(h.clients, client.UserID)
+	}
+	log.Printf("User %s disconnected (%d connections)", client.UserID, len(connections))
+}
+
+// SendToUser queues message on every connection of the user on this
+// instance and returns how many accepted it. Connections whose queue is full
+// are too slow to keep up and are dropped; the client reconnects and reloads
+// what it missed.
+func (h *Hub) SendToUser(userID string, message []byte) int {
+	return h.sendToUser("", userID, message)
+}
+
+func (h *Hub) sendToUser(topic, userID string, message []byte) int {
+	h.mu.RLock()
+	delivered := 0
+	var slow []*Client
+	for client := range h.clients[userID] {
+		if topic != "" {
+			if _, ok := client.topics[topic]; !ok {
+				continue
+			}
+		}
+		select {
+		case client.send <- message:
+			delivered++
+		default:
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range slow {
+		log.Printf("Dropping slow WebSocket connection of user %s", client.UserID)
+		h.Unregister(client)
+	}
+	return delivered
+}
+
+// subscribe adds topics to the connection's subscriptions.
+func (h *Hub) subscribe(client *Client, topics []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if client.topics == nil {
+		client.topics = make(map[string]struct{})
+	}
+	for _, topic := range topics {
+		client.topics[topic] = struct{}{}
+	}
+}
+
+func (h *Hub) unsubscribe(client *Client, topics []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		delete(client.topics, topic)
+	}
+}
+
+// send queues message on one connection, if it is still registered.
+func (h *Hub) send(client *Client, message []byte) bool {
+	h.mu.RLock()
+	_, registered := h.clients[client.UserID][client]
+	queued := false
+	if registered {
+		select {
+		case client.send <- message:
+			queued = true
+		default:
+		}
+	}
+	h.mu.RUnlock()
+
+	if registered && !queued {
+		log.Printf("Dropping slow WebSocket connection of user %s", client.UserID)
+		h.Unregister(client)
+	}
+	return queued
+}
+
+// IsOnline reports whether the user has at least one open connection.
+func (h *Hub) IsOnline(userID string) bool {
+	return h.ConnectionCount(userID) > 0
+}
+
+// ConnectionCount returns the number of open connections of the user.
+func (h *Hub) ConnectionCount(userID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userID])
+}
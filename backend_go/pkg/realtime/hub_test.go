@@ -0,0 +1,212 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(hub *Hub, userID string) *Client {
+	return &Client{UserID: userID, hub: hub, send: make(chan []byte, sendBufferSize)}
+}
+
+func TestHubMultipleConnectionsPerUser(t *testing.T) {
+	hub := NewHub(nil)
+	phone := newTestClient(hub, "alice")
+	laptop := newTestClient(hub, "alice")
+	hub.Register(phone)
+	hub.Register(laptop)
+
+	assert.Equal(t, 2, hub.SendToUser("alice", []byte("hello")))
+	assert.Equal(t, "hello", string(<-phone.send))
+	assert.Equal(t, "hello", string(<-laptop.send))
+
+	hub.Unregister(phone)
+	hub.Unregister(phone)
+	assert.Equal(t, 1, hub.ConnectionCount("alice"))
+	assert.Equal(t, 1, hub.SendToUser("alice", []byte("again")))
+
+	hub.Unregister(laptop)
+	assert.False(t, hub.IsOnline("alice"))
+	assert.Equal(t, 0, hub.SendToUser("alice", []byte("nobody")))
+	assert.False(t, laptop.Send([]byte("closed")))
+}
+
+func TestHubDropsSlowConnection(t *testing.T) {
+	hub := NewHub(nil)
+	slow := newTestClient(hub, "bob")
+	hub.Register(slow)
+
+	for i := 0; i < sendBufferSize; i++ {
+		require.Equal(t, 1, hub.SendToUser("bob", []byte("x")))
+	}
+	assert.Equal(t, 0, hub.SendToUser("bob", []byte("overflow")))
+	assert.False(t, hub.IsOnline("bob"))
+
+	received := 0
+	for range slow.send {
+		received++
+	}
+	assert.Equal(t, sendBufferSize, received)
+}
+
+// TestHubConcurrentStress is meant to be run with -race: connections come
+// and go while others send to the same users.
+func TestHubConcurrentStress(t *testing.T) {
+	hub := NewHub(nil)
+	const users = 10
+	const workers = 20
+	const rounds = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				client := newTestClient(hub, fmt.Sprintf("user-%d", (w+i)%users))
+				hub.Register(client)
+				go func() {
+					for range client.send {
+					}
+				}()
+				client.Send([]byte("direct"))
+				hub.Unregister(client)
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				userID := fmt.Sprintf("user-%d", (w*i)%users)
+				hub.SendToUser(userID, []byte("fan-out"))
+				hub.IsOnline(userID)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for u := 0; u < users; u++ {
+		assert.Equal(t, 0, hub.ConnectionCount(fmt.Sprintf("user-%d", u)))
+	}
+}
+
+func startTestServer(t *testing.T, hub *Hub, onMessage func(c *Client, message []byte)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		NewClient(hub, r.URL.Query().Get("user"), conn).Run(onMessage)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dialTestServer(t *testing.T, url, userID string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?user="+userID, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	require.Eventually(t, condition, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestClientDeliversToEveryDevice(t *testing.T) {
+	hub := NewHub(nil)
+	url := startTestServer(t, hub, func(c *Client, message []byte) {
+		c.Send(append([]byte("echo:"), message...))
+	})
+
+	first := dialTestServer(t, url, "carol")
+	second := dialTestServer(t, url, "carol")
+	waitFor(t, func() bool { return hub.ConnectionCount("carol") == 2 })
+
+	require.Equal(t, 2, hub.SendToUser("carol", []byte("event")))
+	for _, conn := range []*websocket.Conn{first, second} {
+		_, message, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "event", string(message))
+	}
+
+	require.NoError(t, first.WriteMessage(websocket.TextMessage, []byte("hi")))
+	_, message, err := first.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "echo:hi", string(message))
+
+	first.Close()
+	waitFor(t, func() bool { return hub.ConnectionCount("carol") == 1 })
+}
+
+func TestClientClosedWithoutPong(t *testing.T) {
+	hub := NewHub(nil)
+	hub.pongWait = 200 * time.Millisecond
+	hub.pingPeriod = 50 * time.Millisecond
+	url := startTestServer(t, hub, nil)
+
+	// A connection that reads answers pings and stays open.
+	alive := dialTestServer(t, url, "dave")
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	// One that never reads never answers and is dropped.
+	dialTestServer(t, url, "dave")
+	waitFor(t, func() bool { return hub.ConnectionCount("dave") == 2 })
+
+	waitFor(t, func() bool { return hub.ConnectionCount("dave") == 1 })
+	time.Sleep(3 * hub.pongWait)
+	assert.Equal(t, 1, hub.ConnectionCount("dave"))
+}
+
+func TestHubTopicDeliveryOnlyToSubscribers(t *testing.T) {
+	hub := NewHub(nil)
+	watching := newTestClient(hub, "erin")
+	other := newTestClient(hub, "erin")
+	hub.Register(watching)
+	hub.Register(other)
+	watching.Subscribe("presence:frank")
+
+	require.NoError(t, hub.PublishTopic(context.Background(), "presence:frank", []string{"erin"}, []byte("online")))
+	assert.Equal(t, "online", string(<-watching.send))
+	assert.Empty(t, other.send)
+
+	watching.Unsubscribe("presence:frank")
+	require.NoError(t, hub.PublishTopic(context.Background(), "presence:frank", []string{"erin"}, []byte("away")))
+	assert.Empty(t, watching.send)
+
+	require.NoError(t, hub.Publish(context.Background(), []string{"erin"}, []byte("message")))
+	assert.Equal(t, "message", string(<-watching.send))
+	assert.Equal(t, "message", string(<-other.send))
+}
+
+func TestEventsCarryTypeAndVersion(t *testing.T) {
+	encoded, err := json.Marshal(TypingEvent{Header: NewHeader(EventTypingStart), ChatID: "c1", UserID: "u1"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"typing_start","v":1,"chat_id":"c1","user_id":"u1"}`, string(encoded))
+
+	var header Header
+	require.NoError(t, json.Unmarshal([]byte(`{"type":"ping"}`), &header))
+	assert.Equal(t, EventPing, header.Type)
+	assert.True(t, header.Supported())
+	header.Version = ProtocolVersion + 1
+	assert.False(t, header.Supported())
+}
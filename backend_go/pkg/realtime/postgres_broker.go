@@ -0,0 +1,138 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	notifyChannel = "realtime_events"
+	// maxNotifyPayload stays under Postgres' 8000 byte NOTIFY limit. Larger
+	// envelopes are stored in realtime_event_payloads and sent by reference.
+	maxNotifyPayload = 7900
+	// storedPayloadTTL is how long stored envelopes are kept for listeners.
+	storedPayloadTTL = "10 minutes"
+	listenRetryDelay = 2 * time.Second
+)
+
+// PostgresBroker fans messages out to every server instance with Postgres
+// LISTEN/NOTIFY. Messages published while an instance is reconnecting are
+// not replayed to it; clients reload what they missed when they reconnect.
+type PostgresBroker struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresBroker(db *pgxpool.Pool) *PostgresBroker {
+	return &PostgresBroker{db: db}
+}
+
+// notification is the NOTIFY payload: the message, its topic and
+// recipients, or the id of the stored payload when it is too large.
+type notification struct {
+	Topic   string          `json:"topic,omitempty"`
+	UserIDs []string        `json:"user_ids,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"`
+	Ref     int64           `json:"ref,omitempty"`
+}
+
+// Publish notifies every instance. message must be a JSON document.
+func (b *PostgresBroker) Publish(ctx context.Context, topic string, userIDs []string, message []byte) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(notification{Topic: topic, UserIDs: userIDs, Message: message})
+	if err != nil {
+		return fmt.Errorf("could not encode realtime message: %v", err)
+	}
+
+	if len(payload) > maxNotifyPayload {
+		var ref int64
+		err := b.db.QueryRow(ctx,
+			"INSERT INTO realtime_event_payloads (payload) VALUES ($1) RETURNING id",
+			string(payload)).Scan(&ref)
+		if err != nil {
+			return fmt.Errorf("could not store realtime message: %v", err)
+		}
+		if _, err := b.db.Exec(ctx,
+			"DELETE FROM realtime_event_payloads WHERE created_at < NOW() - INTERVAL '"+storedPayloadTTL+"'"); err != nil {
+			log.Printf("Warning: failed to prune realtime payloads: %v", err)
+		}
+		payload, _ = json.Marshal(notification{Ref: ref})
+	}
+
+	if _, err := b.db.Exec(ctx, "SELECT pg_notify($1, $2)", notifyChannel, string(payload)); err != nil {
+		return fmt.Errorf("could not publish realtime message: %v", err)
+	}
+	return nil
+}
+
+// Listen holds a dedicated connection that listens for notifications and
+// reconnects after failures until ctx is done.
+func (b *PostgresBroker) Listen(ctx context.Context, deliver func(topic string, userIDs []string, message []byte)) error {
+	for {
+		err := b.listen(ctx, deliver)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Realtime listener disconnected, retrying: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+func (b *PostgresBroker) listen(ctx context.Context, deliver func(topic string, userIDs []string, message []byte)) error {
+	conn, err := b.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("could not acquire listener connection: %v", err)
+	}
+	// The connection is still subscribed, so it must not go back to the pool.
+	defer func() {
+		conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		return fmt.Errorf("could not listen: %v", err)
+	}
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		decoded, err := b.decode(ctx, n.Payload)
+		if err != nil {
+			log.Printf("Dropping realtime notification: %v", err)
+			continue
+		}
+		deliver(decoded.Topic, decoded.UserIDs, decoded.Message)
+	}
+}
+
+func (b *PostgresBroker) decode(ctx context.Context, payload string) (notification, error) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		return n, fmt.Errorf("invalid payload: %v", err)
+	}
+	if ref := n.Ref; ref != 0 {
+		var stored string
+		err := b.db.QueryRow(ctx, "SELECT payload FROM realtime_event_payloads WHERE id = $1", ref).Scan(&stored)
+		if err != nil {
+			return n, fmt.Errorf("could not load stored payload %d: %v", ref, err)
+		}
+		n = notification{}
+		if err := json.Unmarshal([]byte(stored), &n); err != nil {
+			return n, fmt.Errorf("invalid stored payload %d: %v", ref, err)
+		}
+	}
+	return n, nil
+}
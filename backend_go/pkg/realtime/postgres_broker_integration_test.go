@@ -0,0 +1,88 @@
+package realtime
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/testhelpers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, client *Client) string {
+	t.Helper()
+	select {
+	case message := <-client.send:
+		return string(message)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message for %s", client.UserID)
+		return ""
+	}
+}
+
+// TestPostgresBrokerAcrossHubs runs two hubs, as two server instances would,
+// against one database and checks that a message published on one reaches
+// users connected to the other.
+func TestPostgresBrokerAcrossHubs_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testDB, err := testhelpers.SetupLocalTestDatabase(ctx)
+	if err != nil {
+		t.Skipf("Test database unavailable: %v", err)
+	}
+	defer testDB.Pool.Close()
+	_, err = testDB.Pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS realtime_event_payloads (
+		id BIGSERIAL PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	)`)
+	require.NoError(t, err)
+
+	first := NewHub(NewPostgresBroker(testDB.Pool))
+	second := NewHub(NewPostgresBroker(testDB.Pool))
+	go first.Run(ctx)
+	go second.Run(ctx)
+
+	alice := newTestClient(first, "alice")
+	first.Register(alice)
+	bobPhone := newTestClient(second, "bob")
+	bobLaptop := newTestClient(first, "bob")
+	second.Register(bobPhone)
+	first.Register(bobLaptop)
+
+	// Wait until both listeners are subscribed, then drop the probes.
+	for _, probe := range []*Client{alice, bobPhone} {
+		require.Eventually(t, func() bool {
+			_ = first.Publish(ctx, []string{probe.UserID}, []byte(`{"type":"probe"}`))
+			select {
+			case <-probe.send:
+				return true
+			case <-time.After(100 * time.Millisecond):
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	for _, client := range []*Client{alice, bobPhone, bobLaptop} {
+		for len(client.send) > 0 {
+			<-client.send
+		}
+	}
+
+	require.NoError(t, first.Publish(ctx, []string{"bob"}, []byte(`{"type":"new_message","content":"hi"}`)))
+	assert.Equal(t, `{"type":"new_message","content":"hi"}`, receive(t, bobPhone))
+	assert.Equal(t, `{"type":"new_message","content":"hi"}`, receive(t, bobLaptop))
+
+	large := `{"type":"new_message","content":"` + strings.Repeat("x", 2*maxNotifyPayload) + `"}`
+	require.NoError(t, second.Publish(ctx, []string{"alice", "bob"}, []byte(large)))
+	assert.Equal(t, large, receive(t, alice))
+	assert.Equal(t, large, receive(t, bobPhone))
+	assert.Equal(t, large, receive(t, bobLaptop))
+}
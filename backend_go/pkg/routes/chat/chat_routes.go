@@ -1,24 +1,43 @@
 package chat
 
 import (
+	"context"
+
 	"healthcare_backend/pkg/config"
 	chatHandler "healthcare_backend/pkg/handlers/chat"
-	"healthcare_backend/pkg/utils"
+	"healthcare_backend/pkg/realtime"
+	"healthcare_backend/pkg/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
-func SetupChatRoutes(router *gin.RouterGroup, db *pgxpool.Pool, cfg *config.Config, wsClients map[string]*utils.WSClient) {
-	handler := chatHandler.NewChatHandler(db, cfg, wsClients)
+func SetupChatRoutes(router *gin.RouterGroup, db *pgxpool.Pool, cfg *config.Config, store storage.Storage, hub *realtime.Hub) {
+	handler := chatHandler.NewChatHandler(db, cfg, store, hub)
+	go handler.RunPresence(context.Background())
 
 	chatRoutes := router.Group("/chats")
 	chatRoutes.GET("", handler.GetChatsForUser)
 	chatRoutes.GET("/search/:username/:userId", handler.SearchUsersLegacy)
+	chatRoutes.POST("/groups", handler.CreateGroupChat)
+	chatRoutes.PATCH("/:chatId", handler.RenameGroupChat)
+	chatRoutes.GET("/:chatId/participants", handler.GetGroupChat)
+	chatRoutes.POST("/:chatId/participants", handler.AddParticipant)
+	chatRoutes.PATCH("/:chatId/participants/:userId", handler.UpdateParticipant)
+	chatRoutes.DELETE("/:chatId/participants/:userId", handler.RemoveParticipant)
+	chatRoutes.GET("/messages/search", handler.SearchMessages)
 	chatRoutes.GET("/:chatId/messages", handler.GetMessagesForChat)
+	chatRoutes.PATCH("/:chatId/messages/:messageId", handler.EditMessage)
+	chatRoutes.DELETE("/:chatId/messages/:messageId", handler.DeleteMessage)
+	chatRoutes.GET("/:chatId/messages/:messageId/edits", handler.GetMessageEdits)
+	chatRoutes.PUT("/:chatId/messages/:messageId/reactions/:emoji", handler.AddReaction)
+	chatRoutes.DELETE("/:chatId/messages/:messageId/reactions/:emoji", handler.RemoveReaction)
+	chatRoutes.POST("/:chatId/attachments", handler.UploadAttachment)
+	chatRoutes.GET("/:chatId/attachments/:attachmentId/link", handler.CreateAttachmentLink)
+	chatRoutes.GET("/attachments/:token", handler.DownloadAttachment)
+	chatRoutes.POST("/:chatId/read", handler.MarkChatRead)
 	chatRoutes.GET("/find-or-create", handler.FindOrCreateChatWithUserLegacy)
 
 	chatRoutes.POST("/send-message", handler.SendMessage)
-	chatRoutes.POST("/upload-image", handler.UploadImage)
 	chatRoutes.GET("/ws", handler.WebSocketHandler)
 }
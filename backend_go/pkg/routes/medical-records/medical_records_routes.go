@@ -3,35 +3,92 @@ package medicalrecords
 import (
 	"healthcare_backend/pkg/config"
 	medicalRecordsHandler "healthcare_backend/pkg/handlers/medical-records"
+	"healthcare_backend/pkg/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
-func SetupMedicalRecordsRoutes(router *gin.RouterGroup, db *pgxpool.Pool, cfg *config.Config) {
-	handler := medicalRecordsHandler.NewMedicalRecordsHandler(db, cfg)
-	shareHandler := medicalRecordsHandler.NewShareHandler(db, cfg)
-	clinicalHandler := medicalRecordsHandler.NewClinicalRecordsHandler(db, cfg)
+// SetupPublicMedicalRecordsRoutes registers the routes services call without
+// a user session.
+func SetupPublicMedicalRecordsRoutes(router *gin.RouterGroup, db *pgxpool.Pool, cfg *config.Config, store storage.Storage) {
+	ingestionHandler := medicalRecordsHandler.NewIngestionHandler(db, cfg, store)
+	router.GET("/records/ingestion/documents/:token", ingestionHandler.DownloadDocument)
+}
+
+func SetupMedicalRecordsRoutes(router *gin.RouterGroup, db *pgxpool.Pool, cfg *config.Config, store storage.Storage) {
+	handler := medicalRecordsHandler.NewMedicalRecordsHandler(db, cfg, store)
+	shareHandler := medicalRecordsHandler.NewShareHandler(db, cfg, store)
+	clinicalHandler := medicalRecordsHandler.NewClinicalRecordsHandler(db, cfg, store)
+	accessLogHandler := medicalRecordsHandler.NewAccessLogHandler(db, cfg)
+	chunkedUploadHandler := medicalRecordsHandler.NewChunkedUploadHandler(db, cfg, store)
+	dicomHandler := medicalRecordsHandler.NewDicomHandler(db, cfg, store)
+	searchHandler := medicalRecordsHandler.NewDocumentSearchHandler(db, cfg, store)
+	ingestionHandler := medicalRecordsHandler.NewIngestionHandler(db, cfg, store)
+	consentHandler := medicalRecordsHandler.NewAIConsentHandler(db, cfg)
+	labHandler := medicalRecordsHandler.NewLabObservationHandler(db, cfg)
+	templateHandler := medicalRecordsHandler.NewUploadTemplateHandler(db, cfg)
+	importHandler := medicalRecordsHandler.NewImportHandler(db, cfg, store)
 	records := router.Group("/records")
 
 	records.POST("/create-folder", handler.CreateFolder)
 	records.GET("/folders", handler.GetFolders)
 	records.GET("/folders/:folderId/subfolders", handler.GetSubFolders)
 	records.GET("/folders/:folderId/breadcrumbs", handler.GetBreadcrumbs)
+	records.GET("/folders/:folderId/usage", handler.GetFolderUsage)
+	records.GET("/usage", handler.GetStorageUsage)
 	records.DELETE("/delete-files/:folderId", handler.DeleteFolderAndContents)
 	records.PATCH("/rename-item", handler.RenameFileOrFolder)
+	records.POST("/items/:itemId/move", handler.MoveItem)
+	records.POST("/items/:itemId/copy", handler.CopyItem)
 	records.POST("/upload-file", handler.UploadFile)
 	records.GET("/download-file/:fileId", handler.DownloadFile)
+	records.GET("/files/:fileId/preview", handler.GetFilePreview)
 	records.POST("/download-multiple-files", handler.DownloadMultipleFiles)
 	records.GET("/items/:itemId/history", handler.GetFileHistory)
+	records.GET("/search", searchHandler.SearchDocuments)
+
+	records.POST("/uploads", chunkedUploadHandler.InitiateUpload)
+	records.GET("/uploads/:uploadId", chunkedUploadHandler.GetUploadStatus)
+	records.PUT("/uploads/:uploadId/chunks/:chunkNumber", chunkedUploadHandler.UploadChunk)
+	records.POST("/uploads/:uploadId/complete", chunkedUploadHandler.CompleteUpload)
+	records.DELETE("/uploads/:uploadId", chunkedUploadHandler.AbortUpload)
+
+	records.POST("/imports", importHandler.ImportArchive)
+	records.GET("/imports", importHandler.ListImports)
+	records.GET("/imports/:importId", importHandler.GetImport)
+
+	records.POST("/dicom/import", dicomHandler.ImportStudy)
+	records.GET("/dicom/studies/:studyId", dicomHandler.GetStudy)
+	records.GET("/dicom/folders/:folderId/study", dicomHandler.GetStudy)
 
 	records.GET("/medical-records/by-category", clinicalHandler.GetMedicalRecordsByCategory)
 	records.GET("/medical-records/all-users", clinicalHandler.GetAllUsers)
 	records.POST("/medical-records/upload-clinical", clinicalHandler.UploadAndShareClinicalDocument)
+	records.POST("/medical-records/chat-attachments/:attachmentId", clinicalHandler.SaveChatAttachment)
 	records.GET("/medical-records/categories", clinicalHandler.GetCategoriesForRole)
+	records.GET("/medical-records/upload-templates", templateHandler.ListTemplates)
+	records.POST("/medical-records/upload-templates", templateHandler.CreateTemplate)
+	records.PUT("/medical-records/upload-templates/:templateId", templateHandler.UpdateTemplate)
+	records.DELETE("/medical-records/upload-templates/:templateId", templateHandler.DeleteTemplate)
+	records.GET("/medical-records/ingestion/patients/:patientId", ingestionHandler.GetIngestionStatus)
+	records.POST("/medical-records/ingestion/patients/:patientId/retry", ingestionHandler.RetryIngestion)
 
 	records.GET("/share/doctors", shareHandler.ListDoctors)
 	records.POST("/share/items", shareHandler.ShareItems)
 	records.GET("/share/shared-with-me", shareHandler.GetSharedWithMe)
 	records.GET("/share/shared-by-me", shareHandler.GetSharedByMe)
+
+	records.POST("/patients/:patientId/labs", labHandler.CreateObservations)
+	records.POST("/patients/:patientId/labs/import", labHandler.ImportObservations)
+	records.GET("/patients/:patientId/labs", labHandler.GetObservations)
+	records.GET("/patients/:patientId/labs/series", labHandler.GetSeries)
+
+	records.GET("/ai-consent", consentHandler.GetAIConsent)
+	records.PUT("/ai-consent", consentHandler.SetAIConsent)
+	records.GET("/ai-consent/history", consentHandler.GetAIConsentAudit)
+	records.PUT("/items/:itemId/ai-consent", consentHandler.SetDocumentAIConsent)
+
+	records.GET("/access-log/who-viewed", accessLogHandler.GetWhoViewedMyRecords)
+	records.GET("/access-log/my-trail/export", accessLogHandler.ExportMyAccessTrail)
 }
@@ -1,14 +1,13 @@
 package routes
 
 import (
-	"encoding/json"
-	"log"
-	"net/http"
+	"context"
 	"time"
 
 	"healthcare_backend/pkg/config"
 	"healthcare_backend/pkg/handlers"
 	"healthcare_backend/pkg/middleware"
+	"healthcare_backend/pkg/realtime"
 	"healthcare_backend/pkg/routes/appointment"
 	"healthcare_backend/pkg/routes/auth"
 	"healthcare_backend/pkg/routes/calendar"
@@ -23,22 +22,22 @@ import (
 	"healthcare_backend/pkg/routes/search"
 	"healthcare_backend/pkg/routes/user"
 	"healthcare_backend/pkg/services"
+	"healthcare_backend/pkg/storage"
 	"healthcare_backend/pkg/utils"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
-func SetupRoutes(router *gin.Engine, db *pgxpool.Pool, cfg *config.Config) {
-	wsClients := make(map[string]*utils.WSClient)
+func SetupRoutes(router *gin.Engine, db *pgxpool.Pool, cfg *config.Config, store storage.Storage) {
+	hub := realtime.NewHub(realtime.NewPostgresBroker(db))
+	go hub.Run(context.Background())
 
 	router.Static("/user_photos", "./user_photos")
-	router.Static("/uploads", "./uploads")
 
 	config := cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://10.188.27.252:3000", "http://192.168.1.239:3000"},
+		AllowOrigins:     cfg.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Content-Length", "Authorization", "X-CSRF-Token"},
 		ExposeHeaders:    []string{"Content-Length", "Content-Disposition", "Content-Type"},
@@ -57,34 +56,12 @@ func SetupRoutes(router *gin.Engine, db *pgxpool.Pool, cfg *config.Config) {
 
 	community.SetupCommunityRoutes(api, db, cfg)
 
+	medicalrecords.SetupPublicMedicalRecordsRoutes(api, db, cfg, store)
+
 	settingsService := services.NewSettingsService(db, cfg)
 	settingsHandler := handlers.NewSettingsHandler(settingsService)
 	api.GET("/insurance-providers", settingsHandler.ListInsuranceProviders)
 
-	router.GET("/ws", func(c *gin.Context) {
-		userID := c.Query("userId")
-		if userID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "userId is required"})
-			return
-		}
-
-		conn, err := utils.Upgrader.Upgrade(c.Writer, c.Request, nil)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "WebSocket upgrade failed"})
-			return
-		}
-
-		client := &utils.WSClient{
-			UserID: userID,
-			Conn:   conn,
-			Send:   make(chan []byte, 256),
-		}
-
-		wsClients[userID] = client
-
-		go handleWebSocketConnection(client, wsClients)
-	})
-
 	protected := api.Group("/")
 	protected.Use(middleware.AuthMiddleware())
 	protected.Use(middleware.CSRFMiddleware())
@@ -97,9 +74,9 @@ func SetupRoutes(router *gin.Engine, db *pgxpool.Pool, cfg *config.Config) {
 
 		calendar.SetupCalendarRoutes(protected, db, cfg)
 
-		chat.SetupChatRoutes(protected, db, cfg, wsClients)
+		chat.SetupChatRoutes(protected, db, cfg, store, hub)
 
-		medicalrecords.SetupMedicalRecordsRoutes(protected, db, cfg)
+		medicalrecords.SetupMedicalRecordsRoutes(protected, db, cfg, store)
 
 		feed.SetupFeedRoutes(protected, db, cfg)
 
@@ -134,54 +111,3 @@ func SetupRoutes(router *gin.Engine, db *pgxpool.Pool, cfg *config.Config) {
 		receptionist.SetupReceptionistRoutes(router, db, cfg)
 	}
 }
-
-func handleWebSocketConnection(client *utils.WSClient, wsClients map[string]*utils.WSClient) {
-	defer func() {
-		delete(wsClients, client.UserID)
-		client.Conn.Close()
-		log.Printf("User %s disconnected. Total clients: %d", client.UserID, len(wsClients))
-	}()
-
-	go func() {
-		defer client.Conn.Close()
-		for {
-			message, ok := <-client.Send
-			if !ok {
-				client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				break
-			}
-			client.Conn.WriteMessage(websocket.TextMessage, message)
-		}
-	}()
-
-	for {
-		_, message, err := client.Conn.ReadMessage()
-		if err != nil {
-			log.Printf("WebSocket read error for user %s: %v", client.UserID, err)
-			break
-		}
-
-		var msg struct {
-			ChatID      string `json:"chat_id"`
-			SenderID    string `json:"sender_id"`
-			RecipientID string `json:"recipient_id"`
-			Content     string `json:"content"`
-		}
-
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("Error parsing WebSocket message: %v", err)
-			continue
-		}
-
-		if recipient, exists := wsClients[msg.RecipientID]; exists {
-			select {
-			case recipient.Send <- message:
-				log.Printf("Message forwarded from %s to %s", msg.SenderID, msg.RecipientID)
-			default:
-				log.Printf("Recipient %s channel is full, message dropped", msg.RecipientID)
-			}
-		} else {
-			log.Printf("Recipient %s not connected", msg.RecipientID)
-		}
-	}
-}
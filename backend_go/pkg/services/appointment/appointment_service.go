@@ -1535,6 +1535,44 @@ func (s *AppointmentService) getMedicationsForReport(reportID uuid.UUID) ([]mode
 	return medications, nil
 }
 
+func (s *AppointmentService) getLabObservationsForReport(reportID uuid.UUID) ([]models.LabObservation, error) {
+	query := `
+		SELECT
+			id::text, patient_id::text, doctor_id::text, report_id::text, file_id::text,
+			test_code, test_name, value::float8, value_text, unit,
+			reference_low::float8, reference_high::float8, reference_text, abnormal_flag,
+			COALESCE(abnormal_flag <> 'N', false) AS out_of_range,
+			collected_at, entered_by_id::text, entered_by_role, created_at
+		FROM lab_observations
+		WHERE report_id = $1
+		ORDER BY collected_at ASC, test_code ASC
+	`
+
+	rows, err := s.db.Query(context.Background(), query, reportID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lab observations: %v", err)
+	}
+	defer rows.Close()
+
+	var observations []models.LabObservation
+	for rows.Next() {
+		var obs models.LabObservation
+		err := rows.Scan(
+			&obs.ID, &obs.PatientID, &obs.DoctorID, &obs.ReportID, &obs.FileID,
+			&obs.TestCode, &obs.TestName, &obs.Value, &obs.ValueText, &obs.Unit,
+			&obs.ReferenceLow, &obs.ReferenceHigh, &obs.ReferenceText, &obs.AbnormalFlag,
+			&obs.OutOfRange,
+			&obs.CollectedAt, &obs.EnteredByID, &obs.EnteredByRole, &obs.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan lab observation: %v", err)
+		}
+		observations = append(observations, obs)
+	}
+
+	return observations, rows.Err()
+}
+
 func (s *AppointmentService) GetPatientMedications(patientID string) ([]models.Medications, error) {
 	patientUUID, err := uuid.Parse(patientID)
 	if err != nil {
@@ -1912,6 +1950,13 @@ func (s *AppointmentService) GetReport(reportID string) (*models.MedicalReport,
 	}
 	report.Medications = medications
 
+	labObservations, err := s.getLabObservationsForReport(report.ReportID)
+	if err != nil {
+		log.Printf("Failed to fetch lab observations for report %s: %v", report.ReportID, err)
+	} else {
+		report.LabObservations = labObservations
+	}
+
 	return &report, nil
 }
 
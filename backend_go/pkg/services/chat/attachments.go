@@ -0,0 +1,264 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"path"
+	"time"
+
+	"healthcare_backend/pkg/filecheck"
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/storage"
+	"healthcare_backend/pkg/utils"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// maxChatAttachmentSize keeps chat files small; larger documents belong
+	// in the medical records.
+	maxChatAttachmentSize = 25 << 20
+
+	// AttachmentLinkTTL is how long a download link stays valid. Clients ask
+	// for a new link each time they open an attachment.
+	AttachmentLinkTTL = 5 * time.Minute
+)
+
+var (
+	// ErrAttachmentNotFound is returned for attachments that do not exist,
+	// were deleted, or are not visible to the user.
+	ErrAttachmentNotFound = errors.New("attachment not found")
+
+	// ErrAttachmentAlreadySent is returned when a message names an
+	// attachment that another message already carries.
+	ErrAttachmentAlreadySent = errors.New("attachment has already been sent")
+
+	// ErrAttachmentLinkExpired is returned for a download link past its
+	// expiry.
+	ErrAttachmentLinkExpired = errors.New("attachment link has expired")
+)
+
+// attachmentKey is the storage key of a chat attachment. It lies under the
+// uploader's records so encrypted storage keys it to them.
+func attachmentKey(uploaderID, chatID, attachmentID string) string {
+	return path.Join("records", "chat-attachments", uploaderID, chatID, attachmentID)
+}
+
+// hashLinkToken is what is stored of a link token, so the table cannot be
+// used to download anything.
+func hashLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// UploadAttachment validates and privately stores a file the user is about to
+// send in the chat. The attachment is only visible to the uploader until a
+// message carries it.
+func (s *ChatService) UploadAttachment(chatID, userID, userType string, file multipart.File, header *multipart.FileHeader) (*models.ChatAttachment, error) {
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	checked, err := s.validator.Check(ctx, filecheck.Upload{
+		Name:    header.Filename,
+		Size:    header.Size,
+		Role:    userType,
+		Allowed: filecheck.ChatAttachmentTypes,
+		MaxSize: maxChatAttachmentSize,
+		Content: file,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := &models.ChatAttachment{
+		ID:          uuid.NewString(),
+		ChatID:      chatID,
+		UploaderID:  userID,
+		FileName:    checked.Name,
+		ContentType: checked.ContentType,
+		Size:        header.Size,
+	}
+	key := attachmentKey(userID, chatID, attachment.ID)
+	if err := s.storage.Put(ctx, key, file, header.Size, checked.ContentType); err != nil {
+		return nil, fmt.Errorf("failed to store attachment: %v", err)
+	}
+
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO chat_attachments (id, chat_id, uploader_id, file_name, content_type, size, storage_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`,
+		attachment.ID, chatID, userID, attachment.FileName, attachment.ContentType, attachment.Size, key).
+		Scan(&attachment.CreatedAt)
+	if err != nil {
+		if deleteErr := s.storage.Delete(ctx, key); deleteErr != nil {
+			log.Printf("Warning: failed to remove unrecorded attachment %s: %v", key, deleteErr)
+		}
+		return nil, fmt.Errorf("failed to record attachment: %v", err)
+	}
+	return attachment, nil
+}
+
+// claimAttachment locks an attachment the sender uploaded to the chat for a
+// new message, making sure no other message carries it.
+func claimAttachment(ctx context.Context, tx pgx.Tx, chatID, attachmentID, senderID string) (*models.ChatAttachment, error) {
+	var a models.ChatAttachment
+	err := tx.QueryRow(ctx,
+		`SELECT id::text, chat_id::text, uploader_id::text, file_name, content_type, size, created_at
+		FROM chat_attachments
+		WHERE id::text = $1 AND chat_id::text = $2 AND uploader_id::text = $3 AND deleted_at IS NULL
+		FOR UPDATE`, attachmentID, chatID, senderID).
+		Scan(&a.ID, &a.ChatID, &a.UploaderID, &a.FileName, &a.ContentType, &a.Size, &a.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attachment: %v", err)
+	}
+
+	var sent bool
+	if err := tx.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM messages WHERE attachment_id::text = $1)", attachmentID).Scan(&sent); err != nil {
+		return nil, fmt.Errorf("failed to check attachment: %v", err)
+	}
+	if sent {
+		return nil, ErrAttachmentAlreadySent
+	}
+	return &a, nil
+}
+
+// CreateAttachmentLink issues a download token for an attachment the user
+// can see: one they uploaded and have not sent yet, or one on a message in
+// the chat that was neither deleted nor hidden by them. The token only works
+// for this user and expires after AttachmentLinkTTL.
+func (s *ChatService) CreateAttachmentLink(chatID, attachmentID, userID string) (string, time.Time, error) {
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return "", time.Time{}, err
+	}
+
+	var visible bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(
+			SELECT 1 FROM chat_attachments a
+			LEFT JOIN messages m ON m.attachment_id = a.id
+			WHERE a.id::text = $1 AND a.chat_id::text = $2 AND a.deleted_at IS NULL
+				AND (
+					(m.id IS NULL AND a.uploader_id::text = $3)
+					OR (m.id IS NOT NULL AND m.deleted_at IS NULL AND NOT EXISTS (
+						SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id::text = $3
+					))
+				)
+		)`, attachmentID, chatID, userID).Scan(&visible)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to check attachment: %v", err)
+	}
+	if !visible {
+		return "", time.Time{}, ErrAttachmentNotFound
+	}
+
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(AttachmentLinkTTL)
+
+	if _, err := s.db.Exec(ctx, "DELETE FROM chat_attachment_links WHERE expires_at < NOW()"); err != nil {
+		log.Printf("Warning: failed to prune expired attachment links: %v", err)
+	}
+	if _, err := s.db.Exec(ctx,
+		`INSERT INTO chat_attachment_links (token_hash, attachment_id, user_id, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		hashLinkToken(token), attachmentID, userID, expiresAt); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create attachment link: %v", err)
+	}
+	return token, expiresAt, nil
+}
+
+// OpenAttachment redeems a download token of the user. Chat membership is
+// checked again, so someone removed from the chat after the link was issued
+// cannot use it.
+func (s *ChatService) OpenAttachment(token, userID string) (*models.ChatAttachment, io.ReadCloser, error) {
+	ctx := context.Background()
+	var a models.ChatAttachment
+	var key string
+	var expiresAt time.Time
+	err := s.db.QueryRow(ctx,
+		`SELECT a.id::text, a.chat_id::text, a.uploader_id::text, a.file_name, a.content_type, a.size, a.created_at,
+			a.storage_key, l.expires_at
+		FROM chat_attachment_links l
+		JOIN chat_attachments a ON a.id = l.attachment_id AND a.deleted_at IS NULL
+		WHERE l.token_hash = $1 AND l.user_id::text = $2`, hashLinkToken(token), userID).
+		Scan(&a.ID, &a.ChatID, &a.UploaderID, &a.FileName, &a.ContentType, &a.Size, &a.CreatedAt, &key, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up attachment link: %v", err)
+	}
+	if !time.Now().Before(expiresAt) {
+		return nil, nil, ErrAttachmentLinkExpired
+	}
+	if err := s.checkParticipant(ctx, a.ChatID, userID); err != nil {
+		return nil, nil, err
+	}
+
+	content, err := s.storage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read attachment: %v", err)
+	}
+	return &a, content, nil
+}
+
+// deleteAttachmentOf marks the attachment of a message deleted and returns
+// its storage key, or "" when the message has none.
+func deleteAttachmentOf(ctx context.Context, tx pgx.Tx, messageID string) (string, error) {
+	var key string
+	err := tx.QueryRow(ctx,
+		`UPDATE chat_attachments SET deleted_at = NOW()
+		WHERE id = (SELECT attachment_id FROM messages WHERE id::text = $1) AND deleted_at IS NULL
+		RETURNING storage_key`, messageID).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to delete attachment: %v", err)
+	}
+	return key, nil
+}
+
+// attachmentColumns receives the attachment columns of a message row, which
+// are NULL when the message has no attachment.
+type attachmentColumns struct {
+	id          *string
+	uploaderID  *string
+	fileName    *string
+	contentType *string
+	size        *int64
+	createdAt   *time.Time
+}
+
+func (c attachmentColumns) attachment(chatID string) *models.ChatAttachment {
+	if c.id == nil || c.uploaderID == nil || c.fileName == nil || c.contentType == nil || c.size == nil || c.createdAt == nil {
+		return nil
+	}
+	return &models.ChatAttachment{
+		ID:          *c.id,
+		ChatID:      chatID,
+		UploaderID:  *c.uploaderID,
+		FileName:    *c.fileName,
+		ContentType: *c.contentType,
+		Size:        *c.size,
+		CreatedAt:   *c.createdAt,
+	}
+}
@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// insertTestAttachment stores an unsent attachment as UploadAttachment
+// would, without going through upload validation.
+func insertTestAttachment(t *testing.T, ctx context.Context, service *ChatService, chatID, uploaderID, content string) string {
+	t.Helper()
+	attachmentID := uuid.NewString()
+	key := attachmentKey(uploaderID, chatID, attachmentID)
+	require.NoError(t, service.storage.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain"))
+	_, err := service.db.Exec(ctx,
+		`INSERT INTO chat_attachments (id, chat_id, uploader_id, file_name, content_type, size, storage_key)
+		VALUES ($1, $2, $3, 'results.txt', 'text/plain', $4, $5)`,
+		attachmentID, chatID, uploaderID, len(content), key)
+	require.NoError(t, err)
+	return attachmentID
+}
+
+func openAttachment(t *testing.T, service *ChatService, token, userID string) string {
+	t.Helper()
+	_, content, err := service.OpenAttachment(token, userID)
+	require.NoError(t, err)
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestAttachmentLinksRequireMembership_Integration(t *testing.T) {
+	service, db, ctx := setupChatIntegrationTest(t)
+	sender, recipient, outsider := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	chatID := createTestChat(t, ctx, db, "direct", testParticipant{userID: sender}, testParticipant{userID: recipient})
+	attachmentID := insertTestAttachment(t, ctx, service, chatID, sender, "HbA1c 6.1%")
+
+	// Until a message carries it, only the uploader sees the attachment.
+	_, _, err := service.CreateAttachmentLink(chatID, attachmentID, recipient)
+	assert.ErrorIs(t, err, ErrAttachmentNotFound)
+	_, _, err = service.CreateAttachmentLink(chatID, attachmentID, outsider)
+	assert.ErrorIs(t, err, ErrNotChatParticipant)
+	token, _, err := service.CreateAttachmentLink(chatID, attachmentID, sender)
+	require.NoError(t, err)
+	assert.Equal(t, "HbA1c 6.1%", openAttachment(t, service, token, sender))
+
+	require.NoError(t, service.SendMessage(CombinedMessage{
+		ChatID: chatID, SenderID: sender, RecipientID: recipient, AttachmentID: attachmentID, CreatedAt: time.Now(),
+	}))
+	token, expiresAt, err := service.CreateAttachmentLink(chatID, attachmentID, recipient)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(AttachmentLinkTTL), expiresAt, time.Minute)
+	assert.Equal(t, "HbA1c 6.1%", openAttachment(t, service, token, recipient))
+
+	_, _, err = service.OpenAttachment(token, outsider)
+	assert.ErrorIs(t, err, ErrAttachmentNotFound, "a link only works for the user it was issued to")
+	_, _, err = service.CreateAttachmentLink(chatID, attachmentID, outsider)
+	assert.ErrorIs(t, err, ErrNotChatParticipant)
+
+	// A link issued before the user left the chat stops working.
+	_, err = db.Exec(ctx, "DELETE FROM participants WHERE chat_id::text = $1 AND user_id::text = $2", chatID, recipient)
+	require.NoError(t, err)
+	_, _, err = service.OpenAttachment(token, recipient)
+	assert.ErrorIs(t, err, ErrNotChatParticipant)
+}
+
+func TestAttachmentLinksOfHiddenAndExpired_Integration(t *testing.T) {
+	service, db, ctx := setupChatIntegrationTest(t)
+	sender, recipient := uuid.NewString(), uuid.NewString()
+	chatID := createTestChat(t, ctx, db, "direct", testParticipant{userID: sender}, testParticipant{userID: recipient})
+	attachmentID := insertTestAttachment(t, ctx, service, chatID, sender, "x-ray report")
+	require.NoError(t, service.SendMessage(CombinedMessage{
+		ChatID: chatID, SenderID: sender, RecipientID: recipient, AttachmentID: attachmentID, CreatedAt: time.Now(),
+	}))
+
+	token, _, err := service.CreateAttachmentLink(chatID, attachmentID, recipient)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, "UPDATE chat_attachment_links SET expires_at = NOW() - INTERVAL '1 second' WHERE token_hash = $1",
+		hashLinkToken(token))
+	require.NoError(t, err)
+	_, _, err = service.OpenAttachment(token, recipient)
+	assert.ErrorIs(t, err, ErrAttachmentLinkExpired)
+
+	var messageID string
+	require.NoError(t, db.QueryRow(ctx,
+		"SELECT id::text FROM messages WHERE attachment_id::text = $1", attachmentID).Scan(&messageID))
+	require.NoError(t, service.DeleteMessage(chatID, messageID, recipient, DeleteForMe))
+	_, _, err = service.CreateAttachmentLink(chatID, attachmentID, recipient)
+	assert.ErrorIs(t, err, ErrAttachmentNotFound, "hidden messages do not hand out links")
+	_, _, err = service.CreateAttachmentLink(chatID, attachmentID, sender)
+	require.NoError(t, err)
+
+	require.NoError(t, service.DeleteMessage(chatID, messageID, sender, DeleteForEveryone))
+	_, _, err = service.CreateAttachmentLink(chatID, attachmentID, sender)
+	assert.ErrorIs(t, err, ErrAttachmentNotFound)
+}
@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachmentKeyIsOwnedByUploader(t *testing.T) {
+	key := attachmentKey("uploader", "chat", "attachment")
+	assert.Equal(t, "records/chat-attachments/uploader/chat/attachment", key)
+}
+
+func TestHashLinkToken(t *testing.T) {
+	hash := hashLinkToken("token")
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, hashLinkToken("token"))
+	assert.NotEqual(t, hash, hashLinkToken("other"))
+	assert.NotContains(t, hash, "token")
+}
+
+func TestAttachmentColumns(t *testing.T) {
+	assert.Nil(t, attachmentColumns{}.attachment("chat"))
+
+	id, uploader, name, contentType := "a1", "u1", "labs.pdf", "application/pdf"
+	size := int64(1234)
+	createdAt := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	columns := attachmentColumns{
+		id:          &id,
+		uploaderID:  &uploader,
+		fileName:    &name,
+		contentType: &contentType,
+		size:        &size,
+		createdAt:   &createdAt,
+	}
+	assert.Equal(t, &models.ChatAttachment{
+		ID:          "a1",
+		ChatID:      "chat",
+		UploaderID:  "u1",
+		FileName:    "labs.pdf",
+		ContentType: "application/pdf",
+		Size:        1234,
+		CreatedAt:   createdAt,
+	}, columns.attachment("chat"))
+}
@@ -2,16 +2,17 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"mime/multipart"
-	"os"
-	"path/filepath"
 	"time"
 
 	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/filecheck"
 	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/realtime"
+	"healthcare_backend/pkg/storage"
 	"healthcare_backend/pkg/utils"
 
 	"github.com/jackc/pgx/v4"
@@ -34,22 +35,89 @@ type CombinedMessage struct {
 	RecipientID string    `json:"recipientId"`
 	Content     string    `json:"content"`
 	CreatedAt   time.Time `json:"createdAt"`
-	Key         *string   `json:"key"`
+	// AttachmentID is a file the sender uploaded to the chat and not yet
+	// sent; Content is then an optional caption.
+	AttachmentID string `json:"attachmentId"`
+}
+
+// chatUserDirectory is a subquery of every doctor, patient and receptionist
+// with their names and photo, keyed by user_id.
+const chatUserDirectory = `
+	SELECT doctor_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar, profile_photo_url, 'doctor' AS user_type
+	FROM doctor_info
+	UNION
+	SELECT patient_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar, profile_photo_url, 'patient' AS user_type
+	FROM patient_info
+	UNION
+	SELECT receptionist_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar, profile_photo_url, 'receptionist' AS user_type
+	FROM receptionists`
+
+// latestVisibleMessage joins a chat c to its newest message the user in $1
+// can see: deleted messages and those the user hid are skipped.
+const latestVisibleMessage = `LEFT JOIN LATERAL (
+		SELECT lv.content, lv.created_at
+		FROM messages lv
+		WHERE lv.chat_id = c.id
+			AND lv.deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = lv.id AND h.user_id::text = $1::text)
+		ORDER BY lv.created_at DESC
+		LIMIT 1
+	) lm ON true`
+
+// ErrNotChatParticipant is returned when the sender or the recipient of a
+// message is not a participant of its chat.
+var ErrNotChatParticipant = errors.New("sender and recipient must both be participants of the chat")
+
+// ErrInvalidCursor is returned when a message page is asked for relative to
+// more than one message.
+var ErrInvalidCursor = errors.New("only one of before, after and around may be given")
+
+const (
+	DefaultMessagePageSize = 50
+	MaxMessagePageSize     = 200
+	DefaultMessageContext  = 10
+)
+
+// MessagePageQuery selects a page of a chat's history: the messages before
+// or after the message with the given ID, or the newest ones when neither is
+// set. Limit defaults to DefaultMessagePageSize. Around jumps to a message,
+// such as a search hit: the page holds it and up to Limit messages on each
+// side, DefaultMessageContext by default.
+type MessagePageQuery struct {
+	Before string
+	After  string
+	Around string
+	Limit  int
 }
 
 type ChatService struct {
-	db  *pgxpool.Pool
-	cfg *config.Config
+	db        *pgxpool.Pool
+	cfg       *config.Config
+	validator *filecheck.Validator
+	storage   storage.Storage
+	events    realtime.Publisher
+	presence  *presenceTracker
 }
 
-func NewChatService(db *pgxpool.Pool, cfg *config.Config) *ChatService {
+func NewChatService(db *pgxpool.Pool, cfg *config.Config, store storage.Storage, events realtime.Publisher) *ChatService {
 	return &ChatService{
-		db:  db,
-		cfg: cfg,
+		db:        db,
+		cfg:       cfg,
+		validator: filecheck.NewValidator(cfg),
+		storage:   store,
+		events:    events,
+		presence:  newPresenceTracker(),
 	}
 }
 
-func (s *ChatService) GetChatsForUser(userID string) ([]models.Chat, error) {
+// GetChatsForUser lists the user's chats with their unread counts. Loading
+// the list means the user's device has received the messages, so their
+// delivery markers are moved forward first.
+func (s *ChatService) GetChatsForUser(userID string) (*models.ChatList, error) {
+	if err := s.MarkChatDelivered("", userID); err != nil {
+		log.Printf("Warning: failed to mark chats delivered for user %s: %v", userID, err)
+	}
+
 	conn, err := s.db.Acquire(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("failed to acquire database connection: %v", err)
@@ -57,62 +125,51 @@ func (s *ChatService) GetChatsForUser(userID string) ([]models.Chat, error) {
 	defer conn.Release()
 
 	const query = `
-	WITH one_to_one_chats AS (
-		SELECT chat_id
-		FROM participants
-		GROUP BY chat_id
-		HAVING COUNT(DISTINCT user_id) = 2
-	),
-	my_one_to_one_chats AS (
-		SELECT oc.chat_id
-		FROM one_to_one_chats oc
-		JOIN participants p ON p.chat_id = oc.chat_id
+	WITH my_chats AS (
+		SELECT p.chat_id, MAX(p.last_read_at) AS last_read_at
+		FROM participants p
+		JOIN chats c ON c.id = p.chat_id
 		WHERE p.user_id::text = $1
+			AND (c.kind = 'group' OR (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2)
+		GROUP BY p.chat_id
 	)
 	SELECT
 		c.id,
+		c.kind,
+		c.name,
+		(SELECT COUNT(DISTINCT user_id) FROM participants pc WHERE pc.chat_id = c.id) AS participant_count,
 		c.updated_at AS updated_at,
 		$1 AS sender_user_id,
-		other_p.user_id AS recipient_user_id,
-		other_user.first_name AS first_name_recipient,
-		other_user.first_name_ar AS first_name_recipient_ar,
-		other_user.last_name AS last_name_recipient,
-		other_user.last_name_ar AS last_name_recipient_ar,
+		COALESCE(other_p.user_id::text, '') AS recipient_user_id,
+		COALESCE(other_user.first_name, '') AS first_name_recipient,
+		COALESCE(other_user.first_name_ar, '') AS first_name_recipient_ar,
+		COALESCE(other_user.last_name, '') AS last_name_recipient,
+		COALESCE(other_user.last_name_ar, '') AS last_name_recipient_ar,
 		lm.content AS latest_message_content,
 		lm.created_at AS latest_message_time,
-		other_user.profile_photo_url AS recipient_image_url,
-		other_user.user_type AS recipient_user_type
+		COALESCE(other_user.profile_photo_url, '') AS recipient_image_url,
+		COALESCE(other_user.user_type, '') AS recipient_user_type,
+		(
+			SELECT COUNT(*)
+			FROM messages um
+			WHERE um.chat_id = c.id
+				AND um.sender_id::text <> $1
+				AND um.created_at > COALESCE(mc.last_read_at, '-infinity'::timestamptz)
+				AND um.deleted_at IS NULL
+				AND um.system_event IS NULL
+				AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = um.id AND h.user_id::text = $1)
+		) AS unread_count
 	FROM
-		my_one_to_one_chats mc
+		my_chats mc
 	JOIN
 		chats c ON c.id = mc.chat_id
-	JOIN
+	LEFT JOIN
 		(
 			SELECT DISTINCT chat_id, user_id
 			FROM participants
-		) other_p ON c.id = other_p.chat_id AND other_p.user_id::text <> $1
-	LEFT JOIN (
-		SELECT doctor_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar, profile_photo_url, 'doctor' AS user_type
-		FROM doctor_info
-		UNION
-		SELECT patient_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar, profile_photo_url, 'patient' AS user_type
-		FROM patient_info
-		UNION
-		SELECT receptionist_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar, profile_photo_url, 'receptionist' AS user_type
-		FROM receptionists
-	) other_user ON other_p.user_id = other_user.user_id
-	LEFT JOIN (
-		SELECT chat_id, content, created_at
-		FROM (
-			SELECT
-				chat_id,
-				content,
-				created_at,
-				ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY created_at DESC) AS rn
-			FROM messages
-		) ranked
-		WHERE rn = 1
-	) lm ON c.id = lm.chat_id
+		) other_p ON c.kind = 'direct' AND c.id = other_p.chat_id AND other_p.user_id::text <> $1
+	LEFT JOIN (` + chatUserDirectory + `) other_user ON other_p.user_id = other_user.user_id
+	` + latestVisibleMessage + `
 	ORDER BY
 		COALESCE(lm.created_at, c.updated_at) DESC`
 
@@ -122,12 +179,12 @@ func (s *ChatService) GetChatsForUser(userID string) ([]models.Chat, error) {
 	}
 	defer rows.Close()
 
-	var chats []models.Chat
+	list := &models.ChatList{Chats: []models.Chat{}}
 	for rows.Next() {
 		var chat models.Chat
-		if err := rows.Scan(&chat.ID, &chat.UpdatedAt, &chat.SenderUserID, &chat.RecipientUserID,
+		if err := rows.Scan(&chat.ID, &chat.Type, &chat.Name, &chat.ParticipantCount, &chat.UpdatedAt, &chat.SenderUserID, &chat.RecipientUserID,
 			&chat.FirstNameRecipient, &chat.FirstNameRecipientAr, &chat.LastNameRecipient, &chat.LastNameRecipientAr, &chat.LastMessage, &chat.LastMessageCreatedAt,
-			&chat.RecipientImageURL, &chat.RecipientUserType); err != nil {
+			&chat.RecipientImageURL, &chat.RecipientUserType, &chat.UnreadCount); err != nil {
 			return nil, fmt.Errorf("error scanning chat row: %v", err)
 		}
 
@@ -140,16 +197,19 @@ func (s *ChatService) GetChatsForUser(userID string) ([]models.Chat, error) {
 			}
 		}
 
-		chats = append(chats, chat)
+		list.Chats = append(list.Chats, chat)
+		list.UnreadTotal += chat.UnreadCount
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating chat rows: %v", err)
 	}
 
-	return chats, nil
+	return list, nil
 }
 
+// SendMessage stores a message after checking that both the sender and the
+// recipient take part in the chat.
 func (s *ChatService) SendMessage(message CombinedMessage) error {
 	conn, err := s.db.Acquire(context.Background())
 	if err != nil {
@@ -157,62 +217,92 @@ func (s *ChatService) SendMessage(message CombinedMessage) error {
 	}
 	defer conn.Release()
 
-	return s.storeMessage(conn, message.SenderID, message.ChatID, message.Content, message.CreatedAt, message.Key)
-}
+	// The recipient names the other side of a direct chat; group messages
+	// go to every participant and may leave it out.
+	members := []string{message.SenderID}
+	if message.RecipientID != "" {
+		if message.SenderID == message.RecipientID {
+			return ErrNotChatParticipant
+		}
+		members = append(members, message.RecipientID)
+	}
+	var participants int
+	err = conn.QueryRow(context.Background(),
+		`SELECT COUNT(DISTINCT user_id) FROM participants
+		WHERE chat_id::text = $1 AND user_id::text = ANY($2)`,
+		message.ChatID, members).Scan(&participants)
+	if err != nil {
+		return fmt.Errorf("failed to check chat participants: %v", err)
+	}
+	if participants != len(members) {
+		return ErrNotChatParticipant
+	}
 
-func (s *ChatService) storeMessage(conn *pgxpool.Conn, senderID, chatID, content string, createdAt time.Time, key *string) error {
-	_, err := conn.Exec(context.Background(),
-		`INSERT INTO messages (chat_id, sender_id, content, key, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, NOW())`,
-		chatID, senderID, content, key, createdAt)
-	return err
+	return s.storeMessage(conn, message)
 }
 
-func (s *ChatService) UploadImage(file multipart.File, header *multipart.FileHeader) (string, string, error) {
-	awsRegion := os.Getenv("AWS_REGION")
-	bucket := os.Getenv("S3_BUCKET_NAME")
-
-	if awsRegion != "" && bucket != "" {
-		key := fmt.Sprintf("images/%d_%s", time.Now().Unix(), header.Filename)
-
-		if err := utils.UploadToS3(file, header, key); err != nil {
-			return "", "", fmt.Errorf("failed to upload to S3: %v", err)
-		}
+// storeMessage persists the message, with its attachment if it has one, and
+// publishes it to the recipient's connections on every server instance. A
+// failed publish is only logged: the recipient still gets the message when
+// the chat is next loaded.
+func (s *ChatService) storeMessage(conn *pgxpool.Conn, message CombinedMessage) error {
+	ctx := context.Background()
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
 
-		presignedURL, err := utils.GeneratePresignedObjectURL(key)
+	var attachment *models.ChatAttachment
+	var attachmentID *string
+	if message.AttachmentID != "" {
+		attachment, err = claimAttachment(ctx, tx, message.ChatID, message.AttachmentID, message.SenderID)
 		if err != nil {
-			return "", "", fmt.Errorf("failed to generate presigned URL: %v", err)
+			return err
 		}
-
-		return presignedURL, key, nil
+		attachmentID = &attachment.ID
 	}
 
-	uploadDir := filepath.Join("uploads", "chat")
-	if err := os.MkdirAll(uploadDir, 0o755); err != nil {
-		return "", "", fmt.Errorf("failed to prepare upload directory: %v", err)
+	var messageID string
+	err = tx.QueryRow(ctx,
+		`INSERT INTO messages (chat_id, sender_id, content, attachment_id, created_at, updated_at) VALUES ($1, $2, NULLIF($3, ''), $4, $5, NOW()) RETURNING id::text`,
+		message.ChatID, message.SenderID, message.Content, attachmentID, message.CreatedAt).Scan(&messageID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
-	filename := fmt.Sprintf("%d_%s", time.Now().Unix(), header.Filename)
-	dstPath := filepath.Join(uploadDir, filename)
-	dst, err := os.Create(dstPath)
+	newMessage := realtime.NewMessageEvent{
+		Header:      realtime.NewHeader(realtime.EventNewMessage),
+		ID:          messageID,
+		ChatID:      message.ChatID,
+		SenderID:    message.SenderID,
+		RecipientID: message.RecipientID,
+		Content:     message.Content,
+		CreatedAt:   message.CreatedAt,
+	}
+	if attachment != nil {
+		if newMessage.Attachment, err = json.Marshal(attachment); err != nil {
+			log.Printf("Warning: failed to encode attachment of message %s: %v", messageID, err)
+			return nil
+		}
+	}
+	event, err := json.Marshal(newMessage)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create destination file: %v", err)
+		log.Printf("Warning: failed to encode message event: %v", err)
+		return nil
 	}
-	defer dst.Close()
-
-	if seeker, ok := file.(interface {
-		Seek(offset int64, whence int) (int64, error)
-	}); ok {
-		_, _ = seeker.Seek(0, 0)
+	others, err := s.otherParticipants(ctx, message.ChatID, message.SenderID)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return nil
 	}
-
-	if _, err := io.Copy(dst, file); err != nil {
-		return "", "", fmt.Errorf("failed to write file: %v", err)
+	if err := s.events.Publish(ctx, others, event); err != nil {
+		log.Printf("Warning: failed to publish message %s: %v", messageID, err)
 	}
-
-	baseURL := fmt.Sprintf("http://localhost:%s", s.cfg.ServerPort)
-	publicURL := baseURL + "/uploads/chat/" + filename
-
-	return publicURL, "", nil
+	return nil
 }
 
 func (s *ChatService) SearchUsers(inputName, currentUserID, currentUserType string) ([]CombinedUser, error) {
@@ -318,37 +408,198 @@ func (s *ChatService) SearchUsers(inputName, currentUserID, currentUserType stri
 	return combinedUsers, nil
 }
 
-func (s *ChatService) GetMessagesForChat(chatID string) ([]models.Message, error) {
-	rows, err := s.db.Query(context.Background(),
-		"SELECT id, chat_id, sender_id, content, key, created_at FROM messages WHERE chat_id = $1", chatID)
+// GetMessagesForChat returns one page of the chat's messages, oldest first
+// and ordered by creation time and then ID. Without a cursor it returns the
+// newest messages. Each message has its status as seen by the other
+// participants' receipt markers and its reactions; messages the user deleted
+// for themselves are left out. Attachments are described but not linked;
+// only legacy image messages of the returned page get presigned URLs.
+func (s *ChatService) GetMessagesForChat(chatID, userID string, query MessagePageQuery) (*models.MessagePage, error) {
+	ctx := context.Background()
+	cursorID, cursors := "", 0
+	for _, cursor := range []string{query.Before, query.After, query.Around} {
+		if cursor != "" {
+			cursorID = cursor
+			cursors++
+		}
+	}
+	if cursors > 1 {
+		return nil, ErrInvalidCursor
+	}
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultMessagePageSize
+		if query.Around != "" {
+			limit = DefaultMessageContext
+		}
+	}
+	if limit > MaxMessagePageSize {
+		limit = MaxMessagePageSize
+	}
+
+	// A message the user hid cannot be jumped to, but still works as a
+	// paging cursor.
+	var cursorTime time.Time
+	if cursorID != "" {
+		err := s.db.QueryRow(ctx,
+			`SELECT created_at FROM messages m WHERE id::text = $1 AND chat_id::text = $2
+				AND (NOT $3 OR NOT EXISTS (
+					SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id::text = $4
+				))`,
+			cursorID, chatID, query.Around != "", userID).Scan(&cursorTime)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMessageNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up cursor message: %v", err)
+		}
+	}
+
+	// Messages before the cursor, and the newest page, are read backwards
+	// from the end and reversed. One extra row tells whether there is more.
+	const (
+		beforeCursor = "AND (m.created_at, m.id) < ($4, $5::uuid)"
+		fromCursor   = "AND (m.created_at, m.id) >= ($4, $5::uuid)"
+		afterCursor  = "AND (m.created_at, m.id) > ($4, $5::uuid)"
+	)
+	page := &models.MessagePage{}
+	var messages []models.Message
+	switch {
+	case query.Around != "":
+		before, err := s.readMessages(ctx, chatID, userID, beforeCursor, "DESC", limit+1, cursorTime, cursorID)
+		if err != nil {
+			return nil, err
+		}
+		// The message itself comes first, followed by limit more.
+		after, err := s.readMessages(ctx, chatID, userID, fromCursor, "ASC", limit+2, cursorTime, cursorID)
+		if err != nil {
+			return nil, err
+		}
+		before, page.HasMoreBefore = trimMessages(before, limit)
+		after, page.HasMoreAfter = trimMessages(after, limit+1)
+		reverseMessages(before)
+		messages = append(before, after...)
+	case query.After != "":
+		after, err := s.readMessages(ctx, chatID, userID, afterCursor, "ASC", limit+1, cursorTime, cursorID)
+		if err != nil {
+			return nil, err
+		}
+		messages, page.HasMoreAfter = trimMessages(after, limit)
+		page.HasMoreBefore = true
+	default:
+		bound := ""
+		if query.Before != "" {
+			bound = beforeCursor
+		}
+		before, err := s.readMessages(ctx, chatID, userID, bound, "DESC", limit+1, cursorTime, cursorID)
+		if err != nil {
+			return nil, err
+		}
+		messages, page.HasMoreBefore = trimMessages(before, limit)
+		page.HasMoreAfter = query.Before != ""
+		reverseMessages(messages)
+	}
+	page.Messages = messages
+
+	if err := s.loadReactions(ctx, messages); err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		if key := messages[i].Key; key != nil && *key != "" {
+			presignedURL, err := utils.GeneratePresignedObjectURL(*key)
+			if err != nil {
+				log.Printf("Warning: failed to generate presigned URL for key %s: %v", *key, err)
+				continue
+			}
+			messages[i].Content = &presignedURL
+		}
+	}
+	return page, nil
+}
+
+// readMessages reads up to limit messages of the chat visible to the user,
+// within bound of the cursor, in the given order of creation.
+func (s *ChatService) readMessages(ctx context.Context, chatID, userID, bound, order string, limit int, cursorTime time.Time, cursorID string) ([]models.Message, error) {
+	args := []interface{}{chatID, limit, userID}
+	if bound != "" {
+		args = append(args, cursorTime, cursorID)
+	}
+	rows, err := s.db.Query(ctx,
+		`SELECT m.id, m.chat_id, m.sender_id, m.content, m.key, m.created_at, m.updated_at,
+			m.edited_at, m.deleted_at, m.system_event,
+			a.id::text, a.uploader_id::text, a.file_name, a.content_type, a.size, a.created_at,
+			CASE
+				WHEN m.created_at <= r.read_at THEN 'read'
+				WHEN m.created_at <= r.delivered_at THEN 'delivered'
+				ELSE 'sent'
+			END
+		FROM messages m
+		LEFT JOIN chat_attachments a ON a.id = m.attachment_id AND a.deleted_at IS NULL
+		LEFT JOIN LATERAL (
+			SELECT MIN(COALESCE(p.last_read_at, '-infinity'::timestamptz)) AS read_at,
+				MIN(COALESCE(p.last_delivered_at, '-infinity'::timestamptz)) AS delivered_at
+			FROM participants p
+			WHERE p.chat_id = m.chat_id AND p.user_id <> m.sender_id
+		) r ON true
+		WHERE m.chat_id = $1 `+bound+`
+			AND NOT EXISTS (
+				SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id::text = $3
+			)
+		ORDER BY m.created_at `+order+`, m.id `+order+`
+		LIMIT $2`, args...)
 	if err != nil {
 		log.Printf("Error retrieving messages for chat %s: %v", chatID, err)
 		return nil, fmt.Errorf("failed to retrieve messages: %v", err)
 	}
 	defer rows.Close()
 
-	var messages []models.Message
+	messages := []models.Message{}
 	for rows.Next() {
 		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Content, &msg.Key, &msg.CreatedAt); err != nil {
+		var status string
+		var systemEvent []byte
+		var attachment attachmentColumns
+		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Content, &msg.Key, &msg.CreatedAt, &msg.UpdatedAt,
+			&msg.EditedAt, &msg.DeletedAt, &systemEvent,
+			&attachment.id, &attachment.uploaderID, &attachment.fileName, &attachment.contentType, &attachment.size, &attachment.createdAt,
+			&status); err != nil {
 			log.Printf("Error scanning message for chat %s: %v", chatID, err)
 			return nil, fmt.Errorf("failed to scan message: %v", err)
 		}
-
-		if msg.Key != nil && *msg.Key != "" {
-			presignedURL, err := utils.GeneratePresignedObjectURL(*msg.Key)
-			if err != nil {
-				log.Printf("Warning: failed to generate presigned URL for key %s: %v", *msg.Key, err)
-				log.Printf("Failed to generate presigned URL for key %s: %v", *msg.Key, err)
-			} else {
-				msg.Content = &presignedURL
+		msg.Status = models.MessageStatus(status)
+		if systemEvent != nil {
+			msg.SystemEvent = &models.ChatSystemEvent{}
+			if err := json.Unmarshal(systemEvent, msg.SystemEvent); err != nil {
+				return nil, fmt.Errorf("invalid system message %s: %v", msg.ID, err)
 			}
 		}
+		msg.Attachment = attachment.attachment(msg.ChatID)
 		messages = append(messages, msg)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %v", err)
+	}
 	return messages, nil
 }
 
+// trimMessages cuts messages to limit and reports whether there were more.
+func trimMessages(messages []models.Message, limit int) ([]models.Message, bool) {
+	if len(messages) > limit {
+		return messages[:limit], true
+	}
+	return messages, false
+}
+
+func reverseMessages(messages []models.Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
 func (s *ChatService) FindOrCreateChatWithUser(currentUserID, selectedUserID, currentUserType, selectedUserType string) ([]models.Chat, error) {
 	conn, err := s.db.Acquire(context.Background())
 	if err != nil {
@@ -405,20 +656,13 @@ func (s *ChatService) findOrCreateChat(conn *pgxpool.Conn, currentUserID, select
             SELECT patient_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM patient_info
             UNION
             SELECT receptionist_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM receptionists) other_user ON other_p.user_id = other_user.user_id
-        LEFT JOIN
-            (SELECT
-                chat_id,
-                content,
-                created_at,
-                ROW_NUMBER() OVER(PARTITION BY chat_id ORDER BY created_at DESC) as rn
-            FROM
-                messages) lm ON c.id = lm.chat_id AND lm.rn = 1
+        `+latestVisibleMessage+`
         WHERE
             curr_p.user_id = $1 
         AND
             other_p.user_id = $2 
         AND
-            (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
+            c.kind = 'direct' AND (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
         ORDER BY
             lm.created_at DESC`,
 			currentUserID, selectedUserID).Scan(&chat.ID, &chat.UpdatedAt, &chat.SenderUserID, &chat.RecipientUserID,
@@ -457,20 +701,13 @@ func (s *ChatService) findOrCreateChat(conn *pgxpool.Conn, currentUserID, select
             SELECT patient_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM patient_info
             UNION
             SELECT receptionist_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM receptionists) other_user ON other_p.user_id = other_user.user_id
-        LEFT JOIN
-            (SELECT
-                chat_id,
-                content,
-                created_at,
-                ROW_NUMBER() OVER(PARTITION BY chat_id ORDER BY created_at DESC) as rn
-            FROM
-                messages) lm ON c.id = lm.chat_id AND lm.rn = 1
+        `+latestVisibleMessage+`
         WHERE
             curr_p.user_id = $1 
         AND
             other_p.user_id = $2 
         AND
-            (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
+            c.kind = 'direct' AND (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
         ORDER BY
             lm.created_at DESC`,
 			currentUserID, selectedUserID).Scan(&chat.ID, &chat.UpdatedAt, &chat.SenderUserID, &chat.RecipientUserID,
@@ -505,20 +742,13 @@ func (s *ChatService) findOrCreateChat(conn *pgxpool.Conn, currentUserID, select
             (SELECT doctor_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM doctor_info
             UNION
             SELECT receptionist_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM receptionists) other_user ON other_p.user_id = other_user.user_id
-        LEFT JOIN
-            (SELECT
-                chat_id,
-                content,
-                created_at,
-                ROW_NUMBER() OVER(PARTITION BY chat_id ORDER BY created_at DESC) as rn
-            FROM
-                messages) lm ON c.id = lm.chat_id AND lm.rn = 1
+        `+latestVisibleMessage+`
         WHERE
             curr_p.user_id = $1 
         AND
             other_p.user_id = $2 
         AND
-            (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
+            c.kind = 'direct' AND (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
         ORDER BY
             lm.created_at DESC`,
 			currentUserID, selectedUserID).Scan(&chat.ID, &chat.UpdatedAt, &chat.SenderUserID, &chat.RecipientUserID,
@@ -595,20 +825,13 @@ func (s *ChatService) findOrCreateChat(conn *pgxpool.Conn, currentUserID, select
 				SELECT patient_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM patient_info
 				UNION
 				SELECT receptionist_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM receptionists) other_user ON other_p.user_id = other_user.user_id
-			LEFT JOIN
-				(SELECT
-					chat_id,
-					content,
-					created_at,
-					ROW_NUMBER() OVER(PARTITION BY chat_id ORDER BY created_at DESC) as rn
-				FROM
-					messages) lm ON c.id = lm.chat_id AND lm.rn = 1
+			`+latestVisibleMessage+`
 			WHERE
 				curr_p.user_id = $1 
 			AND
 				other_p.user_id = $2 
 			AND
-				(SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
+				c.kind = 'direct' AND (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
 			ORDER BY
 				lm.created_at DESC`,
 				currentUserID, selectedUserID).Scan(&chat.ID, &chat.UpdatedAt, &chat.SenderUserID, &chat.RecipientUserID,
@@ -648,20 +871,13 @@ func (s *ChatService) findOrCreateChat(conn *pgxpool.Conn, currentUserID, select
 				SELECT patient_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM patient_info
 				UNION
 				SELECT receptionist_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM receptionists) other_user ON other_p.user_id = other_user.user_id
-			LEFT JOIN
-				(SELECT
-					chat_id,
-					content,
-					created_at,
-					ROW_NUMBER() OVER(PARTITION BY chat_id ORDER BY created_at DESC) as rn
-				FROM
-					messages) lm ON c.id = lm.chat_id AND lm.rn = 1
+			`+latestVisibleMessage+`
 			WHERE
 				curr_p.user_id = $1 
 			AND
 				other_p.user_id = $2 
 			AND
-				(SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
+				c.kind = 'direct' AND (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
 			ORDER BY
 				lm.created_at DESC`,
 				currentUserID, selectedUserID).Scan(&chat.ID, &chat.UpdatedAt, &chat.SenderUserID, &chat.RecipientUserID,
@@ -697,20 +913,13 @@ func (s *ChatService) findOrCreateChat(conn *pgxpool.Conn, currentUserID, select
 				(SELECT doctor_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM doctor_info
 				UNION
 				SELECT receptionist_id AS user_id, first_name, COALESCE(first_name_ar, '') AS first_name_ar, last_name, COALESCE(last_name_ar, '') AS last_name_ar FROM receptionists) other_user ON other_p.user_id = other_user.user_id
-			LEFT JOIN
-				(SELECT
-					chat_id,
-					content,
-					created_at,
-					ROW_NUMBER() OVER(PARTITION BY chat_id ORDER BY created_at DESC) as rn
-				FROM
-					messages) lm ON c.id = lm.chat_id AND lm.rn = 1
+			`+latestVisibleMessage+`
 			WHERE
 				curr_p.user_id = $1 
 			AND
 				other_p.user_id = $2 
 			AND
-				(SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
+				c.kind = 'direct' AND (SELECT COUNT(DISTINCT user_id) FROM participants p2 WHERE p2.chat_id = c.id) = 2
 			ORDER BY
 				lm.created_at DESC`,
 				currentUserID, selectedUserID).Scan(&chat.ID, &chat.UpdatedAt, &chat.SenderUserID, &chat.RecipientUserID,
@@ -0,0 +1,586 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/realtime"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	maxGroupNameLength   = 100
+	maxGroupParticipants = 50
+)
+
+var (
+	ErrGroupCreatorNotAllowed = errors.New("only doctors and receptionists with an assigned doctor can create group chats")
+	ErrNotGroupChat           = errors.New("this is not a group chat")
+	ErrNotGroupManager        = errors.New("you are not allowed to change this group chat")
+	ErrParticipantNotAllowed  = errors.New("this user is not part of the care team")
+	ErrAlreadyParticipant     = errors.New("the user is already a participant of this chat")
+	ErrParticipantNotFound    = errors.New("the user is not a participant of this chat")
+	ErrTooManyParticipants    = errors.New("the group chat is full")
+	ErrInvalidGroup           = errors.New("invalid group chat")
+)
+
+// GroupMember is a user to add to a group chat. Role defaults to member.
+type GroupMember struct {
+	UserID   string                 `json:"userId" binding:"required"`
+	UserType string                 `json:"userType" binding:"required"`
+	Role     models.ParticipantRole `json:"role"`
+}
+
+// CreateGroupChat starts a named care team chat owned by its creator, a
+// doctor or receptionist. A receptionist's assigned doctor always joins as
+// an admin. Receptionists may join when they work for a doctor of the group
+// and patients when they have an appointment with one.
+func (s *ChatService) CreateGroupChat(creatorID, creatorType, name string, members []GroupMember) (*models.GroupChat, error) {
+	name, err := groupName(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(members)+1 > maxGroupParticipants {
+		return nil, ErrTooManyParticipants
+	}
+
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var doctors []string
+	switch creatorType {
+	case "doctor":
+		doctors = append(doctors, creatorID)
+	case "receptionist":
+		var doctorID *string
+		err := tx.QueryRow(ctx,
+			"SELECT assigned_doctor_id::text FROM receptionists WHERE receptionist_id::text = $1",
+			creatorID).Scan(&doctorID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("failed to look up assigned doctor: %v", err)
+		}
+		if doctorID == nil {
+			return nil, ErrGroupCreatorNotAllowed
+		}
+		doctors = append(doctors, *doctorID)
+		members = append([]GroupMember{{UserID: *doctorID, UserType: "doctor", Role: models.RoleAdmin}}, members...)
+	default:
+		return nil, ErrGroupCreatorNotAllowed
+	}
+
+	// Doctors form the care team the other members are checked against, so
+	// they are validated first.
+	seen := map[string]bool{creatorID: true}
+	var accepted []GroupMember
+	for _, pass := range []bool{true, false} {
+		for _, member := range members {
+			if (member.UserType == "doctor") != pass || seen[member.UserID] {
+				continue
+			}
+			if member.Role == "" {
+				member.Role = models.RoleMember
+			}
+			if err := validGroupRole(member.UserType, member.Role, false); err != nil {
+				return nil, err
+			}
+			if err := checkCareTeamMember(ctx, tx, member, doctors); err != nil {
+				return nil, err
+			}
+			if member.UserType == "doctor" {
+				doctors = append(doctors, member.UserID)
+			}
+			seen[member.UserID] = true
+			accepted = append(accepted, member)
+		}
+	}
+
+	var chatID string
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO chats (kind, name, created_by, created_at, updated_at)
+		VALUES ('group', $1, $2, NOW(), NOW()) RETURNING id::text`,
+		name, creatorID).Scan(&chatID); err != nil {
+		return nil, fmt.Errorf("failed to create group chat: %v", err)
+	}
+	owner := GroupMember{UserID: creatorID, UserType: creatorType, Role: models.RoleOwner}
+	for _, member := range append([]GroupMember{owner}, accepted...) {
+		if err := insertParticipant(ctx, tx, chatID, member); err != nil {
+			return nil, err
+		}
+	}
+	event, err := addSystemMessage(ctx, tx, chatID, creatorID, models.ChatSystemEvent{Action: models.SystemGroupCreated, Name: name})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	s.publishToChat(ctx, chatID, event)
+	return s.GetGroupChat(chatID, creatorID)
+}
+
+// GetGroupChat returns a group chat and its participants, owner first.
+func (s *ChatService) GetGroupChat(chatID, userID string) (*models.GroupChat, error) {
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	group := &models.GroupChat{ID: chatID, Participants: []models.Participant{}}
+	var kind string
+	var name, createdBy *string
+	err := s.db.QueryRow(ctx,
+		"SELECT kind, name, created_by::text, created_at FROM chats WHERE id::text = $1",
+		chatID).Scan(&kind, &name, &createdBy, &group.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat: %v", err)
+	}
+	if kind != string(models.ChatGroup) {
+		return nil, ErrNotGroupChat
+	}
+	if name != nil {
+		group.Name = *name
+	}
+	if createdBy != nil {
+		group.CreatedBy = *createdBy
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT DISTINCT ON (p.user_id) p.user_id::text, COALESCE(p.user_type, u.user_type, ''), p.role, p.joined_at,
+			COALESCE(u.first_name, ''), COALESCE(u.first_name_ar, ''), COALESCE(u.last_name, ''), COALESCE(u.last_name_ar, '')
+		FROM participants p
+		LEFT JOIN (`+chatUserDirectory+`) u ON u.user_id = p.user_id
+		WHERE p.chat_id::text = $1
+		ORDER BY p.user_id, p.joined_at`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load participants: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		participant := models.Participant{ChatID: chatID}
+		if err := rows.Scan(&participant.UserID, &participant.UserType, &participant.Role, &participant.JoinedAt,
+			&participant.FirstName, &participant.FirstNameAr, &participant.LastName, &participant.LastNameAr); err != nil {
+			return nil, fmt.Errorf("failed to read participant: %v", err)
+		}
+		group.Participants = append(group.Participants, participant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read participants: %v", err)
+	}
+	sortParticipants(group.Participants)
+	return group, nil
+}
+
+// AddParticipant adds a care team member to a group chat. Owners and
+// admins add members; only the owner adds admins.
+func (s *ChatService) AddParticipant(chatID, actorID string, member GroupMember) error {
+	if member.Role == "" {
+		member.Role = models.RoleMember
+	}
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	actorRole, err := lockGroup(ctx, tx, chatID, actorID)
+	if err != nil {
+		return err
+	}
+	if !canManage(actorRole, member.Role) {
+		return ErrNotGroupManager
+	}
+	if err := validGroupRole(member.UserType, member.Role, false); err != nil {
+		return err
+	}
+	if _, err := participantRole(ctx, tx, chatID, member.UserID); err == nil {
+		return ErrAlreadyParticipant
+	} else if !errors.Is(err, ErrParticipantNotFound) {
+		return err
+	}
+
+	var count int
+	var doctors []string
+	if err := tx.QueryRow(ctx,
+		`SELECT COUNT(DISTINCT user_id),
+			COALESCE(array_agg(DISTINCT user_id::text) FILTER (WHERE user_type = 'doctor'), '{}')
+		FROM participants WHERE chat_id::text = $1`, chatID).Scan(&count, &doctors); err != nil {
+		return fmt.Errorf("failed to load participants: %v", err)
+	}
+	if count >= maxGroupParticipants {
+		return ErrTooManyParticipants
+	}
+	if err := checkCareTeamMember(ctx, tx, member, doctors); err != nil {
+		return err
+	}
+
+	if err := insertParticipant(ctx, tx, chatID, member); err != nil {
+		return err
+	}
+	event, err := addSystemMessage(ctx, tx, chatID, actorID, models.ChatSystemEvent{
+		Action: models.SystemMemberAdded, UserID: member.UserID, Role: member.Role,
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	s.publishToChat(ctx, chatID, event)
+	return nil
+}
+
+// RemoveParticipant takes a user out of a group chat. Anyone may leave;
+// admins remove members and the owner removes anyone. When the owner leaves,
+// the longest-standing admin, or else staff member, becomes owner.
+func (s *ChatService) RemoveParticipant(chatID, actorID, userID string) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	actorRole, err := lockGroup(ctx, tx, chatID, actorID)
+	if err != nil {
+		return err
+	}
+	targetRole, err := participantRole(ctx, tx, chatID, userID)
+	if err != nil {
+		return err
+	}
+	action := models.SystemMemberRemoved
+	if userID == actorID {
+		action = models.SystemMemberLeft
+	} else if !canManage(actorRole, targetRole) {
+		return ErrNotGroupManager
+	}
+
+	// The removed user still hears about their removal.
+	recipients, err := participantsInTx(ctx, tx, chatID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		"DELETE FROM participants WHERE chat_id::text = $1 AND user_id::text = $2", chatID, userID); err != nil {
+		return fmt.Errorf("failed to remove participant: %v", err)
+	}
+	events := []realtime.NewMessageEvent{}
+	event, err := addSystemMessage(ctx, tx, chatID, actorID, models.ChatSystemEvent{Action: action, UserID: userID})
+	if err != nil {
+		return err
+	}
+	events = append(events, event)
+
+	if targetRole == models.RoleOwner {
+		var heir string
+		err := tx.QueryRow(ctx,
+			`UPDATE participants SET role = 'owner', updated_at = NOW()
+			WHERE id = (
+				SELECT id FROM participants
+				WHERE chat_id::text = $1 AND COALESCE(user_type, '') <> 'patient'
+				ORDER BY role = 'admin' DESC, joined_at, id
+				LIMIT 1
+			)
+			RETURNING user_id::text`, chatID).Scan(&heir)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to pass on ownership: %v", err)
+		}
+		if heir != "" {
+			event, err := addSystemMessage(ctx, tx, chatID, actorID, models.ChatSystemEvent{
+				Action: models.SystemRoleChanged, UserID: heir, Role: models.RoleOwner,
+			})
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	for _, event := range events {
+		s.publishTo(ctx, recipients, event)
+	}
+	return nil
+}
+
+// SetParticipantRole changes a participant's role. Only the owner may, and
+// making someone else owner turns the current owner into an admin.
+func (s *ChatService) SetParticipantRole(chatID, actorID, userID string, role models.ParticipantRole) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	actorRole, err := lockGroup(ctx, tx, chatID, actorID)
+	if err != nil {
+		return err
+	}
+	if actorRole != models.RoleOwner {
+		return ErrNotGroupManager
+	}
+	if userID == actorID {
+		return fmt.Errorf("%w: the owner's role changes by making someone else owner", ErrInvalidGroup)
+	}
+	if _, err := participantRole(ctx, tx, chatID, userID); err != nil {
+		return err
+	}
+	var userType string
+	if err := tx.QueryRow(ctx,
+		`SELECT COALESCE(MAX(user_type), '') FROM participants WHERE chat_id::text = $1 AND user_id::text = $2`,
+		chatID, userID).Scan(&userType); err != nil {
+		return fmt.Errorf("failed to load participant: %v", err)
+	}
+	if err := validGroupRole(userType, role, true); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE participants SET role = $3, updated_at = NOW() WHERE chat_id::text = $1 AND user_id::text = $2`,
+		chatID, userID, string(role)); err != nil {
+		return fmt.Errorf("failed to change role: %v", err)
+	}
+	if role == models.RoleOwner {
+		if _, err := tx.Exec(ctx,
+			`UPDATE participants SET role = 'admin', updated_at = NOW() WHERE chat_id::text = $1 AND user_id::text = $2`,
+			chatID, actorID); err != nil {
+			return fmt.Errorf("failed to change role: %v", err)
+		}
+	}
+	event, err := addSystemMessage(ctx, tx, chatID, actorID, models.ChatSystemEvent{
+		Action: models.SystemRoleChanged, UserID: userID, Role: role,
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	s.publishToChat(ctx, chatID, event)
+	return nil
+}
+
+// RenameGroupChat changes the name of a group chat. Owners and admins may.
+func (s *ChatService) RenameGroupChat(chatID, actorID, name string) error {
+	name, err := groupName(name)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	actorRole, err := lockGroup(ctx, tx, chatID, actorID)
+	if err != nil {
+		return err
+	}
+	if actorRole != models.RoleOwner && actorRole != models.RoleAdmin {
+		return ErrNotGroupManager
+	}
+	if _, err := tx.Exec(ctx, "UPDATE chats SET name = $2 WHERE id::text = $1", chatID, name); err != nil {
+		return fmt.Errorf("failed to rename group chat: %v", err)
+	}
+	event, err := addSystemMessage(ctx, tx, chatID, actorID, models.ChatSystemEvent{Action: models.SystemGroupRenamed, Name: name})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	s.publishToChat(ctx, chatID, event)
+	return nil
+}
+
+func groupName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxGroupNameLength {
+		return "", fmt.Errorf("%w: the name must be 1 to %d characters", ErrInvalidGroup, maxGroupNameLength)
+	}
+	return name, nil
+}
+
+// validGroupRole checks that a user of userType may hold role. Patients are
+// always plain members, and owner is only given by the current owner.
+func validGroupRole(userType string, role models.ParticipantRole, allowOwner bool) error {
+	switch role {
+	case models.RoleMember:
+		return nil
+	case models.RoleAdmin:
+	case models.RoleOwner:
+		if !allowOwner {
+			return fmt.Errorf("%w: a group chat has one owner", ErrInvalidGroup)
+		}
+	default:
+		return fmt.Errorf("%w: unknown role %q", ErrInvalidGroup, role)
+	}
+	if userType == "patient" {
+		return fmt.Errorf("%w: patients can only be members", ErrInvalidGroup)
+	}
+	return nil
+}
+
+// canManage reports whether a participant with actorRole may add or remove
+// a participant with targetRole.
+func canManage(actorRole, targetRole models.ParticipantRole) bool {
+	switch actorRole {
+	case models.RoleOwner:
+		return targetRole != models.RoleOwner
+	case models.RoleAdmin:
+		return targetRole == models.RoleMember
+	}
+	return false
+}
+
+// sortParticipants orders participants by role, then by when they joined.
+func sortParticipants(participants []models.Participant) {
+	rank := map[models.ParticipantRole]int{models.RoleOwner: 0, models.RoleAdmin: 1, models.RoleMember: 2}
+	sort.SliceStable(participants, func(i, j int) bool {
+		if rank[participants[i].Role] != rank[participants[j].Role] {
+			return rank[participants[i].Role] < rank[participants[j].Role]
+		}
+		return participants[i].JoinedAt.Before(participants[j].JoinedAt)
+	})
+}
+
+// lockGroup locks a group chat against concurrent membership changes and
+// returns the actor's role in it.
+func lockGroup(ctx context.Context, tx pgx.Tx, chatID, actorID string) (models.ParticipantRole, error) {
+	var kind string
+	err := tx.QueryRow(ctx, "SELECT kind FROM chats WHERE id::text = $1 FOR UPDATE", chatID).Scan(&kind)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotChatParticipant
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load chat: %v", err)
+	}
+	role, err := participantRole(ctx, tx, chatID, actorID)
+	if errors.Is(err, ErrParticipantNotFound) {
+		return "", ErrNotChatParticipant
+	}
+	if err != nil {
+		return "", err
+	}
+	if kind != string(models.ChatGroup) {
+		return "", ErrNotGroupChat
+	}
+	return role, nil
+}
+
+func participantRole(ctx context.Context, tx pgx.Tx, chatID, userID string) (models.ParticipantRole, error) {
+	var role string
+	err := tx.QueryRow(ctx,
+		`SELECT role FROM participants WHERE chat_id::text = $1 AND user_id::text = $2
+		ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END LIMIT 1`,
+		chatID, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrParticipantNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load participant: %v", err)
+	}
+	return models.ParticipantRole(role), nil
+}
+
+func participantsInTx(ctx context.Context, tx pgx.Tx, chatID string) ([]string, error) {
+	var userIDs []string
+	err := tx.QueryRow(ctx,
+		"SELECT COALESCE(array_agg(DISTINCT user_id::text), '{}') FROM participants WHERE chat_id::text = $1",
+		chatID).Scan(&userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat participants: %v", err)
+	}
+	return userIDs, nil
+}
+
+// checkCareTeamMember checks that the user exists with the given type and
+// belongs with the group's doctors: receptionists must work for one of
+// them and patients must have an appointment with one.
+func checkCareTeamMember(ctx context.Context, tx pgx.Tx, member GroupMember, doctors []string) error {
+	var query string
+	switch member.UserType {
+	case "doctor":
+		query = "SELECT EXISTS(SELECT 1 FROM doctor_info WHERE doctor_id::text = $1)"
+	case "receptionist":
+		query = `SELECT EXISTS(
+			SELECT 1 FROM receptionists
+			WHERE receptionist_id::text = $1 AND is_active AND deleted_at IS NULL
+				AND assigned_doctor_id::text = ANY($2)
+		)`
+	case "patient":
+		query = `SELECT EXISTS(
+			SELECT 1 FROM appointments
+			WHERE patient_id::text = $1 AND doctor_id::text = ANY($2) AND canceled = FALSE
+		)`
+	default:
+		return fmt.Errorf("%w: unknown user type %q", ErrInvalidGroup, member.UserType)
+	}
+
+	var allowed bool
+	args := []interface{}{member.UserID}
+	if member.UserType != "doctor" {
+		args = append(args, doctors)
+	}
+	if err := tx.QueryRow(ctx, query, args...).Scan(&allowed); err != nil {
+		return fmt.Errorf("failed to check care team member: %v", err)
+	}
+	if !allowed {
+		return ErrParticipantNotAllowed
+	}
+	return nil
+}
+
+func insertParticipant(ctx context.Context, tx pgx.Tx, chatID string, member GroupMember) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO participants (chat_id, user_id, user_type, role, joined_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW(), NOW())`,
+		chatID, member.UserID, member.UserType, string(member.Role))
+	if err != nil {
+		return fmt.Errorf("failed to add participant: %v", err)
+	}
+	return nil
+}
+
+// addSystemMessage records a membership change in the chat and returns the
+// event to publish once the transaction commits.
+func addSystemMessage(ctx context.Context, tx pgx.Tx, chatID, actorID string, systemEvent models.ChatSystemEvent) (realtime.NewMessageEvent, error) {
+	encoded, err := json.Marshal(systemEvent)
+	if err != nil {
+		return realtime.NewMessageEvent{}, fmt.Errorf("failed to encode system message: %v", err)
+	}
+	event := realtime.NewMessageEvent{
+		Header:      realtime.NewHeader(realtime.EventNewMessage),
+		ChatID:      chatID,
+		SenderID:    actorID,
+		SystemEvent: encoded,
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO messages (chat_id, sender_id, system_event, created_at, updated_at)
+		VALUES ($1, $2, $3, clock_timestamp(), NOW()) RETURNING id::text, created_at`,
+		chatID, actorID, string(encoded)).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return realtime.NewMessageEvent{}, fmt.Errorf("failed to add system message: %v", err)
+	}
+	if _, err := tx.Exec(ctx, "UPDATE chats SET updated_at = NOW() WHERE id::text = $1", chatID); err != nil {
+		return realtime.NewMessageEvent{}, fmt.Errorf("failed to update chat: %v", err)
+	}
+	return event, nil
+}
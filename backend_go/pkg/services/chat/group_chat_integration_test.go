@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func participantRoles(t *testing.T, ctx context.Context, db *pgxpool.Pool, chatID string) map[string]string {
+	t.Helper()
+	rows, err := db.Query(ctx, "SELECT user_id::text, role FROM participants WHERE chat_id::text = $1", chatID)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	roles := map[string]string{}
+	for rows.Next() {
+		var userID, role string
+		require.NoError(t, rows.Scan(&userID, &role))
+		roles[userID] = role
+	}
+	require.NoError(t, rows.Err())
+	return roles
+}
+
+// systemEvents returns the system messages of the chat as the user sees
+// them, oldest first.
+func systemEvents(t *testing.T, service *ChatService, chatID, userID string) []models.ChatSystemEvent {
+	t.Helper()
+	page, err := service.GetMessagesForChat(chatID, userID, MessagePageQuery{})
+	require.NoError(t, err)
+	events := []models.ChatSystemEvent{}
+	for _, message := range page.Messages {
+		if message.SystemEvent != nil {
+			events = append(events, *message.SystemEvent)
+		}
+	}
+	return events
+}
+
+func TestOwnerLeavingPassesOwnership_Integration(t *testing.T) {
+	service, db, ctx := setupChatIntegrationTest(t)
+	owner, patient, doctor, admin := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+	now := time.Now()
+	chatID := createTestChat(t, ctx, db, "group",
+		testParticipant{userID: owner, userType: "doctor", role: "owner", joinedAt: now.Add(-3 * time.Hour)},
+		testParticipant{userID: patient, userType: "patient", joinedAt: now.Add(-4 * time.Hour)},
+		testParticipant{userID: doctor, userType: "doctor", joinedAt: now.Add(-2 * time.Hour)},
+		testParticipant{userID: admin, userType: "doctor", role: "admin", joinedAt: now.Add(-time.Hour)},
+	)
+
+	assert.ErrorIs(t, service.RemoveParticipant(chatID, doctor, admin), ErrNotGroupManager)
+
+	// Admins come before members, however long the members have been in.
+	require.NoError(t, service.RemoveParticipant(chatID, owner, owner))
+	assert.Equal(t, map[string]string{patient: "member", doctor: "member", admin: "owner"}, participantRoles(t, ctx, db, chatID))
+	assert.Equal(t, []models.ChatSystemEvent{
+		{Action: models.SystemMemberLeft, UserID: owner},
+		{Action: models.SystemRoleChanged, UserID: admin, Role: models.RoleOwner},
+	}, systemEvents(t, service, chatID, patient))
+
+	// Without admins the longest-standing staff member takes over; patients
+	// never do.
+	require.NoError(t, service.RemoveParticipant(chatID, admin, admin))
+	assert.Equal(t, map[string]string{patient: "member", doctor: "owner"}, participantRoles(t, ctx, db, chatID))
+
+	require.NoError(t, service.RemoveParticipant(chatID, doctor, doctor))
+	assert.Equal(t, map[string]string{patient: "member"}, participantRoles(t, ctx, db, chatID))
+}
@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanManage(t *testing.T) {
+	assert.True(t, canManage(models.RoleOwner, models.RoleAdmin))
+	assert.True(t, canManage(models.RoleOwner, models.RoleMember))
+	assert.False(t, canManage(models.RoleOwner, models.RoleOwner))
+	assert.True(t, canManage(models.RoleAdmin, models.RoleMember))
+	assert.False(t, canManage(models.RoleAdmin, models.RoleAdmin))
+	assert.False(t, canManage(models.RoleMember, models.RoleMember))
+}
+
+func TestValidGroupRole(t *testing.T) {
+	assert.NoError(t, validGroupRole("patient", models.RoleMember, false))
+	assert.NoError(t, validGroupRole("receptionist", models.RoleAdmin, false))
+	assert.NoError(t, validGroupRole("doctor", models.RoleOwner, true))
+
+	for _, err := range []error{
+		validGroupRole("patient", models.RoleAdmin, false),
+		validGroupRole("patient", models.RoleOwner, true),
+		validGroupRole("doctor", models.RoleOwner, false),
+		validGroupRole("doctor", "moderator", false),
+	} {
+		assert.True(t, errors.Is(err, ErrInvalidGroup), "%v", err)
+	}
+}
+
+func TestGroupName(t *testing.T) {
+	name, err := groupName("  Cardiology follow-up ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Cardiology follow-up", name)
+
+	_, err = groupName("   ")
+	assert.ErrorIs(t, err, ErrInvalidGroup)
+}
+
+func TestSortParticipants(t *testing.T) {
+	now := time.Now()
+	participants := []models.Participant{
+		{UserID: "patient", Role: models.RoleMember, JoinedAt: now},
+		{UserID: "receptionist", Role: models.RoleAdmin, JoinedAt: now.Add(time.Minute)},
+		{UserID: "specialist", Role: models.RoleMember, JoinedAt: now.Add(-time.Minute)},
+		{UserID: "doctor", Role: models.RoleOwner, JoinedAt: now.Add(time.Hour)},
+	}
+	sortParticipants(participants)
+
+	var order []string
+	for _, p := range participants {
+		order = append(order, p.UserID)
+	}
+	assert.Equal(t, []string{"doctor", "receptionist", "specialist", "patient"}, order)
+}
@@ -0,0 +1,474 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/realtime"
+	"healthcare_backend/pkg/utils"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// MessageEditWindow is how long after sending a message its sender may
+	// edit it. Earlier versions are kept in message_edits.
+	MessageEditWindow = 15 * time.Minute
+	// MessageDeleteWindow is how long after sending a message its sender may
+	// delete it for everyone. Deleting for oneself is always possible.
+	MessageDeleteWindow = 24 * time.Hour
+
+	maxReactionBytes = 32
+)
+
+// DeleteScope says who a deleted message disappears for.
+type DeleteScope string
+
+const (
+	DeleteForMe       DeleteScope = "me"
+	DeleteForEveryone DeleteScope = "everyone"
+)
+
+var (
+	ErrNotMessageSender   = errors.New("only the sender can change this message")
+	ErrEditWindowClosed   = errors.New("the message can no longer be edited")
+	ErrDeleteWindowClosed = errors.New("the message can no longer be deleted for everyone")
+	ErrMessageDeleted     = errors.New("the message has been deleted")
+	ErrMessageNotEditable = errors.New("image messages cannot be edited")
+	ErrSystemMessage      = errors.New("system messages cannot be changed")
+	ErrInvalidReaction    = errors.New("a reaction must be an emoji")
+	ErrInvalidDeleteScope = errors.New("scope must be me or everyone")
+)
+
+// changedMessage is the stored state of a message about to be changed.
+type changedMessage struct {
+	senderID  string
+	content   *string
+	key       *string
+	createdAt time.Time
+	editedAt  *time.Time
+	deletedAt *time.Time
+	system    bool
+}
+
+func lockMessage(ctx context.Context, tx pgx.Tx, chatID, messageID string) (*changedMessage, error) {
+	var m changedMessage
+	err := tx.QueryRow(ctx,
+		`SELECT sender_id::text, content, key, created_at, edited_at, deleted_at, system_event IS NOT NULL
+		FROM messages WHERE id::text = $1 AND chat_id::text = $2
+		FOR UPDATE`, messageID, chatID).
+		Scan(&m.senderID, &m.content, &m.key, &m.createdAt, &m.editedAt, &m.deletedAt, &m.system)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %v", err)
+	}
+	return &m, nil
+}
+
+// EditMessage replaces the text of the user's own message within the edit
+// window and keeps the previous text in its history. Users who have left
+// the chat can no longer edit what they sent.
+func (s *ChatService) EditMessage(chatID, messageID, userID, content string) (*models.Message, error) {
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	m, err := lockMessage(ctx, tx, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case m.system:
+		return nil, ErrSystemMessage
+	case m.senderID != userID:
+		return nil, ErrNotMessageSender
+	case m.deletedAt != nil:
+		return nil, ErrMessageDeleted
+	case m.key != nil && *m.key != "":
+		return nil, ErrMessageNotEditable
+	case time.Since(m.createdAt) > MessageEditWindow:
+		return nil, ErrEditWindowClosed
+	}
+
+	message := &models.Message{ID: messageID, ChatID: chatID, SenderID: userID, Content: &content, CreatedAt: m.createdAt, EditedAt: m.editedAt}
+	if m.content != nil && *m.content == content {
+		return message, nil
+	}
+
+	previousAt := m.createdAt
+	if m.editedAt != nil {
+		previousAt = *m.editedAt
+	}
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO message_edits (message_id, content, edited_at) VALUES ($1, $2, $3)",
+		messageID, m.content, previousAt); err != nil {
+		return nil, fmt.Errorf("failed to keep message history: %v", err)
+	}
+	var editedAt time.Time
+	if err := tx.QueryRow(ctx,
+		`UPDATE messages SET content = $2, edited_at = NOW(), updated_at = NOW()
+		WHERE id::text = $1 RETURNING edited_at`, messageID, content).Scan(&editedAt); err != nil {
+		return nil, fmt.Errorf("failed to edit message: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	message.EditedAt = &editedAt
+	message.UpdatedAt = editedAt
+
+	s.publishToChat(ctx, chatID, realtime.MessageEditedEvent{
+		Header:   realtime.NewHeader(realtime.EventMessageEdited),
+		ID:       messageID,
+		ChatID:   chatID,
+		Content:  content,
+		EditedAt: editedAt,
+	})
+	return message, nil
+}
+
+// GetMessageEdits returns the earlier versions of a message, oldest first.
+func (s *ChatService) GetMessageEdits(chatID, messageID, userID string) ([]models.MessageEdit, error) {
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := s.db.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM messages WHERE id::text = $1 AND chat_id::text = $2)",
+		messageID, chatID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look up message: %v", err)
+	}
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT COALESCE(content, ''), edited_at FROM message_edits
+		WHERE message_id::text = $1 ORDER BY edited_at, id`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message history: %v", err)
+	}
+	defer rows.Close()
+
+	edits := []models.MessageEdit{}
+	for rows.Next() {
+		var edit models.MessageEdit
+		if err := rows.Scan(&edit.Content, &edit.EditedAt); err != nil {
+			return nil, fmt.Errorf("failed to read message history: %v", err)
+		}
+		edits = append(edits, edit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read message history: %v", err)
+	}
+	return edits, nil
+}
+
+// DeleteMessage hides a message from the user, or for the sender within the
+// delete window removes its content for everyone and leaves a tombstone.
+func (s *ChatService) DeleteMessage(chatID, messageID, userID string, scope DeleteScope) error {
+	switch scope {
+	case DeleteForMe:
+		return s.hideMessage(chatID, messageID, userID)
+	case DeleteForEveryone:
+		return s.deleteMessageForEveryone(chatID, messageID, userID)
+	}
+	return ErrInvalidDeleteScope
+}
+
+func (s *ChatService) hideMessage(chatID, messageID, userID string) error {
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return err
+	}
+
+	var hiddenAt time.Time
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO message_hidden (message_id, user_id)
+		SELECT id, $3 FROM messages WHERE id::text = $1 AND chat_id::text = $2
+		ON CONFLICT (message_id, user_id) DO UPDATE SET hidden_at = message_hidden.hidden_at
+		RETURNING hidden_at`, messageID, chatID, userID).Scan(&hiddenAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %v", err)
+	}
+
+	s.publishTo(ctx, []string{userID}, realtime.MessageDeletedEvent{
+		Header:    realtime.NewHeader(realtime.EventMessageDeleted),
+		ID:        messageID,
+		ChatID:    chatID,
+		Scope:     string(DeleteForMe),
+		DeletedAt: hiddenAt,
+	})
+	return nil
+}
+
+func (s *ChatService) deleteMessageForEveryone(chatID, messageID, userID string) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	m, err := lockMessage(ctx, tx, chatID, messageID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case m.system:
+		return ErrSystemMessage
+	case m.senderID != userID:
+		return ErrNotMessageSender
+	case m.deletedAt != nil:
+		return nil
+	case time.Since(m.createdAt) > MessageDeleteWindow:
+		return ErrDeleteWindowClosed
+	}
+
+	var deletedAt time.Time
+	if err := tx.QueryRow(ctx,
+		`UPDATE messages SET content = NULL, key = NULL, deleted_at = NOW(), updated_at = NOW()
+		WHERE id::text = $1 RETURNING deleted_at`, messageID).Scan(&deletedAt); err != nil {
+		return fmt.Errorf("failed to delete message: %v", err)
+	}
+	// The tombstone keeps nothing of what was said.
+	if _, err := tx.Exec(ctx, "DELETE FROM message_edits WHERE message_id::text = $1", messageID); err != nil {
+		return fmt.Errorf("failed to delete message history: %v", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM message_reactions WHERE message_id::text = $1", messageID); err != nil {
+		return fmt.Errorf("failed to delete message reactions: %v", err)
+	}
+	storedKey, err := deleteAttachmentOf(ctx, tx, messageID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	if storedKey != "" {
+		if err := s.storage.Delete(ctx, storedKey); err != nil {
+			log.Printf("Warning: failed to delete attachment %s of deleted message %s: %v", storedKey, messageID, err)
+		}
+	}
+
+	if m.key != nil && *m.key != "" {
+		if err := utils.DeleteFromS3(*m.key); err != nil {
+			log.Printf("Warning: failed to delete image %s of deleted message %s: %v", *m.key, messageID, err)
+		}
+	}
+
+	s.publishToChat(ctx, chatID, realtime.MessageDeletedEvent{
+		Header:    realtime.NewHeader(realtime.EventMessageDeleted),
+		ID:        messageID,
+		ChatID:    chatID,
+		Scope:     string(DeleteForEveryone),
+		DeletedAt: deletedAt,
+	})
+	return nil
+}
+
+// AddReaction reacts to a message with an emoji. Reacting twice with the
+// same emoji changes nothing.
+func (s *ChatService) AddReaction(chatID, messageID, userID, emoji string) error {
+	if !validReaction(emoji) {
+		return ErrInvalidReaction
+	}
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return err
+	}
+
+	var deletedAt *time.Time
+	err := s.db.QueryRow(ctx,
+		"SELECT deleted_at FROM messages WHERE id::text = $1 AND chat_id::text = $2",
+		messageID, chatID).Scan(&deletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up message: %v", err)
+	}
+	if deletedAt != nil {
+		return ErrMessageDeleted
+	}
+
+	tag, err := s.db.Exec(ctx,
+		`INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, messageID, userID, emoji)
+	if err != nil {
+		return fmt.Errorf("failed to add reaction: %v", err)
+	}
+	if tag.RowsAffected() > 0 {
+		s.publishReaction(ctx, realtime.EventReactionAdded, chatID, messageID, userID, emoji)
+	}
+	return nil
+}
+
+// RemoveReaction takes the user's emoji off a message.
+func (s *ChatService) RemoveReaction(chatID, messageID, userID, emoji string) error {
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return err
+	}
+
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM message_reactions r USING messages m
+		WHERE r.message_id = m.id AND m.id::text = $1 AND m.chat_id::text = $2
+			AND r.user_id::text = $3 AND r.emoji = $4`,
+		messageID, chatID, userID, emoji)
+	if err != nil {
+		return fmt.Errorf("failed to remove reaction: %v", err)
+	}
+	if tag.RowsAffected() > 0 {
+		s.publishReaction(ctx, realtime.EventReactionRemoved, chatID, messageID, userID, emoji)
+	}
+	return nil
+}
+
+func (s *ChatService) publishReaction(ctx context.Context, eventType realtime.EventType, chatID, messageID, userID, emoji string) {
+	s.publishToChat(ctx, chatID, realtime.ReactionEvent{
+		Header:    realtime.NewHeader(eventType),
+		MessageID: messageID,
+		ChatID:    chatID,
+		UserID:    userID,
+		Emoji:     emoji,
+	})
+}
+
+// validReaction accepts a short emoji sequence, including ones joined with
+// zero width joiners and skin tone modifiers, but no text.
+func validReaction(emoji string) bool {
+	if emoji == "" || len(emoji) > maxReactionBytes || !utf8.ValidString(emoji) {
+		return false
+	}
+	hasSymbol := false
+	for _, r := range emoji {
+		if r < utf8.RuneSelf || unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsPunct(r) {
+			return false
+		}
+		if unicode.IsSymbol(r) {
+			hasSymbol = true
+		}
+	}
+	return hasSymbol
+}
+
+// loadReactions fills in the reactions of messages, in the order they were
+// first used.
+func (s *ChatService) loadReactions(ctx context.Context, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(messages))
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		index[msg.ID] = i
+		ids[i] = msg.ID
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT message_id::text, emoji, array_agg(user_id::text ORDER BY created_at)
+		FROM message_reactions
+		WHERE message_id::text = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY MIN(created_at)`, ids)
+	if err != nil {
+		return fmt.Errorf("failed to load reactions: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var reaction models.MessageReaction
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.UserIDs); err != nil {
+			return fmt.Errorf("failed to read reaction: %v", err)
+		}
+		reaction.Count = len(reaction.UserIDs)
+		i := index[messageID]
+		messages[i].Reactions = append(messages[i].Reactions, reaction)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read reactions: %v", err)
+	}
+	return nil
+}
+
+// participantIDs returns every participant of the chat.
+func (s *ChatService) participantIDs(ctx context.Context, chatID string) ([]string, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT user_id::text FROM participants WHERE chat_id::text = $1", chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat participants: %v", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to read chat participant: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chat participants: %v", err)
+	}
+	return userIDs, nil
+}
+
+// otherParticipants returns the participants of the chat except userID.
+func (s *ChatService) otherParticipants(ctx context.Context, chatID, userID string) ([]string, error) {
+	participants, err := s.participantIDs(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	others := participants[:0]
+	for _, participant := range participants {
+		if participant != userID {
+			others = append(others, participant)
+		}
+	}
+	return others, nil
+}
+
+// publishToChat sends event to every participant of the chat. Failures are
+// logged; the change itself has already been stored.
+func (s *ChatService) publishToChat(ctx context.Context, chatID string, event interface{}) {
+	participants, err := s.participantIDs(ctx, chatID)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	s.publishTo(ctx, participants, event)
+}
+
+func (s *ChatService) publishTo(ctx context.Context, userIDs []string, event interface{}) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Warning: failed to encode chat event: %v", err)
+		return
+	}
+	if err := s.events.Publish(ctx, userIDs, encoded); err != nil {
+		log.Printf("Warning: failed to publish chat event: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/realtime"
+	"healthcare_backend/pkg/storage"
+	"healthcare_backend/pkg/testhelpers"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupChatIntegrationTest connects to a freshly migrated local test
+// database and returns a service storing attachments in a temporary
+// directory, or skips the test when there is no database.
+func setupChatIntegrationTest(t *testing.T) (*ChatService, *pgxpool.Pool, context.Context) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	ctx := context.Background()
+
+	testDB, err := testhelpers.SetupMigratedTestDatabase(ctx)
+	if err != nil {
+		t.Skipf("Test database unavailable: %v", err)
+	}
+	t.Cleanup(func() { testDB.Cleanup(ctx) })
+
+	cfg := &config.Config{UploadScanner: "none"}
+	service := NewChatService(testDB.Pool, cfg, storage.NewLocalStorage(t.TempDir()), realtime.NewHub(nil))
+	return service, testDB.Pool, ctx
+}
+
+// testParticipant is a user to add to a test chat. Role defaults to member
+// and joinedAt to now.
+type testParticipant struct {
+	userID   string
+	userType string
+	role     string
+	joinedAt time.Time
+}
+
+func createTestChat(t *testing.T, ctx context.Context, db *pgxpool.Pool, kind string, participants ...testParticipant) string {
+	t.Helper()
+	var chatID string
+	require.NoError(t, db.QueryRow(ctx,
+		"INSERT INTO chats (kind) VALUES ($1) RETURNING id::text", kind).Scan(&chatID))
+
+	for _, p := range participants {
+		if p.role == "" {
+			p.role = "member"
+		}
+		if p.joinedAt.IsZero() {
+			p.joinedAt = time.Now()
+		}
+		_, err := db.Exec(ctx,
+			`INSERT INTO participants (chat_id, user_id, user_type, role, joined_at)
+			VALUES ($1, $2, NULLIF($3, ''), $4, $5)`,
+			chatID, p.userID, p.userType, p.role, p.joinedAt)
+		require.NoError(t, err)
+	}
+	return chatID
+}
+
+func insertTestMessage(t *testing.T, ctx context.Context, db *pgxpool.Pool, chatID, senderID, content string, createdAt time.Time) string {
+	t.Helper()
+	var messageID string
+	require.NoError(t, db.QueryRow(ctx,
+		`INSERT INTO messages (chat_id, sender_id, content, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4) RETURNING id::text`,
+		chatID, senderID, content, createdAt).Scan(&messageID))
+	return messageID
+}
+
+// visibleMessages returns the IDs of the chat's messages the user sees.
+func visibleMessages(t *testing.T, service *ChatService, chatID, userID string) []string {
+	t.Helper()
+	page, err := service.GetMessagesForChat(chatID, userID, MessagePageQuery{})
+	require.NoError(t, err)
+	ids := []string{}
+	for _, message := range page.Messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+func TestEditMessageWithinWindow_Integration(t *testing.T) {
+	service, db, ctx := setupChatIntegrationTest(t)
+	sender, recipient := uuid.NewString(), uuid.NewString()
+	chatID := createTestChat(t, ctx, db, "direct", testParticipant{userID: sender}, testParticipant{userID: recipient})
+
+	fresh := insertTestMessage(t, ctx, db, chatID, sender, "see you at 10", time.Now().Add(-time.Minute))
+	stale := insertTestMessage(t, ctx, db, chatID, sender, "see you at 9", time.Now().Add(-MessageEditWindow-time.Minute))
+
+	_, err := service.EditMessage(chatID, fresh, recipient, "see you at 11")
+	assert.ErrorIs(t, err, ErrNotMessageSender)
+	_, err = service.EditMessage(chatID, stale, sender, "see you at 11")
+	assert.ErrorIs(t, err, ErrEditWindowClosed)
+
+	edited, err := service.EditMessage(chatID, fresh, sender, "see you at 11")
+	require.NoError(t, err)
+	assert.Equal(t, "see you at 11", *edited.Content)
+	assert.NotNil(t, edited.EditedAt)
+
+	edits, err := service.GetMessageEdits(chatID, fresh, recipient)
+	require.NoError(t, err)
+	require.Len(t, edits, 1)
+	assert.Equal(t, "see you at 10", edits[0].Content)
+}
+
+func TestDeleteMessageScopes_Integration(t *testing.T) {
+	service, db, ctx := setupChatIntegrationTest(t)
+	sender, recipient := uuid.NewString(), uuid.NewString()
+	chatID := createTestChat(t, ctx, db, "direct", testParticipant{userID: sender}, testParticipant{userID: recipient})
+
+	message := insertTestMessage(t, ctx, db, chatID, sender, "my blood pressure is 14/9", time.Now().Add(-time.Minute))
+	old := insertTestMessage(t, ctx, db, chatID, sender, "hello", time.Now().Add(-MessageDeleteWindow-time.Minute))
+	_, err := service.EditMessage(chatID, message, sender, "my blood pressure is 13/8")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, service.DeleteMessage(chatID, message, sender, "all"), ErrInvalidDeleteScope)
+	assert.ErrorIs(t, service.DeleteMessage(chatID, message, recipient, DeleteForEveryone), ErrNotMessageSender)
+	assert.ErrorIs(t, service.DeleteMessage(chatID, old, sender, DeleteForEveryone), ErrDeleteWindowClosed)
+	require.NoError(t, service.DeleteMessage(chatID, old, sender, DeleteForMe), "deleting for oneself has no window")
+
+	require.NoError(t, service.DeleteMessage(chatID, message, sender, DeleteForEveryone))
+	page, err := service.GetMessagesForChat(chatID, recipient, MessagePageQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Messages, 2)
+	tombstone := page.Messages[1]
+	assert.Equal(t, message, tombstone.ID)
+	assert.Nil(t, tombstone.Content)
+	assert.NotNil(t, tombstone.DeletedAt)
+
+	edits, err := service.GetMessageEdits(chatID, message, recipient)
+	require.NoError(t, err)
+	assert.Empty(t, edits, "the tombstone keeps no earlier versions")
+
+	_, err = service.EditMessage(chatID, message, sender, "again")
+	assert.ErrorIs(t, err, ErrMessageDeleted)
+}
+
+func TestChangeMessageRestrictions_Integration(t *testing.T) {
+	service, db, ctx := setupChatIntegrationTest(t)
+	owner, member := uuid.NewString(), uuid.NewString()
+	chatID := createTestChat(t, ctx, db, "group",
+		testParticipant{userID: owner, role: "owner"}, testParticipant{userID: member})
+
+	var systemMessage string
+	require.NoError(t, db.QueryRow(ctx,
+		`INSERT INTO messages (chat_id, sender_id, system_event, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW()) RETURNING id::text`,
+		chatID, owner, `{"action":"group_renamed","name":"Ward 3"}`).Scan(&systemMessage))
+	_, err := service.EditMessage(chatID, systemMessage, owner, "renamed")
+	assert.ErrorIs(t, err, ErrSystemMessage)
+	assert.ErrorIs(t, service.DeleteMessage(chatID, systemMessage, owner, DeleteForEveryone), ErrSystemMessage)
+
+	message := insertTestMessage(t, ctx, db, chatID, member, "running late", time.Now().Add(-time.Minute))
+	_, err = db.Exec(ctx, "DELETE FROM participants WHERE chat_id::text = $1 AND user_id::text = $2", chatID, member)
+	require.NoError(t, err)
+	_, err = service.EditMessage(chatID, message, member, "on my way")
+	assert.ErrorIs(t, err, ErrNotChatParticipant, "former participants cannot edit what they sent")
+}
+
+func TestHideMessage_Integration(t *testing.T) {
+	service, db, ctx := setupChatIntegrationTest(t)
+	sender, recipient, outsider := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	chatID := createTestChat(t, ctx, db, "direct", testParticipant{userID: sender}, testParticipant{userID: recipient})
+
+	first := insertTestMessage(t, ctx, db, chatID, sender, "first", time.Now().Add(-2*time.Minute))
+	second := insertTestMessage(t, ctx, db, chatID, sender, "second", time.Now().Add(-time.Minute))
+
+	assert.ErrorIs(t, service.DeleteMessage(chatID, first, outsider, DeleteForMe), ErrNotChatParticipant)
+	assert.ErrorIs(t, service.DeleteMessage(chatID, uuid.NewString(), recipient, DeleteForMe), ErrMessageNotFound)
+
+	require.NoError(t, service.DeleteMessage(chatID, first, recipient, DeleteForMe))
+	require.NoError(t, service.DeleteMessage(chatID, first, recipient, DeleteForMe), "hiding twice is harmless")
+
+	assert.Equal(t, []string{second}, visibleMessages(t, service, chatID, recipient))
+	assert.Equal(t, []string{first, second}, visibleMessages(t, service, chatID, sender))
+}
@@ -0,0 +1,16 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidReaction(t *testing.T) {
+	for _, emoji := range []string{"👍", "❤️", "👍🏽", "👨‍👩‍👧", "🇲🇦"} {
+		assert.True(t, validReaction(emoji), emoji)
+	}
+	for _, text := range []string{"", "ok", "👍 ", "!", "هـ", "<3", "👍👍👍👍👍👍👍👍👍"} {
+		assert.False(t, validReaction(text), text)
+	}
+}
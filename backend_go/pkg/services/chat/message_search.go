@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/textsearch"
+)
+
+const (
+	defaultMessageSearchLimit = 20
+	maxMessageSearchLimit     = 100
+)
+
+// messageSearchLanguages are the text search configurations the
+// search_vector of messages is built from. Chat messages are too short to
+// tell their language, so each is indexed under all of them and a query
+// matches in whichever language it was typed in.
+var messageSearchLanguages = []string{"simple", "english", "french", "arabic"}
+
+// SearchMessages runs a full-text query over the messages of the user's
+// chats, newest first. Deleted messages and those the user hid are left out.
+// Filtering by a chat the user is not in is an error rather than an empty
+// result.
+func (s *ChatService) SearchMessages(userID string, req models.MessageSearchRequest) ([]models.MessageSearchResult, error) {
+	ctx := context.Background()
+	if req.ChatID != "" {
+		if err := s.checkParticipant(ctx, req.ChatID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	query, args := buildMessageSearchQuery(userID, req)
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %v", err)
+	}
+	defer rows.Close()
+
+	results := []models.MessageSearchResult{}
+	for rows.Next() {
+		var result models.MessageSearchResult
+		var chatType string
+		if err := rows.Scan(&result.ID, &result.ChatID, &result.SenderID, &result.Content,
+			&result.CreatedAt, &result.UpdatedAt, &result.EditedAt,
+			&chatType, &result.ChatName, &result.Rank, &result.Snippet); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %v", err)
+		}
+		result.ChatType = models.ChatType(chatType)
+		result.Snippet = textsearch.FormatSnippet(result.Snippet)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read search results: %v", err)
+	}
+	return results, nil
+}
+
+// buildMessageSearchQuery builds the search statement. The query text is
+// parsed with every language and the results OR-ed, like the index. The
+// snippet is highlighted with the first language whose stemming matches the
+// message, so that "results" marks "result".
+func buildMessageSearchQuery(userID string, req models.MessageSearchRequest) (string, []interface{}) {
+	args := []interface{}{req.Query, userID}
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// Legacy image messages hold a URL rather than text.
+	conditions := []string{
+		"m.search_vector @@ query.q",
+		"m.deleted_at IS NULL",
+		"m.key IS NULL",
+		"NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id::text = $2)",
+	}
+	if req.ChatID != "" {
+		conditions = append(conditions, "m.chat_id::text = "+param(req.ChatID))
+	}
+	if req.SenderID != "" {
+		conditions = append(conditions, "m.sender_id::text = "+param(req.SenderID))
+	}
+	if req.From != nil {
+		conditions = append(conditions, "m.created_at >= "+param(*req.From))
+	}
+	if req.To != nil {
+		conditions = append(conditions, "m.created_at < "+param(*req.To))
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultMessageSearchLimit
+	}
+	limit = min(limit, maxMessageSearchLimit)
+	limitParam := param(limit)
+	offsetParam := param(max(req.Offset, 0))
+
+	// Snippets are only built for the page being returned.
+	query := fmt.Sprintf(`
+	WITH query AS (
+		SELECT %s AS q
+	), matches AS (
+		SELECT m.id, m.chat_id, m.sender_id, m.content, m.created_at, m.updated_at, m.edited_at,
+			c.kind, c.name, ts_rank(m.search_vector, query.q) AS rank, query.q
+		FROM messages m
+		JOIN participants p ON p.chat_id = m.chat_id AND p.user_id::text = $2
+		JOIN chats c ON c.id = m.chat_id
+		CROSS JOIN query
+		WHERE %s
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT %s OFFSET %s
+	)
+	SELECT id::text, chat_id::text, sender_id::text, content, created_at, updated_at, edited_at,
+		kind, name, rank,
+		ts_headline(COALESCE((
+			SELECT l FROM unnest(ARRAY['english', 'french', 'arabic']::regconfig[]) l
+			WHERE to_tsvector(l, content) @@ q LIMIT 1
+		), 'simple'), content, q, '%s')
+	FROM matches
+	ORDER BY created_at DESC, id DESC`,
+		textsearch.Query(messageSearchLanguages, "$1"), strings.Join(conditions, "\n\t\tAND "), limitParam, offsetParam,
+		textsearch.HeadlineOptions)
+	return query, args
+}
@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchMessagesOnlyInCallersChats_Integration(t *testing.T) {
+	service, db, ctx := setupChatIntegrationTest(t)
+	patient, doctor, colleague := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	ownChat := createTestChat(t, ctx, db, "direct", testParticipant{userID: patient}, testParticipant{userID: doctor})
+	otherChat := createTestChat(t, ctx, db, "direct", testParticipant{userID: doctor}, testParticipant{userID: colleague})
+
+	now := time.Now()
+	match := insertTestMessage(t, ctx, db, ownChat, doctor, "Does the fever come back at night?", now.Add(-4*time.Minute))
+	hidden := insertTestMessage(t, ctx, db, ownChat, patient, "The fever is gone", now.Add(-3*time.Minute))
+	deleted := insertTestMessage(t, ctx, db, ownChat, patient, "Fevers again", now.Add(-2*time.Minute))
+	insertTestMessage(t, ctx, db, otherChat, colleague, "Your patient's fever worries me", now.Add(-time.Minute))
+
+	require.NoError(t, service.DeleteMessage(ownChat, hidden, patient, DeleteForMe))
+	require.NoError(t, service.DeleteMessage(ownChat, deleted, patient, DeleteForEveryone))
+
+	results, err := service.SearchMessages(patient, models.MessageSearchRequest{Query: "fevers"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, match, results[0].ID)
+	assert.Equal(t, ownChat, results[0].ChatID)
+	assert.Contains(t, results[0].Snippet, "<mark>fever</mark>")
+
+	results, err = service.SearchMessages(doctor, models.MessageSearchRequest{Query: "fever"})
+	require.NoError(t, err)
+	assert.Len(t, results, 3, "the doctor sees both chats and the message the patient only hid for themself")
+
+	_, err = service.SearchMessages(patient, models.MessageSearchRequest{Query: "fever", ChatID: otherChat})
+	assert.ErrorIs(t, err, ErrNotChatParticipant)
+}
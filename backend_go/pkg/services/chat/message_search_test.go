@@ -0,0 +1,45 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildMessageSearchQueryFilters(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)
+	query, args := buildMessageSearchQuery("user", models.MessageSearchRequest{
+		Query:    "blood test",
+		ChatID:   "chat",
+		SenderID: "sender",
+		From:     &from,
+		To:       &to,
+		Limit:    500,
+		Offset:   40,
+	})
+
+	assert.Equal(t, []interface{}{"blood test", "user", "chat", "sender", from, to, maxMessageSearchLimit, 40}, args)
+	for _, language := range messageSearchLanguages {
+		assert.Contains(t, query, "websearch_to_tsquery('"+language+"', $1)")
+	}
+	assert.Contains(t, query, "m.chat_id::text = $3")
+	assert.Contains(t, query, "m.sender_id::text = $4")
+	assert.Contains(t, query, "m.created_at >= $5")
+	assert.Contains(t, query, "m.created_at < $6")
+	assert.Contains(t, query, "LIMIT $7 OFFSET $8")
+}
+
+func TestBuildMessageSearchQueryDefaults(t *testing.T) {
+	query, args := buildMessageSearchQuery("user", models.MessageSearchRequest{Query: "fever"})
+
+	assert.Equal(t, []interface{}{"fever", "user", defaultMessageSearchLimit, 0}, args)
+	assert.Contains(t, query, "p.user_id::text = $2")
+	assert.Contains(t, query, "m.deleted_at IS NULL")
+	assert.Contains(t, query, "message_hidden")
+	assert.False(t, strings.Contains(query, "m.chat_id::text ="))
+}
@@ -0,0 +1,320 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"healthcare_backend/pkg/realtime"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// presenceHeartbeat is how often an instance confirms the users it holds
+	// connections for. Rows not confirmed within presenceTTL belong to an
+	// instance that went away, and their users are offline.
+	presenceHeartbeat = 30 * time.Second
+	presenceTTL       = "90 seconds"
+)
+
+// ErrInvalidPresence is returned when a user sets a status other than online
+// or away.
+var ErrInvalidPresence = errors.New("presence status must be online or away")
+
+// PresenceTopic is the realtime topic of a user's presence updates.
+func PresenceTopic(userID string) string {
+	return "presence:" + userID
+}
+
+// presenceTracker counts this instance's connections per user. A user is
+// online while any instance has a presence_connections row for them.
+type presenceTracker struct {
+	instanceID string
+
+	mu    sync.Mutex
+	local map[string]int
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{instanceID: uuid.NewString(), local: make(map[string]int)}
+}
+
+// add changes the user's connection count and reports whether the user went
+// from none to one connection, or from one to none.
+func (t *presenceTracker) add(userID string, delta int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	before := t.local[userID]
+	after := before + delta
+	if after <= 0 {
+		delete(t.local, userID)
+		return before > 0
+	}
+	t.local[userID] = after
+	return before == 0
+}
+
+func (t *presenceTracker) users() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	users := make([]string, 0, len(t.local))
+	for userID := range t.local {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// UserConnected records a new WebSocket connection of the user. A user who
+// connects is online again even if they had set themselves away.
+func (s *ChatService) UserConnected(userID string) {
+	if !s.presence.add(userID, 1) {
+		return
+	}
+	ctx := context.Background()
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO presence_connections (user_id, instance_id, heartbeat_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id, instance_id) DO UPDATE SET heartbeat_at = NOW()`,
+		userID, s.presence.instanceID)
+	if err != nil {
+		log.Printf("Warning: failed to record presence of user %s: %v", userID, err)
+		return
+	}
+	s.updatePresence(ctx, userID, realtime.PresenceOnline, false)
+}
+
+// UserDisconnected records that a WebSocket connection of the user closed.
+func (s *ChatService) UserDisconnected(userID string) {
+	if !s.presence.add(userID, -1) {
+		return
+	}
+	ctx := context.Background()
+	_, err := s.db.Exec(ctx,
+		"DELETE FROM presence_connections WHERE user_id::text = $1 AND instance_id = $2",
+		userID, s.presence.instanceID)
+	if err != nil {
+		log.Printf("Warning: failed to clear presence of user %s: %v", userID, err)
+		return
+	}
+	s.updatePresence(ctx, userID, "", true)
+}
+
+// SetPresence switches a connected user between online and away.
+func (s *ChatService) SetPresence(userID string, status realtime.PresenceStatus) error {
+	if status != realtime.PresenceOnline && status != realtime.PresenceAway {
+		return ErrInvalidPresence
+	}
+	s.updatePresence(context.Background(), userID, status, false)
+	return nil
+}
+
+// updatePresence stores the user's chosen status, and with seen their last
+// seen time, then tells subscribed contacts the resulting presence.
+func (s *ChatService) updatePresence(ctx context.Context, userID string, status realtime.PresenceStatus, seen bool) {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO user_presence (user_id, status, last_seen_at, updated_at)
+		VALUES ($1, COALESCE(NULLIF($2, ''), 'online'), CASE WHEN $3 THEN NOW() END, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			status = COALESCE(NULLIF($2, ''), user_presence.status),
+			last_seen_at = CASE WHEN $3 THEN NOW() ELSE user_presence.last_seen_at END,
+			updated_at = NOW()`,
+		userID, string(status), seen)
+	if err != nil {
+		log.Printf("Warning: failed to update presence of user %s: %v", userID, err)
+		return
+	}
+	s.publishPresence(ctx, []string{userID})
+}
+
+// RunPresence keeps this instance's presence rows fresh and expires rows
+// nobody refreshes, such as those of instances that stopped, until ctx is
+// done.
+func (s *ChatService) RunPresence(ctx context.Context) {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.presenceHeartbeat(ctx); err != nil {
+				log.Printf("Warning: presence heartbeat failed: %v", err)
+			}
+		}
+	}
+}
+
+func (s *ChatService) presenceHeartbeat(ctx context.Context) error {
+	users := s.presence.users()
+	if _, err := s.db.Exec(ctx,
+		`INSERT INTO presence_connections (user_id, instance_id, heartbeat_at)
+		SELECT u::uuid, $1, NOW() FROM unnest($2::text[]) AS u
+		ON CONFLICT (user_id, instance_id) DO UPDATE SET heartbeat_at = NOW()`,
+		s.presence.instanceID, users); err != nil {
+		return fmt.Errorf("failed to refresh presence: %v", err)
+	}
+
+	rows, err := s.db.Query(ctx,
+		`WITH expired AS (
+			DELETE FROM presence_connections
+			WHERE heartbeat_at < NOW() - INTERVAL '`+presenceTTL+`'
+			RETURNING user_id, heartbeat_at
+		)
+		UPDATE user_presence p SET last_seen_at = e.seen, updated_at = NOW()
+		FROM (SELECT user_id, MAX(heartbeat_at) AS seen FROM expired GROUP BY user_id) e
+		WHERE p.user_id = e.user_id
+		RETURNING p.user_id::text`)
+	if err != nil {
+		return fmt.Errorf("failed to expire presence: %v", err)
+	}
+	defer rows.Close()
+	var expired []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return fmt.Errorf("failed to read expired presence: %v", err)
+		}
+		expired = append(expired, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read expired presence: %v", err)
+	}
+	s.publishPresence(ctx, expired)
+	return nil
+}
+
+// GetPresence returns the presence of those of userIDs who share a chat
+// with viewerID. Others are left out.
+func (s *ChatService) GetPresence(viewerID string, userIDs []string) ([]realtime.PresenceEvent, error) {
+	return s.loadPresence(context.Background(), viewerID, userIDs)
+}
+
+// loadPresence reads the presence of userIDs, limited to the contacts of
+// viewerID unless it is empty. A user is offline when no instance holds a
+// fresh connection row for them, and otherwise has their chosen status.
+func (s *ChatService) loadPresence(ctx context.Context, viewerID string, userIDs []string) ([]realtime.PresenceEvent, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT u.user_id,
+			CASE
+				WHEN NOT EXISTS (
+					SELECT 1 FROM presence_connections c
+					WHERE c.user_id::text = u.user_id AND c.heartbeat_at > NOW() - INTERVAL '`+presenceTTL+`'
+				) THEN 'offline'
+				ELSE COALESCE(p.status, 'online')
+			END,
+			p.last_seen_at
+		FROM (SELECT DISTINCT unnest($2::text[]) AS user_id) u
+		LEFT JOIN user_presence p ON p.user_id::text = u.user_id
+		WHERE $1 = '' OR (u.user_id <> $1 AND EXISTS (
+			SELECT 1 FROM participants me
+			JOIN participants other ON other.chat_id = me.chat_id
+			WHERE me.user_id::text = $1 AND other.user_id::text = u.user_id
+		))`, viewerID, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load presence: %v", err)
+	}
+	defer rows.Close()
+
+	var events []realtime.PresenceEvent
+	for rows.Next() {
+		event := realtime.PresenceEvent{Header: realtime.NewHeader(realtime.EventPresence)}
+		var status string
+		if err := rows.Scan(&event.UserID, &status, &event.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("failed to read presence: %v", err)
+		}
+		event.Status = realtime.PresenceStatus(status)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read presence: %v", err)
+	}
+	return events, nil
+}
+
+// publishPresence sends the users' current presence to their contacts who
+// subscribed to it.
+func (s *ChatService) publishPresence(ctx context.Context, userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	presence, err := s.loadPresence(ctx, "", userIDs)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	for _, p := range presence {
+		contacts, err := s.contactsOf(ctx, p.UserID)
+		if err != nil {
+			log.Printf("Warning: failed to load contacts of user %s: %v", p.UserID, err)
+			continue
+		}
+		if len(contacts) == 0 {
+			continue
+		}
+		event, err := json.Marshal(p)
+		if err != nil {
+			log.Printf("Warning: failed to encode presence event: %v", err)
+			continue
+		}
+		if err := s.events.PublishTopic(ctx, PresenceTopic(p.UserID), contacts, event); err != nil {
+			log.Printf("Warning: failed to publish presence of user %s: %v", p.UserID, err)
+		}
+	}
+}
+
+// contactsOf returns the users who share a chat with userID.
+func (s *ChatService) contactsOf(ctx context.Context, userID string) ([]string, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT DISTINCT other.user_id::text
+		FROM participants me
+		JOIN participants other ON other.chat_id = me.chat_id AND other.user_id <> me.user_id
+		WHERE me.user_id::text = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []string
+	for rows.Next() {
+		var contact string
+		if err := rows.Scan(&contact); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+// SendTyping tells the chat's other participants that the user started or
+// stopped typing. Clients should treat a typist as stopped when no new
+// typing_start arrives for a few seconds, in case the stop is lost.
+func (s *ChatService) SendTyping(chatID, userID string, typing bool) error {
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return err
+	}
+
+	others, err := s.otherParticipants(ctx, chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	eventType := realtime.EventTypingStop
+	if typing {
+		eventType = realtime.EventTypingStart
+	}
+	event, err := json.Marshal(realtime.TypingEvent{
+		Header: realtime.NewHeader(eventType),
+		ChatID: chatID,
+		UserID: userID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode typing event: %v", err)
+	}
+	return s.events.Publish(ctx, others, event)
+}
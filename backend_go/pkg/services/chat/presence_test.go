@@ -0,0 +1,21 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresenceTrackerTransitions(t *testing.T) {
+	tracker := newPresenceTracker()
+
+	assert.True(t, tracker.add("alice", 1), "first connection comes online")
+	assert.False(t, tracker.add("alice", 1), "second device changes nothing")
+	assert.ElementsMatch(t, []string{"alice"}, tracker.users())
+
+	assert.False(t, tracker.add("alice", -1), "one device is still connected")
+	assert.True(t, tracker.add("alice", -1), "last connection goes offline")
+	assert.Empty(t, tracker.users())
+
+	assert.False(t, tracker.add("bob", -1), "unknown users stay offline")
+}
@@ -0,0 +1,173 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"healthcare_backend/pkg/realtime"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrMessageNotFound is returned when a receipt names a message that is not
+// in the chat.
+var ErrMessageNotFound = errors.New("message not found in this chat")
+
+// receiptUpdate is a chat whose marker moved, with the participants to tell.
+type receiptUpdate struct {
+	chatID string
+	at     time.Time
+	others []string
+}
+
+// MarkChatRead records that the user has read the chat up to messageID, or
+// up to its newest message when messageID is empty. It returns the new read
+// marker, or nil when there was nothing new to mark. The other participants
+// and the user's other devices get a chat_read event.
+func (s *ChatService) MarkChatRead(chatID, userID, messageID string) (*time.Time, error) {
+	ctx := context.Background()
+	if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	var upTo *time.Time
+	if messageID != "" {
+		var createdAt time.Time
+		err := s.db.QueryRow(ctx,
+			"SELECT created_at FROM messages WHERE id::text = $1 AND chat_id::text = $2",
+			messageID, chatID).Scan(&createdAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMessageNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up message: %v", err)
+		}
+		upTo = &createdAt
+	}
+
+	updates, err := s.advanceReceipts(ctx, userID, chatID, upTo, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(updates) == 0 {
+		return nil, nil
+	}
+	s.publishReceipts(ctx, realtime.EventChatRead, userID, updates, true)
+	return &updates[0].at, nil
+}
+
+// MarkChatDelivered records that the user's device has received the
+// messages of the chat, or of all their chats when chatID is empty, and
+// tells the senders with a chat_delivered event.
+func (s *ChatService) MarkChatDelivered(chatID, userID string) error {
+	ctx := context.Background()
+	if chatID != "" {
+		if err := s.checkParticipant(ctx, chatID, userID); err != nil {
+			return err
+		}
+	}
+
+	updates, err := s.advanceReceipts(ctx, userID, chatID, nil, false)
+	if err != nil {
+		return err
+	}
+	s.publishReceipts(ctx, realtime.EventChatDelivered, userID, updates, false)
+	return nil
+}
+
+func (s *ChatService) checkParticipant(ctx context.Context, chatID, userID string) error {
+	var isParticipant bool
+	err := s.db.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM participants WHERE chat_id::text = $1 AND user_id::text = $2)",
+		chatID, userID).Scan(&isParticipant)
+	if err != nil {
+		return fmt.Errorf("failed to check chat participants: %v", err)
+	}
+	if !isParticipant {
+		return ErrNotChatParticipant
+	}
+	return nil
+}
+
+// advanceReceipts moves the user's delivery marker, and with read their
+// read marker too, to the newest message from others in the chat (or in all
+// their chats when chatID is empty), no later than upTo. Markers never move
+// back, and only chats whose marker moved are returned.
+func (s *ChatService) advanceReceipts(ctx context.Context, userID, chatID string, upTo *time.Time, read bool) ([]receiptUpdate, error) {
+	rows, err := s.db.Query(ctx,
+		`WITH latest AS (
+			SELECT m.chat_id, MAX(m.created_at) AS at
+			FROM messages m
+			JOIN participants p ON p.chat_id = m.chat_id AND p.user_id::text = $1
+			WHERE m.sender_id <> p.user_id
+				AND ($2 = '' OR m.chat_id::text = $2)
+				AND ($3::timestamptz IS NULL OR m.created_at <= $3)
+			GROUP BY m.chat_id
+		),
+		updated AS (
+			UPDATE participants p
+			SET last_delivered_at = GREATEST(COALESCE(p.last_delivered_at, '-infinity'::timestamptz), l.at),
+				last_read_at = CASE WHEN $4 THEN GREATEST(COALESCE(p.last_read_at, '-infinity'::timestamptz), l.at) ELSE p.last_read_at END,
+				updated_at = NOW()
+			FROM latest l
+			WHERE p.chat_id = l.chat_id AND p.user_id::text = $1
+				AND COALESCE(CASE WHEN $4 THEN p.last_read_at ELSE p.last_delivered_at END, '-infinity'::timestamptz) < l.at
+			RETURNING p.chat_id, l.at
+		)
+		SELECT u.chat_id::text, u.at, COALESCE(array_agg(DISTINCT o.user_id::text) FILTER (WHERE o.user_id IS NOT NULL), '{}')
+		FROM updated u
+		LEFT JOIN participants o ON o.chat_id = u.chat_id AND o.user_id::text <> $1
+		GROUP BY u.chat_id, u.at`,
+		userID, chatID, upTo, read)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update receipts: %v", err)
+	}
+	defer rows.Close()
+
+	var updates []receiptUpdate
+	for rows.Next() {
+		var update receiptUpdate
+		if err := rows.Scan(&update.chatID, &update.at, &update.others); err != nil {
+			return nil, fmt.Errorf("failed to read receipt update: %v", err)
+		}
+		updates = append(updates, update)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read receipt updates: %v", err)
+	}
+	return updates, nil
+}
+
+// publishReceipts sends one event per chat to the other participants, and
+// with includeSelf to the user's own connections as well.
+func (s *ChatService) publishReceipts(ctx context.Context, eventType realtime.EventType, userID string, updates []receiptUpdate, includeSelf bool) {
+	for _, update := range updates {
+		recipients := update.others
+		if includeSelf {
+			recipients = append(recipients, userID)
+		}
+		receipt := realtime.ReceiptEvent{
+			Header: realtime.NewHeader(eventType),
+			ChatID: update.chatID,
+			UserID: userID,
+		}
+		at := update.at
+		if eventType == realtime.EventChatRead {
+			receipt.ReadAt = &at
+		} else {
+			receipt.DeliveredAt = &at
+		}
+		event, err := json.Marshal(receipt)
+		if err != nil {
+			log.Printf("Warning: failed to encode %s event: %v", eventType, err)
+			continue
+		}
+		if err := s.events.Publish(ctx, recipients, event); err != nil {
+			log.Printf("Warning: failed to publish %s event for chat %s: %v", eventType, update.chatID, err)
+		}
+	}
+}
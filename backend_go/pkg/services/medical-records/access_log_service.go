@@ -0,0 +1,199 @@
+package medicalrecords
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// AccessContext identifies who is reading records and through which path.
+type AccessContext struct {
+	UserID     string
+	UserType   string
+	AccessPath string
+	IPAddress  string
+	UserAgent  string
+}
+
+type AccessLogService struct {
+	db *pgxpool.Pool
+}
+
+func NewAccessLogService(db *pgxpool.Pool) *AccessLogService {
+	return &AccessLogService{
+		db: db,
+	}
+}
+
+// RecordAccess appends one access entry per document reachable from itemIDs.
+// Folders are expanded recursively so zip downloads log every file they contain.
+func (s *AccessLogService) RecordAccess(itemIDs []string, action models.AccessAction, actx AccessContext) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+
+	sql := `
+		WITH RECURSIVE items AS (
+			SELECT id FROM folder_file_info WHERE id = ANY($1::uuid[])
+			UNION ALL
+			SELECT fi.id FROM folder_file_info fi
+			INNER JOIN items i ON fi.parent_id = i.id
+		)
+		INSERT INTO record_access_log
+		(item_id, item_name, owner_id, accessed_by_id, accessed_by_type, action, access_path, ip_address, user_agent, created_at)
+		SELECT f.id, f.name, COALESCE(f.patient_id, f.shared_by_id, f.user_id), $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8
+		FROM folder_file_info f
+		WHERE f.id IN (SELECT id FROM items) AND f.type <> 'folder'
+	`
+
+	_, err := s.db.Exec(context.Background(), sql,
+		itemIDs,
+		actx.UserID,
+		actx.UserType,
+		string(action),
+		actx.AccessPath,
+		actx.IPAddress,
+		actx.UserAgent,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record access: %v", err)
+	}
+
+	return nil
+}
+
+// GetAccessReportForOwner lists who accessed the documents owned by ownerID.
+func (s *AccessLogService) GetAccessReportForOwner(ownerID string, includeSelf bool, limit, offset int) ([]models.RecordAccessEntry, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	sql := `
+		SELECT
+			l.id, l.item_id, l.item_name, l.owner_id, l.accessed_by_id, l.accessed_by_type,
+			CASE
+				WHEN l.accessed_by_type = 'doctor' THEN
+					(SELECT CONCAT(first_name, ' ', last_name) FROM doctor_info WHERE doctor_id = l.accessed_by_id)
+				WHEN l.accessed_by_type = 'patient' THEN
+					(SELECT CONCAT(first_name, ' ', last_name) FROM patient_info WHERE patient_id = l.accessed_by_id)
+				WHEN l.accessed_by_type = 'receptionist' THEN
+					(SELECT CONCAT(first_name, ' ', last_name) FROM receptionists WHERE receptionist_id = l.accessed_by_id)
+				ELSE 'Unknown User'
+			END as accessed_by_name,
+			l.action, l.access_path, l.created_at
+		FROM record_access_log l
+		WHERE l.owner_id = $1 AND ($2 OR l.accessed_by_id <> $1)
+		ORDER BY l.created_at DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := s.db.Query(context.Background(), sql, ownerID, includeSelf, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve access report: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []models.RecordAccessEntry{}
+	for rows.Next() {
+		var entry models.RecordAccessEntry
+		var accessedByName *string
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ItemID,
+			&entry.ItemName,
+			&entry.OwnerID,
+			&entry.AccessedByID,
+			&entry.AccessedByType,
+			&accessedByName,
+			&entry.Action,
+			&entry.AccessPath,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan access entry: %v", err)
+		}
+		if accessedByName != nil {
+			entry.AccessedByName = *accessedByName
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read access report: %v", err)
+	}
+
+	return entries, nil
+}
+
+// GetAccessTrail returns every access performed by accessorID in [from, to).
+// Zero times leave the corresponding bound open.
+func (s *AccessLogService) GetAccessTrail(accessorID string, from, to time.Time) ([]models.RecordAccessEntry, error) {
+	sql := `
+		SELECT
+			l.id, l.item_id, l.item_name, l.owner_id,
+			COALESCE(
+				(SELECT CONCAT(first_name, ' ', last_name) FROM patient_info WHERE patient_id = l.owner_id),
+				(SELECT CONCAT(first_name, ' ', last_name) FROM doctor_info WHERE doctor_id = l.owner_id),
+				(SELECT CONCAT(first_name, ' ', last_name) FROM receptionists WHERE receptionist_id = l.owner_id),
+				'Unknown User'
+			) as owner_name,
+			l.accessed_by_id, l.accessed_by_type, l.action, l.access_path,
+			l.ip_address, l.user_agent, l.created_at
+		FROM record_access_log l
+		WHERE l.accessed_by_id = $1
+	`
+	args := []interface{}{accessorID}
+
+	if !from.IsZero() {
+		args = append(args, from)
+		sql += fmt.Sprintf(" AND l.created_at >= $%d", len(args))
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		sql += fmt.Sprintf(" AND l.created_at < $%d", len(args))
+	}
+	sql += " ORDER BY l.created_at ASC"
+
+	rows, err := s.db.Query(context.Background(), sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve access trail: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []models.RecordAccessEntry{}
+	for rows.Next() {
+		var entry models.RecordAccessEntry
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ItemID,
+			&entry.ItemName,
+			&entry.OwnerID,
+			&entry.OwnerName,
+			&entry.AccessedByID,
+			&entry.AccessedByType,
+			&entry.Action,
+			&entry.AccessPath,
+			&entry.IPAddress,
+			&entry.UserAgent,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan access entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read access trail: %v", err)
+	}
+
+	return entries, nil
+}
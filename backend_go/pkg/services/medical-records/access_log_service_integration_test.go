@@ -0,0 +1,132 @@
+package medicalrecords
+
+import (
+	"context"
+	"testing"
+
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/testhelpers"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupRecordsIntegrationTest connects to a freshly migrated local test
+// database, or skips the test when there is none.
+func setupRecordsIntegrationTest(t *testing.T) (*pgxpool.Pool, context.Context) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	ctx := context.Background()
+
+	testDB, err := testhelpers.SetupMigratedTestDatabase(ctx)
+	if err != nil {
+		t.Skipf("Test database unavailable: %v", err)
+	}
+	t.Cleanup(func() { testDB.Cleanup(ctx) })
+	return testDB.Pool, ctx
+}
+
+// insertTestPatient adds a patient, which clinical records point to.
+func insertTestPatient(t *testing.T, ctx context.Context, db *pgxpool.Pool) string {
+	t.Helper()
+	username := "patient-" + uuid.NewString()[:8]
+	var patientID string
+	err := db.QueryRow(ctx,
+		`INSERT INTO patient_info (username, first_name, last_name, age, sex, hashed_password, salt, email,
+			phone_number, street_address, city_name, state_name, zip_code, country_name, birth_date, location, profile_photo_url)
+		VALUES ($1, 'Test', 'Patient', 30, 'Female', 'x', 'x', $2,
+			'+212600000001', '1 Test Street', 'Rabat', 'Rabat-Sale-Kenitra', '10000', 'Morocco', '1995-05-15', 'Rabat', '')
+		RETURNING patient_id::text`, username, username+"@example.com").Scan(&patientID)
+	require.NoError(t, err)
+	return patientID
+}
+
+type testItem struct {
+	name       string
+	itemType   string
+	userID     string
+	parentID   *string
+	sharedByID *string
+	patientID  *string
+}
+
+func insertTestItem(t *testing.T, ctx context.Context, db *pgxpool.Pool, item testItem) string {
+	t.Helper()
+	var id string
+	err := db.QueryRow(ctx,
+		`INSERT INTO folder_file_info (name, type, size, user_id, user_type, parent_id, shared_by_id, patient_id)
+		VALUES ($1, $2, 0, $3, 'patient', $4, $5, $6) RETURNING id::text`,
+		item.name, item.itemType, item.userID, item.parentID, item.sharedByID, item.patientID).Scan(&id)
+	require.NoError(t, err)
+	return id
+}
+
+func accessOwners(t *testing.T, ctx context.Context, db *pgxpool.Pool, accessorID string) map[string]string {
+	t.Helper()
+	rows, err := db.Query(ctx,
+		"SELECT item_name, owner_id::text, action FROM record_access_log WHERE accessed_by_id = $1", accessorID)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	owners := map[string]string{}
+	for rows.Next() {
+		var name, owner, action string
+		require.NoError(t, rows.Scan(&name, &owner, &action))
+		owners[name+":"+action] = owner
+	}
+	require.NoError(t, rows.Err())
+	return owners
+}
+
+func TestRecordAccessLogsViewsAndDownloads_Integration(t *testing.T) {
+	db, ctx := setupRecordsIntegrationTest(t)
+	service := NewAccessLogService(db)
+
+	patientID, doctorID, accessorID := insertTestPatient(t, ctx, db), uuid.NewString(), uuid.NewString()
+	folderID := insertTestItem(t, ctx, db, testItem{name: "scans", itemType: "folder", userID: patientID})
+	personalID := insertTestItem(t, ctx, db, testItem{name: "notes.pdf", itemType: "file", userID: patientID, parentID: &folderID})
+	nestedID := insertTestItem(t, ctx, db, testItem{name: "2024", itemType: "folder", userID: patientID, parentID: &folderID})
+	insertTestItem(t, ctx, db, testItem{name: "ct.dcm", itemType: "file", userID: patientID, parentID: &nestedID})
+	sharedID := insertTestItem(t, ctx, db, testItem{name: "shared.pdf", itemType: "file", userID: accessorID, sharedByID: &patientID})
+	clinicalID := insertTestItem(t, ctx, db, testItem{name: "report.pdf", itemType: "file", userID: patientID, sharedByID: &doctorID, patientID: &patientID})
+
+	actx := AccessContext{UserID: accessorID, UserType: "doctor", AccessPath: models.AccessPathFileDownload}
+	require.NoError(t, service.RecordAccess([]string{personalID, sharedID, clinicalID}, models.AccessActionView, actx))
+	actx.AccessPath = models.AccessPathFolderZip
+	require.NoError(t, service.RecordAccess([]string{folderID}, models.AccessActionDownload, actx))
+
+	assert.Equal(t, map[string]string{
+		"notes.pdf:view":     patientID,
+		"shared.pdf:view":    patientID,
+		"report.pdf:view":    patientID,
+		"notes.pdf:download": patientID,
+		"ct.dcm:download":    patientID,
+	}, accessOwners(t, ctx, db, accessorID), "folders are expanded to their files and never logged themselves")
+
+	report, err := service.GetAccessReportForOwner(patientID, false, 0, 0)
+	require.NoError(t, err)
+	assert.Len(t, report, 5)
+}
+
+func TestRecordAccessLogIsAppendOnly_Integration(t *testing.T) {
+	db, ctx := setupRecordsIntegrationTest(t)
+	service := NewAccessLogService(db)
+
+	patientID, accessorID := uuid.NewString(), uuid.NewString()
+	fileID := insertTestItem(t, ctx, db, testItem{name: "labs.pdf", itemType: "file", userID: patientID})
+	require.NoError(t, service.RecordAccess([]string{fileID}, models.AccessActionView,
+		AccessContext{UserID: accessorID, UserType: "doctor", AccessPath: models.AccessPathSearch}))
+
+	_, err := db.Exec(ctx, "UPDATE record_access_log SET action = 'download' WHERE accessed_by_id = $1", accessorID)
+	assert.ErrorContains(t, err, "append-only")
+	_, err = db.Exec(ctx, "DELETE FROM record_access_log WHERE accessed_by_id = $1", accessorID)
+	assert.ErrorContains(t, err, "append-only")
+	_, err = db.Exec(ctx, "TRUNCATE record_access_log")
+	assert.ErrorContains(t, err, "append-only")
+
+	assert.Equal(t, map[string]string{"labs.pdf:view": patientID}, accessOwners(t, ctx, db, accessorID))
+}
@@ -0,0 +1,304 @@
+package medicalrecords
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ErrNotClinical is returned when consent is set on a document that is
+// never sent to the AI assistant.
+var ErrNotClinical = errors.New("only clinical documents are used by the AI assistant")
+
+// AIConsentService manages whether a patient's clinical documents may be
+// indexed for the AI assistant. Patients consent by default; they can opt
+// out entirely or per document. Every change is written to the append-only
+// ai_consent_audit table and re-queues the affected ingestion jobs, so
+// withdrawn documents are removed from the index.
+type AIConsentService struct {
+	db *pgxpool.Pool
+}
+
+func NewAIConsentService(db *pgxpool.Pool) *AIConsentService {
+	return &AIConsentService{db: db}
+}
+
+// GetConsent returns the patient's settings and the documents they excluded.
+func (s *AIConsentService) GetConsent(patientID string) (*models.AIConsentSettings, error) {
+	settings := &models.AIConsentSettings{RAGEnabled: true, ExcludedDocuments: []models.AIConsentDocument{}}
+	var updatedAt time.Time
+	err := s.db.QueryRow(context.Background(),
+		"SELECT rag_enabled, updated_at FROM patient_ai_consent WHERE patient_id = $1",
+		patientID).Scan(&settings.RAGEnabled, &updatedAt)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("could not get AI consent: %v", err)
+	}
+	if err == nil {
+		settings.UpdatedAt = &updatedAt
+	}
+
+	rows, err := s.db.Query(context.Background(),
+		`SELECT id::text, name, category FROM folder_file_info
+		WHERE patient_id = $1 AND folder_type = $2 AND type <> 'folder' AND NOT ai_consent
+		ORDER BY name`,
+		patientID, models.FolderTypeClinical)
+	if err != nil {
+		return nil, fmt.Errorf("could not list excluded documents: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var doc models.AIConsentDocument
+		if err := rows.Scan(&doc.FileID, &doc.Name, &doc.Category); err != nil {
+			return nil, fmt.Errorf("could not read excluded document: %v", err)
+		}
+		settings.ExcludedDocuments = append(settings.ExcludedDocuments, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list excluded documents: %v", err)
+	}
+	return settings, nil
+}
+
+// SetPatientConsent turns AI use of all the patient's documents on or off.
+// Turning it back on restores documents the patient has not excluded
+// individually.
+func (s *AIConsentService) SetPatientConsent(patientID string, enabled bool, actor AccessContext) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The row lock serialises concurrent changes so the audit trail records
+	// each transition once.
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO patient_ai_consent (patient_id) VALUES ($1) ON CONFLICT (patient_id) DO NOTHING",
+		patientID); err != nil {
+		return fmt.Errorf("could not save AI consent: %v", err)
+	}
+	var current bool
+	if err := tx.QueryRow(ctx,
+		"SELECT rag_enabled FROM patient_ai_consent WHERE patient_id = $1 FOR UPDATE",
+		patientID).Scan(&current); err != nil {
+		return fmt.Errorf("could not get AI consent: %v", err)
+	}
+	if current == enabled {
+		return tx.Commit(ctx)
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE patient_ai_consent SET rag_enabled = $1, updated_at = NOW() WHERE patient_id = $2",
+		enabled, patientID); err != nil {
+		return fmt.Errorf("could not save AI consent: %v", err)
+	}
+	if err := addConsentAudit(ctx, tx, patientID, nil, current, enabled, actor); err != nil {
+		return err
+	}
+	if enabled {
+		err = requeueIngestion(ctx, tx, patientID, "")
+	} else {
+		err = requeueRemoval(ctx, tx, patientID, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+// SetDocumentConsent includes or excludes one of the patient's clinical
+// documents. Including a document has no effect on the index while the
+// patient-wide setting is off.
+func (s *AIConsentService) SetDocumentConsent(patientID, fileID string, enabled bool, actor AccessContext) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var name, itemType, folderType string
+	var current bool
+	err = tx.QueryRow(ctx,
+		`SELECT name, type, folder_type, ai_consent FROM folder_file_info
+		WHERE id = $1 AND patient_id = $2 FOR UPDATE`,
+		fileID, patientID).Scan(&name, &itemType, &folderType, &current)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrItemNotFound
+		}
+		return fmt.Errorf("could not get document: %v", err)
+	}
+	if itemType == "folder" || models.FolderType(folderType) != models.FolderTypeClinical {
+		return ErrNotClinical
+	}
+	if current == enabled {
+		return tx.Commit(ctx)
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE folder_file_info SET ai_consent = $1, updated_at = NOW() WHERE id = $2",
+		enabled, fileID); err != nil {
+		return fmt.Errorf("could not save document consent: %v", err)
+	}
+	if err := addConsentAudit(ctx, tx, patientID, &consentDocument{id: fileID, name: name}, current, enabled, actor); err != nil {
+		return err
+	}
+	if enabled {
+		err = requeueIngestion(ctx, tx, patientID, fileID)
+	} else {
+		err = requeueRemoval(ctx, tx, patientID, fileID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+// GetAuditTrail lists the patient's consent changes, newest first.
+func (s *AIConsentService) GetAuditTrail(patientID string, limit, offset int) ([]models.AIConsentChange, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, err := s.db.Query(context.Background(),
+		`SELECT id::text, patient_id::text, file_id::text, file_name, old_value, new_value,
+			changed_by_id::text, changed_by_type, ip_address, created_at
+		FROM ai_consent_audit
+		WHERE patient_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3`,
+		patientID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("could not get consent audit trail: %v", err)
+	}
+	defer rows.Close()
+
+	changes := []models.AIConsentChange{}
+	for rows.Next() {
+		var change models.AIConsentChange
+		if err := rows.Scan(&change.ID, &change.PatientID, &change.FileID, &change.FileName, &change.OldValue, &change.NewValue,
+			&change.ChangedByID, &change.ChangedByType, &change.IPAddress, &change.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not read consent change: %v", err)
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get consent audit trail: %v", err)
+	}
+	return changes, nil
+}
+
+// CheckIngestionConsent implements ConsentChecker.
+func (s *AIConsentService) CheckIngestionConsent(ctx context.Context, patientID string, s3Keys []string) error {
+	keys := make(map[string]bool, len(s3Keys))
+	for _, key := range s3Keys {
+		keys[key] = true
+	}
+
+	var allowed int
+	err := s.db.QueryRow(ctx,
+		`SELECT COUNT(DISTINCT f.path)
+		FROM folder_file_info f
+		LEFT JOIN patient_ai_consent c ON c.patient_id = f.patient_id
+		WHERE f.patient_id::text = $1 AND f.path = ANY($2) AND f.folder_type = $3
+			AND f.ai_consent AND COALESCE(c.rag_enabled, true)`,
+		patientID, s3Keys, models.FolderTypeClinical).Scan(&allowed)
+	if err != nil {
+		return fmt.Errorf("could not check AI consent: %v", err)
+	}
+	if allowed != len(keys) {
+		return ErrNoAIConsent
+	}
+	return nil
+}
+
+type consentDocument struct {
+	id   string
+	name string
+}
+
+func addConsentAudit(ctx context.Context, tx pgx.Tx, patientID string, doc *consentDocument, oldValue, newValue bool, actor AccessContext) error {
+	var fileID, fileName *string
+	if doc != nil {
+		fileID, fileName = &doc.id, &doc.name
+	}
+	_, err := tx.Exec(ctx,
+		`INSERT INTO ai_consent_audit
+		(patient_id, file_id, file_name, old_value, new_value, changed_by_id, changed_by_type, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''))`,
+		patientID, fileID, fileName, oldValue, newValue, actor.UserID, actor.UserType, actor.IPAddress, actor.UserAgent)
+	if err != nil {
+		return fmt.Errorf("could not record consent change: %v", err)
+	}
+	return nil
+}
+
+// requeueRemoval schedules the removal of the patient's documents, or of
+// fileID alone, from the index. Indexed documents get a removal job even if
+// their ingestion job is gone, and the jobs of documents not indexed yet are
+// turned into removals. Documents already withdrawn are skipped.
+func requeueRemoval(ctx context.Context, tx pgx.Tx, patientID, fileID string) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO rag_ingestion_jobs (file_id, patient_id, doctor_id, operation, status)
+		SELECT f.id, f.patient_id::text, COALESCE(r.assigned_doctor_id, f.uploaded_by_user_id)::text, $1, $2
+		FROM folder_file_info f
+		LEFT JOIN receptionists r ON f.uploaded_by_role = 'receptionist' AND r.receptionist_id = f.uploaded_by_user_id
+		WHERE f.patient_id::text = $3 AND ($4 = '' OR f.id::text = $4) AND f.included_in_rag
+			AND COALESCE(r.assigned_doctor_id, f.uploaded_by_user_id) IS NOT NULL
+		ON CONFLICT (file_id) DO UPDATE
+		SET operation = EXCLUDED.operation, status = EXCLUDED.status, attempts = 0, last_error = NULL,
+			next_attempt_at = NOW(), updated_at = NOW()`,
+		string(models.IngestionOperationRemove), string(models.IngestionPending), patientID, fileID)
+	if err != nil {
+		return fmt.Errorf("could not queue removal from the AI index: %v", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE rag_ingestion_jobs
+		SET operation = $1, status = $2, attempts = 0, last_error = NULL, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE patient_id = $3 AND ($4 = '' OR file_id::text = $4)
+			AND NOT (operation = $1 AND status IN ($2, $5))`,
+		string(models.IngestionOperationRemove), string(models.IngestionPending), patientID, fileID, string(models.IngestionWithdrawn))
+	if err != nil {
+		return fmt.Errorf("could not queue removal from the AI index: %v", err)
+	}
+	return nil
+}
+
+// requeueIngestion schedules the ingestion of withdrawn documents the
+// patient consents to again, or of fileID alone.
+func requeueIngestion(ctx context.Context, tx pgx.Tx, patientID, fileID string) error {
+	_, err := tx.Exec(ctx,
+		`UPDATE rag_ingestion_jobs j
+		SET operation = $1, status = $2, attempts = 0, last_error = NULL, next_attempt_at = NOW(),
+			succeeded_at = NULL, updated_at = NOW()
+		FROM folder_file_info f
+		LEFT JOIN patient_ai_consent c ON c.patient_id = f.patient_id
+		WHERE f.id = j.file_id AND j.patient_id = $3 AND ($4 = '' OR j.file_id::text = $4)
+			AND (j.operation = $5 OR j.status = $6)
+			AND f.ai_consent AND COALESCE(c.rag_enabled, true)`,
+		string(models.IngestionOperationIngest), string(models.IngestionPending), patientID, fileID,
+		string(models.IngestionOperationRemove), string(models.IngestionWithdrawn))
+	if err != nil {
+		return fmt.Errorf("could not queue ingestion: %v", err)
+	}
+	return nil
+}
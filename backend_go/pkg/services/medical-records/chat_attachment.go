@@ -0,0 +1,156 @@
+package medicalrecords
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"healthcare_backend/pkg/filecheck"
+	"healthcare_backend/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// ErrChatAttachmentNotFound is returned when a chat attachment does not
+// exist, was deleted with its message, or was not sent in a chat that both
+// the caller and the patient take part in.
+var ErrChatAttachmentNotFound = errors.New("chat attachment not found")
+
+type chatAttachment struct {
+	key         string
+	name        string
+	contentType string
+	size        int64
+}
+
+// loadChatAttachment returns an attachment sent in a message, not since
+// deleted or hidden by the caller, of a chat both users take part in.
+func (s *MedicalRecordsService) loadChatAttachment(attachmentID, callerID, patientID string) (*chatAttachment, error) {
+	var a chatAttachment
+	err := s.db.QueryRow(context.Background(),
+		`SELECT a.storage_key, a.file_name, a.content_type, a.size
+		FROM chat_attachments a
+		JOIN messages m ON m.attachment_id = a.id AND m.deleted_at IS NULL
+		WHERE a.id::text = $1 AND a.deleted_at IS NULL
+			AND EXISTS (SELECT 1 FROM participants p WHERE p.chat_id = a.chat_id AND p.user_id::text = $2)
+			AND EXISTS (SELECT 1 FROM participants p WHERE p.chat_id = a.chat_id AND p.user_id::text = $3)
+			AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id::text = $2)`,
+		attachmentID, callerID, patientID).Scan(&a.key, &a.name, &a.contentType, &a.size)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrChatAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat attachment: %v", err)
+	}
+	return &a, nil
+}
+
+// SaveChatAttachment copies a file sent in a chat into the records of the
+// patient fileInfo.UserID. The caller, fileInfo.UploadedByUserID, must have
+// been in the chat with the patient. Clinical documents from the care team
+// go to folderName in the patient's medical records and are shared with
+// them like other clinical uploads; a patient saving a file for themselves
+// gets it in their personal records, within their quota.
+func (s *MedicalRecordsService) SaveChatAttachment(attachmentID string, fileInfo *models.FileFolder, folderName string) error {
+	callerID := fileInfo.UserID
+	if fileInfo.UploadedByUserID != nil {
+		callerID = *fileInfo.UploadedByUserID
+	}
+	attachment, err := s.loadChatAttachment(attachmentID, callerID, fileInfo.UserID)
+	if err != nil {
+		return err
+	}
+
+	allowed := filecheck.AllowedForCategory(fileInfo.Category)
+	if !containsType(allowed, attachment.contentType) {
+		return &filecheck.Rejection{
+			Code:         filecheck.RejectTypeNotAllowed,
+			Reason:       fmt.Sprintf("%s files are not accepted here", attachment.contentType),
+			FileName:     attachment.name,
+			DetectedType: attachment.contentType,
+			AllowedTypes: allowed,
+		}
+	}
+
+	id, _ := uuid.NewRandom()
+	fileInfo.ID = id.String()
+	fileInfo.Name = attachment.name
+	fileInfo.Type = "file"
+	ext := strings.TrimPrefix(path.Ext(attachment.name), ".")
+	fileInfo.Ext = &ext
+	fileInfo.Size = attachment.size
+	fileInfo.CreatedAt = time.Now()
+	fileInfo.UpdatedAt = time.Now()
+
+	clinical := fileInfo.FolderType == models.FolderTypeClinical
+	if clinical {
+		fileInfo.Path = clinicalRecordPath(fileInfo.UserID, filecheck.SanitizeFilename(folderName), fileInfo.Name)
+	} else {
+		if err := s.quotaService.CheckQuota(fileInfo.UserID, fileInfo.UserType, attachment.size); err != nil {
+			return err
+		}
+		if err := s.setPersonalRecordPath(fileInfo); err != nil {
+			return err
+		}
+	}
+
+	// Copying between owners re-encrypts the file for the patient.
+	ctx := context.Background()
+	if err := s.storage.Copy(ctx, attachment.key, fileInfo.Path); err != nil {
+		return fmt.Errorf("failed to copy chat attachment: %v", err)
+	}
+	if err := s.registerChatAttachmentCopy(ctx, fileInfo, clinical); err != nil {
+		if deleteErr := s.deleteObject(fileInfo.Path); deleteErr != nil {
+			log.Printf("Warning: failed to remove unregistered copy %s: %v", fileInfo.Path, deleteErr)
+		}
+		return err
+	}
+	s.uploadRegistered(fileInfo)
+	return nil
+}
+
+// registerChatAttachmentCopy records the copied file and, for clinical
+// documents, its share with the patient, both or neither.
+func (s *MedicalRecordsService) registerChatAttachmentCopy(ctx context.Context, fileInfo *models.FileFolder, clinical bool) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if !clinical {
+		if err := s.quotaService.reserveQuota(ctx, tx, fileInfo.UserID, fileInfo.UserType, fileInfo.Size); err != nil {
+			return err
+		}
+	}
+	if err := insertUploadedFile(ctx, tx, fileInfo); err != nil {
+		return err
+	}
+	if clinical {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO shared_items (shared_by_id, shared_with_id, shared_at, item_id)
+			VALUES ($1, $2, $3, $4)`,
+			fileInfo.UploadedByUserID, fileInfo.PatientID, time.Now(), fileInfo.ID)
+		if err != nil {
+			return fmt.Errorf("could not insert shared item: %v", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+func containsType(types []string, contentType string) bool {
+	for _, t := range types {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,635 @@
+package medicalrecords
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/dicom"
+	"healthcare_backend/pkg/filecheck"
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	// S3 requires every part but the last to be at least 5 MiB and allows at
+	// most 10,000 parts per upload.
+	minChunkSize     int64 = 5 << 20
+	maxChunkSize     int64 = 100 << 20
+	defaultChunkSize int64 = 8 << 20
+	maxUploadChunks        = 10000
+	uploadExpiry           = 24 * time.Hour
+)
+
+var (
+	ErrUploadNotFound        = errors.New("upload not found")
+	ErrUploadClosed          = errors.New("upload is no longer in progress")
+	ErrInvalidUpload         = errors.New("invalid upload")
+	ErrInvalidChunk          = errors.New("invalid chunk")
+	ErrChunkChecksumMismatch = errors.New("chunk checksum mismatch")
+	ErrUploadIncomplete      = errors.New("upload is missing chunks")
+)
+
+type ChunkedUploadService struct {
+	db             *pgxpool.Pool
+	storage        storage.Storage
+	recordsService *MedicalRecordsService
+}
+
+func NewChunkedUploadService(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *ChunkedUploadService {
+	recordsService := NewMedicalRecordsService(db, cfg, store)
+	return &ChunkedUploadService{
+		db:             db,
+		storage:        recordsService.storage,
+		recordsService: recordsService,
+	}
+}
+
+// InitiateUpload validates the declared file and opens a multipart upload at
+// the storage key the finished file will live under. Clinical uploads are
+// made by the patient's care team and take their metadata from clinical,
+// which is resolved and validated like a direct clinical upload.
+func (s *ChunkedUploadService) InitiateUpload(upload *models.ChunkedUpload, clinical *ClinicalUploadForm) error {
+	s.abortExpiredUploads(upload.UserID)
+
+	if strings.TrimSpace(upload.FileName) == "" {
+		return fmt.Errorf("%w: file name is required", ErrInvalidUpload)
+	}
+	upload.FileName = filecheck.SanitizeFilename(upload.FileName)
+	if upload.TotalSize <= 0 {
+		return fmt.Errorf("%w: total size must be positive", ErrInvalidUpload)
+	}
+	if upload.ChunkSize == 0 {
+		upload.ChunkSize = defaultChunkSize
+	}
+	if upload.ChunkSize > maxChunkSize {
+		return fmt.Errorf("%w: chunk size cannot exceed %d bytes", ErrInvalidUpload, maxChunkSize)
+	}
+
+	totalChunks := (upload.TotalSize + upload.ChunkSize - 1) / upload.ChunkSize
+	if totalChunks > 1 && upload.ChunkSize < minChunkSize {
+		return fmt.Errorf("%w: chunk size must be at least %d bytes", ErrInvalidUpload, minChunkSize)
+	}
+	if totalChunks > maxUploadChunks {
+		return fmt.Errorf("%w: file needs more than %d chunks, use a larger chunk size", ErrInvalidUpload, maxUploadChunks)
+	}
+	upload.TotalChunks = int(totalChunks)
+
+	if upload.FolderType == "" {
+		upload.FolderType = models.FolderTypePersonal
+	}
+	if upload.FolderType != models.FolderTypePersonal && upload.FolderType != models.FolderTypeClinical {
+		return fmt.Errorf("%w: unknown folder type %s", ErrInvalidUpload, upload.FolderType)
+	}
+	if upload.PatientID != nil && *upload.PatientID == "" {
+		upload.PatientID = nil
+	}
+	folderName := ""
+	if upload.FolderType == models.FolderTypeClinical {
+		if upload.PatientID == nil || clinical == nil {
+			return fmt.Errorf("%w: clinical uploads need a patient", ErrInvalidUpload)
+		}
+		doctorID, err := s.recordsService.CareTeamDoctor(upload.UserID, upload.UserType, *upload.PatientID)
+		if err != nil {
+			return err
+		}
+		metadata, err := s.recordsService.ResolveClinicalUpload(doctorID, *clinical)
+		if err != nil {
+			return err
+		}
+		upload.Category = &metadata.Category
+		upload.BodyPart = metadata.BodyPart
+		upload.StudyDate = metadata.StudyDate
+		folderName = filecheck.SanitizeFilename(metadata.FolderName)
+	} else if upload.PatientID != nil && *upload.PatientID != upload.UserID {
+		if _, err := s.recordsService.CareTeamDoctor(upload.UserID, upload.UserType, *upload.PatientID); err != nil {
+			return err
+		}
+	}
+	if upload.Category != nil && upload.Category.GetDisplayName() == "Unknown" {
+		return fmt.Errorf("%w: unknown category %s", ErrInvalidUpload, *upload.Category)
+	}
+	if upload.BodyPart != nil && models.BodyPart(*upload.BodyPart).GetDisplayName() == "Unknown" {
+		return fmt.Errorf("%w: unknown body part %s", ErrInvalidUpload, *upload.BodyPart)
+	}
+	if upload.ContentType == "" {
+		upload.ContentType = "application/octet-stream"
+	}
+	// The type is checked on the first chunk and the content is scanned
+	// once assembled; the size can be refused before anything is sent.
+	if err := s.recordsService.validator.CheckSize(filecheck.Upload{
+		Name: upload.FileName,
+		Size: upload.TotalSize,
+		Role: upload.UserType,
+	}); err != nil {
+		return err
+	}
+
+	// In-progress uploads reserve their declared size, so the quota holds
+	// even when several large uploads run at once. The reservation is checked
+	// and saved in one transaction.
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := s.recordsService.quotaService.reserveQuota(ctx, tx, upload.UserID, upload.UserType, upload.TotalSize); err != nil {
+		return err
+	}
+
+	if upload.FolderType == models.FolderTypeClinical {
+		upload.StorageKey = clinicalRecordPath(*upload.PatientID, folderName, upload.FileName)
+	} else {
+		target := models.FileFolder{
+			Name:     upload.FileName,
+			UserID:   upload.UserID,
+			ParentID: upload.ParentID,
+		}
+		if err := s.recordsService.setPersonalRecordPath(&target); err != nil {
+			return err
+		}
+		upload.StorageKey = target.Path
+	}
+
+	storageUploadID, err := s.storage.CreateMultipart(ctx, upload.StorageKey, upload.ContentType)
+	if err != nil {
+		return fmt.Errorf("could not start upload: %v", err)
+	}
+	upload.StorageUploadID = storageUploadID
+
+	upload.ID = uuid.New().String()
+	upload.Status = models.ChunkedUploadInProgress
+	upload.CreatedAt = time.Now()
+	upload.UpdatedAt = upload.CreatedAt
+	upload.ExpiresAt = upload.CreatedAt.Add(uploadExpiry)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO chunked_uploads
+		(id, user_id, user_type, file_name, file_type, extension, content_type, total_size, chunk_size, total_chunks,
+		 storage_key, storage_upload_id, parent_id, folder_type, category, body_part, study_date, patient_id,
+		 status, created_at, updated_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)`,
+		upload.ID, upload.UserID, upload.UserType, upload.FileName, upload.FileType, upload.Ext, upload.ContentType,
+		upload.TotalSize, upload.ChunkSize, upload.TotalChunks, upload.StorageKey, upload.StorageUploadID,
+		upload.ParentID, upload.FolderType, upload.Category, upload.BodyPart, upload.StudyDate, upload.PatientID,
+		upload.Status, upload.CreatedAt, upload.UpdatedAt, upload.ExpiresAt)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		if abortErr := s.storage.AbortMultipart(ctx, upload.StorageKey, upload.StorageUploadID); abortErr != nil {
+			log.Printf("Warning: failed to abort multipart upload after insert error: %v", abortErr)
+		}
+		return fmt.Errorf("could not save upload: %v", err)
+	}
+
+	upload.ReceivedChunks = []int{}
+	upload.ReceivedRanges = []models.ByteRange{}
+	upload.MissingChunks = missingChunks(nil, upload.TotalChunks)
+
+	return nil
+}
+
+// UploadChunk stores chunk number (1-based) of an upload. The chunk is
+// spooled and checked against expectedSHA256 before it reaches storage, so a
+// corrupted re-send never replaces a good part. The first chunk must also
+// have a type allowed for the upload's category.
+func (s *ChunkedUploadService) UploadChunk(uploadID, userID string, number int, content io.Reader, expectedSHA256 string) (*models.UploadChunk, error) {
+	upload, err := s.loadUpload(uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUploadOpen(upload); err != nil {
+		return nil, err
+	}
+	if number < 1 || number > upload.TotalChunks {
+		return nil, fmt.Errorf("%w: chunk number must be between 1 and %d", ErrInvalidChunk, upload.TotalChunks)
+	}
+
+	spool, err := os.CreateTemp("", "upload-chunk-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size := expectedChunkSize(upload, number)
+	hasher := sha256.New()
+	received, err := io.Copy(io.MultiWriter(spool, hasher), io.LimitReader(content, size))
+	if err != nil {
+		return nil, fmt.Errorf("could not receive chunk %d: %v", number, err)
+	}
+	if received != size {
+		return nil, fmt.Errorf("%w: chunk %d has %d bytes, expected %d", ErrInvalidChunk, number, received, size)
+	}
+	if extra, _ := io.ReadFull(content, make([]byte, 1)); extra > 0 {
+		return nil, fmt.Errorf("%w: chunk %d is larger than %d bytes", ErrInvalidChunk, number, size)
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if !strings.EqualFold(checksum, expectedSHA256) {
+		return nil, fmt.Errorf("%w: chunk %d has sha256 %s", ErrChunkChecksumMismatch, number, checksum)
+	}
+
+	if number == 1 {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("could not rewind chunk %d: %v", number, err)
+		}
+		detected, err := s.recordsService.validator.CheckType(filecheck.Upload{
+			Name:    upload.FileName,
+			Allowed: filecheck.AllowedForCategory(upload.Category),
+			Content: spool,
+		})
+		if err != nil {
+			return nil, err
+		}
+		upload.ContentType = detected
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not rewind chunk %d: %v", number, err)
+	}
+	etag, err := s.storage.UploadPart(context.Background(), upload.StorageKey, upload.StorageUploadID, number, spool, size)
+	if err != nil {
+		return nil, fmt.Errorf("could not store chunk %d: %v", number, err)
+	}
+
+	chunk := &models.UploadChunk{
+		UploadID:   upload.ID,
+		Number:     number,
+		Size:       size,
+		SHA256:     checksum,
+		ETag:       etag,
+		ReceivedAt: time.Now(),
+	}
+
+	_, err = s.db.Exec(context.Background(),
+		`INSERT INTO chunked_upload_parts (upload_id, chunk_number, size, sha256, etag, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (upload_id, chunk_number)
+		DO UPDATE SET size = EXCLUDED.size, sha256 = EXCLUDED.sha256, etag = EXCLUDED.etag, received_at = EXCLUDED.received_at`,
+		chunk.UploadID, chunk.Number, chunk.Size, chunk.SHA256, chunk.ETag, chunk.ReceivedAt)
+	if err != nil {
+		return nil, fmt.Errorf("could not record chunk %d: %v", number, err)
+	}
+
+	// The type detected from the first chunk replaces the declared one.
+	_, err = s.db.Exec(context.Background(),
+		"UPDATE chunked_uploads SET content_type = $1, updated_at = $2 WHERE id = $3", upload.ContentType, time.Now(), upload.ID)
+	if err != nil {
+		log.Printf("Warning: failed to touch upload %s: %v", upload.ID, err)
+	}
+
+	return chunk, nil
+}
+
+// GetUpload returns the upload with the chunks and byte ranges received so far.
+func (s *ChunkedUploadService) GetUpload(uploadID, userID string) (*models.ChunkedUpload, error) {
+	upload, err := s.loadUpload(uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := s.loadParts(upload.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	received := make([]int, 0, len(parts))
+	for _, part := range parts {
+		received = append(received, part.Number)
+	}
+	upload.ReceivedChunks = received
+	upload.ReceivedRanges = receivedRanges(received, upload.ChunkSize, upload.TotalSize)
+	upload.MissingChunks = missingChunks(received, upload.TotalChunks)
+
+	return upload, nil
+}
+
+// CompleteUpload assembles the received chunks and registers the result as a
+// regular medical record file. The file row and the upload's completion are
+// committed together, and completing an upload again returns the file it
+// produced, so a client can retry after any failure.
+func (s *ChunkedUploadService) CompleteUpload(uploadID, userID string) (*models.FileFolder, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status models.ChunkedUploadStatus
+	var fileID *string
+	err = tx.QueryRow(ctx,
+		"SELECT status, file_id FROM chunked_uploads WHERE id = $1 AND user_id = $2 FOR UPDATE", uploadID, userID).Scan(&status, &fileID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("could not lock upload: %v", err)
+	}
+	if status == models.ChunkedUploadCompleted && fileID != nil {
+		return s.recordsService.lockItem(ctx, tx, *fileID)
+	}
+
+	upload, err := s.GetUpload(uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUploadOpen(upload); err != nil {
+		return nil, err
+	}
+	if len(upload.MissingChunks) > 0 {
+		return nil, fmt.Errorf("%w: %d of %d chunks missing", ErrUploadIncomplete, len(upload.MissingChunks), upload.TotalChunks)
+	}
+
+	parts, err := s.loadParts(upload.ID)
+	if err != nil {
+		return nil, err
+	}
+	storageParts := make([]storage.Part, 0, len(parts))
+	for _, part := range parts {
+		storageParts = append(storageParts, storage.Part{Number: part.Number, ETag: part.ETag})
+	}
+	if err := s.assemble(ctx, upload, storageParts); err != nil {
+		return nil, err
+	}
+	if err := s.scanAssembled(ctx, upload); err != nil {
+		var rejection *filecheck.Rejection
+		if errors.As(err, &rejection) {
+			s.discardAssembled(ctx, tx, upload)
+		}
+		return nil, err
+	}
+
+	uploadedByUserID := upload.UserID
+	uploadedByRole := upload.UserType
+	fileInfo := &models.FileFolder{
+		ID:               uuid.New().String(),
+		Name:             upload.FileName,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		Type:             upload.FileType,
+		Size:             upload.TotalSize,
+		Ext:              upload.Ext,
+		UserID:           upload.UserID,
+		UserType:         upload.UserType,
+		ParentID:         upload.ParentID,
+		Path:             upload.StorageKey,
+		FolderType:       upload.FolderType,
+		Category:         upload.Category,
+		BodyPart:         upload.BodyPart,
+		StudyDate:        upload.StudyDate,
+		PatientID:        upload.PatientID,
+		UploadedByUserID: &uploadedByUserID,
+		UploadedByRole:   &uploadedByRole,
+	}
+	if isDicomUpload(fileInfo.Ext, upload.ContentType) {
+		s.applyAssembledDicomMetadata(ctx, fileInfo)
+	}
+	if err := insertUploadedFile(ctx, tx, fileInfo); err != nil {
+		// Metadata is fixed when the upload starts, so a retry cannot fix it.
+		var metadataErr *MetadataError
+		if errors.As(err, &metadataErr) {
+			s.discardAssembled(ctx, tx, upload)
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE chunked_uploads SET status = $1, file_id = $2, updated_at = $3 WHERE id = $4",
+		models.ChunkedUploadCompleted, fileInfo.ID, time.Now(), upload.ID)
+	if err != nil {
+		return nil, fmt.Errorf("could not mark upload completed: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	s.recordsService.uploadRegistered(fileInfo)
+
+	return fileInfo, nil
+}
+
+// assemble completes the multipart upload. An earlier attempt may have
+// assembled it and then failed to commit, in which case the storage upload
+// is already closed and the object it produced is used as is.
+func (s *ChunkedUploadService) assemble(ctx context.Context, upload *models.ChunkedUpload, parts []storage.Part) error {
+	err := s.storage.CompleteMultipart(ctx, upload.StorageKey, upload.StorageUploadID, parts)
+	if err == nil {
+		return nil
+	}
+	object, getErr := s.storage.Get(ctx, upload.StorageKey)
+	if getErr != nil {
+		return fmt.Errorf("could not assemble upload: %v", err)
+	}
+	object.Close()
+	log.Printf("Upload %s was already assembled, registering the stored object", upload.ID)
+	return nil
+}
+
+// scanAssembled runs the malware scanner over the whole assembled object.
+func (s *ChunkedUploadService) scanAssembled(ctx context.Context, upload *models.ChunkedUpload) error {
+	object, err := s.storage.Get(ctx, upload.StorageKey)
+	if err != nil {
+		return fmt.Errorf("could not read assembled upload: %v", err)
+	}
+	defer object.Close()
+	return s.recordsService.validator.Scan(ctx, upload.FileName, object)
+}
+
+// applyAssembledDicomMetadata fills record fields from the DICOM header at
+// the start of the assembled object, as UploadFile does for single uploads.
+func (s *ChunkedUploadService) applyAssembledDicomMetadata(ctx context.Context, fileInfo *models.FileFolder) {
+	object, err := s.storage.Get(ctx, fileInfo.Path)
+	if err != nil {
+		log.Printf("Warning: failed to read DICOM header of %s: %v", fileInfo.Path, err)
+		return
+	}
+	defer object.Close()
+	if parsed, err := dicom.Parse(io.LimitReader(object, dicomHeaderPeekLimit)); err == nil {
+		applyDicomMetadata(fileInfo, parsed)
+	}
+}
+
+// discardAssembled closes an upload whose assembled content was rejected and
+// deletes the object.
+func (s *ChunkedUploadService) discardAssembled(ctx context.Context, tx pgx.Tx, upload *models.ChunkedUpload) {
+	_, err := tx.Exec(ctx,
+		"UPDATE chunked_uploads SET status = $1, updated_at = $2 WHERE id = $3",
+		models.ChunkedUploadAborted, time.Now(), upload.ID)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to close rejected upload %s: %v", upload.ID, err)
+	}
+	if err := s.storage.Delete(ctx, upload.StorageKey); err != nil {
+		log.Printf("Warning: failed to delete rejected upload %s: %v", upload.StorageKey, err)
+	}
+}
+
+func (s *ChunkedUploadService) AbortUpload(uploadID, userID string) error {
+	upload, err := s.loadUpload(uploadID, userID)
+	if err != nil {
+		return err
+	}
+	if upload.Status != models.ChunkedUploadInProgress {
+		return ErrUploadClosed
+	}
+	return s.abort(upload)
+}
+
+func (s *ChunkedUploadService) abort(upload *models.ChunkedUpload) error {
+	if err := s.storage.AbortMultipart(context.Background(), upload.StorageKey, upload.StorageUploadID); err != nil {
+		return fmt.Errorf("could not abort upload: %v", err)
+	}
+
+	_, err := s.db.Exec(context.Background(),
+		"UPDATE chunked_uploads SET status = $1, updated_at = $2 WHERE id = $3",
+		models.ChunkedUploadAborted, time.Now(), upload.ID)
+	if err != nil {
+		return fmt.Errorf("could not mark upload aborted: %v", err)
+	}
+	return nil
+}
+
+// abortExpiredUploads releases storage held by the user's abandoned uploads.
+func (s *ChunkedUploadService) abortExpiredUploads(userID string) {
+	rows, err := s.db.Query(context.Background(),
+		"SELECT id FROM chunked_uploads WHERE user_id = $1 AND status = $2 AND expires_at < $3",
+		userID, models.ChunkedUploadInProgress, time.Now())
+	if err != nil {
+		log.Printf("Warning: failed to look up expired uploads: %v", err)
+		return
+	}
+
+	var expired []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Warning: failed to scan expired upload: %v", err)
+			continue
+		}
+		expired = append(expired, id)
+	}
+	rows.Close()
+
+	for _, id := range expired {
+		upload, err := s.loadUpload(id, userID)
+		if err != nil {
+			log.Printf("Warning: failed to load expired upload %s: %v", id, err)
+			continue
+		}
+		if err := s.abort(upload); err != nil {
+			log.Printf("Warning: failed to abort expired upload %s: %v", id, err)
+		}
+	}
+}
+
+func (s *ChunkedUploadService) loadUpload(uploadID, userID string) (*models.ChunkedUpload, error) {
+	var upload models.ChunkedUpload
+	err := s.db.QueryRow(context.Background(),
+		`SELECT id, user_id, user_type, file_name, file_type, extension, content_type, total_size, chunk_size, total_chunks,
+			storage_key, storage_upload_id, parent_id, folder_type, category, body_part, study_date, patient_id,
+			status, file_id, created_at, updated_at, expires_at
+		FROM chunked_uploads WHERE id = $1 AND user_id = $2`, uploadID, userID).Scan(
+		&upload.ID, &upload.UserID, &upload.UserType, &upload.FileName, &upload.FileType, &upload.Ext, &upload.ContentType,
+		&upload.TotalSize, &upload.ChunkSize, &upload.TotalChunks, &upload.StorageKey, &upload.StorageUploadID,
+		&upload.ParentID, &upload.FolderType, &upload.Category, &upload.BodyPart, &upload.StudyDate, &upload.PatientID,
+		&upload.Status, &upload.FileID, &upload.CreatedAt, &upload.UpdatedAt, &upload.ExpiresAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("could not retrieve upload: %v", err)
+	}
+	return &upload, nil
+}
+
+func (s *ChunkedUploadService) loadParts(uploadID string) ([]models.UploadChunk, error) {
+	rows, err := s.db.Query(context.Background(),
+		"SELECT upload_id, chunk_number, size, sha256, etag, received_at FROM chunked_upload_parts WHERE upload_id = $1 ORDER BY chunk_number",
+		uploadID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve chunks: %v", err)
+	}
+	defer rows.Close()
+
+	var parts []models.UploadChunk
+	for rows.Next() {
+		var part models.UploadChunk
+		if err := rows.Scan(&part.UploadID, &part.Number, &part.Size, &part.SHA256, &part.ETag, &part.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("could not scan chunk: %v", err)
+		}
+		parts = append(parts, part)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read chunks: %v", err)
+	}
+
+	return parts, nil
+}
+
+func checkUploadOpen(upload *models.ChunkedUpload) error {
+	if upload.Status != models.ChunkedUploadInProgress {
+		return ErrUploadClosed
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return fmt.Errorf("%w: upload expired at %s", ErrUploadClosed, upload.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func expectedChunkSize(upload *models.ChunkedUpload, number int) int64 {
+	if number < upload.TotalChunks {
+		return upload.ChunkSize
+	}
+	return upload.TotalSize - int64(upload.TotalChunks-1)*upload.ChunkSize
+}
+
+// receivedRanges merges sorted chunk numbers into inclusive byte ranges.
+func receivedRanges(chunks []int, chunkSize, totalSize int64) []models.ByteRange {
+	ranges := []models.ByteRange{}
+	for _, number := range chunks {
+		start := int64(number-1) * chunkSize
+		end := start + chunkSize - 1
+		if end >= totalSize {
+			end = totalSize - 1
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].End+1 == start {
+			ranges[n-1].End = end
+			continue
+		}
+		ranges = append(ranges, models.ByteRange{Start: start, End: end})
+	}
+	return ranges
+}
+
+func missingChunks(received []int, totalChunks int) []int {
+	have := make(map[int]bool, len(received))
+	for _, number := range received {
+		have[number] = true
+	}
+
+	missing := []int{}
+	for number := 1; number <= totalChunks; number++ {
+		if !have[number] {
+			missing = append(missing, number)
+		}
+	}
+	return missing
+}
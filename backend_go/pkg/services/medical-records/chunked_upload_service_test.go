@@ -0,0 +1,35 @@
+package medicalrecords
+
+import (
+	"testing"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpectedChunkSize(t *testing.T) {
+	upload := &models.ChunkedUpload{TotalSize: 25, ChunkSize: 10, TotalChunks: 3}
+
+	assert.Equal(t, int64(10), expectedChunkSize(upload, 1))
+	assert.Equal(t, int64(10), expectedChunkSize(upload, 2))
+	assert.Equal(t, int64(5), expectedChunkSize(upload, 3))
+}
+
+func TestReceivedRanges_MergesAdjacentChunks(t *testing.T) {
+	ranges := receivedRanges([]int{1, 2, 4, 5}, 10, 45)
+
+	assert.Equal(t, []models.ByteRange{
+		{Start: 0, End: 19},
+		{Start: 30, End: 44},
+	}, ranges)
+}
+
+func TestReceivedRanges_Empty(t *testing.T) {
+	assert.Equal(t, []models.ByteRange{}, receivedRanges(nil, 10, 45))
+}
+
+func TestMissingChunks(t *testing.T) {
+	assert.Equal(t, []int{2, 5}, missingChunks([]int{1, 3, 4}, 5))
+	assert.Equal(t, []int{}, missingChunks([]int{1, 2}, 2))
+}
@@ -0,0 +1,233 @@
+package medicalrecords
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const maxFolderNameLen = 100
+
+var (
+	ErrNoAssignedDoctor = errors.New("receptionist has no assigned doctor")
+	ErrNotCareTeam      = errors.New("caller is not on the patient's care team")
+)
+
+// MetadataError lists the fields of a request that failed validation.
+type MetadataError struct {
+	Fields []models.FieldError
+}
+
+func (e *MetadataError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + ": " + field.Message
+	}
+	return "invalid metadata: " + strings.Join(messages, "; ")
+}
+
+func (e *MetadataError) add(field, message string) {
+	e.Fields = append(e.Fields, models.FieldError{Field: field, Message: message})
+}
+
+// ClinicalUploadForm is the metadata sent with a clinical document, as
+// received. Lab results are dated by CollectionDate, other documents by
+// StudyDate.
+type ClinicalUploadForm struct {
+	TemplateID     string
+	Category       string
+	FolderName     string
+	BodyPart       string
+	StudyDate      string
+	CollectionDate string
+}
+
+// ClinicalUploadMetadata is validated upload metadata. StudyDate holds the
+// clinical date of the document: the study date of imaging and the
+// collection date of lab results.
+type ClinicalUploadMetadata struct {
+	Category   models.Category
+	FolderName string
+	BodyPart   *string
+	StudyDate  *time.Time
+}
+
+// RequiredUploadFields lists the metadata a clinical document of the category
+// must be uploaded with, beyond the category itself.
+func RequiredUploadFields(category models.Category) []string {
+	switch {
+	case category.IsImagingCategory():
+		return []string{"body_part", "study_date"}
+	case category == models.CategoryLabResults:
+		return []string{"collection_date"}
+	default:
+		return nil
+	}
+}
+
+// CareTeamDoctor returns the doctor a clinical document from the caller is
+// filed for: the caller, or the doctor a receptionist is assigned to. That
+// doctor must have had an appointment with the patient, which allows them to
+// add to the patient's records. Other callers get ErrNotCareTeam.
+func (s *MedicalRecordsService) CareTeamDoctor(callerID, callerType, patientID string) (string, error) {
+	doctorID := callerID
+	switch callerType {
+	case "doctor":
+	case "receptionist":
+		var assignedDoctorID sql.NullString
+		err := s.db.QueryRow(context.Background(), "SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1", callerID).Scan(&assignedDoctorID)
+		if err != nil {
+			return "", fmt.Errorf("could not verify receptionist assignment: %v", err)
+		}
+		if !assignedDoctorID.Valid {
+			return "", ErrNoAssignedDoctor
+		}
+		doctorID = assignedDoctorID.String
+	default:
+		return "", ErrNotCareTeam
+	}
+
+	var hasRelationship bool
+	err := s.db.QueryRow(context.Background(),
+		`SELECT EXISTS(
+			SELECT 1
+			FROM appointments
+			WHERE doctor_id = $1 AND patient_id = $2 AND COALESCE(is_doctor_patient, false) = false
+		)`,
+		doctorID, patientID).Scan(&hasRelationship)
+	if err != nil {
+		return "", fmt.Errorf("could not validate doctor-patient relationship: %v", err)
+	}
+	if !hasRelationship {
+		return "", ErrNotCareTeam
+	}
+	return doctorID, nil
+}
+
+// ResolveClinicalUpload applies the doctor's template named in the form, if
+// any, and validates the result against the rules of its category. Invalid
+// metadata is returned as a *MetadataError.
+func (s *MedicalRecordsService) ResolveClinicalUpload(doctorID string, form ClinicalUploadForm) (*ClinicalUploadMetadata, error) {
+	if form.TemplateID != "" {
+		row := s.db.QueryRow(context.Background(),
+			`SELECT `+uploadTemplateColumns+`
+			FROM clinical_upload_templates
+			WHERE id::text = $1 AND doctor_id = $2`,
+			form.TemplateID, doctorID)
+		template, err := scanUploadTemplate(row)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, &MetadataError{Fields: []models.FieldError{{Field: "template_id", Message: "no such upload template"}}}
+			}
+			return nil, fmt.Errorf("could not load upload template: %v", err)
+		}
+		applyUploadTemplate(&form, template)
+	}
+	return validateClinicalUpload(form, time.Now())
+}
+
+// checkClinicalMetadata applies the rules of its category to a clinical
+// document about to be registered, so documents arriving through chunked
+// uploads, DICOM imports or chat are held to the same rules as direct
+// uploads. Invalid metadata is returned as a *MetadataError.
+func checkClinicalMetadata(fileInfo *models.FileFolder) error {
+	if fileInfo.FolderType != models.FolderTypeClinical || fileInfo.Type == "folder" {
+		return nil
+	}
+	var form ClinicalUploadForm
+	if fileInfo.Category != nil {
+		form.Category = string(*fileInfo.Category)
+	}
+	if fileInfo.BodyPart != nil {
+		form.BodyPart = *fileInfo.BodyPart
+	}
+	if fileInfo.StudyDate != nil {
+		form.StudyDate = fileInfo.StudyDate.Format("2006-01-02")
+	}
+	_, err := validateClinicalUpload(form, time.Now())
+	return err
+}
+
+// applyUploadTemplate fills in the fields the form left blank.
+func applyUploadTemplate(form *ClinicalUploadForm, template *models.UploadTemplate) {
+	if form.Category == "" {
+		form.Category = string(template.Category)
+	}
+	if form.FolderName == "" && template.FolderName != nil {
+		form.FolderName = *template.FolderName
+	}
+	if form.BodyPart == "" && template.BodyPart != nil {
+		form.BodyPart = string(*template.BodyPart)
+	}
+}
+
+// validateClinicalUpload checks the form against the rules of its category
+// and reports every invalid field at once. Documents without a folder go to
+// a folder named after their category.
+func validateClinicalUpload(form ClinicalUploadForm, now time.Time) (*ClinicalUploadMetadata, error) {
+	problems := &MetadataError{}
+	metadata := &ClinicalUploadMetadata{
+		Category:   models.Category(strings.ToUpper(strings.TrimSpace(form.Category))),
+		FolderName: strings.TrimSpace(form.FolderName),
+	}
+
+	if metadata.Category == "" {
+		problems.add("category", "is required")
+	} else if !metadata.Category.IsValid() {
+		problems.add("category", fmt.Sprintf("%q is not a known category", form.Category))
+	}
+	required := make(map[string]bool)
+	for _, field := range RequiredUploadFields(metadata.Category) {
+		required[field] = true
+	}
+
+	if bodyPart := strings.ToUpper(strings.TrimSpace(form.BodyPart)); bodyPart != "" {
+		if models.BodyPart(bodyPart).IsValid() {
+			metadata.BodyPart = &bodyPart
+		} else {
+			problems.add("body_part", fmt.Sprintf("%q is not a known body part", form.BodyPart))
+		}
+	} else if required["body_part"] {
+		problems.add("body_part", "is required for "+metadata.Category.GetDisplayName())
+	}
+
+	dateField, dateValue := "study_date", form.StudyDate
+	if metadata.Category == models.CategoryLabResults {
+		dateField = "collection_date"
+		if strings.TrimSpace(form.CollectionDate) != "" || strings.TrimSpace(form.StudyDate) == "" {
+			dateValue = form.CollectionDate
+		}
+	}
+	if dateValue = strings.TrimSpace(dateValue); dateValue != "" {
+		date, err := time.Parse("2006-01-02", dateValue)
+		switch {
+		case err != nil:
+			problems.add(dateField, "must be a date in YYYY-MM-DD format")
+		// Dates carry no time zone; allow for callers ahead of the server.
+		case date.After(now.AddDate(0, 0, 1)):
+			problems.add(dateField, "cannot be in the future")
+		default:
+			metadata.StudyDate = &date
+		}
+	} else if required[dateField] {
+		problems.add(dateField, "is required for "+metadata.Category.GetDisplayName())
+	}
+
+	if len(metadata.FolderName) > maxFolderNameLen {
+		problems.add("folder_name", fmt.Sprintf("must be at most %d characters", maxFolderNameLen))
+	} else if metadata.FolderName == "" && metadata.Category.IsValid() {
+		metadata.FolderName = metadata.Category.GetDisplayName()
+	}
+
+	if len(problems.Fields) > 0 {
+		return nil, problems
+	}
+	return metadata, nil
+}
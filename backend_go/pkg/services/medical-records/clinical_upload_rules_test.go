@@ -0,0 +1,141 @@
+package medicalrecords
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var uploadNow = time.Date(2026, 5, 10, 9, 0, 0, 0, time.UTC)
+
+func fieldErrors(t *testing.T, err error) map[string]string {
+	var metadataErr *MetadataError
+	require.True(t, errors.As(err, &metadataErr), "got %v", err)
+	fields := make(map[string]string)
+	for _, field := range metadataErr.Fields {
+		fields[field.Field] = field.Message
+	}
+	return fields
+}
+
+func TestValidateClinicalUpload_Imaging(t *testing.T) {
+	metadata, err := validateClinicalUpload(ClinicalUploadForm{Category: "imaging_mri", BodyPart: "knee", StudyDate: "2026-05-02"}, uploadNow)
+	require.NoError(t, err)
+	assert.Equal(t, models.CategoryImagingMRI, metadata.Category)
+	assert.Equal(t, "KNEE", *metadata.BodyPart)
+	assert.Equal(t, time.Date(2026, 5, 2, 0, 0, 0, 0, time.UTC), *metadata.StudyDate)
+	assert.Equal(t, "MRI", metadata.FolderName, "Folder defaults to the category")
+
+	_, err = validateClinicalUpload(ClinicalUploadForm{Category: "IMAGING_CT"}, uploadNow)
+	assert.Equal(t, map[string]string{
+		"body_part":  "is required for CT Scan",
+		"study_date": "is required for CT Scan",
+	}, fieldErrors(t, err))
+}
+
+func TestValidateClinicalUpload_LabResults(t *testing.T) {
+	metadata, err := validateClinicalUpload(ClinicalUploadForm{Category: "LAB_RESULTS", CollectionDate: "2026-05-09", FolderName: " Bloodwork "}, uploadNow)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 5, 9, 0, 0, 0, 0, time.UTC), *metadata.StudyDate)
+	assert.Nil(t, metadata.BodyPart)
+	assert.Equal(t, "Bloodwork", metadata.FolderName)
+
+	metadata, err = validateClinicalUpload(ClinicalUploadForm{Category: "LAB_RESULTS", StudyDate: "2026-05-09"}, uploadNow)
+	require.NoError(t, err, "study_date is accepted for older clients")
+	assert.NotNil(t, metadata.StudyDate)
+
+	_, err = validateClinicalUpload(ClinicalUploadForm{Category: "LAB_RESULTS"}, uploadNow)
+	assert.Equal(t, map[string]string{"collection_date": "is required for Lab Results"}, fieldErrors(t, err))
+}
+
+func TestValidateClinicalUpload_ReportsEveryField(t *testing.T) {
+	_, err := validateClinicalUpload(ClinicalUploadForm{
+		Category:   "XRAY",
+		BodyPart:   "ELBOW",
+		StudyDate:  "10/05/2026",
+		FolderName: string(make([]byte, maxFolderNameLen+1)),
+	}, uploadNow)
+	fields := fieldErrors(t, err)
+	assert.Len(t, fields, 4)
+	assert.Contains(t, fields["category"], "not a known category")
+	assert.Contains(t, fields["body_part"], "not a known body part")
+	assert.Contains(t, fields["study_date"], "YYYY-MM-DD")
+	assert.Contains(t, fields, "folder_name")
+
+	_, err = validateClinicalUpload(ClinicalUploadForm{}, uploadNow)
+	assert.Equal(t, map[string]string{"category": "is required"}, fieldErrors(t, err))
+
+	_, err = validateClinicalUpload(ClinicalUploadForm{Category: "LAB_RESULTS", CollectionDate: "2026-05-12"}, uploadNow)
+	assert.Equal(t, map[string]string{"collection_date": "cannot be in the future"}, fieldErrors(t, err))
+
+	_, err = validateClinicalUpload(ClinicalUploadForm{Category: "OTHER"}, uploadNow)
+	assert.NoError(t, err, "Other documents need no extra metadata")
+}
+
+func TestApplyUploadTemplate(t *testing.T) {
+	folder := "Radiology"
+	knee := models.BodyPartKnee
+	template := &models.UploadTemplate{Category: models.CategoryImagingMRI, FolderName: &folder, BodyPart: &knee}
+
+	form := ClinicalUploadForm{StudyDate: "2026-05-01"}
+	applyUploadTemplate(&form, template)
+	metadata, err := validateClinicalUpload(form, uploadNow)
+	require.NoError(t, err)
+	assert.Equal(t, models.CategoryImagingMRI, metadata.Category)
+	assert.Equal(t, "Radiology", metadata.FolderName)
+	assert.Equal(t, "KNEE", *metadata.BodyPart)
+
+	form = ClinicalUploadForm{Category: "IMAGING_XRAY", BodyPart: "HAND"}
+	applyUploadTemplate(&form, template)
+	assert.Equal(t, "IMAGING_XRAY", form.Category, "Sent values win over the template")
+	assert.Equal(t, "HAND", form.BodyPart)
+}
+
+func TestValidateUploadTemplate(t *testing.T) {
+	folder, bodyPart := "  ", "chest"
+	name, category, folderName, part, err := validateUploadTemplate(models.UploadTemplateRequest{
+		Name: " Chest X-ray ", Category: "imaging_xray", FolderName: &folder, BodyPart: &bodyPart,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Chest X-ray", name)
+	assert.Equal(t, models.CategoryImagingXray, category)
+	assert.Nil(t, folderName)
+	assert.Equal(t, "CHEST", *part)
+
+	bad := "TAIL"
+	_, _, _, _, err = validateUploadTemplate(models.UploadTemplateRequest{Category: "SCAN", BodyPart: &bad})
+	assert.Equal(t, []string{"body_part", "category", "name"}, sortedKeys(fieldErrors(t, err)))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCheckClinicalMetadata(t *testing.T) {
+	category := models.CategoryImagingCT
+	studyDate := time.Date(2026, 5, 2, 0, 0, 0, 0, time.UTC)
+	fileInfo := &models.FileFolder{Type: "file", FolderType: models.FolderTypeClinical, Category: &category, StudyDate: &studyDate}
+	assert.Equal(t, map[string]string{"body_part": "is required for CT Scan"}, fieldErrors(t, checkClinicalMetadata(fileInfo)))
+
+	bodyPart := "CHEST"
+	fileInfo.BodyPart = &bodyPart
+	assert.NoError(t, checkClinicalMetadata(fileInfo))
+
+	labResults := models.CategoryLabResults
+	fileInfo = &models.FileFolder{Type: "file", FolderType: models.FolderTypeClinical, Category: &labResults, StudyDate: &studyDate}
+	assert.NoError(t, checkClinicalMetadata(fileInfo), "lab results keep their collection date in study_date")
+
+	assert.NoError(t, checkClinicalMetadata(&models.FileFolder{Type: "file", FolderType: models.FolderTypePersonal}))
+	assert.NoError(t, checkClinicalMetadata(&models.FileFolder{Type: "folder", FolderType: models.FolderTypeClinical}))
+}
@@ -0,0 +1,951 @@
+package medicalrecords
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/dicom"
+	"healthcare_backend/pkg/filecheck"
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	dicomContentType       = "application/dicom"
+	maxDicomArchiveEntries = 20000
+	// Headers sit before pixel data and are rarely more than a few KB.
+	dicomHeaderPeekLimit = 1 << 20
+	maxImportNameLength  = 80
+)
+
+var (
+	ErrNoDicomInstances     = errors.New("no DICOM images found")
+	ErrDicomPatientMismatch = errors.New("DICOM patient does not match the target patient")
+	ErrDicomPatientNotFound = errors.New("target patient not found")
+	ErrDicomStudyNotFound   = errors.New("study not found")
+	ErrDicomSourceNotFound  = errors.New("source file not found")
+	ErrDicomPatientRequired = errors.New("clinical imports need a target patient")
+)
+
+// DicomImportTarget describes where imported studies are filed and whose
+// records they are.
+type DicomImportTarget struct {
+	UserID     string
+	UserType   string
+	ParentID   *string
+	PatientID  *string
+	FolderType models.FolderType
+}
+
+type DicomService struct {
+	db             *pgxpool.Pool
+	storage        storage.Storage
+	recordsService *MedicalRecordsService
+}
+
+func NewDicomService(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *DicomService {
+	recordsService := NewMedicalRecordsService(db, cfg, store)
+	return &DicomService{
+		db:             db,
+		storage:        recordsService.storage,
+		recordsService: recordsService,
+	}
+}
+
+type dicomInstance struct {
+	name   string
+	size   int64
+	open   func() (io.ReadCloser, error)
+	header *dicom.Header
+}
+
+// Import files a single DICOM file or a zip of them into study and series
+// folders, filling category, body part, study date and referring physician
+// from the headers.
+func (s *DicomService) Import(target DicomImportTarget, name string, content io.ReaderAt, size int64) (*models.DicomImportResult, error) {
+	result := &models.DicomImportResult{Studies: []models.DicomStudy{}}
+
+	if target.FolderType == "" {
+		target.FolderType = models.FolderTypePersonal
+	}
+	if err := s.checkTarget(target); err != nil {
+		return nil, err
+	}
+
+	// The upload is checked like any other. Its content is scanned image by
+	// image as they are stored, since compression hides archive entries
+	// from the scanner.
+	upload := filecheck.Upload{
+		Name:    name,
+		Size:    size,
+		Role:    target.UserType,
+		Allowed: []string{filecheck.TypeDICOM, filecheck.TypeZip},
+		Content: io.NewSectionReader(content, 0, size),
+	}
+	if err := s.recordsService.validator.CheckSize(upload); err != nil {
+		return nil, err
+	}
+	if _, err := s.recordsService.validator.CheckType(upload); err != nil {
+		return nil, err
+	}
+
+	instances, err := collectDicomInstances(name, content, size, result)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, ErrNoDicomInstances
+	}
+
+	warnings, err := s.checkPatient(instances, target.PatientID)
+	if err != nil {
+		return nil, err
+	}
+	result.Warnings = append(result.Warnings, warnings...)
+
+	var requested int64
+	for _, instance := range instances {
+		requested += instance.size
+	}
+	if err := s.recordsService.quotaService.CheckQuota(target.UserID, target.UserType, requested); err != nil {
+		return nil, err
+	}
+
+	if err := checkClinicalInstances(target, instances); err != nil {
+		return nil, err
+	}
+
+	parentDir := fmt.Sprintf("records/my-records/%s", target.UserID)
+	if target.FolderType == models.FolderTypeClinical {
+		// Clinical studies are stored in the patient's area. Each uploader
+		// gets their own directory there, as study and series folder names
+		// are only unique among the uploader's folders.
+		parentDir = clinicalRecordPath(*target.PatientID, target.UserID)
+	}
+	if target.ParentID != nil && *target.ParentID != "" {
+		parentPath, err := s.recordsService.getParentFolderPath(*target.ParentID)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving parent folder path: %v", err)
+		}
+		parentDir = path.Join(parentDir, parentPath)
+	}
+
+	var studyOrder []string
+	byStudy := map[string][]*dicomInstance{}
+	for _, instance := range instances {
+		uid := instance.header.StudyInstanceUID
+		if _, ok := byStudy[uid]; !ok {
+			studyOrder = append(studyOrder, uid)
+		}
+		byStudy[uid] = append(byStudy[uid], instance)
+	}
+
+	for _, uid := range studyOrder {
+		studyID, err := s.importStudy(target, parentDir, byStudy[uid], result)
+		if err != nil {
+			return nil, err
+		}
+		study, err := s.GetStudy(studyID)
+		if err != nil {
+			return nil, err
+		}
+		result.Studies = append(result.Studies, *study)
+	}
+
+	return result, nil
+}
+
+// ImportStoredFile imports a file already in the caller's records, such as a
+// zipped study sent through the chunked upload endpoints. Studies are filed
+// next to the source file unless target.ParentID is set.
+func (s *DicomService) ImportStoredFile(target DicomImportTarget, fileID string) (*models.DicomImportResult, error) {
+	var name, fileType, storageKey string
+	var parentID *string
+	var sharedByID *string
+	err := s.db.QueryRow(context.Background(),
+		"SELECT name, type, path, parent_id, shared_by_id FROM folder_file_info WHERE id = $1 AND user_id = $2",
+		fileID, target.UserID).Scan(&name, &fileType, &storageKey, &parentID, &sharedByID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrDicomSourceNotFound
+		}
+		return nil, fmt.Errorf("could not retrieve source file: %v", err)
+	}
+	if fileType == "folder" || sharedByID != nil {
+		return nil, ErrDicomSourceNotFound
+	}
+	if target.ParentID == nil {
+		target.ParentID = parentID
+	}
+
+	source, err := s.storage.Get(context.Background(), storageKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not open source file: %v", err)
+	}
+	defer source.Close()
+
+	// Zip archives need random access, so spool the object to disk first.
+	tmp, err := os.CreateTemp("", "dicom-import-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	written, err := io.Copy(tmp, source)
+	if err != nil {
+		return nil, fmt.Errorf("could not read source file: %v", err)
+	}
+
+	return s.Import(target, name, tmp, written)
+}
+
+// GetStudy returns a study with its series and instance counts.
+func (s *DicomService) GetStudy(studyID string) (*models.DicomStudy, error) {
+	var study models.DicomStudy
+	var modality, description, physician, accession, dicomPatientID, dicomPatientName *string
+	err := s.db.QueryRow(context.Background(),
+		`SELECT id, study_instance_uid, folder_id, user_id, patient_id, modality, category, body_part, study_date,
+			description, referring_physician, accession_number, dicom_patient_id, dicom_patient_name, created_at, updated_at
+		FROM dicom_studies WHERE id = $1`, studyID).Scan(
+		&study.ID, &study.StudyInstanceUID, &study.FolderID, &study.UserID, &study.PatientID, &modality, &study.Category,
+		&study.BodyPart, &study.StudyDate, &description, &physician, &accession, &dicomPatientID, &dicomPatientName,
+		&study.CreatedAt, &study.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrDicomStudyNotFound
+		}
+		return nil, fmt.Errorf("could not retrieve study: %v", err)
+	}
+	study.Modality = derefString(modality)
+	study.Description = derefString(description)
+	study.ReferringPhysician = derefString(physician)
+	study.AccessionNumber = derefString(accession)
+	study.DicomPatientID = derefString(dicomPatientID)
+	study.DicomPatientName = derefString(dicomPatientName)
+
+	rows, err := s.db.Query(context.Background(),
+		`SELECT ds.id, ds.series_instance_uid, ds.folder_id, ds.modality, ds.series_number, ds.description, ds.body_part,
+			(SELECT COUNT(*) FROM dicom_instances di WHERE di.series_id = ds.id)
+		FROM dicom_series ds
+		WHERE ds.study_id = $1
+		ORDER BY ds.series_number NULLS LAST, ds.created_at`, studyID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve series: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var series models.DicomSeries
+		var seriesModality, seriesDescription *string
+		if err := rows.Scan(&series.ID, &series.SeriesInstanceUID, &series.FolderID, &seriesModality, &series.SeriesNumber,
+			&seriesDescription, &series.BodyPart, &series.InstanceCount); err != nil {
+			return nil, fmt.Errorf("could not scan series: %v", err)
+		}
+		series.Modality = derefString(seriesModality)
+		series.Description = derefString(seriesDescription)
+		study.Series = append(study.Series, series)
+		study.InstanceCount += series.InstanceCount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read series: %v", err)
+	}
+	study.SeriesCount = len(study.Series)
+
+	return &study, nil
+}
+
+// GetStudyIDByFolder finds the study filed in folderID, which may be the
+// study folder itself or one of its series folders.
+func (s *DicomService) GetStudyIDByFolder(folderID string) (string, error) {
+	var studyID string
+	err := s.db.QueryRow(context.Background(),
+		`SELECT id FROM dicom_studies WHERE folder_id = $1
+		UNION
+		SELECT study_id FROM dicom_series WHERE folder_id = $1
+		LIMIT 1`, folderID).Scan(&studyID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", ErrDicomStudyNotFound
+		}
+		return "", fmt.Errorf("could not retrieve study: %v", err)
+	}
+	return studyID, nil
+}
+
+func (s *DicomService) importStudy(target DicomImportTarget, parentDir string, instances []*dicomInstance, result *models.DicomImportResult) (string, error) {
+	first := instances[0].header
+
+	studyID, studyDir, err := s.findStudy(target.UserID, first.StudyInstanceUID)
+	if err != nil {
+		return "", err
+	}
+	if studyID == "" {
+		studyID, studyDir, err = s.createStudy(target, parentDir, first)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	type seriesFolder struct {
+		id, folderID, dir string
+		names             map[string]bool
+	}
+	seriesByUID := map[string]*seriesFolder{}
+
+	for _, instance := range instances {
+		header := instance.header
+
+		series, ok := seriesByUID[header.SeriesInstanceUID]
+		if !ok {
+			id, folderID, dir, err := s.ensureSeries(target, studyID, studyDir, header)
+			if err != nil {
+				return "", err
+			}
+			names, err := s.folderFileNames(folderID)
+			if err != nil {
+				return "", err
+			}
+			series = &seriesFolder{id: id, folderID: folderID, dir: dir, names: names}
+			seriesByUID[header.SeriesInstanceUID] = series
+		}
+
+		var exists bool
+		err := s.db.QueryRow(context.Background(),
+			"SELECT EXISTS (SELECT 1 FROM dicom_instances WHERE series_id = $1 AND sop_instance_uid = $2)",
+			series.id, header.SOPInstanceUID).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("could not check for duplicate instance: %v", err)
+		}
+		if exists {
+			result.DuplicateInstances++
+			continue
+		}
+
+		name := instanceFileName(instance.name, header)
+		for i := 2; series.names[name]; i++ {
+			name = fmt.Sprintf("%d_%s", i, instanceFileName(instance.name, header))
+		}
+		series.names[name] = true
+
+		if err := s.storeInstance(target, series.id, series.folderID, series.dir, name, instance); err != nil {
+			return "", err
+		}
+		result.ImportedInstances++
+	}
+
+	_, err = s.db.Exec(context.Background(), "UPDATE dicom_studies SET updated_at = $1 WHERE id = $2", time.Now(), studyID)
+	if err != nil {
+		log.Printf("Warning: failed to touch study %s: %v", studyID, err)
+	}
+
+	return studyID, nil
+}
+
+func (s *DicomService) findStudy(userID, studyUID string) (string, string, error) {
+	var studyID, markerPath string
+	err := s.db.QueryRow(context.Background(),
+		`SELECT ds.id, f.path FROM dicom_studies ds
+		JOIN folder_file_info f ON f.id = ds.folder_id
+		WHERE ds.user_id = $1 AND ds.study_instance_uid = $2`, userID, studyUID).Scan(&studyID, &markerPath)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("could not look up study: %v", err)
+	}
+	return studyID, path.Dir(markerPath), nil
+}
+
+func (s *DicomService) createStudy(target DicomImportTarget, parentDir string, header *dicom.Header) (string, string, error) {
+	folder, err := s.createImportFolder(target, target.ParentID, parentDir, studyFolderName(header))
+	if err != nil {
+		return "", "", err
+	}
+
+	study := &models.FileFolder{}
+	applyDicomMetadata(study, header)
+
+	studyID := uuid.New().String()
+	_, err = s.db.Exec(context.Background(),
+		`INSERT INTO dicom_studies
+		(id, user_id, patient_id, folder_id, study_instance_uid, modality, category, body_part, study_date,
+		 description, referring_physician, accession_number, dicom_patient_id, dicom_patient_name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		studyID, target.UserID, target.PatientID, folder.ID, header.StudyInstanceUID, header.Modality, study.Category,
+		study.BodyPart, study.StudyDate, header.StudyDescription, study.DoctorName, header.AccessionNumber,
+		header.PatientID, dicom.FormatPersonName(header.PatientName))
+	if err != nil {
+		return "", "", fmt.Errorf("could not save study: %v", err)
+	}
+
+	return studyID, path.Dir(folder.Path), nil
+}
+
+func (s *DicomService) ensureSeries(target DicomImportTarget, studyID, studyDir string, header *dicom.Header) (string, string, string, error) {
+	var seriesID, folderID, markerPath string
+	err := s.db.QueryRow(context.Background(),
+		`SELECT ds.id, ds.folder_id, f.path FROM dicom_series ds
+		JOIN folder_file_info f ON f.id = ds.folder_id
+		WHERE ds.study_id = $1 AND ds.series_instance_uid = $2`, studyID, header.SeriesInstanceUID).Scan(&seriesID, &folderID, &markerPath)
+	if err == nil {
+		return seriesID, folderID, path.Dir(markerPath), nil
+	}
+	if err != pgx.ErrNoRows {
+		return "", "", "", fmt.Errorf("could not look up series: %v", err)
+	}
+
+	var studyFolderID string
+	if err := s.db.QueryRow(context.Background(), "SELECT folder_id FROM dicom_studies WHERE id = $1", studyID).Scan(&studyFolderID); err != nil {
+		return "", "", "", fmt.Errorf("could not retrieve study folder: %v", err)
+	}
+
+	folder, err := s.createImportFolder(target, &studyFolderID, studyDir, seriesFolderName(header))
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var seriesNumber *int
+	if header.SeriesNumber != 0 {
+		seriesNumber = &header.SeriesNumber
+	}
+	var bodyPart *string
+	if bp := bodyPartFromDicom(header.BodyPartExamined); bp != "" {
+		value := string(bp)
+		bodyPart = &value
+	}
+
+	seriesID = uuid.New().String()
+	_, err = s.db.Exec(context.Background(),
+		`INSERT INTO dicom_series (id, study_id, folder_id, series_instance_uid, modality, series_number, description, body_part)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		seriesID, studyID, folder.ID, header.SeriesInstanceUID, header.Modality, seriesNumber, header.SeriesDescription, bodyPart)
+	if err != nil {
+		return "", "", "", fmt.Errorf("could not save series: %v", err)
+	}
+
+	return seriesID, folder.ID, path.Dir(folder.Path), nil
+}
+
+func (s *DicomService) storeInstance(target DicomImportTarget, seriesID, folderID, dir, name string, instance *dicomInstance) error {
+	if err := s.scanInstance(instance); err != nil {
+		return err
+	}
+	content, err := instance.open()
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", instance.name, err)
+	}
+	defer content.Close()
+
+	ext := "dcm"
+	uploadedByUserID := target.UserID
+	uploadedByRole := target.UserType
+	fileInfo := &models.FileFolder{
+		ID:               uuid.New().String(),
+		Name:             name,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		Type:             "file",
+		Size:             instance.size,
+		Ext:              &ext,
+		UserID:           target.UserID,
+		UserType:         target.UserType,
+		ParentID:         &folderID,
+		Path:             path.Join(dir, name),
+		FolderType:       target.FolderType,
+		PatientID:        target.PatientID,
+		UploadedByUserID: &uploadedByUserID,
+		UploadedByRole:   &uploadedByRole,
+	}
+	applyDicomMetadata(fileInfo, instance.header)
+
+	if err := s.storage.Put(context.Background(), fileInfo.Path, content, instance.size, dicomContentType); err != nil {
+		return fmt.Errorf("failed to store %s: %v", instance.name, err)
+	}
+	if err := s.recordsService.registerUploadedFile(fileInfo); err != nil {
+		if deleteErr := s.storage.Delete(context.Background(), fileInfo.Path); deleteErr != nil {
+			log.Printf("Warning: failed to remove unregistered instance %s: %v", fileInfo.Path, deleteErr)
+		}
+		return err
+	}
+
+	var instanceNumber *int
+	if instance.header.InstanceNumber != 0 {
+		instanceNumber = &instance.header.InstanceNumber
+	}
+	_, err = s.db.Exec(context.Background(),
+		"INSERT INTO dicom_instances (file_id, series_id, sop_instance_uid, instance_number) VALUES ($1, $2, $3, $4)",
+		fileInfo.ID, seriesID, instance.header.SOPInstanceUID, instanceNumber)
+	if err != nil {
+		return fmt.Errorf("could not save instance: %v", err)
+	}
+
+	return nil
+}
+
+func (s *DicomService) scanInstance(instance *dicomInstance) error {
+	content, err := instance.open()
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", instance.name, err)
+	}
+	defer content.Close()
+	return s.recordsService.validator.Scan(context.Background(), instance.name, content)
+}
+
+func (s *DicomService) folderFileNames(folderID string) (map[string]bool, error) {
+	rows, err := s.db.Query(context.Background(), "SELECT name FROM folder_file_info WHERE parent_id = $1", folderID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list folder contents: %v", err)
+	}
+	defer rows.Close()
+
+	names := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("could not scan folder contents: %v", err)
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+// createImportFolder creates a folder under parentDir, adding a numeric
+// suffix when a sibling already uses the name so stored objects never mix.
+func (s *DicomService) createImportFolder(target DicomImportTarget, parentID *string, parentDir, name string) (*models.FileFolder, error) {
+	var taken []string
+	rows, err := s.db.Query(context.Background(),
+		`SELECT name FROM folder_file_info
+		WHERE user_id = $1 AND type = 'folder' AND parent_id IS NOT DISTINCT FROM $2`, target.UserID, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list sibling folders: %v", err)
+	}
+	for rows.Next() {
+		var sibling string
+		if err := rows.Scan(&sibling); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("could not scan sibling folder: %v", err)
+		}
+		taken = append(taken, sibling)
+	}
+	rows.Close()
+	name = uniqueName(name, taken)
+
+	folder := &models.FileFolder{
+		ID:               uuid.New().String(),
+		Name:             name,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		Type:             "folder",
+		UserID:           target.UserID,
+		UserType:         target.UserType,
+		ParentID:         parentID,
+		Path:             path.Join(parentDir, name, "marker.txt"),
+		FolderType:       target.FolderType,
+		OwnerUserID:      &target.UserID,
+		PatientID:        target.PatientID,
+		UploadedByUserID: &target.UserID,
+		UploadedByRole:   &target.UserType,
+	}
+
+	if err := s.recordsService.uploadMarkerFile(folder.Path); err != nil {
+		return nil, fmt.Errorf("failed to store folder marker: %v", err)
+	}
+
+	_, err = s.db.Exec(context.Background(),
+		"INSERT INTO folder_file_info (id, name, created_at, updated_at, type, user_id, user_type, parent_id, size, path, folder_type, owner_user_id, patient_id, uploaded_by_user_id, uploaded_by_role, included_in_rag) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)",
+		folder.ID, folder.Name, folder.CreatedAt, folder.UpdatedAt, folder.Type, folder.UserID, folder.UserType, folder.ParentID, folder.Size, folder.Path, folder.FolderType, folder.OwnerUserID, folder.PatientID, folder.UploadedByUserID, folder.UploadedByRole, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not insert folder info: %v", err)
+	}
+
+	return folder, nil
+}
+
+// checkTarget makes sure the caller may file images for the target patient:
+// clinical imports and imports for another patient are reserved to the
+// patient's care team.
+func (s *DicomService) checkTarget(target DicomImportTarget) error {
+	if target.FolderType == models.FolderTypeClinical {
+		if target.PatientID == nil {
+			return ErrDicomPatientRequired
+		}
+	} else if target.PatientID == nil || *target.PatientID == target.UserID {
+		return nil
+	}
+	_, err := s.recordsService.CareTeamDoctor(target.UserID, target.UserType, *target.PatientID)
+	return err
+}
+
+// checkClinicalInstances applies the clinical upload rules to the metadata
+// each image will be filed with, before any of them is stored.
+func checkClinicalInstances(target DicomImportTarget, instances []*dicomInstance) error {
+	if target.FolderType != models.FolderTypeClinical {
+		return nil
+	}
+	for _, instance := range instances {
+		fileInfo := &models.FileFolder{Type: "file", FolderType: target.FolderType}
+		applyDicomMetadata(fileInfo, instance.header)
+		if err := checkClinicalMetadata(fileInfo); err != nil {
+			return fmt.Errorf("%s: %w", instance.name, err)
+		}
+	}
+	return nil
+}
+
+// checkPatient makes sure every image belongs to one patient and, when the
+// import targets a patient, that the headers identify that patient.
+func (s *DicomService) checkPatient(instances []*dicomInstance, patientID *string) ([]string, error) {
+	identities := map[string]bool{}
+	for _, instance := range instances {
+		if key := dicomPatientKey(instance.header); key != "" {
+			identities[key] = true
+		}
+	}
+	if len(identities) > 1 {
+		return nil, fmt.Errorf("%w: the upload contains images of %d different patients", ErrDicomPatientMismatch, len(identities))
+	}
+
+	if patientID == nil {
+		return nil, nil
+	}
+
+	var firstName, lastName string
+	var birthDate time.Time
+	err := s.db.QueryRow(context.Background(),
+		"SELECT first_name, last_name, birth_date FROM patient_info WHERE patient_id = $1", *patientID).Scan(&firstName, &lastName, &birthDate)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrDicomPatientNotFound
+		}
+		return nil, fmt.Errorf("could not retrieve patient: %v", err)
+	}
+
+	header := instances[0].header
+	if header.PatientID == *patientID {
+		return nil, nil
+	}
+	if header.PatientName == "" && header.PatientBirthDate == "" {
+		return []string{"DICOM headers carry no patient name or birth date, so the patient could not be verified"}, nil
+	}
+
+	if mismatches := matchDicomPatient(header, firstName, lastName, birthDate); len(mismatches) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDicomPatientMismatch, strings.Join(mismatches, "; "))
+	}
+	return nil, nil
+}
+
+// collectDicomInstances parses the header of every DICOM file in the upload.
+// Files that are not DICOM images are reported in result.Skipped.
+func collectDicomInstances(name string, content io.ReaderAt, size int64, result *models.DicomImportResult) ([]*dicomInstance, error) {
+	signature := make([]byte, 4)
+	if _, err := content.ReadAt(signature, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("could not read upload: %v", err)
+	}
+
+	var candidates []*dicomInstance
+	if string(signature) == "PK\x03\x04" {
+		archive, err := zip.NewReader(content, size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: could not read zip archive: %v", ErrNoDicomInstances, err)
+		}
+		if len(archive.File) > maxDicomArchiveEntries {
+			return nil, fmt.Errorf("%w: archive has more than %d entries", ErrNoDicomInstances, maxDicomArchiveEntries)
+		}
+		for _, entry := range archive.File {
+			if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") {
+				continue
+			}
+			candidates = append(candidates, &dicomInstance{
+				name: entry.Name,
+				size: int64(entry.UncompressedSize64),
+				open: entry.Open,
+			})
+		}
+	} else {
+		candidates = append(candidates, &dicomInstance{
+			name: name,
+			size: size,
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(io.NewSectionReader(content, 0, size)), nil
+			},
+		})
+	}
+
+	var instances []*dicomInstance
+	for _, candidate := range candidates {
+		rc, err := candidate.open()
+		if err != nil {
+			result.Skipped = append(result.Skipped, models.DicomSkippedFile{Name: candidate.name, Reason: err.Error()})
+			continue
+		}
+		header, err := dicom.Parse(rc)
+		rc.Close()
+		if err != nil {
+			result.Skipped = append(result.Skipped, models.DicomSkippedFile{Name: candidate.name, Reason: err.Error()})
+			continue
+		}
+		if header.StudyInstanceUID == "" || header.SeriesInstanceUID == "" || header.SOPInstanceUID == "" {
+			result.Skipped = append(result.Skipped, models.DicomSkippedFile{Name: candidate.name, Reason: "not an image instance"})
+			continue
+		}
+		candidate.header = header
+		instances = append(instances, candidate)
+	}
+
+	return instances, nil
+}
+
+// applyDicomMetadata fills the record fields that were not set by hand.
+func applyDicomMetadata(fileInfo *models.FileFolder, header *dicom.Header) {
+	if fileInfo.Category == nil && header.Modality != "" {
+		category := categoryForModality(header.Modality)
+		fileInfo.Category = &category
+	}
+	if fileInfo.BodyPart == nil {
+		if bodyPart := bodyPartFromDicom(header.BodyPartExamined); bodyPart != "" {
+			value := string(bodyPart)
+			fileInfo.BodyPart = &value
+		}
+	}
+	if fileInfo.StudyDate == nil {
+		if studyDate, ok := dicom.ParseDate(header.StudyDate); ok {
+			fileInfo.StudyDate = &studyDate
+		}
+	}
+	if fileInfo.DoctorName == nil {
+		if physician := dicom.FormatPersonName(header.ReferringPhysicianName); physician != "" {
+			fileInfo.DoctorName = &physician
+		}
+	}
+}
+
+func isDicomUpload(ext *string, contentType string) bool {
+	if contentType == dicomContentType {
+		return true
+	}
+	if ext == nil {
+		return false
+	}
+	switch strings.ToLower(strings.TrimPrefix(*ext, ".")) {
+	case "dcm", "dicom":
+		return true
+	}
+	return false
+}
+
+func categoryForModality(modality string) models.Category {
+	switch strings.ToUpper(modality) {
+	case "CT":
+		return models.CategoryImagingCT
+	case "MR":
+		return models.CategoryImagingMRI
+	case "CR", "DX", "RF", "XA", "RG", "IO", "PX":
+		return models.CategoryImagingXray
+	case "US", "IVUS":
+		return models.CategoryImagingUS
+	case "MG":
+		return models.CategoryImagingMammo
+	case "PT":
+		return models.CategoryImagingPET
+	}
+	return models.CategoryOther
+}
+
+// bodyPartFromDicom maps Body Part Examined defined terms onto the record
+// body parts. Unknown non-empty values map to OTHER.
+func bodyPartFromDicom(value string) models.BodyPart {
+	normalized := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, value)
+
+	switch normalized {
+	case "":
+		return ""
+	case "HEAD", "SKULL", "FACE", "SINUS", "ORBIT", "JAW", "TMJ", "EAR":
+		return models.BodyPartHead
+	case "NECK":
+		return models.BodyPartNeck
+	case "CHEST", "THORAX", "RIB", "STERNUM", "CLAVICLE":
+		return models.BodyPartChest
+	case "ABDOMEN", "ABDOMENPELVIS", "CHESTABDOMEN":
+		return models.BodyPartAbdomen
+	case "PELVIS", "SACRUM", "COCCYX":
+		return models.BodyPartPelvis
+	case "SPINE", "CSPINE", "TSPINE", "LSPINE", "SSPINE", "CTSPINE", "TLSPINE", "LSSPINE":
+		return models.BodyPartSpine
+	case "ARM", "UPPEREXTREMITY", "HUMERUS", "FOREARM", "ELBOW", "UPRLIMB":
+		return models.BodyPartArm
+	case "HAND", "FINGER", "THUMB":
+		return models.BodyPartHand
+	case "LEG", "LOWEREXTREMITY", "FEMUR", "TIBIA", "FIBULA", "LOWRLIMB":
+		return models.BodyPartLeg
+	case "FOOT", "TOE", "HEEL", "CALCANEUS":
+		return models.BodyPartFoot
+	case "BRAIN":
+		return models.BodyPartBrain
+	case "HEART", "CARDIAC", "CORONARY":
+		return models.BodyPartHeart
+	case "LUNG", "LUNGS":
+		return models.BodyPartLungs
+	case "KIDNEY", "KIDNEYS":
+		return models.BodyPartKidney
+	case "LIVER":
+		return models.BodyPartLiver
+	case "KNEE":
+		return models.BodyPartKnee
+	case "SHOULDER":
+		return models.BodyPartShoulder
+	case "HIP":
+		return models.BodyPartHip
+	case "ANKLE":
+		return models.BodyPartAnkle
+	case "WRIST":
+		return models.BodyPartWrist
+	case "WHOLEBODY", "FULLBODY":
+		return models.BodyPartFullBody
+	}
+	return models.BodyPartOther
+}
+
+// matchDicomPatient compares the header's patient name and birth date with a
+// patient's profile and describes every mismatch.
+func matchDicomPatient(header *dicom.Header, firstName, lastName string, birthDate time.Time) []string {
+	var mismatches []string
+
+	if header.PatientName != "" {
+		family, given := dicom.PersonName(header.PatientName)
+		family, given = normalizeName(family), normalizeName(given)
+		first, last := normalizeName(firstName), normalizeName(lastName)
+
+		matches := (family == last && (given == "" || given == first)) ||
+			(family == first && given == last)
+		if !matches {
+			mismatches = append(mismatches, fmt.Sprintf("patient name %q does not match %s %s",
+				dicom.FormatPersonName(header.PatientName), firstName, lastName))
+		}
+	}
+
+	if dob, ok := dicom.ParseDate(header.PatientBirthDate); ok {
+		if dob.Format("2006-01-02") != birthDate.Format("2006-01-02") {
+			mismatches = append(mismatches, fmt.Sprintf("birth date %s does not match %s",
+				dob.Format("2006-01-02"), birthDate.Format("2006-01-02")))
+		}
+	}
+
+	return mismatches
+}
+
+func dicomPatientKey(header *dicom.Header) string {
+	if header.PatientID == "" && header.PatientName == "" && header.PatientBirthDate == "" {
+		return ""
+	}
+	family, given := dicom.PersonName(header.PatientName)
+	return strings.Join([]string{header.PatientID, normalizeName(family), normalizeName(given), header.PatientBirthDate}, "|")
+}
+
+func normalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, name)
+}
+
+func studyFolderName(header *dicom.Header) string {
+	var parts []string
+	if studyDate, ok := dicom.ParseDate(header.StudyDate); ok {
+		parts = append(parts, studyDate.Format("2006-01-02"))
+	}
+	if header.Modality != "" {
+		parts = append(parts, header.Modality)
+	}
+	if header.StudyDescription != "" {
+		parts = append(parts, header.StudyDescription)
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "DICOM Study")
+	}
+	return sanitizeImportName(strings.Join(parts, " "))
+}
+
+func seriesFolderName(header *dicom.Header) string {
+	name := "Series"
+	if header.SeriesNumber != 0 {
+		name = fmt.Sprintf("Series %d", header.SeriesNumber)
+	}
+	if header.SeriesDescription != "" {
+		name += " - " + header.SeriesDescription
+	}
+	return sanitizeImportName(name)
+}
+
+func instanceFileName(entryName string, header *dicom.Header) string {
+	name := path.Base(strings.ReplaceAll(entryName, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		name = header.SOPInstanceUID
+	}
+	if strings.EqualFold(path.Ext(name), ".dcm") {
+		name = name[:len(name)-len(".dcm")]
+	}
+	return sanitizeImportName(name) + ".dcm"
+}
+
+// sanitizeImportName keeps header-derived names safe to use as a single
+// storage path segment.
+func sanitizeImportName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '-'
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if runes := []rune(name); len(runes) > maxImportNameLength {
+		name = strings.TrimSpace(string(runes[:maxImportNameLength]))
+	}
+	if name == "" || name == "." || name == ".." {
+		name = "unnamed"
+	}
+	return name
+}
+
+func uniqueName(name string, taken []string) string {
+	used := map[string]bool{}
+	for _, t := range taken {
+		used[t] = true
+	}
+	candidate := name
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s (%d)", name, i)
+	}
+	return candidate
+}
+
+func derefString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,149 @@
+package medicalrecords
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/dicom"
+	"healthcare_backend/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// minimalDicom builds an explicit VR little endian file with the given
+// string attributes, keyed by group<<16|element.
+func minimalDicom(attrs map[uint32]string) []byte {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 128))
+	buf.WriteString("DICM")
+
+	write := func(tag uint32, value string) {
+		if len(value)%2 == 1 {
+			value += " "
+		}
+		binary.Write(&buf, binary.LittleEndian, uint16(tag>>16))
+		binary.Write(&buf, binary.LittleEndian, uint16(tag))
+		buf.WriteString("LO")
+		binary.Write(&buf, binary.LittleEndian, uint16(len(value)))
+		buf.WriteString(value)
+	}
+
+	write(0x00020010, "1.2.840.10008.1.2.1\x00")
+	for _, tag := range []uint32{0x00080018, 0x00080020, 0x00080060, 0x00100010, 0x00180015, 0x0020000D, 0x0020000E} {
+		if value, ok := attrs[tag]; ok {
+			write(tag, value)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestCollectDicomInstances_ReadsZipAndSkipsOtherFiles(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	entries := map[string][]byte{
+		"study/IM0001": minimalDicom(map[uint32]string{
+			0x00080018: "1.2.3.1", 0x00080060: "MR", 0x0020000D: "1.2.3", 0x0020000E: "1.2.3.9",
+		}),
+		"study/DICOMDIR": minimalDicom(map[uint32]string{0x00080060: "MR"}),
+		"README.txt":     []byte("exported by PACS"),
+	}
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	result := &models.DicomImportResult{}
+	instances, err := collectDicomInstances("study.zip", bytes.NewReader(archive.Bytes()), int64(archive.Len()), result)
+	require.NoError(t, err)
+
+	require.Len(t, instances, 1)
+	assert.Equal(t, "study/IM0001", instances[0].name)
+	assert.Equal(t, "MR", instances[0].header.Modality)
+	assert.Len(t, result.Skipped, 2)
+}
+
+func TestCollectDicomInstances_SingleFile(t *testing.T) {
+	data := minimalDicom(map[uint32]string{
+		0x00080018: "1.2.3.1", 0x00080060: "CT", 0x0020000D: "1.2.3", 0x0020000E: "1.2.3.9",
+	})
+
+	instances, err := collectDicomInstances("scan.dcm", bytes.NewReader(data), int64(len(data)), &models.DicomImportResult{})
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, int64(len(data)), instances[0].size)
+}
+
+func TestApplyDicomMetadata_KeepsManualValues(t *testing.T) {
+	header := &dicom.Header{
+		Modality:               "MR",
+		BodyPartExamined:       "L-SPINE",
+		StudyDate:              "20240315",
+		ReferringPhysicianName: "House^Gregory",
+	}
+
+	auto := &models.FileFolder{}
+	applyDicomMetadata(auto, header)
+	require.NotNil(t, auto.Category)
+	assert.Equal(t, models.CategoryImagingMRI, *auto.Category)
+	assert.Equal(t, string(models.BodyPartSpine), *auto.BodyPart)
+	assert.Equal(t, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), *auto.StudyDate)
+	assert.Equal(t, "Gregory House", *auto.DoctorName)
+
+	category := models.CategoryOther
+	manual := &models.FileFolder{Category: &category}
+	applyDicomMetadata(manual, header)
+	assert.Equal(t, models.CategoryOther, *manual.Category)
+}
+
+func TestCategoryForModality(t *testing.T) {
+	assert.Equal(t, models.CategoryImagingCT, categoryForModality("CT"))
+	assert.Equal(t, models.CategoryImagingXray, categoryForModality("DX"))
+	assert.Equal(t, models.CategoryImagingMammo, categoryForModality("MG"))
+	assert.Equal(t, models.CategoryImagingPET, categoryForModality("PT"))
+	assert.Equal(t, models.CategoryOther, categoryForModality("SR"))
+}
+
+func TestBodyPartFromDicom(t *testing.T) {
+	assert.Equal(t, models.BodyPartChest, bodyPartFromDicom("CHEST"))
+	assert.Equal(t, models.BodyPartLungs, bodyPartFromDicom("lung"))
+	assert.Equal(t, models.BodyPartFullBody, bodyPartFromDicom("WHOLEBODY"))
+	assert.Equal(t, models.BodyPartOther, bodyPartFromDicom("BREAST"))
+	assert.Equal(t, models.BodyPart(""), bodyPartFromDicom(""))
+}
+
+func TestMatchDicomPatient(t *testing.T) {
+	birth := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ok := &dicom.Header{PatientName: "DOE^JANE", PatientBirthDate: "19800101"}
+	assert.Empty(t, matchDicomPatient(ok, "Jane", "Doe", birth))
+
+	familyOnly := &dicom.Header{PatientName: "Doe"}
+	assert.Empty(t, matchDicomPatient(familyOnly, "Jane", "Doe", birth))
+
+	wrong := &dicom.Header{PatientName: "Roe^Richard", PatientBirthDate: "19790202"}
+	assert.Len(t, matchDicomPatient(wrong, "Jane", "Doe", birth), 2)
+}
+
+func TestImportNames(t *testing.T) {
+	header := &dicom.Header{
+		StudyDate:         "20240315",
+		Modality:          "CT",
+		StudyDescription:  "CT CHEST W/O CONTRAST",
+		SeriesNumber:      2,
+		SeriesDescription: "AXIAL 1.25",
+		SOPInstanceUID:    "1.2.3.4",
+	}
+
+	assert.Equal(t, "2024-03-15 CT CT CHEST W-O CONTRAST", studyFolderName(header))
+	assert.Equal(t, "Series 2 - AXIAL 1.25", seriesFolderName(header))
+	assert.Equal(t, "IM0001.dcm", instanceFileName("export/IM0001", header))
+	assert.Equal(t, "scan.dcm", instanceFileName("scan.DCM", header))
+	assert.Equal(t, "Series (3)", uniqueName("Series", []string{"Series", "Series (2)"}))
+}
@@ -0,0 +1,436 @@
+package medicalrecords
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/pdf"
+	"healthcare_backend/pkg/storage"
+	"healthcare_backend/pkg/textsearch"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var errNoText = errors.New("document has no extractable text")
+
+const (
+	maxTextIndexAttempts = 3
+	textIndexBatchSize   = 5
+	textIndexStaleAfter  = 10 * time.Minute
+
+	maxTextSourceSize = 64 << 20
+	// maxIndexedText keeps documents well under the 1MB tsvector limit.
+	maxIndexedText = 256 << 10
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchLanguages are the text search configurations queries are matched
+// against. Documents are indexed with the one their text is written in.
+var searchLanguages = []string{"english", "french", "arabic", "simple"}
+
+// DocumentSearchService extracts the text of uploaded PDFs and plain-text
+// reports into document_text_index and answers full-text queries over it.
+// Extraction runs in the background like previews.
+type DocumentSearchService struct {
+	db           *pgxpool.Pool
+	storage      storage.Storage
+	queue        *fileJobQueue
+	pollInterval time.Duration
+
+	languagesOnce sync.Once
+	languages     []string
+}
+
+func NewDocumentSearchService(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *DocumentSearchService {
+	return &DocumentSearchService{
+		db:           db,
+		storage:      store,
+		queue:        newTextIndexQueue(db),
+		pollInterval: time.Duration(cfg.SearchIndexPollSeconds) * time.Second,
+	}
+}
+
+func newTextIndexQueue(db *pgxpool.Pool) *fileJobQueue {
+	return &fileJobQueue{
+		db:          db,
+		table:       "document_text_index",
+		kind:        "text index",
+		batchSize:   textIndexBatchSize,
+		maxAttempts: maxTextIndexAttempts,
+		staleAfter:  textIndexStaleAfter,
+	}
+}
+
+// Run indexes queued documents until ctx is cancelled. A non-positive
+// SEARCH_INDEX_POLL_SECONDS disables the worker.
+func (s *DocumentSearchService) Run(ctx context.Context) {
+	if s.pollInterval <= 0 {
+		log.Printf("Text index worker disabled")
+		return
+	}
+	s.queue.run(ctx, s.pollInterval, s.process)
+}
+
+// ProcessBatch claims up to textIndexBatchSize due jobs and indexes their
+// documents. It returns how many jobs it claimed.
+func (s *DocumentSearchService) ProcessBatch(ctx context.Context) (int, error) {
+	return s.queue.processBatch(ctx, s.process)
+}
+
+func (s *DocumentSearchService) process(ctx context.Context, job fileJob) {
+	source, err := s.storage.Get(ctx, job.path)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.queue.finish(job, string(models.TextIndexFailed), err)
+		} else {
+			s.queue.retryOrFail(job, err, string(models.TextIndexFailed))
+		}
+		return
+	}
+	text, err := extractText(source, fileExtension(job.name, job.ext))
+	source.Close()
+	if err != nil {
+		status := models.TextIndexFailed
+		if errors.Is(err, pdf.ErrUnsupported) || errors.Is(err, errNoText) {
+			status = models.TextIndexUnsupported
+		}
+		s.queue.finish(job, string(status), err)
+		return
+	}
+
+	// Configurations missing from the server fall back to simple.
+	s.queue.complete(ctx, job, string(models.TextIndexIndexed),
+		`language = COALESCE((SELECT oid::regconfig FROM pg_ts_config WHERE cfgname = $3), 'simple'),
+		content = $4,
+		search_vector = to_tsvector(COALESCE((SELECT oid::regconfig FROM pg_ts_config WHERE cfgname = $3), 'simple'), $4)`,
+		detectLanguage(text), text)
+}
+
+// textIndexable reports whether files with this extension have text to
+// index.
+func textIndexable(ext string) bool {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "pdf", "txt", "csv":
+		return true
+	}
+	return false
+}
+
+// extractText returns the searchable text of a PDF or plain-text report.
+func extractText(content io.Reader, ext string) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(content, maxTextSourceSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	if len(data) > maxTextSourceSize {
+		return "", fmt.Errorf("%w: file is larger than %d bytes", pdf.ErrUnsupported, maxTextSourceSize)
+	}
+
+	var text string
+	if pdf.IsPDF(data) {
+		doc, err := pdf.Parse(data)
+		if err != nil {
+			return "", err
+		}
+		if text, err = doc.Text(maxIndexedText); err != nil {
+			return "", err
+		}
+	} else if strings.EqualFold(strings.TrimPrefix(ext, "."), "pdf") {
+		return "", fmt.Errorf("%w: missing PDF header", pdf.ErrUnsupported)
+	} else {
+		text = plainText(data)
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return "", errNoText
+	}
+	return text, nil
+}
+
+// plainText decodes a text report. Files that are not valid UTF-8 are read
+// as Latin-1, which older lab systems export. Control characters, which
+// Postgres text cannot always hold, become spaces.
+func plainText(data []byte) string {
+	if len(data) > maxIndexedText {
+		data = data[:maxIndexedText]
+		// Drop a rune cut in half by the limit.
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	data = []byte(strings.TrimPrefix(string(data), "\ufeff"))
+
+	var text string
+	if utf8.Valid(data) {
+		text = string(data)
+	} else {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// frenchWords and englishWords are frequent function words used to tell the
+// two languages apart.
+var (
+	frenchWords = map[string]bool{
+		"le": true, "la": true, "les": true, "des": true, "du": true, "de": true,
+		"et": true, "est": true, "une": true, "un": true, "pour": true, "avec": true,
+		"dans": true, "sur": true, "au": true, "aux": true, "pas": true, "qui": true,
+		"que": true, "sont": true, "par": true, "il": true, "elle": true,
+	}
+	englishWords = map[string]bool{
+		"the": true, "and": true, "of": true, "to": true, "is": true, "in": true,
+		"for": true, "with": true, "on": true, "was": true, "are": true, "a": true,
+		"an": true, "by": true, "be": true, "this": true, "that": true, "he": true,
+		"she": true, "no": true,
+	}
+)
+
+// detectLanguage picks the text search configuration for a document: Arabic
+// when Arabic script dominates, otherwise French or English by their common
+// words, and simple when there is nothing to go on.
+func detectLanguage(text string) string {
+	var letters, arabic int
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.Is(unicode.Arabic, r) {
+				arabic++
+			}
+		}
+	}
+	if letters == 0 {
+		return "simple"
+	}
+	if arabic*10 >= letters*3 {
+		return "arabic"
+	}
+
+	var french, english int
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if frenchWords[word] {
+			french++
+		}
+		if englishWords[word] {
+			english++
+		}
+		if strings.ContainsAny(word, "éèêàçùœ") {
+			french++
+		}
+	}
+	if french > english {
+		return "french"
+	}
+	if english > 0 {
+		return "english"
+	}
+	return "simple"
+}
+
+// Search returns the indexed documents matching req.Query that the caller
+// may see, best matches first. Patients see their own files and documents
+// filed for them; doctors see files they own or uploaded and clinical
+// documents of patients they have appointments with. Receptionists search
+// on behalf of their assigned doctor.
+func (s *DocumentSearchService) Search(callerID, callerType string, req models.DocumentSearchRequest) ([]models.DocumentSearchResult, error) {
+	doctorID := ""
+	switch callerType {
+	case "doctor":
+		doctorID = callerID
+	case "receptionist":
+		var assigned *string
+		err := s.db.QueryRow(context.Background(),
+			"SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1", callerID).Scan(&assigned)
+		if err != nil && err != pgx.ErrNoRows {
+			return nil, fmt.Errorf("could not verify receptionist assignment: %v", err)
+		}
+		if assigned != nil {
+			doctorID = *assigned
+		}
+	}
+
+	query, args := buildDocumentSearchQuery(s.availableLanguages(), callerID, doctorID, req)
+	rows, err := s.db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching documents: %v", err)
+	}
+	defer rows.Close()
+
+	results := []models.DocumentSearchResult{}
+	for rows.Next() {
+		var result models.DocumentSearchResult
+		var folderType, category *string
+		err := rows.Scan(
+			&result.ID, &result.Name, &result.CreatedAt, &result.UpdatedAt, &result.Type, &result.Size,
+			&result.Ext, &result.UserID, &result.UserType, &result.ParentID, &result.Path,
+			&folderType, &category, &result.BodyPart, &result.StudyDate, &result.DoctorName,
+			&result.OwnerUserID, &result.PatientID, &result.UploadedByUserID, &result.UploadedByRole,
+			&result.IncludedInRAG, &result.Rank, &result.Snippet)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning search result: %v", err)
+		}
+		if folderType != nil {
+			result.FolderType = models.FolderType(*folderType)
+		}
+		if category != nil {
+			c := models.Category(*category)
+			result.Category = &c
+		}
+		result.Snippet = textsearch.FormatSnippet(result.Snippet)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning search results: %v", err)
+	}
+
+	files := make([]models.FileFolder, len(results))
+	for i := range results {
+		files[i] = results[i].FileFolder
+	}
+	if err := attachPreviews(s.db, files); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].FileFolder = files[i]
+	}
+	return results, nil
+}
+
+// availableLanguages returns the search languages this server has text
+// search configurations for.
+func (s *DocumentSearchService) availableLanguages() []string {
+	s.languagesOnce.Do(func() {
+		rows, err := s.db.Query(context.Background(),
+			"SELECT cfgname FROM pg_ts_config WHERE cfgname = ANY($1)", searchLanguages)
+		if err != nil {
+			log.Printf("Warning: failed to list text search configurations: %v", err)
+			return
+		}
+		defer rows.Close()
+
+		available := map[string]bool{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err == nil {
+				available[name] = true
+			}
+		}
+		for _, language := range searchLanguages {
+			if available[language] {
+				s.languages = append(s.languages, language)
+			}
+		}
+	})
+	if len(s.languages) == 0 {
+		return []string{"simple"}
+	}
+	return s.languages
+}
+
+// buildDocumentSearchQuery builds the search statement. The query text is
+// parsed with every language and the results OR-ed, so a French document
+// matches a French query while the caller does not have to say which
+// language they typed in. doctorID is the doctor whose patients' clinical
+// documents are visible, empty for patients.
+func buildDocumentSearchQuery(languages []string, callerID, doctorID string, req models.DocumentSearchRequest) (string, []interface{}) {
+	args := []interface{}{req.Query, callerID}
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	access := "(f.user_id = $2 OR f.patient_id = $2 OR f.uploaded_by_user_id = $2)"
+	if doctorID != "" {
+		access = fmt.Sprintf(`(f.user_id = $2 OR f.uploaded_by_user_id = $2 OR (f.folder_type = 'CLINICAL' AND f.patient_id IN (
+				SELECT patient_id FROM appointments WHERE doctor_id = %s AND COALESCE(is_doctor_patient, false) = false)))`,
+			param(doctorID))
+	}
+
+	conditions := []string{"d.status = 'indexed'", "d.search_vector @@ query.q", access}
+	if req.PatientID != "" {
+		conditions = append(conditions, "f.patient_id = "+param(req.PatientID))
+	}
+	if req.Category != "" {
+		conditions = append(conditions, "f.category = "+param(req.Category))
+	}
+	if req.BodyPart != "" {
+		conditions = append(conditions, "LOWER(f.body_part) = LOWER("+param(req.BodyPart)+")")
+	}
+	if req.From != nil {
+		conditions = append(conditions, "COALESCE(f.study_date, f.created_at) >= "+param(*req.From))
+	}
+	if req.To != nil {
+		conditions = append(conditions, "COALESCE(f.study_date, f.created_at) < "+param(*req.To))
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+	limitParam := param(limit)
+	offsetParam := param(max(req.Offset, 0))
+
+	// Snippets are only built for the page being returned.
+	query := fmt.Sprintf(`
+	WITH query AS (
+		SELECT %s AS q
+	), matches AS (
+		SELECT f.id, f.name, f.created_at, f.updated_at, f.type, f.size, f.extension, f.user_id, f.user_type,
+			f.parent_id, COALESCE(f.path, '') AS path, f.folder_type, f.category, f.body_part, f.study_date, f.doctor_name,
+			f.owner_user_id, f.patient_id, f.uploaded_by_user_id, f.uploaded_by_role, f.included_in_rag,
+			ts_rank(d.search_vector, query.q) AS rank, d.language, d.content, query.q
+		FROM document_text_index d
+		JOIN folder_file_info f ON f.id = d.file_id
+		CROSS JOIN query
+		WHERE %s
+		ORDER BY rank DESC, f.created_at DESC
+		LIMIT %s OFFSET %s
+	)
+	SELECT id, name, created_at, updated_at, type, size, extension, user_id, user_type,
+		parent_id, path, folder_type, category, body_part, study_date, doctor_name,
+		owner_user_id, patient_id, uploaded_by_user_id, uploaded_by_role, included_in_rag,
+		rank, ts_headline(language, content, q, '%s')
+	FROM matches
+	ORDER BY rank DESC, created_at DESC`,
+		textsearch.Query(languages, "$1"), strings.Join(conditions, "\n\t\tAND "), limitParam, offsetParam,
+		textsearch.HeadlineOptions)
+	return query, args
+}
+
+// queueTextIndex schedules text extraction for a file, skipping types that
+// have no text to index.
+func queueTextIndex(db *pgxpool.Pool, fileID, name string, ext *string) {
+	if !textIndexable(fileExtension(name, ext)) {
+		return
+	}
+	newTextIndexQueue(db).enqueue(fileID)
+}
@@ -0,0 +1,90 @@
+package medicalrecords
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/pdf"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	assert.Equal(t, "arabic", detectLanguage("تحليل الدم: نسبة الهيموغلوبين طبيعية"))
+	assert.Equal(t, "french", detectLanguage("Le patient présente une fièvre depuis trois jours et une toux sèche."))
+	assert.Equal(t, "english", detectLanguage("The patient was admitted with chest pain and shortness of breath."))
+	assert.Equal(t, "simple", detectLanguage("12.5 / 140 - 90"))
+	assert.Equal(t, "french", detectLanguage("Glycémie à jeun: 0,95 g/L. Hémoglobine glyquée: 5,8 %"))
+}
+
+func TestExtractText_PlainTextReports(t *testing.T) {
+	text, err := extractText(strings.NewReader("\ufeffCholesterol,5.2\nGlucose,\x005.1\n"), ".csv")
+	require.NoError(t, err)
+	assert.Equal(t, "Cholesterol,5.2\nGlucose, 5.1\n", text)
+
+	// Latin-1 exports are not valid UTF-8.
+	text, err = extractText(strings.NewReader("Fi\xe8vre l\xe9g\xe8re"), "txt")
+	require.NoError(t, err)
+	assert.Equal(t, "Fièvre légère", text)
+
+	_, err = extractText(strings.NewReader(" \n\t "), "txt")
+	assert.True(t, errors.Is(err, errNoText), "got %v", err)
+}
+
+func TestExtractText_PDFWithoutHeaderIsUnsupported(t *testing.T) {
+	_, err := extractText(strings.NewReader("not really a pdf"), "pdf")
+	assert.True(t, errors.Is(err, pdf.ErrUnsupported), "got %v", err)
+}
+
+func TestPlainText_CutsAtRuneBoundary(t *testing.T) {
+	text := plainText([]byte(strings.Repeat("é", maxIndexedText)))
+	assert.Equal(t, maxIndexedText/2, len([]rune(text)))
+	assert.NotContains(t, text, "Ã", "A cut rune must not fall back to Latin-1")
+}
+
+func TestBuildDocumentSearchQuery_Patient(t *testing.T) {
+	query, args := buildDocumentSearchQuery([]string{"english", "simple"}, "p1", "", models.DocumentSearchRequest{Query: "blood test"})
+
+	assert.Contains(t, query, "websearch_to_tsquery('english', $1) || websearch_to_tsquery('simple', $1)")
+	assert.Contains(t, query, "(f.user_id = $2 OR f.patient_id = $2 OR f.uploaded_by_user_id = $2)")
+	assert.NotContains(t, query, "appointments")
+	assert.Contains(t, query, "LIMIT $3 OFFSET $4")
+	assert.Equal(t, []interface{}{"blood test", "p1", defaultSearchLimit, 0}, args)
+}
+
+func TestBuildDocumentSearchQuery_DoctorWithFilters(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	query, args := buildDocumentSearchQuery(searchLanguages, "d1", "d1", models.DocumentSearchRequest{
+		Query:     "fracture",
+		PatientID: "p9",
+		Category:  "IMAGING_XRAY",
+		BodyPart:  "Knee",
+		From:      &from,
+		To:        &to,
+		Limit:     500,
+		Offset:    40,
+	})
+
+	assert.Contains(t, query, "f.folder_type = 'CLINICAL' AND f.patient_id IN (")
+	assert.Contains(t, query, "WHERE doctor_id = $3 AND COALESCE(is_doctor_patient, false) = false")
+	assert.Contains(t, query, "f.patient_id = $4")
+	assert.Contains(t, query, "f.category = $5")
+	assert.Contains(t, query, "LOWER(f.body_part) = LOWER($6)")
+	assert.Contains(t, query, "COALESCE(f.study_date, f.created_at) >= $7")
+	assert.Contains(t, query, "COALESCE(f.study_date, f.created_at) < $8")
+	assert.Contains(t, query, "LIMIT $9 OFFSET $10")
+	assert.Equal(t, []interface{}{"fracture", "d1", "d1", "p9", "IMAGING_XRAY", "Knee", from, to, maxSearchLimit, 40}, args)
+}
+
+func TestTextIndexable(t *testing.T) {
+	assert.True(t, textIndexable("PDF"))
+	assert.True(t, textIndexable(".txt"))
+	assert.True(t, textIndexable("csv"))
+	assert.False(t, textIndexable("jpg"))
+	assert.False(t, textIndexable("dcm"))
+}
@@ -0,0 +1,832 @@
+package medicalrecords
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/filecheck"
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var (
+	// ErrInvalidArchive is returned for uploads that are not a readable zip.
+	ErrInvalidArchive = errors.New("invalid zip archive")
+	// ErrImportInProgress is returned when the same archive is already being
+	// imported into the same folder.
+	ErrImportInProgress = errors.New("this archive is already being imported")
+	ErrImportNotFound   = errors.New("import not found")
+)
+
+const (
+	maxImportEntries = 10000
+	maxImportListed  = 50
+	// importStaleAfter is how long a running import may go without progress
+	// before a worker or a new request for the same archive takes it over.
+	importStaleAfter = "15 minutes"
+	// zipFlagEncrypted is bit 0 of the zip general purpose flags.
+	zipFlagEncrypted = 0x1
+)
+
+// ImportService recreates the folder tree of a zip archive in a user's
+// records and uploads its files through the normal upload path. Archives are
+// stored when they are received and imported by a background worker; every
+// folder and file is recorded in record_import_entries, so sending the same
+// archive to the same folder again resumes an interrupted import.
+type ImportService struct {
+	db           *pgxpool.Pool
+	storage      storage.Storage
+	records      *MedicalRecordsService
+	pollInterval time.Duration
+}
+
+func NewImportService(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *ImportService {
+	return &ImportService{
+		db:           db,
+		storage:      store,
+		records:      NewMedicalRecordsService(db, cfg, store),
+		pollInterval: time.Duration(cfg.ImportPollSeconds) * time.Second,
+	}
+}
+
+// importItem is one file of the archive. path is its sanitized path in the
+// archive and identifies it across attempts.
+type importItem struct {
+	path     string
+	dir      string
+	name     string
+	file     *zip.File
+	category *models.Category
+	skip     string
+}
+
+type importPlan struct {
+	// folders holds sanitized folder paths, parents before children.
+	folders []string
+	files   []importItem
+}
+
+func (p *importPlan) total() int {
+	return len(p.folders) + len(p.files)
+}
+
+// importArchiveKey is where an archive waits for the worker. It is keyed by
+// what identifies an import, so sending the archive again overwrites it.
+func importArchiveKey(userID, digest string, parentID *string) string {
+	target := "root"
+	if parentID != nil {
+		target = *parentID
+	}
+	return path.Join("records", "imports", userID, digest+"-"+target+".zip")
+}
+
+// Queue checks and stores the archive to import under parentID, or at the
+// root of the user's records when it is nil, and returns the pending import.
+// The worker imports it in the background; its progress is read with Get.
+func (s *ImportService) Queue(userID, userType string, parentID *string, archiveName string, archive io.ReaderAt, size int64) (*models.RecordImport, error) {
+	digest, err := archiveDigest(archive, size)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	plan, err := planImport(reader.File)
+	if err != nil {
+		return nil, err
+	}
+
+	// The archive is stored before the import is queued, so the worker never
+	// claims an import whose archive is still arriving.
+	key := importArchiveKey(userID, digest, parentID)
+	if err := s.storage.Put(context.Background(), key, io.NewSectionReader(archive, 0, size), size, filecheck.TypeZip); err != nil {
+		return nil, fmt.Errorf("failed to store archive: %v", err)
+	}
+	importID, err := s.claimImport(userID, userType, parentID, filecheck.SanitizeFilename(archiveName), digest, key, plan.total())
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(userID, importID)
+}
+
+// Run imports queued archives until ctx is cancelled. A non-positive
+// IMPORT_POLL_SECONDS disables the worker.
+func (s *ImportService) Run(ctx context.Context) {
+	if s.pollInterval <= 0 {
+		log.Printf("Import worker disabled")
+		return
+	}
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			processed, err := s.ProcessNext(ctx)
+			if err != nil {
+				log.Printf("Import worker: %v", err)
+				break
+			}
+			if !processed {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// queuedImport is an import claimed by the worker.
+type queuedImport struct {
+	id         string
+	userID     string
+	userType   string
+	parentID   *string
+	archiveKey string
+}
+
+// ProcessNext claims the oldest pending import, or a running one that
+// stopped making progress, and imports it. It reports whether there was one.
+func (s *ImportService) ProcessNext(ctx context.Context) (bool, error) {
+	var imp queuedImport
+	err := s.db.QueryRow(ctx,
+		`UPDATE record_imports SET status = 'running', updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM record_imports
+			WHERE archive_key IS NOT NULL AND (status = 'pending'
+				OR (status = 'running' AND updated_at < NOW() - INTERVAL '`+importStaleAfter+`'))
+			ORDER BY updated_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id::text, user_id::text, user_type, parent_id::text, archive_key`).
+		Scan(&imp.id, &imp.userID, &imp.userType, &imp.parentID, &imp.archiveKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not claim import: %v", err)
+	}
+
+	status, err := s.importArchive(ctx, imp)
+	if err != nil {
+		log.Printf("Error importing archive %s: %v", imp.id, err)
+		status = models.RecordImportInterrupted
+	}
+	s.finishImport(imp.id, status)
+	// A finished import is resumed by sending the archive again, which
+	// stores it anew.
+	if err := s.storage.Delete(ctx, imp.archiveKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Warning: failed to remove archive %s: %v", imp.archiveKey, err)
+	}
+	return true, nil
+}
+
+// importArchive imports the stored archive. Entries imported by an earlier
+// attempt are kept; failed ones are retried. The import stops early, with
+// status interrupted, when the user runs out of storage.
+func (s *ImportService) importArchive(ctx context.Context, imp queuedImport) (models.RecordImportStatus, error) {
+	stored, err := s.storage.Get(ctx, imp.archiveKey)
+	if err != nil {
+		return "", fmt.Errorf("could not read archive: %v", err)
+	}
+	defer stored.Close()
+
+	// zip needs random access, which stored objects do not offer.
+	spool, err := os.CreateTemp("", "record-import-archive-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, stored)
+	if err != nil {
+		return "", fmt.Errorf("could not read archive: %v", err)
+	}
+
+	reader, err := zip.NewReader(spool, size)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	plan, err := planImport(reader.File)
+	if err != nil {
+		return "", err
+	}
+	previous, err := s.loadEntries(imp.id)
+	if err != nil {
+		return "", err
+	}
+	return s.runImport(imp.id, imp.userID, imp.userType, imp.parentID, plan, previous)
+}
+
+func (s *ImportService) runImport(importID, userID, userType string, parentID *string, plan *importPlan, previous map[string]models.RecordImportEntry) (models.RecordImportStatus, error) {
+	folderIDs := map[string]*string{"": parentID}
+	for _, dir := range plan.folders {
+		parent, ok := folderIDs[parentImportDir(dir)]
+		entry := models.RecordImportEntry{Path: dir, Kind: "folder"}
+		if !ok {
+			entry.Status = models.ImportEntryFailed
+			entry.Error = stringPtr("parent folder could not be created")
+		} else if folderID, err := s.importFolder(userID, userType, parent, path.Base(dir), previous[dir]); err != nil {
+			log.Printf("Error importing folder %q: %v", dir, err)
+			entry.Status = models.ImportEntryFailed
+			entry.Error = stringPtr("folder could not be created")
+		} else {
+			folderIDs[dir] = &folderID
+			entry.Status = models.ImportEntryImported
+			entry.ItemID = &folderID
+		}
+		if err := s.recordEntry(importID, entry); err != nil {
+			return "", err
+		}
+	}
+
+	for _, item := range plan.files {
+		if prev, ok := previous[item.path]; ok && prev.Status == models.ImportEntryImported {
+			continue
+		}
+		entry := models.RecordImportEntry{Path: item.path, Kind: "file", Category: item.category}
+		stop := false
+		folderID, ok := folderIDs[item.dir]
+		switch {
+		case item.skip != "":
+			entry.Status = models.ImportEntrySkipped
+			entry.Error = stringPtr(item.skip)
+		case !ok:
+			entry.Status = models.ImportEntryFailed
+			entry.Error = stringPtr("folder could not be created")
+		default:
+			fileInfo, err := s.importFile(userID, userType, folderID, item)
+			if err != nil {
+				var message string
+				message, stop = importFailure(item.path, err)
+				entry.Status = models.ImportEntryFailed
+				entry.Error = &message
+				break
+			}
+			entry.Status = models.ImportEntryImported
+			entry.ItemID = &fileInfo.ID
+			entry.StoredName = &fileInfo.Name
+			entry.Category = fileInfo.Category
+		}
+		if err := s.recordEntry(importID, entry); err != nil {
+			return "", err
+		}
+		if stop {
+			return models.RecordImportInterrupted, nil
+		}
+	}
+
+	return models.RecordImportCompleted, nil
+}
+
+// importFailure turns an upload error into the message shown in the report
+// and says whether the import has to stop.
+func importFailure(entryPath string, err error) (string, bool) {
+	var rejection *filecheck.Rejection
+	var quotaErr *QuotaExceededError
+	var entryErr *importEntryError
+	switch {
+	case errors.As(err, &quotaErr):
+		return "storage quota exceeded", true
+	case errors.As(err, &rejection):
+		return rejection.Reason, false
+	case errors.As(err, &entryErr):
+		return entryErr.message, false
+	}
+	log.Printf("Error importing %q: %v", entryPath, err)
+	return "file could not be stored", false
+}
+
+// importEntryError is a problem with the archive entry itself, reported to
+// the user as-is.
+type importEntryError struct {
+	message string
+}
+
+func (e *importEntryError) Error() string {
+	return e.message
+}
+
+// importFolder returns the folder to import dir into: the one created by an
+// earlier attempt, an existing folder of the same name, which the archive is
+// merged into, or a new one.
+func (s *ImportService) importFolder(userID, userType string, parentID *string, name string, previous models.RecordImportEntry) (string, error) {
+	ctx := context.Background()
+	if previous.Status == models.ImportEntryImported && previous.ItemID != nil {
+		var exists bool
+		err := s.db.QueryRow(ctx,
+			"SELECT EXISTS(SELECT 1 FROM folder_file_info WHERE id = $1::uuid AND user_id = $2 AND type = 'folder')",
+			*previous.ItemID, userID).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("could not check imported folder: %v", err)
+		}
+		if exists {
+			return *previous.ItemID, nil
+		}
+	}
+
+	var folderID string
+	err := s.db.QueryRow(ctx,
+		`SELECT id::text FROM folder_file_info
+		WHERE user_id = $1 AND parent_id IS NOT DISTINCT FROM $2::uuid AND name = $3
+			AND type = 'folder' AND folder_type = $4 AND shared_by_id IS NULL
+		LIMIT 1`,
+		userID, parentID, name, models.FolderTypePersonal).Scan(&folderID)
+	if err == nil {
+		return folderID, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("could not look up folder: %v", err)
+	}
+
+	folder := models.FileFolder{
+		Name:     name,
+		Type:     "folder",
+		UserID:   userID,
+		UserType: userType,
+		ParentID: parentID,
+	}
+	if err := s.records.CreateFolder(&folder); err != nil {
+		return "", err
+	}
+	return folder.ID, nil
+}
+
+// importFile spools the entry to a temporary file, which also verifies its
+// checksum, and uploads it. Files whose type does not fit the inferred
+// category are stored uncategorized rather than rejected.
+func (s *ImportService) importFile(userID, userType string, parentID *string, item importItem) (*models.FileFolder, error) {
+	spool, err := os.CreateTemp("", "record-import-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	entry, err := item.file.Open()
+	if err != nil {
+		return nil, &importEntryError{message: fmt.Sprintf("entry could not be read: %v", err)}
+	}
+	// One byte over the limit is enough for the validator to reject it.
+	limit := s.records.validator.MaxSizeForRole(userType) + 1
+	size, err := io.Copy(spool, io.LimitReader(entry, limit))
+	entry.Close()
+	if err != nil {
+		return nil, &importEntryError{message: fmt.Sprintf("entry is corrupted: %v", err)}
+	}
+
+	name, err := s.availableName(userID, parentID, filecheck.SanitizeFilename(item.name))
+	if err != nil {
+		return nil, err
+	}
+
+	upload := func(category *models.Category) (*models.FileFolder, error) {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to rewind upload: %v", err)
+		}
+		ext := strings.TrimPrefix(path.Ext(name), ".")
+		fileInfo := &models.FileFolder{
+			Name:             name,
+			Type:             "file",
+			Ext:              &ext,
+			UserID:           userID,
+			UserType:         userType,
+			ParentID:         parentID,
+			FolderType:       models.FolderTypePersonal,
+			Category:         category,
+			UploadedByUserID: &userID,
+			UploadedByRole:   &userType,
+		}
+		return fileInfo, s.records.UploadFile(fileInfo, spool, size, "")
+	}
+
+	fileInfo, err := upload(item.category)
+	var rejection *filecheck.Rejection
+	if item.category != nil && errors.As(err, &rejection) && rejection.Code == filecheck.RejectTypeNotAllowed {
+		fileInfo, err = upload(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo, nil
+}
+
+// availableName returns name, or "name (n).ext" when the folder already
+// holds an item called name.
+func (s *ImportService) availableName(userID string, parentID *string, name string) (string, error) {
+	rows, err := s.db.Query(context.Background(),
+		"SELECT name FROM folder_file_info WHERE user_id = $1 AND parent_id IS NOT DISTINCT FROM $2::uuid",
+		userID, parentID)
+	if err != nil {
+		return "", fmt.Errorf("could not list folder contents: %v", err)
+	}
+	defer rows.Close()
+
+	taken := map[string]bool{}
+	for rows.Next() {
+		var existing string
+		if err := rows.Scan(&existing); err != nil {
+			return "", fmt.Errorf("could not read folder contents: %v", err)
+		}
+		taken[strings.ToLower(existing)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("could not read folder contents: %v", err)
+	}
+	return uniqueImportName(name, taken), nil
+}
+
+func uniqueImportName(name string, taken map[string]bool) string {
+	if !taken[strings.ToLower(name)] {
+		return name
+	}
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		if !taken[strings.ToLower(candidate)] {
+			return candidate
+		}
+	}
+}
+
+// claimImport queues the import of this archive into this folder, or
+// re-queues an earlier one unless it is still queued or running.
+func (s *ImportService) claimImport(userID, userType string, parentID *string, archiveName, digest, archiveKey string, total int) (string, error) {
+	var importID string
+	err := s.db.QueryRow(context.Background(),
+		`INSERT INTO record_imports (user_id, user_type, parent_id, archive_name, archive_sha256, archive_key, total_entries, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 'pending')
+		ON CONFLICT (user_id, archive_sha256, COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'::uuid))
+		DO UPDATE SET status = 'pending', archive_name = EXCLUDED.archive_name, archive_key = EXCLUDED.archive_key,
+			total_entries = EXCLUDED.total_entries, updated_at = NOW(), completed_at = NULL
+		WHERE record_imports.status NOT IN ('pending', 'running')
+			OR (record_imports.status = 'running' AND record_imports.updated_at < NOW() - INTERVAL '`+importStaleAfter+`')
+		RETURNING id::text`,
+		userID, userType, parentID, archiveName, digest, archiveKey, total).Scan(&importID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrImportInProgress
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not start import: %v", err)
+	}
+	return importID, nil
+}
+
+// recordEntry stores the outcome of one entry and marks the import as making
+// progress.
+func (s *ImportService) recordEntry(importID string, entry models.RecordImportEntry) error {
+	_, err := s.db.Exec(context.Background(),
+		`WITH progress AS (
+			UPDATE record_imports SET updated_at = NOW() WHERE id = $1
+		)
+		INSERT INTO record_import_entries (import_id, entry_path, kind, status, item_id, stored_name, category, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (import_id, entry_path) DO UPDATE SET
+			kind = EXCLUDED.kind, status = EXCLUDED.status, item_id = EXCLUDED.item_id,
+			stored_name = EXCLUDED.stored_name, category = EXCLUDED.category, error = EXCLUDED.error,
+			attempts = record_import_entries.attempts + 1, updated_at = NOW()`,
+		importID, entry.Path, entry.Kind, entry.Status, entry.ItemID, entry.StoredName, entry.Category, entry.Error)
+	if err != nil {
+		return fmt.Errorf("could not record import entry: %v", err)
+	}
+	return nil
+}
+
+func (s *ImportService) finishImport(importID string, status models.RecordImportStatus) {
+	_, err := s.db.Exec(context.Background(),
+		`UPDATE record_imports
+		SET status = $2, updated_at = NOW(), completed_at = CASE WHEN $2 = 'completed' THEN NOW() END
+		WHERE id = $1`,
+		importID, string(status))
+	if err != nil {
+		log.Printf("Error finishing import %s: %v", importID, err)
+	}
+}
+
+func (s *ImportService) loadEntries(importID string) (map[string]models.RecordImportEntry, error) {
+	entries, err := s.listEntries(importID)
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]models.RecordImportEntry, len(entries))
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+	}
+	return byPath, nil
+}
+
+func (s *ImportService) listEntries(importID string) ([]models.RecordImportEntry, error) {
+	rows, err := s.db.Query(context.Background(),
+		`SELECT entry_path, kind, status, item_id::text, stored_name, category, error, attempts, updated_at
+		FROM record_import_entries
+		WHERE import_id = $1
+		ORDER BY entry_path`,
+		importID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list import entries: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []models.RecordImportEntry{}
+	for rows.Next() {
+		var entry models.RecordImportEntry
+		var status string
+		var category *string
+		if err := rows.Scan(&entry.Path, &entry.Kind, &status, &entry.ItemID, &entry.StoredName, &category, &entry.Error, &entry.Attempts, &entry.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("could not read import entry: %v", err)
+		}
+		entry.Status = models.ImportEntryStatus(status)
+		if category != nil {
+			c := models.Category(*category)
+			entry.Category = &c
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read import entries: %v", err)
+	}
+	return entries, nil
+}
+
+const recordImportQuery = `
+	SELECT i.id::text, i.archive_name, i.parent_id::text, i.status, i.total_entries,
+		i.created_at, i.updated_at, i.completed_at,
+		COUNT(e.entry_path) FILTER (WHERE e.status = 'imported'),
+		COUNT(e.entry_path) FILTER (WHERE e.status = 'failed'),
+		COUNT(e.entry_path) FILTER (WHERE e.status = 'skipped')
+	FROM record_imports i
+	LEFT JOIN record_import_entries e ON e.import_id = i.id`
+
+// List returns the user's most recent imports without their entries.
+func (s *ImportService) List(userID string) ([]models.RecordImport, error) {
+	rows, err := s.db.Query(context.Background(),
+		recordImportQuery+`
+		WHERE i.user_id = $1
+		GROUP BY i.id
+		ORDER BY i.created_at DESC
+		LIMIT $2`,
+		userID, maxImportListed)
+	if err != nil {
+		return nil, fmt.Errorf("could not list imports: %v", err)
+	}
+	defer rows.Close()
+
+	imports := []models.RecordImport{}
+	for rows.Next() {
+		imp, err := scanRecordImport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read import: %v", err)
+		}
+		imports = append(imports, *imp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read imports: %v", err)
+	}
+	return imports, nil
+}
+
+// Get returns the user's import with its per-entry report.
+func (s *ImportService) Get(userID, importID string) (*models.RecordImport, error) {
+	imp, err := scanRecordImport(s.db.QueryRow(context.Background(),
+		recordImportQuery+`
+		WHERE i.user_id = $1 AND i.id = $2::uuid
+		GROUP BY i.id`,
+		userID, importID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get import: %v", err)
+	}
+
+	imp.Entries, err = s.listEntries(importID)
+	if err != nil {
+		return nil, err
+	}
+	return imp, nil
+}
+
+func scanRecordImport(row pgx.Row) (*models.RecordImport, error) {
+	var imp models.RecordImport
+	var status string
+	err := row.Scan(&imp.ID, &imp.ArchiveName, &imp.ParentID, &status, &imp.TotalEntries,
+		&imp.CreatedAt, &imp.UpdatedAt, &imp.CompletedAt,
+		&imp.Imported, &imp.Failed, &imp.Skipped)
+	if err != nil {
+		return nil, err
+	}
+	imp.Status = models.RecordImportStatus(status)
+	imp.Pending = max(imp.TotalEntries-imp.Imported-imp.Failed-imp.Skipped, 0)
+	return &imp, nil
+}
+
+func archiveDigest(archive io.ReaderAt, size int64) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(archive, 0, size)); err != nil {
+		return "", fmt.Errorf("could not read archive: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// planImport lists the folders and files to import. Paths are sanitized one
+// segment at a time, so entries cannot escape the target folder. Operating
+// system metadata such as __MACOSX and .DS_Store is left out; entries that
+// cannot be imported are kept with a skip reason for the report.
+func planImport(files []*zip.File) (*importPlan, error) {
+	if len(files) > maxImportEntries {
+		return nil, fmt.Errorf("%w: the archive has more than %d entries", ErrInvalidArchive, maxImportEntries)
+	}
+
+	plan := &importPlan{}
+	folders := map[string]bool{}
+	seen := map[string]bool{}
+	for _, f := range files {
+		if isJunkImportEntry(f.Name) {
+			continue
+		}
+		segments, ok := importPathSegments(f.Name)
+		if !ok {
+			plan.files = append(plan.files, importItem{path: f.Name, name: path.Base(f.Name), skip: "unsafe path"})
+			continue
+		}
+		if len(segments) == 0 {
+			continue
+		}
+
+		dirSegments := segments
+		if !f.FileInfo().IsDir() {
+			dirSegments = segments[:len(segments)-1]
+		}
+		for i := 1; i <= len(dirSegments); i++ {
+			folders[strings.Join(dirSegments[:i], "/")] = true
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		item := importItem{
+			path: strings.Join(segments, "/"),
+			dir:  strings.Join(dirSegments, "/"),
+			name: segments[len(segments)-1],
+			file: f,
+		}
+		switch {
+		case seen[item.path]:
+			item.skip = "duplicate entry in archive"
+		case f.Mode()&fs.ModeSymlink != 0:
+			item.skip = "symbolic links are not imported"
+		case f.Flags&zipFlagEncrypted != 0:
+			item.skip = "encrypted entries are not supported"
+		default:
+			item.category = inferImportCategory(item.dir, item.name)
+		}
+		seen[item.path] = true
+		plan.files = append(plan.files, item)
+	}
+
+	for i := range plan.files {
+		if item := &plan.files[i]; item.skip == "" && folders[item.path] {
+			item.skip = "a folder of the same name exists in the archive"
+		}
+	}
+	for folder := range folders {
+		plan.folders = append(plan.folders, folder)
+	}
+	// A parent path is a prefix of its children's, so it sorts first.
+	sort.Strings(plan.folders)
+
+	return plan, nil
+}
+
+// importPathSegments splits a zip entry name into sanitized path segments.
+// Names that climb out of the archive with ".." are refused.
+func importPathSegments(name string) ([]string, bool) {
+	var segments []string
+	for _, segment := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return nil, false
+		}
+		segments = append(segments, filecheck.SanitizeFilename(segment))
+	}
+	return segments, true
+}
+
+func parentImportDir(dir string) string {
+	if i := strings.LastIndex(dir, "/"); i >= 0 {
+		return dir[:i]
+	}
+	return ""
+}
+
+func isJunkImportEntry(name string) bool {
+	for _, segment := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		switch strings.ToLower(segment) {
+		case "__macosx", ".ds_store", "thumbs.db", "desktop.ini":
+			return true
+		}
+		if strings.HasPrefix(segment, "._") {
+			return true
+		}
+	}
+	return false
+}
+
+// importCategoryKeywords maps words found in folder names to categories.
+// Rules are tried in order, so "PET-CT" is PET imaging.
+var importCategoryKeywords = []struct {
+	category models.Category
+	keywords []string
+}{
+	{models.CategoryImagingPET, []string{"pet", "tep", "petscan"}},
+	{models.CategoryImagingMammo, []string{"mammo", "mammogram", "mammograms", "mammography", "mammographie"}},
+	{models.CategoryImagingMRI, []string{"mri", "irm"}},
+	{models.CategoryImagingCT, []string{"ct", "ctscan", "scanner", "tdm", "tomodensitometrie"}},
+	{models.CategoryImagingXray, []string{"xray", "xrays", "radio", "radios", "radiograph", "radiographs", "radiography", "radiographie"}},
+	{models.CategoryImagingUS, []string{"ultrasound", "ultrasounds", "echo", "echography", "echographie", "sonography", "doppler"}},
+	{models.CategoryLabResults, []string{"lab", "labs", "laboratory", "blood", "bloodwork", "biology", "biologie", "analyses", "bilan"}},
+	{models.CategoryDischarge, []string{"discharge"}},
+	{models.CategoryClinicalReport, []string{"report", "reports", "consultation", "consultations", "compterendu"}},
+}
+
+// importCategoryByExtension covers files whose format names their category.
+// DICOM files are left uncategorized here: the upload path reads the
+// modality from their header.
+var importCategoryByExtension = map[string]models.Category{
+	".hl7": models.CategoryLabResults,
+}
+
+// inferImportCategory guesses a file's category from the closest folder
+// whose name gives one away, then from its extension.
+func inferImportCategory(dir, name string) *models.Category {
+	if dir != "" {
+		folders := strings.Split(dir, "/")
+		for i := len(folders) - 1; i >= 0; i-- {
+			if category, ok := categoryForFolderName(folders[i]); ok {
+				return &category
+			}
+		}
+	}
+	if category, ok := importCategoryByExtension[strings.ToLower(path.Ext(name))]; ok {
+		return &category
+	}
+	return nil
+}
+
+func categoryForFolderName(name string) (models.Category, bool) {
+	words := strings.FieldsFunc(strings.ToLower(removeAccents(name)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	// Also match words split by punctuation, such as "X-Ray" or "compte-rendu".
+	candidates := map[string]bool{}
+	for i, word := range words {
+		candidates[word] = true
+		if i+1 < len(words) {
+			candidates[word+words[i+1]] = true
+		}
+	}
+	for _, rule := range importCategoryKeywords {
+		for _, keyword := range rule.keywords {
+			if candidates[keyword] {
+				return rule.category, true
+			}
+		}
+	}
+	return "", false
+}
+
+func removeAccents(s string) string {
+	return strings.NewReplacer(
+		"à", "a", "â", "a", "ç", "c", "é", "e", "è", "e", "ê", "e", "ë", "e",
+		"î", "i", "ï", "i", "ô", "o", "ù", "u", "û", "u", "ü", "u",
+		"É", "E", "È", "E",
+	).Replace(s)
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
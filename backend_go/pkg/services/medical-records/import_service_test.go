@@ -0,0 +1,138 @@
+package medicalrecords
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildTestZip(t *testing.T, names ...string) []*zip.File {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		_, err = f.Write([]byte("content of " + name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	return r.File
+}
+
+func TestPlanImport(t *testing.T) {
+	files := buildTestZip(t,
+		"Records/",
+		"Records/MRI 2023/knee.dcm",
+		"Records/Blood tests/cbc.pdf",
+		"Records/notes.txt",
+		"Records/.DS_Store",
+		"__MACOSX/Records/._notes.txt",
+		"../escape.txt",
+		"Records/a:b.pdf",
+		"Records/a_b.pdf",
+		"Empty/",
+	)
+
+	plan, err := planImport(files)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Empty", "Records", "Records/Blood tests", "Records/MRI 2023"}, plan.folders)
+
+	byPath := map[string]importItem{}
+	for _, item := range plan.files {
+		byPath[item.path] = item
+	}
+	require.Len(t, plan.files, 6)
+
+	assert.Equal(t, "unsafe path", byPath["../escape.txt"].skip)
+	assert.Equal(t, "Records/MRI 2023", byPath["Records/MRI 2023/knee.dcm"].dir)
+	assert.Equal(t, models.CategoryImagingMRI, *byPath["Records/MRI 2023/knee.dcm"].category)
+	assert.Equal(t, models.CategoryLabResults, *byPath["Records/Blood tests/cbc.pdf"].category)
+	assert.Nil(t, byPath["Records/notes.txt"].category)
+	assert.Equal(t, "Records/a_b.pdf", plan.files[len(plan.files)-2].path)
+	assert.Empty(t, plan.files[len(plan.files)-2].skip)
+	assert.Equal(t, "duplicate entry in archive", plan.files[len(plan.files)-1].skip)
+	assert.Equal(t, 10, plan.total())
+}
+
+func TestPlanImportFileShadowedByFolder(t *testing.T) {
+	plan, err := planImport(buildTestZip(t, "scans", "scans/ct.pdf"))
+	require.NoError(t, err)
+
+	require.Len(t, plan.files, 2)
+	assert.Equal(t, "a folder of the same name exists in the archive", plan.files[0].skip)
+	assert.Empty(t, plan.files[1].skip)
+}
+
+func TestImportPathSegments(t *testing.T) {
+	segments, ok := importPathSegments(`Scans\2023\./chest*.png`)
+	require.True(t, ok)
+	assert.Equal(t, []string{"Scans", "2023", "chest_.png"}, segments)
+
+	segments, ok = importPathSegments("/etc/passwd")
+	require.True(t, ok)
+	assert.Equal(t, []string{"etc", "passwd"}, segments)
+
+	_, ok = importPathSegments("a/../../b")
+	assert.False(t, ok)
+}
+
+func TestIsJunkImportEntry(t *testing.T) {
+	for _, name := range []string{"__MACOSX/a/b.pdf", "x/.DS_Store", "Thumbs.db", "a/desktop.ini", "a/._b.pdf"} {
+		assert.True(t, isJunkImportEntry(name), name)
+	}
+	for _, name := range []string{"a/b.pdf", "macosx/notes.txt", ".hidden/report.pdf"} {
+		assert.False(t, isJunkImportEntry(name), name)
+	}
+}
+
+func TestInferImportCategory(t *testing.T) {
+	tests := []struct {
+		dir, name string
+		want      *models.Category
+	}{
+		{"Imaging/X-Ray", "hand.jpg", ptr(models.CategoryImagingXray)},
+		{"PET-CT", "scan.pdf", ptr(models.CategoryImagingPET)},
+		{"Hospital/Échographie", "abdomen.pdf", ptr(models.CategoryImagingUS)},
+		{"Labs/2024 MRI", "brain.dcm", ptr(models.CategoryImagingMRI)},
+		{"MRI/Lab work", "panel.pdf", ptr(models.CategoryLabResults)},
+		{"Compte-rendu", "visite.pdf", ptr(models.CategoryClinicalReport)},
+		{"Discharge summaries", "stay.pdf", ptr(models.CategoryDischarge)},
+		{"Doctor letters", "note.pdf", nil},
+		{"Protect", "file.pdf", nil},
+		{"", "results.hl7", ptr(models.CategoryLabResults)},
+		{"", "knee.dcm", nil},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, inferImportCategory(tt.dir, tt.name), tt.dir+"/"+tt.name)
+	}
+}
+
+func TestUniqueImportName(t *testing.T) {
+	taken := map[string]bool{"report.pdf": true, "report (1).pdf": true, "notes": true}
+
+	assert.Equal(t, "scan.pdf", uniqueImportName("scan.pdf", taken))
+	assert.Equal(t, "Report (2).pdf", uniqueImportName("Report.pdf", taken))
+	assert.Equal(t, "notes (1)", uniqueImportName("notes", taken))
+}
+
+func TestImportArchiveKey(t *testing.T) {
+	folderID := "7b0c3c1e-4a56-4f0e-9a53-2f1d5e0a9c11"
+
+	// The archive lies under the user's records, so it is encrypted for them.
+	assert.Equal(t, "records/imports/u1/abc-root.zip", importArchiveKey("u1", "abc", nil))
+	assert.Equal(t, "records/imports/u1/abc-"+folderID+".zip", importArchiveKey("u1", "abc", &folderID))
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,15 +14,32 @@ import (
 	"healthcare_backend/pkg/config"
 )
 
+// ErrNoAIConsent is returned for documents the patient has not allowed the
+// AI assistant to use.
+var ErrNoAIConsent = errors.New("patient has not consented to AI use of this document")
+
+// ConsentChecker decides whether documents may be sent for ingestion.
+type ConsentChecker interface {
+	// CheckIngestionConsent returns ErrNoAIConsent unless every key belongs
+	// to a document of the patient that may be indexed.
+	CheckIngestionConsent(ctx context.Context, patientID string, s3Keys []string) error
+}
+
 type IngestionClient struct {
 	baseURL    string
 	httpClient *http.Client
+	consent    ConsentChecker
+	// links, when set, gives the service a download link for every
+	// document, for blobs it could not read from the bucket itself.
+	links DocumentLinker
 }
 
 type IngestionRequest struct {
 	PatientID string   `json:"patient_id"`
 	DoctorID  string   `json:"doctor_id"`
 	S3Keys    []string `json:"s3_keys"`
+	// DownloadURLs maps S3 keys to the links to download them from.
+	DownloadURLs map[string]string `json:"download_urls,omitempty"`
 }
 
 type IngestionResponse struct {
@@ -31,74 +49,133 @@ type IngestionResponse struct {
 	FailedIngests     int    `json:"failed_ingests"`
 }
 
-func NewIngestionClient(cfg *config.Config) *IngestionClient {
+// NewIngestionClient returns a client that only sends documents consent
+// allows. Without a ConsentChecker it refuses every document.
+func NewIngestionClient(cfg *config.Config, consent ConsentChecker) *IngestionClient {
 	return &IngestionClient{
 		baseURL: cfg.PythonAPIBaseURL,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		consent: consent,
 	}
 }
 
+// IngestionError is an ingestion call the service answered with an error
+// status. Client errors other than timeouts and rate limiting are permanent:
+// sending the same request again cannot succeed. A missing route is not: it
+// means the ingestion service is older than this backend, and the job must
+// stay visible as failing until it is upgraded.
+type IngestionError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *IngestionError) Error() string {
+	return fmt.Sprintf("ingestion API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *IngestionError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusNotFound, http.StatusMethodNotAllowed:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
 func (c *IngestionClient) TriggerIngestion(ctx context.Context, patientID, doctorID string, s3Keys []string) error {
 	if len(s3Keys) == 0 {
 		log.Printf("No S3 keys provided for ingestion, skipping")
 		return nil
 	}
 
-	endpoint := fmt.Sprintf("%s/api/v1/medical-records/ingest-to-qdrant", c.baseURL)
+	if c.consent == nil {
+		return ErrNoAIConsent
+	}
+	if err := c.consent.CheckIngestionConsent(ctx, patientID, s3Keys); err != nil {
+		return err
+	}
+
+	log.Printf("Triggering Python API ingestion for patient %s, doctor %s, files: %v", patientID, doctorID, s3Keys)
 
 	request := IngestionRequest{
 		PatientID: patientID,
 		DoctorID:  doctorID,
 		S3Keys:    s3Keys,
 	}
+	if c.links != nil {
+		links, err := c.links.DocumentLinks(ctx, s3Keys)
+		if err != nil {
+			return err
+		}
+		request.DownloadURLs = links
+	}
+
+	body, err := c.post(ctx, "ingest-to-qdrant", request)
+	if err != nil {
+		return err
+	}
+
+	var ingestionResp IngestionResponse
+	if err := json.Unmarshal(body, &ingestionResp); err != nil {
+		log.Printf("Warning: failed to parse ingestion response: %v", err)
+		return nil
+	}
+	log.Printf("Ingestion completed: %d/%d files successfully ingested",
+		ingestionResp.SuccessfulIngests, ingestionResp.TotalFiles)
+	if ingestionResp.FailedIngests > 0 {
+		return fmt.Errorf("ingestion API failed to ingest %d of %d files: %s",
+			ingestionResp.FailedIngests, ingestionResp.TotalFiles, ingestionResp.Message)
+	}
+
+	return nil
+}
+
+// RemoveDocuments asks the ingestion service to drop the documents from the
+// patient's index. Removing documents that are not indexed succeeds.
+func (c *IngestionClient) RemoveDocuments(ctx context.Context, patientID, doctorID string, s3Keys []string) error {
+	if len(s3Keys) == 0 {
+		return nil
+	}
+
+	log.Printf("Requesting Python API removal for patient %s, doctor %s, files: %v", patientID, doctorID, s3Keys)
+
+	_, err := c.post(ctx, "remove-from-qdrant", IngestionRequest{
+		PatientID: patientID,
+		DoctorID:  doctorID,
+		S3Keys:    s3Keys,
+	})
+	return err
+}
+
+func (c *IngestionClient) post(ctx context.Context, action string, request IngestionRequest) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/medical-records/%s", c.baseURL, action)
 
 	jsonData, err := json.Marshal(request)
 	if err != nil {
-		return fmt.Errorf("failed to marshal ingestion request: %w", err)
+		return nil, fmt.Errorf("failed to marshal %s request: %w", action, err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create ingestion request: %w", err)
+		return nil, fmt.Errorf("failed to create %s request: %w", action, err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	log.Printf("Triggering Python API ingestion for patient %s, doctor %s, files: %v", patientID, doctorID, s3Keys)
-
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to call Python API ingestion endpoint: %w", err)
+		return nil, fmt.Errorf("failed to call Python API %s endpoint: %w", action, err)
 	}
 	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		log.Printf("Python API ingestion failed with status %d: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("ingestion API returned status %d: %s", resp.StatusCode, string(body))
+		log.Printf("Python API %s failed with status %d: %s", action, resp.StatusCode, string(body))
+		return nil, &IngestionError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
-	var ingestionResp IngestionResponse
-	if err := json.Unmarshal(body, &ingestionResp); err != nil {
-		log.Printf("Warning: failed to parse ingestion response: %v", err)
-	} else {
-		log.Printf("Ingestion completed: %d/%d files successfully ingested",
-			ingestionResp.SuccessfulIngests, ingestionResp.TotalFiles)
-	}
-
-	return nil
-}
-
-func (c *IngestionClient) TriggerIngestionAsync(patientID, doctorID string, s3Keys []string) {
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
-
-		if err := c.TriggerIngestion(ctx, patientID, doctorID, s3Keys); err != nil {
-			log.Printf("Async ingestion failed: %v", err)
-		}
-	}()
+	return body, nil
 }
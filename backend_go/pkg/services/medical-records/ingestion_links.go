@@ -0,0 +1,107 @@
+package medicalrecords
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"healthcare_backend/pkg/storage"
+	"healthcare_backend/pkg/utils"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ingestionDocumentLinkTTL is how long the ingestion service may take to
+// download the documents of a request it was sent.
+const ingestionDocumentLinkTTL = ingestionTimeout
+
+// ErrIngestionLinkNotFound is returned for document links that do not exist
+// or have expired.
+var ErrIngestionLinkNotFound = errors.New("ingestion document link not found")
+
+// DocumentLinker issues links the ingestion service downloads documents
+// through, instead of reading them from the bucket.
+type DocumentLinker interface {
+	DocumentLinks(ctx context.Context, s3Keys []string) (map[string]string, error)
+}
+
+// ingestionLinks links documents to the decrypting download endpoint. It is
+// used while records are encrypted, since the ingestion service has no data
+// keys.
+type ingestionLinks struct {
+	db      *pgxpool.Pool
+	baseURL string
+}
+
+func hashIngestionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (l *ingestionLinks) DocumentLinks(ctx context.Context, s3Keys []string) (map[string]string, error) {
+	if _, err := l.db.Exec(ctx, "DELETE FROM ingestion_document_links WHERE expires_at < NOW()"); err != nil {
+		log.Printf("Warning: failed to prune expired ingestion document links: %v", err)
+	}
+
+	expiresAt := time.Now().Add(ingestionDocumentLinkTTL)
+	links := make(map[string]string, len(s3Keys))
+	for _, key := range s3Keys {
+		token, err := utils.GenerateSecureToken(32)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := l.db.Exec(ctx,
+			`INSERT INTO ingestion_document_links (token_hash, storage_key, expires_at)
+			VALUES ($1, $2, $3)`,
+			hashIngestionToken(token), key, expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to create ingestion document link: %v", err)
+		}
+		links[key] = strings.TrimSuffix(l.baseURL, "/") + "/api/v1/records/ingestion/documents/" + token
+	}
+	return links, nil
+}
+
+// LinkedDocument is the file a document link was issued for and the doctor
+// whose ingestion request it was sent with.
+type LinkedDocument struct {
+	FileID   string
+	DoctorID string
+}
+
+// OpenLinkedDocument redeems a document link issued to the ingestion
+// service and returns the decrypted document.
+func (s *IngestionService) OpenLinkedDocument(token string) (io.ReadCloser, *LinkedDocument, error) {
+	ctx := context.Background()
+	var key string
+	var document LinkedDocument
+	err := s.db.QueryRow(ctx,
+		`SELECT l.storage_key, f.id::text, j.doctor_id
+		FROM ingestion_document_links l
+		JOIN folder_file_info f ON f.path = l.storage_key AND f.type <> 'folder'
+		JOIN rag_ingestion_jobs j ON j.file_id = f.id
+		WHERE l.token_hash = $1 AND l.expires_at > NOW()
+		LIMIT 1`,
+		hashIngestionToken(token)).Scan(&key, &document.FileID, &document.DoctorID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, ErrIngestionLinkNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up ingestion document link: %v", err)
+	}
+
+	content, err := s.storage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, ErrIngestionLinkNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read document: %v", err)
+	}
+	return content, &document, nil
+}
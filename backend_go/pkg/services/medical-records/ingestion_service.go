@@ -0,0 +1,275 @@
+package medicalrecords
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	maxIngestionAttempts = 8
+	ingestionBatchSize   = 5
+	ingestionStaleAfter  = 10 * time.Minute
+	ingestionTimeout     = 2 * time.Minute
+
+	ingestionBaseBackoff = 30 * time.Second
+	ingestionMaxBackoff  = 6 * time.Hour
+)
+
+// IngestionService sends clinical documents to the RAG ingestion service.
+// Documents are queued in rag_ingestion_jobs when they are uploaded and a
+// worker delivers them, retrying with exponential backoff while the service
+// is unavailable. A document's included_in_rag flag is only set once the
+// service has accepted it. When a patient withdraws consent the same jobs
+// carry the removal of their documents from the index.
+type IngestionService struct {
+	db           *pgxpool.Pool
+	storage      storage.Storage
+	client       *IngestionClient
+	queue        *fileJobQueue
+	pollInterval time.Duration
+}
+
+func NewIngestionService(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *IngestionService {
+	client := NewIngestionClient(cfg, NewAIConsentService(db))
+	if cfg.RecordEncryptionKey != "" {
+		client.links = &ingestionLinks{db: db, baseURL: cfg.IngestionDocumentBaseURL}
+	}
+	return &IngestionService{
+		db:           db,
+		storage:      store,
+		client:       client,
+		queue:        newIngestionQueue(db),
+		pollInterval: time.Duration(cfg.RAGIngestionPollSeconds) * time.Second,
+	}
+}
+
+func newIngestionQueue(db *pgxpool.Pool) *fileJobQueue {
+	return &fileJobQueue{
+		db:          db,
+		table:       "rag_ingestion_jobs",
+		kind:        "RAG ingestion",
+		batchSize:   ingestionBatchSize,
+		maxAttempts: maxIngestionAttempts,
+		staleAfter:  ingestionStaleAfter,
+		retryStatus: string(models.IngestionFailed),
+		backoff:     ingestionBackoff,
+	}
+}
+
+// ingestionBackoff doubles the delay after every failed attempt, starting at
+// ingestionBaseBackoff and capped at ingestionMaxBackoff.
+func ingestionBackoff(attempts int) time.Duration {
+	delay := ingestionBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= ingestionMaxBackoff {
+			return ingestionMaxBackoff
+		}
+	}
+	return delay
+}
+
+// Run delivers queued documents until ctx is cancelled. A non-positive
+// RAG_INGESTION_POLL_SECONDS disables the worker.
+func (s *IngestionService) Run(ctx context.Context) {
+	if s.pollInterval <= 0 {
+		log.Printf("RAG ingestion worker disabled")
+		return
+	}
+	s.queue.run(ctx, s.pollInterval, s.process)
+}
+
+// ProcessBatch claims up to ingestionBatchSize due jobs and delivers their
+// documents. It returns how many jobs it claimed.
+func (s *IngestionService) ProcessBatch(ctx context.Context) (int, error) {
+	return s.queue.processBatch(ctx, s.process)
+}
+
+func (s *IngestionService) process(ctx context.Context, job fileJob) {
+	var patientID, doctorID, operation string
+	err := s.db.QueryRow(ctx,
+		"SELECT patient_id, doctor_id, operation FROM rag_ingestion_jobs WHERE file_id = $1",
+		job.fileID).Scan(&patientID, &doctorID, &operation)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			s.queue.retryOrFail(job, fmt.Errorf("could not load ingestion job: %v", err), string(models.IngestionDeadLetter))
+		}
+		return
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, ingestionTimeout)
+	if models.IngestionOperation(operation) == models.IngestionOperationRemove {
+		err = s.client.RemoveDocuments(callCtx, patientID, doctorID, []string{job.path})
+	} else {
+		err = s.client.TriggerIngestion(callCtx, patientID, doctorID, []string{job.path})
+	}
+	cancel()
+	if err != nil {
+		var ingestionErr *IngestionError
+		if errors.Is(err, ErrNoAIConsent) {
+			s.queue.finish(job, string(models.IngestionWithdrawn), err)
+		} else if errors.As(err, &ingestionErr) && ingestionErr.Permanent() {
+			s.queue.finish(job, string(models.IngestionDeadLetter), err)
+		} else {
+			s.queue.retryOrFail(job, err, string(models.IngestionDeadLetter))
+		}
+		return
+	}
+
+	status := models.IngestionSucceeded
+	assignments := "succeeded_at = NOW()"
+	if models.IngestionOperation(operation) == models.IngestionOperationRemove {
+		status = models.IngestionWithdrawn
+		assignments = ""
+	}
+	if err := s.recordDelivery(ctx, job, operation, status, assignments); err != nil {
+		s.queue.retryOrFail(job, err, string(models.IngestionDeadLetter))
+	}
+}
+
+// recordDelivery completes the job and sets the file's RAG flag in one
+// transaction. A job re-queued while the service handled it keeps the flag
+// as it was. If that happens to a delivered ingestion the document is in the
+// index whatever the flag says, so unless the patient still consents to a
+// pending re-ingestion, its removal is queued.
+func (s *IngestionService) recordDelivery(ctx context.Context, job fileJob, operation string, status models.IngestionStatus, assignments string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	completed, err := s.queue.completeIn(ctx, tx, job, string(status), assignments)
+	if err != nil {
+		return fmt.Errorf("could not record ingestion result: %v", err)
+	}
+	if completed {
+		if _, err := tx.Exec(ctx,
+			"UPDATE folder_file_info SET included_in_rag = $1 WHERE id = $2",
+			status == models.IngestionSucceeded, job.fileID); err != nil {
+			return fmt.Errorf("could not update file RAG flag: %v", err)
+		}
+	} else if models.IngestionOperation(operation) == models.IngestionOperationIngest {
+		_, err := tx.Exec(ctx,
+			`UPDATE rag_ingestion_jobs j
+			SET operation = $1, status = $2, attempts = 0, last_error = NULL, next_attempt_at = NOW(), updated_at = NOW()
+			FROM folder_file_info f
+			LEFT JOIN patient_ai_consent c ON c.patient_id = f.patient_id
+			WHERE j.file_id = $3 AND f.id = j.file_id
+				AND NOT (j.operation = $1 AND j.status = $2)
+				AND NOT (j.operation = $4 AND j.status = $2 AND f.ai_consent AND COALESCE(c.rag_enabled, true))`,
+			string(models.IngestionOperationRemove), string(models.IngestionPending), job.fileID,
+			string(models.IngestionOperationIngest))
+		if err != nil {
+			return fmt.Errorf("could not queue removal of re-queued document: %v", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+// ListJobs returns the ingestion status of the documents the doctor's
+// practice shared with the patient, most recently queued first.
+func (s *IngestionService) ListJobs(doctorID, patientID string) ([]models.IngestionJob, error) {
+	rows, err := s.db.Query(context.Background(),
+		`SELECT j.file_id::text, f.name, f.category, j.patient_id, j.doctor_id, j.operation, j.status, j.attempts,
+			j.last_error, j.next_attempt_at, j.succeeded_at, j.created_at, j.updated_at
+		FROM rag_ingestion_jobs j
+		JOIN folder_file_info f ON f.id = j.file_id
+		WHERE j.doctor_id = $1 AND j.patient_id = $2
+		ORDER BY j.created_at DESC`,
+		doctorID, patientID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list ingestion jobs: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := []models.IngestionJob{}
+	for rows.Next() {
+		var job models.IngestionJob
+		var operation, status string
+		if err := rows.Scan(&job.FileID, &job.FileName, &job.Category, &job.PatientID, &job.DoctorID, &operation, &status, &job.Attempts,
+			&job.LastError, &job.NextAttemptAt, &job.SucceededAt, &job.CreatedAt, &job.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("could not read ingestion job: %v", err)
+		}
+		job.Operation = models.IngestionOperation(operation)
+		job.Status = models.IngestionStatus(status)
+		if job.Status != models.IngestionPending && job.Status != models.IngestionFailed {
+			job.NextAttemptAt = nil
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list ingestion jobs: %v", err)
+	}
+	return jobs, nil
+}
+
+// Retry schedules the patient's failed and dead-lettered jobs for an
+// immediate attempt with a fresh set of retries. An empty fileID retries
+// every such job for the patient. It returns how many jobs were queued.
+func (s *IngestionService) Retry(doctorID, patientID, fileID string) (int64, error) {
+	tag, err := s.db.Exec(context.Background(),
+		`UPDATE rag_ingestion_jobs
+		SET status = $1, attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE doctor_id = $2 AND patient_id = $3 AND status IN ($4, $5)
+			AND ($6 = '' OR file_id::text = $6)`,
+		string(models.IngestionPending), doctorID, patientID, string(models.IngestionFailed), string(models.IngestionDeadLetter), fileID)
+	if err != nil {
+		return 0, fmt.Errorf("could not retry ingestion jobs: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// queueIngestion schedules a clinical document for RAG ingestion under the
+// doctor responsible for it: the uploader, or the doctor a receptionist
+// works for. Documents without one are not ingested.
+func queueIngestion(db *pgxpool.Pool, fileInfo *models.FileFolder) {
+	if fileInfo.FolderType != models.FolderTypeClinical || fileInfo.PatientID == nil ||
+		fileInfo.UploadedByUserID == nil || fileInfo.UploadedByRole == nil {
+		return
+	}
+
+	doctorID := *fileInfo.UploadedByUserID
+	switch *fileInfo.UploadedByRole {
+	case "doctor":
+	case "receptionist":
+		var assignedDoctorID sql.NullString
+		err := db.QueryRow(context.Background(),
+			"SELECT assigned_doctor_id FROM receptionists WHERE receptionist_id = $1",
+			doctorID).Scan(&assignedDoctorID)
+		if err != nil || !assignedDoctorID.Valid {
+			log.Printf("Warning: no doctor to ingest %s for receptionist %s: %v", fileInfo.ID, doctorID, err)
+			return
+		}
+		doctorID = assignedDoctorID.String
+	default:
+		return
+	}
+
+	_, err := db.Exec(context.Background(),
+		`INSERT INTO rag_ingestion_jobs (file_id, patient_id, doctor_id, operation, status) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (file_id) DO UPDATE
+		SET patient_id = EXCLUDED.patient_id, doctor_id = EXCLUDED.doctor_id, operation = EXCLUDED.operation, status = EXCLUDED.status,
+			attempts = 0, last_error = NULL, next_attempt_at = NOW(), succeeded_at = NULL, updated_at = NOW()`,
+		fileInfo.ID, *fileInfo.PatientID, doctorID, string(models.IngestionOperationIngest), string(models.IngestionPending))
+	if err != nil {
+		log.Printf("Warning: failed to queue RAG ingestion for %s: %v", fileInfo.ID, err)
+		return
+	}
+	log.Printf("Queued medical record ingestion for patient %s, file: %s", *fileInfo.PatientID, fileInfo.Path)
+}
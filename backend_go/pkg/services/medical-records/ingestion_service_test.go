@@ -0,0 +1,198 @@
+package medicalrecords
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// consentStub allows every document except the keys it lists.
+type consentStub map[string]bool
+
+func (c consentStub) CheckIngestionConsent(_ context.Context, _ string, s3Keys []string) error {
+	for _, key := range s3Keys {
+		if c[key] {
+			return ErrNoAIConsent
+		}
+	}
+	return nil
+}
+
+// ingestionStandIn serves the ingestion endpoints of the Python service with
+// a fixed status and body, recording the requests it receives.
+func ingestionStandIn(t *testing.T, status int, body string) (*IngestionClient, *[]IngestionRequest) {
+	client, received, _ := ingestionStandInWithConsent(t, status, body, consentStub{})
+	return client, received
+}
+
+// ingestionRoutes are the routes of doctor_ai_assistant's medical records
+// router. The stand-in answers 404 for any other path, as FastAPI does.
+var ingestionRoutes = map[string]bool{
+	"/api/v1/medical-records/ingest-to-qdrant":   true,
+	"/api/v1/medical-records/remove-from-qdrant": true,
+}
+
+// ingestionStandInWithConsent is ingestionStandIn with a consent checker. It
+// also records the paths called.
+func ingestionStandInWithConsent(t *testing.T, status int, body string, consent ConsentChecker) (*IngestionClient, *[]IngestionRequest, *[]string) {
+	var received []IngestionRequest
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if !ingestionRoutes[r.URL.Path] {
+			http.Error(w, `{"detail":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var req IngestionRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		received = append(received, req)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return NewIngestionClient(&config.Config{PythonAPIBaseURL: server.URL}, consent), &received, &paths
+}
+
+func TestTriggerIngestion_Succeeds(t *testing.T) {
+	client, received, paths := ingestionStandInWithConsent(t, http.StatusOK,
+		`{"message":"ok","total_files":1,"successful_ingests":1,"failed_ingests":0}`, consentStub{})
+
+	err := client.TriggerIngestion(context.Background(), "p1", "d1", []string{"records/medical-records/p1/Labs/cbc.pdf"})
+	require.NoError(t, err)
+	assert.Equal(t, []IngestionRequest{{PatientID: "p1", DoctorID: "d1", S3Keys: []string{"records/medical-records/p1/Labs/cbc.pdf"}}}, *received)
+	assert.Equal(t, []string{"/api/v1/medical-records/ingest-to-qdrant"}, *paths)
+}
+
+// linkStub links every key to a fixed download endpoint.
+type linkStub string
+
+func (l linkStub) DocumentLinks(_ context.Context, s3Keys []string) (map[string]string, error) {
+	links := map[string]string{}
+	for _, key := range s3Keys {
+		links[key] = string(l) + "/" + key
+	}
+	return links, nil
+}
+
+func TestTriggerIngestion_SendsDownloadLinks(t *testing.T) {
+	// Encrypted blobs cannot be read from the bucket, so the service is
+	// given a link to download each document from.
+	client, received, _ := ingestionStandInWithConsent(t, http.StatusOK,
+		`{"message":"ok","total_files":1,"successful_ingests":1,"failed_ingests":0}`, consentStub{})
+	client.links = linkStub("https://api.example/documents")
+
+	err := client.TriggerIngestion(context.Background(), "p1", "d1", []string{"records/medical-records/p1/Labs/cbc.pdf"})
+	require.NoError(t, err)
+	require.Len(t, *received, 1)
+	assert.Equal(t, map[string]string{
+		"records/medical-records/p1/Labs/cbc.pdf": "https://api.example/documents/records/medical-records/p1/Labs/cbc.pdf",
+	}, (*received)[0].DownloadURLs)
+}
+
+func TestTriggerIngestion_RefusesWithoutConsent(t *testing.T) {
+	client, received, _ := ingestionStandInWithConsent(t, http.StatusOK, `{}`,
+		consentStub{"records/medical-records/p1/Labs/hiv.pdf": true})
+
+	err := client.TriggerIngestion(context.Background(), "p1", "d1",
+		[]string{"records/medical-records/p1/Labs/cbc.pdf", "records/medical-records/p1/Labs/hiv.pdf"})
+	assert.True(t, errors.Is(err, ErrNoAIConsent), "got %v", err)
+	assert.Empty(t, *received, "Nothing is sent when any document lacks consent")
+
+	client, received, _ = ingestionStandInWithConsent(t, http.StatusOK, `{}`, nil)
+	err = client.TriggerIngestion(context.Background(), "p1", "d1", []string{"records/medical-records/p1/Labs/cbc.pdf"})
+	assert.True(t, errors.Is(err, ErrNoAIConsent), "A client without a consent checker refuses, got %v", err)
+	assert.Empty(t, *received)
+}
+
+func TestRemoveDocuments(t *testing.T) {
+	// Removal is never gated on consent: it is how withdrawn consent is
+	// honoured.
+	client, received, paths := ingestionStandInWithConsent(t, http.StatusOK, `{"message":"removed"}`, nil)
+
+	err := client.RemoveDocuments(context.Background(), "p1", "d1", []string{"records/medical-records/p1/Labs/hiv.pdf"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/api/v1/medical-records/remove-from-qdrant"}, *paths)
+	assert.Equal(t, []IngestionRequest{{PatientID: "p1", DoctorID: "d1", S3Keys: []string{"records/medical-records/p1/Labs/hiv.pdf"}}}, *received)
+
+	client, _, _ = ingestionStandInWithConsent(t, http.StatusBadGateway, `upstream down`, nil)
+	err = client.RemoveDocuments(context.Background(), "p1", "d1", []string{"a.pdf"})
+	var ingestionErr *IngestionError
+	require.True(t, errors.As(err, &ingestionErr), "got %v", err)
+	assert.False(t, ingestionErr.Permanent())
+}
+
+func TestRemoveDocuments_MissingRouteIsRetryable(t *testing.T) {
+	// An ingestion service without the removal route must leave the job
+	// failing visibly rather than dead-letter it.
+	client, _, _ := ingestionStandInWithConsent(t, http.StatusOK, `{}`, nil)
+	client.baseURL += "/old"
+
+	err := client.RemoveDocuments(context.Background(), "p1", "d1", []string{"a.pdf"})
+	var ingestionErr *IngestionError
+	require.True(t, errors.As(err, &ingestionErr), "got %v", err)
+	assert.Equal(t, http.StatusNotFound, ingestionErr.StatusCode)
+	assert.False(t, ingestionErr.Permanent())
+}
+
+func TestTriggerIngestion_PartialFailureIsRetryable(t *testing.T) {
+	client, _ := ingestionStandIn(t, http.StatusOK,
+		`{"message":"embedding model unavailable","total_files":1,"successful_ingests":0,"failed_ingests":1}`)
+
+	err := client.TriggerIngestion(context.Background(), "p1", "d1", []string{"a.pdf"})
+	require.Error(t, err)
+	var ingestionErr *IngestionError
+	assert.False(t, errors.As(err, &ingestionErr))
+	assert.Contains(t, err.Error(), "embedding model unavailable")
+}
+
+func TestTriggerIngestion_ClassifiesErrorStatuses(t *testing.T) {
+	for status, permanent := range map[int]bool{
+		http.StatusBadRequest:          true,
+		http.StatusUnprocessableEntity: true,
+		http.StatusNotFound:            false,
+		http.StatusMethodNotAllowed:    false,
+		http.StatusRequestTimeout:      false,
+		http.StatusTooManyRequests:     false,
+		http.StatusInternalServerError: false,
+		http.StatusServiceUnavailable:  false,
+	} {
+		client, _ := ingestionStandIn(t, status, `{"detail":"nope"}`)
+		err := client.TriggerIngestion(context.Background(), "p1", "d1", []string{"a.pdf"})
+
+		var ingestionErr *IngestionError
+		require.True(t, errors.As(err, &ingestionErr), "status %d: %v", status, err)
+		assert.Equal(t, status, ingestionErr.StatusCode)
+		assert.Equal(t, permanent, ingestionErr.Permanent(), "status %d", status)
+	}
+}
+
+func TestTriggerIngestion_ServiceDown(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	client := NewIngestionClient(&config.Config{PythonAPIBaseURL: server.URL}, consentStub{})
+
+	err := client.TriggerIngestion(context.Background(), "p1", "d1", []string{"a.pdf"})
+	require.Error(t, err)
+	var ingestionErr *IngestionError
+	assert.False(t, errors.As(err, &ingestionErr), "Connection errors are retried")
+}
+
+func TestIngestionBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, ingestionBackoff(1))
+	assert.Equal(t, time.Minute, ingestionBackoff(2))
+	assert.Equal(t, 4*time.Minute, ingestionBackoff(4))
+	assert.Equal(t, 64*time.Minute, ingestionBackoff(8))
+	assert.Equal(t, ingestionMaxBackoff, ingestionBackoff(20))
+	assert.Equal(t, ingestionMaxBackoff, ingestionBackoff(1000))
+}
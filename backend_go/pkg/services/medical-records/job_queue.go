@@ -0,0 +1,196 @@
+package medicalrecords
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	jobPending    = "pending"
+	jobProcessing = "processing"
+)
+
+// fileJobQueue drives a table of per-file background jobs, such as
+// file_previews. Each table is keyed by file_id and has status, attempts,
+// last_error, next_attempt_at and updated_at columns; jobs are pending until
+// a worker claims them and each job type names its own final statuses.
+type fileJobQueue struct {
+	db          *pgxpool.Pool
+	table       string
+	kind        string
+	batchSize   int
+	maxAttempts int
+	// staleAfter releases jobs left in processing by a worker that stopped
+	// mid-way.
+	staleAfter time.Duration
+	// retryStatus marks jobs waiting for another attempt. It defaults to
+	// pending.
+	retryStatus string
+	// backoff is the delay before the next attempt. It defaults to
+	// attempts² minutes.
+	backoff func(attempts int) time.Duration
+}
+
+type fileJob struct {
+	fileID   string
+	attempts int
+	path     string
+	name     string
+	ext      *string
+}
+
+// enqueue schedules a job for the file. Queuing again resets earlier
+// failures, so every new version gets a fresh attempt.
+func (q *fileJobQueue) enqueue(fileID string) {
+	_, err := q.db.Exec(context.Background(),
+		`INSERT INTO `+q.table+` (file_id, status) VALUES ($1, $2)
+		ON CONFLICT (file_id) DO UPDATE
+		SET status = EXCLUDED.status, attempts = 0, last_error = NULL, next_attempt_at = NOW(), updated_at = NOW()`,
+		fileID, jobPending)
+	if err != nil {
+		log.Printf("Warning: failed to queue %s for %s: %v", q.kind, fileID, err)
+	}
+}
+
+// run processes due jobs every interval until ctx is cancelled.
+func (q *fileJobQueue) run(ctx context.Context, interval time.Duration, process func(context.Context, fileJob)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			processed, err := q.processBatch(ctx, process)
+			if err != nil {
+				log.Printf("%s worker: %v", q.kind, err)
+				break
+			}
+			if processed < q.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processBatch claims up to batchSize due jobs and processes them. It
+// returns how many jobs it claimed.
+func (q *fileJobQueue) processBatch(ctx context.Context, process func(context.Context, fileJob)) (int, error) {
+	rows, err := q.db.Query(ctx,
+		`UPDATE `+q.table+` j
+		SET status = $1, attempts = j.attempts + 1, updated_at = NOW()
+		FROM folder_file_info f
+		WHERE f.id = j.file_id AND j.file_id IN (
+			SELECT file_id FROM `+q.table+`
+			WHERE (status IN ($2, $5) AND next_attempt_at <= NOW())
+				OR (status = $1 AND updated_at < NOW() - make_interval(secs => $3))
+			ORDER BY next_attempt_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING j.file_id::text, j.attempts, f.path, f.name, f.extension`,
+		jobProcessing, jobPending, q.staleAfter.Seconds(), q.batchSize, q.retryState())
+	if err != nil {
+		return 0, fmt.Errorf("could not claim %s jobs: %v", q.kind, err)
+	}
+
+	var jobs []fileJob
+	for rows.Next() {
+		var job fileJob
+		if err := rows.Scan(&job.fileID, &job.attempts, &job.path, &job.name, &job.ext); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("could not read %s job: %v", q.kind, err)
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not claim %s jobs: %v", q.kind, err)
+	}
+
+	for _, job := range jobs {
+		if err := ctx.Err(); err != nil {
+			return len(jobs), nil
+		}
+		process(ctx, job)
+	}
+	return len(jobs), nil
+}
+
+// complete records a job's result. assignments sets the job's own result
+// columns, numbering its placeholders from $3. A job re-queued while it was
+// processing is left pending so the new version is processed as well.
+func (q *fileJobQueue) complete(ctx context.Context, job fileJob, status, assignments string, args ...interface{}) {
+	if _, err := q.completeIn(ctx, q.db, job, status, assignments, args...); err != nil {
+		log.Printf("Warning: failed to record %s for %s: %v", q.kind, job.fileID, err)
+	}
+}
+
+// completeIn records a job's result like complete, through db, which may be
+// a transaction. It reports whether the job was still processing, that is
+// whether the result was recorded.
+func (q *fileJobQueue) completeIn(ctx context.Context, db execer, job fileJob, status, assignments string, args ...interface{}) (bool, error) {
+	if assignments != "" {
+		assignments += ", "
+	}
+	params := append([]interface{}{job.fileID, status}, args...)
+	params = append(params, jobProcessing)
+	tag, err := db.Exec(ctx,
+		fmt.Sprintf(`UPDATE %s
+		SET %sstatus = $2, last_error = NULL, updated_at = NOW()
+		WHERE file_id = $1 AND status = $%d`, q.table, assignments, len(params)),
+		params...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// retryOrFail reschedules a job that hit a transient error, with a growing
+// delay, until it runs out of attempts.
+func (q *fileJobQueue) retryOrFail(job fileJob, cause error, failed string) {
+	if job.attempts >= q.maxAttempts {
+		q.finish(job, failed, cause)
+		return
+	}
+
+	backoff := time.Duration(job.attempts*job.attempts) * time.Minute
+	if q.backoff != nil {
+		backoff = q.backoff(job.attempts)
+	}
+	_, err := q.db.Exec(context.Background(),
+		`UPDATE `+q.table+`
+		SET status = $1, last_error = $2, next_attempt_at = $3, updated_at = NOW()
+		WHERE file_id = $4 AND status = $5`,
+		q.retryState(), cause.Error(), time.Now().Add(backoff), job.fileID, jobProcessing)
+	if err != nil {
+		log.Printf("Warning: failed to reschedule %s for %s: %v", q.kind, job.fileID, err)
+	}
+}
+
+// finish ends a job with a final status and the reason for it.
+func (q *fileJobQueue) finish(job fileJob, status string, cause error) {
+	log.Printf("%s for %s %s: %v", q.kind, job.fileID, status, cause)
+	_, err := q.db.Exec(context.Background(),
+		`UPDATE `+q.table+`
+		SET status = $1, last_error = $2, updated_at = NOW()
+		WHERE file_id = $3 AND status = $4`,
+		status, cause.Error(), job.fileID, jobProcessing)
+	if err != nil {
+		log.Printf("Warning: failed to record %s failure for %s: %v", q.kind, job.fileID, err)
+	}
+}
+
+func (q *fileJobQueue) retryState() string {
+	if q.retryStatus != "" {
+		return q.retryStatus
+	}
+	return jobPending
+}
@@ -0,0 +1,336 @@
+package medicalrecords
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"healthcare_backend/pkg/models"
+)
+
+const (
+	maxLabImportRows = 5000
+	maxTestCodeLen   = 64
+	maxLabTextLen    = 255
+	maxLabUnitLen    = 32
+)
+
+var testCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9._-]*$`)
+
+// LabImportError lists the rows of a CSV import that could not be read.
+// Nothing is imported when any row fails.
+type LabImportError struct {
+	Rows []models.LabImportRowError
+}
+
+func (e *LabImportError) Error() string {
+	return fmt.Sprintf("%d invalid lab result rows", len(e.Rows))
+}
+
+// labColumns maps the accepted header names, lower-cased, to the fields they
+// fill. Lab systems export under various names.
+var labColumns = map[string]string{
+	"test_code":       "test_code",
+	"code":            "test_code",
+	"loinc":           "test_code",
+	"test_name":       "test_name",
+	"test":            "test_name",
+	"name":            "test_name",
+	"value":           "value",
+	"result":          "value",
+	"unit":            "unit",
+	"units":           "unit",
+	"reference_low":   "reference_low",
+	"ref_low":         "reference_low",
+	"low":             "reference_low",
+	"reference_high":  "reference_high",
+	"ref_high":        "reference_high",
+	"high":            "reference_high",
+	"reference_range": "reference_range",
+	"ref_range":       "reference_range",
+	"range":           "reference_range",
+	"flag":            "flag",
+	"abnormal_flag":   "flag",
+	"collected_at":    "collected_at",
+	"collection_date": "collected_at",
+	"date":            "collected_at",
+}
+
+var labDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseLabCSV reads lab results from a CSV file with a header row. Files
+// using semicolons as separators, as spreadsheets do in locales with decimal
+// commas, may also use decimal commas.
+func parseLabCSV(r io.Reader, now time.Time) ([]models.LabObservationInput, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(4096)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+	firstLine := strings.SplitN(string(header), "\n", 2)[0]
+	semicolons := strings.Count(firstLine, ";") > strings.Count(firstLine, ",")
+
+	reader := csv.NewReader(br)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if semicolons {
+		reader.Comma = ';'
+	}
+
+	names, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("CSV file is empty")
+		}
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range names {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if field, ok := labColumns[name]; ok {
+			if _, dup := columns[field]; dup {
+				return nil, fmt.Errorf("CSV header has more than one %s column", field)
+			}
+			columns[field] = i
+		}
+	}
+	for _, required := range []string{"test_code", "value", "collected_at"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+
+	var inputs []models.LabObservationInput
+	var rowErrors []models.LabImportRowError
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, models.LabImportRowError{Row: row, Error: err.Error()})
+			// A malformed quote makes the rest of the file unreliable.
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && parseErr.Err != csv.ErrFieldCount {
+				break
+			}
+			continue
+		}
+		if blankRecord(record) {
+			continue
+		}
+		if len(inputs)+len(rowErrors) >= maxLabImportRows {
+			return nil, fmt.Errorf("CSV file has more than %d rows", maxLabImportRows)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		input, err := labRowInput(field, semicolons)
+		if err == nil {
+			err = normalizeLabObservation(&input, now)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, models.LabImportRowError{Row: row, Error: err.Error()})
+			continue
+		}
+		inputs = append(inputs, input)
+	}
+
+	if len(rowErrors) > 0 {
+		return nil, &LabImportError{Rows: rowErrors}
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("CSV file has no lab results")
+	}
+	return inputs, nil
+}
+
+func blankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func labRowInput(field func(string) string, decimalComma bool) (models.LabObservationInput, error) {
+	input := models.LabObservationInput{TestCode: field("test_code")}
+	input.TestName = optionalText(field("test_name"))
+	input.Unit = optionalText(field("unit"))
+
+	number := func(s string) (float64, error) {
+		if decimalComma {
+			s = strings.Replace(s, ",", ".", 1)
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			return 0, fmt.Errorf("%q is not a finite number", s)
+		}
+		return v, err
+	}
+
+	if value := field("value"); value != "" {
+		if v, err := number(value); err == nil {
+			input.Value = &v
+		} else {
+			input.ValueText = &value
+		}
+	}
+
+	for _, bound := range []struct {
+		name   string
+		target **float64
+	}{{"reference_low", &input.ReferenceLow}, {"reference_high", &input.ReferenceHigh}} {
+		if value := field(bound.name); value != "" {
+			v, err := number(value)
+			if err != nil {
+				return input, fmt.Errorf("invalid %s %q", bound.name, value)
+			}
+			*bound.target = &v
+		}
+	}
+	if rng := field("reference_range"); rng != "" {
+		if input.ReferenceLow == nil && input.ReferenceHigh == nil {
+			input.ReferenceLow, input.ReferenceHigh = parseReferenceRange(rng, number)
+		}
+		input.ReferenceText = &rng
+	}
+
+	if flag := field("flag"); flag != "" {
+		f := models.AbnormalFlag(strings.ToUpper(flag))
+		input.AbnormalFlag = &f
+	}
+
+	collected := field("collected_at")
+	for _, layout := range labDateLayouts {
+		if t, err := time.Parse(layout, collected); err == nil {
+			input.CollectedAt = t
+			break
+		}
+	}
+	if input.CollectedAt.IsZero() {
+		return input, fmt.Errorf("invalid collected_at %q, expected YYYY-MM-DD or an ISO 8601 timestamp", collected)
+	}
+	return input, nil
+}
+
+// parseReferenceRange reads ranges written as "3.5-5.0", "3.5 - 5.0",
+// "<5.0" or ">40". Ranges it cannot read are kept as text only.
+func parseReferenceRange(rng string, number func(string) (float64, error)) (*float64, *float64) {
+	rng = strings.TrimSpace(rng)
+	switch {
+	case strings.HasPrefix(rng, "<="), strings.HasPrefix(rng, "<"):
+		if v, err := number(strings.TrimSpace(strings.TrimLeft(rng, "<="))); err == nil {
+			return nil, &v
+		}
+		return nil, nil
+	case strings.HasPrefix(rng, ">="), strings.HasPrefix(rng, ">"):
+		if v, err := number(strings.TrimSpace(strings.TrimLeft(rng, ">="))); err == nil {
+			return &v, nil
+		}
+		return nil, nil
+	}
+
+	// Skip a leading sign so "-1.0-1.0" splits at the separator.
+	if i := strings.Index(rng[1:], "-"); i >= 0 {
+		low, lowErr := number(strings.TrimSpace(rng[:i+1]))
+		high, highErr := number(strings.TrimSpace(rng[i+2:]))
+		if lowErr == nil && highErr == nil {
+			return &low, &high
+		}
+	}
+	return nil, nil
+}
+
+func optionalText(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// normalizeLabObservation validates an observation and fills in its abnormal
+// flag from the reference range when none was given.
+func normalizeLabObservation(input *models.LabObservationInput, now time.Time) error {
+	input.TestCode = normalizeTestCode(input.TestCode)
+	if input.TestCode == "" {
+		return errors.New("test_code is required")
+	}
+	if len(input.TestCode) > maxTestCodeLen || !testCodePattern.MatchString(input.TestCode) {
+		return fmt.Errorf("invalid test_code %q", input.TestCode)
+	}
+	for _, text := range []struct {
+		name  string
+		value *string
+	}{{"test_name", input.TestName}, {"value_text", input.ValueText}, {"reference_text", input.ReferenceText}} {
+		if text.value != nil && len(*text.value) > maxLabTextLen {
+			return fmt.Errorf("%s is longer than %d characters", text.name, maxLabTextLen)
+		}
+	}
+	if input.Unit != nil && len(*input.Unit) > maxLabUnitLen {
+		return fmt.Errorf("unit is longer than %d characters", maxLabUnitLen)
+	}
+	if input.Value == nil && (input.ValueText == nil || strings.TrimSpace(*input.ValueText) == "") {
+		return errors.New("a value is required")
+	}
+	if input.ReferenceLow != nil && input.ReferenceHigh != nil && *input.ReferenceLow > *input.ReferenceHigh {
+		return errors.New("reference_low is greater than reference_high")
+	}
+	if input.CollectedAt.IsZero() {
+		return errors.New("collected_at is required")
+	}
+	// Allow for clock skew between the lab system and the server.
+	if input.CollectedAt.After(now.Add(24 * time.Hour)) {
+		return errors.New("collected_at is in the future")
+	}
+
+	if input.AbnormalFlag != nil {
+		switch *input.AbnormalFlag {
+		case models.AbnormalFlagNormal, models.AbnormalFlagLow, models.AbnormalFlagHigh,
+			models.AbnormalFlagCriticalLow, models.AbnormalFlagCriticalHigh, models.AbnormalFlagAbnormal:
+		default:
+			return fmt.Errorf("invalid abnormal_flag %q", *input.AbnormalFlag)
+		}
+	} else {
+		input.AbnormalFlag = rangeFlag(input.Value, input.ReferenceLow, input.ReferenceHigh)
+	}
+	return nil
+}
+
+// rangeFlag compares a numeric value with its reference range. It returns
+// nil when there is nothing to compare.
+func rangeFlag(value, low, high *float64) *models.AbnormalFlag {
+	if value == nil || (low == nil && high == nil) {
+		return nil
+	}
+	flag := models.AbnormalFlagNormal
+	if low != nil && *value < *low {
+		flag = models.AbnormalFlagLow
+	} else if high != nil && *value > *high {
+		flag = models.AbnormalFlagHigh
+	}
+	return &flag
+}
+
+// outOfRange reports whether a flag marks a result for attention.
+func outOfRange(flag *models.AbnormalFlag) bool {
+	return flag != nil && *flag != models.AbnormalFlagNormal
+}
@@ -0,0 +1,165 @@
+package medicalrecords
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+var labNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestParseLabCSV_CommaSeparated(t *testing.T) {
+	csv := "\ufeffCode,Test,Result,Units,Ref_Range,Date\n" +
+		"k,Potassium,5.9,mmol/L,3.5-5.1,2026-02-10\n" +
+		"\n" +
+		"HGB,Hemoglobin,13.2,g/dL,,2026-02-10T08:30:00Z\n" +
+		"COVID-PCR,SARS-CoV-2 PCR,negative,,,2026-02-11 09:15\n"
+
+	inputs, err := parseLabCSV(strings.NewReader(csv), labNow)
+	require.NoError(t, err)
+	require.Len(t, inputs, 3)
+
+	assert.Equal(t, "K", inputs[0].TestCode)
+	require.NotNil(t, inputs[0].Value)
+	assert.Equal(t, 5.9, *inputs[0].Value)
+	require.NotNil(t, inputs[0].ReferenceLow)
+	assert.Equal(t, 3.5, *inputs[0].ReferenceLow)
+	assert.Equal(t, 5.1, *inputs[0].ReferenceHigh)
+	assert.Equal(t, "3.5-5.1", *inputs[0].ReferenceText)
+	require.NotNil(t, inputs[0].AbnormalFlag)
+	assert.Equal(t, models.AbnormalFlagHigh, *inputs[0].AbnormalFlag)
+
+	assert.Nil(t, inputs[1].AbnormalFlag, "No range, no flag")
+	assert.Equal(t, time.Date(2026, 2, 10, 8, 30, 0, 0, time.UTC), inputs[1].CollectedAt)
+
+	assert.Nil(t, inputs[2].Value)
+	assert.Equal(t, "negative", *inputs[2].ValueText)
+}
+
+func TestParseLabCSV_SemicolonsWithDecimalCommas(t *testing.T) {
+	csv := "test_code;value;unit;reference_low;reference_high;collected_at\n" +
+		"GLU;3,2;mmol/L;3,9;5,6;2026-02-10\n" +
+		"GLU;\"4,8\";mmol/L;3,9;5,6;2026-02-20\n"
+
+	inputs, err := parseLabCSV(strings.NewReader(csv), labNow)
+	require.NoError(t, err)
+	require.Len(t, inputs, 2)
+	assert.Equal(t, 3.2, *inputs[0].Value)
+	assert.Equal(t, 3.9, *inputs[0].ReferenceLow)
+	assert.Equal(t, models.AbnormalFlagLow, *inputs[0].AbnormalFlag)
+	assert.Equal(t, 4.8, *inputs[1].Value)
+	assert.Equal(t, models.AbnormalFlagNormal, *inputs[1].AbnormalFlag)
+}
+
+func TestParseLabCSV_ReportsEveryBadRow(t *testing.T) {
+	csv := "test_code,value,flag,collected_at\n" +
+		"K,4.1,,2026-02-10\n" +
+		",4.1,,2026-02-10\n" +
+		"K,,,2026-02-10\n" +
+		"K,4.1,X,2026-02-10\n" +
+		"K,4.1,,10/02/2026\n" +
+		"K,4.1,,2027-01-01\n"
+
+	_, err := parseLabCSV(strings.NewReader(csv), labNow)
+	var importErr *LabImportError
+	require.True(t, errors.As(err, &importErr), "got %v", err)
+
+	rows := make([]int, len(importErr.Rows))
+	for i, rowErr := range importErr.Rows {
+		rows[i] = rowErr.Row
+	}
+	assert.Equal(t, []int{3, 4, 5, 6, 7}, rows)
+	assert.Contains(t, importErr.Rows[3].Error, "collected_at")
+}
+
+func TestParseLabCSV_RejectsUnusableFiles(t *testing.T) {
+	for name, csv := range map[string]string{
+		"empty":          "",
+		"missing column": "test_code,collected_at\nK,2026-02-10\n",
+		"duplicate":      "code,test_code,value,date\nK,K,4,2026-02-10\n",
+		"no rows":        "test_code,value,collected_at\n\n",
+	} {
+		_, err := parseLabCSV(strings.NewReader(csv), labNow)
+		require.Error(t, err, name)
+		var importErr *LabImportError
+		assert.False(t, errors.As(err, &importErr), name)
+	}
+}
+
+func TestParseReferenceRange(t *testing.T) {
+	number := func(s string) (float64, error) { return strconv.ParseFloat(s, 64) }
+	for rng, want := range map[string][2]*float64{
+		"3.5-5.0":     {ptr(3.5), ptr(5.0)},
+		"3.5 - 5.0":   {ptr(3.5), ptr(5.0)},
+		"-1.0-1.0":    {ptr(-1.0), ptr(1.0)},
+		"<5.0":        {nil, ptr(5.0)},
+		"<= 200":      {nil, ptr(200.0)},
+		">40":         {ptr(40.0), nil},
+		"negative":    {nil, nil},
+		"see comment": {nil, nil},
+	} {
+		low, high := parseReferenceRange(rng, number)
+		assert.Equal(t, want[0], low, rng)
+		assert.Equal(t, want[1], high, rng)
+	}
+}
+
+func TestNormalizeLabObservation(t *testing.T) {
+	valid := func() models.LabObservationInput {
+		return models.LabObservationInput{TestCode: " hba1c ", Value: ptr(6.1), ReferenceHigh: ptr(5.6), CollectedAt: labNow.AddDate(0, 0, -1)}
+	}
+
+	input := valid()
+	require.NoError(t, normalizeLabObservation(&input, labNow))
+	assert.Equal(t, "HBA1C", input.TestCode)
+	assert.Equal(t, models.AbnormalFlagHigh, *input.AbnormalFlag)
+
+	critical := models.AbnormalFlagCriticalHigh
+	input = valid()
+	input.AbnormalFlag = &critical
+	require.NoError(t, normalizeLabObservation(&input, labNow))
+	assert.Equal(t, models.AbnormalFlagCriticalHigh, *input.AbnormalFlag, "A flag from the lab is kept")
+
+	for name, mutate := range map[string]func(*models.LabObservationInput){
+		"bad code":      func(in *models.LabObservationInput) { in.TestCode = "HB A1C" },
+		"no value":      func(in *models.LabObservationInput) { in.Value = nil },
+		"blank text":    func(in *models.LabObservationInput) { in.Value = nil; in.ValueText = ptr("  ") },
+		"low over high": func(in *models.LabObservationInput) { in.ReferenceLow = ptr(9.0) },
+		"no date":       func(in *models.LabObservationInput) { in.CollectedAt = time.Time{} },
+		"future":        func(in *models.LabObservationInput) { in.CollectedAt = labNow.Add(48 * time.Hour) },
+		"long unit":     func(in *models.LabObservationInput) { in.Unit = ptr(strings.Repeat("u", 33)) },
+		"bad flag": func(in *models.LabObservationInput) {
+			flag := models.AbnormalFlag("X")
+			in.AbnormalFlag = &flag
+		},
+	} {
+		input := valid()
+		mutate(&input)
+		assert.Error(t, normalizeLabObservation(&input, labNow), name)
+	}
+}
+
+func TestBuildLabSeries(t *testing.T) {
+	series := buildLabSeries("GLU", []models.LabObservation{
+		{TestCode: "GLU", TestName: ptr("Glucose"), Unit: ptr("mg/dL"), Value: ptr(99.0)},
+		{TestCode: "GLU", Value: ptr(101.0)},
+		{TestCode: "GLU", TestName: ptr("Glucose, fasting"), Unit: ptr("mmol/L"), Value: ptr(5.4)},
+	})
+	assert.Equal(t, "Glucose, fasting", *series.TestName)
+	assert.Equal(t, "mmol/L", *series.Unit)
+	assert.True(t, series.MixedUnits)
+
+	series = buildLabSeries("GLU", []models.LabObservation{})
+	assert.Nil(t, series.Unit)
+	assert.False(t, series.MixedUnits)
+	assert.NotNil(t, series.Points)
+}
@@ -0,0 +1,244 @@
+package medicalrecords
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var (
+	// ErrInvalidLabObservation wraps validation failures of entered results.
+	ErrInvalidLabObservation = errors.New("invalid lab observation")
+	// ErrLabSourceNotFound is returned when the report or document results
+	// are linked to does not belong to the patient.
+	ErrLabSourceNotFound = errors.New("report or document not found for this patient")
+)
+
+const (
+	maxLabObservationsPerRequest = 500
+	defaultLabListLimit          = 100
+	maxLabListLimit              = 500
+)
+
+const labObservationColumns = `
+	id::text, patient_id::text, doctor_id::text, report_id::text, file_id::text,
+	test_code, test_name, value::float8, value_text, unit,
+	reference_low::float8, reference_high::float8, reference_text, abnormal_flag,
+	collected_at, entered_by_id::text, entered_by_role, created_at`
+
+// LabObservationService stores structured lab results. Results are entered
+// by a doctor or imported from a lab CSV export, and can be linked to the
+// medical report and the uploaded document they belong to.
+type LabObservationService struct {
+	db *pgxpool.Pool
+}
+
+func NewLabObservationService(db *pgxpool.Pool) *LabObservationService {
+	return &LabObservationService{db: db}
+}
+
+// AddObservations validates and stores results for the patient under the
+// doctor's care. All results are stored or none are.
+func (s *LabObservationService) AddObservations(patientID, doctorID, enteredByID, enteredByRole string, req models.CreateLabObservationsRequest) ([]models.LabObservation, error) {
+	if len(req.Observations) == 0 {
+		return nil, fmt.Errorf("%w: no observations", ErrInvalidLabObservation)
+	}
+	if len(req.Observations) > maxLabObservationsPerRequest {
+		return nil, fmt.Errorf("%w: more than %d observations", ErrInvalidLabObservation, maxLabObservationsPerRequest)
+	}
+	now := time.Now()
+	for i := range req.Observations {
+		if err := normalizeLabObservation(&req.Observations[i], now); err != nil {
+			return nil, fmt.Errorf("%w: observation %d: %v", ErrInvalidLabObservation, i+1, err)
+		}
+	}
+	return s.insertObservations(patientID, doctorID, enteredByID, enteredByRole, req)
+}
+
+// ImportCSV stores the results of a lab CSV export. Rows that cannot be read
+// are returned as a *LabImportError and nothing is stored.
+func (s *LabObservationService) ImportCSV(patientID, doctorID, enteredByID, enteredByRole string, reportID, fileID *string, content io.Reader) ([]models.LabObservation, error) {
+	inputs, err := parseLabCSV(content, time.Now())
+	if err != nil {
+		var importErr *LabImportError
+		if errors.As(err, &importErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLabObservation, err)
+	}
+	return s.insertObservations(patientID, doctorID, enteredByID, enteredByRole, models.CreateLabObservationsRequest{
+		ReportID:     reportID,
+		FileID:       fileID,
+		Observations: inputs,
+	})
+}
+
+func (s *LabObservationService) insertObservations(patientID, doctorID, enteredByID, enteredByRole string, req models.CreateLabObservationsRequest) ([]models.LabObservation, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if req.ReportID != nil {
+		var exists bool
+		if err := tx.QueryRow(ctx,
+			"SELECT EXISTS(SELECT 1 FROM medical_reports WHERE report_id = $1::uuid AND patient_id = $2::uuid)",
+			*req.ReportID, patientID).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("could not check report: %v", err)
+		}
+		if !exists {
+			return nil, ErrLabSourceNotFound
+		}
+	}
+	if req.FileID != nil {
+		var exists bool
+		if err := tx.QueryRow(ctx,
+			`SELECT EXISTS(SELECT 1 FROM folder_file_info
+			WHERE id = $1::uuid AND patient_id = $2::uuid AND type <> 'folder')`,
+			*req.FileID, patientID).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("could not check document: %v", err)
+		}
+		if !exists {
+			return nil, ErrLabSourceNotFound
+		}
+	}
+
+	observations := make([]models.LabObservation, 0, len(req.Observations))
+	for _, in := range req.Observations {
+		row := tx.QueryRow(ctx,
+			`INSERT INTO lab_observations
+			(patient_id, doctor_id, report_id, file_id, test_code, test_name, value, value_text, unit,
+				reference_low, reference_high, reference_text, abnormal_flag, collected_at, entered_by_id, entered_by_role)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			RETURNING `+labObservationColumns,
+			patientID, doctorID, req.ReportID, req.FileID, in.TestCode, in.TestName, in.Value, in.ValueText, in.Unit,
+			in.ReferenceLow, in.ReferenceHigh, in.ReferenceText, in.AbnormalFlag, in.CollectedAt, enteredByID, enteredByRole)
+		observation, err := scanLabObservation(row)
+		if err != nil {
+			return nil, fmt.Errorf("could not insert lab observation: %v", err)
+		}
+		observations = append(observations, observation)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return observations, nil
+}
+
+// ListObservations returns the patient's results, most recent first,
+// optionally for one test only.
+func (s *LabObservationService) ListObservations(patientID, testCode string, limit, offset int) ([]models.LabObservation, error) {
+	if limit <= 0 {
+		limit = defaultLabListLimit
+	}
+	if limit > maxLabListLimit {
+		limit = maxLabListLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, err := s.db.Query(context.Background(),
+		`SELECT `+labObservationColumns+`
+		FROM lab_observations
+		WHERE patient_id = $1::uuid AND ($2 = '' OR test_code = $2)
+		ORDER BY collected_at DESC, test_code
+		LIMIT $3 OFFSET $4`,
+		patientID, normalizeTestCode(testCode), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("could not list lab observations: %v", err)
+	}
+	return collectLabObservations(rows)
+}
+
+// GetSeries returns the history of one test for the patient, optionally
+// limited to results collected in [from, to).
+func (s *LabObservationService) GetSeries(patientID, testCode string, from, to *time.Time) (*models.LabSeries, error) {
+	testCode = normalizeTestCode(testCode)
+	rows, err := s.db.Query(context.Background(),
+		`SELECT `+labObservationColumns+`
+		FROM lab_observations
+		WHERE patient_id = $1::uuid AND test_code = $2
+			AND ($3::timestamptz IS NULL OR collected_at >= $3)
+			AND ($4::timestamptz IS NULL OR collected_at < $4)
+		ORDER BY collected_at, created_at`,
+		patientID, testCode, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("could not get lab series: %v", err)
+	}
+	points, err := collectLabObservations(rows)
+	if err != nil {
+		return nil, err
+	}
+	return buildLabSeries(testCode, points), nil
+}
+
+// buildLabSeries names the series after its most recent point, so renamed
+// tests and changed units show as they are reported today.
+func buildLabSeries(testCode string, points []models.LabObservation) *models.LabSeries {
+	series := &models.LabSeries{TestCode: testCode, Points: points}
+	var unit *string
+	for i := range points {
+		point := &points[i]
+		if point.TestName != nil {
+			series.TestName = point.TestName
+		}
+		if point.Unit != nil {
+			if unit != nil && *unit != *point.Unit {
+				series.MixedUnits = true
+			}
+			unit = point.Unit
+		}
+	}
+	series.Unit = unit
+	return series
+}
+
+func normalizeTestCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func collectLabObservations(rows pgx.Rows) ([]models.LabObservation, error) {
+	defer rows.Close()
+	observations := []models.LabObservation{}
+	for rows.Next() {
+		observation, err := scanLabObservation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read lab observation: %v", err)
+		}
+		observations = append(observations, observation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read lab observations: %v", err)
+	}
+	return observations, nil
+}
+
+func scanLabObservation(row pgx.Row) (models.LabObservation, error) {
+	var o models.LabObservation
+	var flag *string
+	err := row.Scan(&o.ID, &o.PatientID, &o.DoctorID, &o.ReportID, &o.FileID,
+		&o.TestCode, &o.TestName, &o.Value, &o.ValueText, &o.Unit,
+		&o.ReferenceLow, &o.ReferenceHigh, &o.ReferenceText, &flag,
+		&o.CollectedAt, &o.EnteredByID, &o.EnteredByRole, &o.CreatedAt)
+	if err != nil {
+		return o, err
+	}
+	if flag != nil {
+		f := models.AbnormalFlag(*flag)
+		o.AbnormalFlag = &f
+	}
+	o.OutOfRange = outOfRange(o.AbnormalFlag)
+	return o, nil
+}
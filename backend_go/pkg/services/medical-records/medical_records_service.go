@@ -1,13 +1,12 @@
 package medicalrecords
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -15,29 +14,34 @@ import (
 	"time"
 
 	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/dicom"
+	"healthcare_backend/pkg/filecheck"
 	"healthcare_backend/pkg/models"
-	"healthcare_backend/pkg/utils"
+	"healthcare_backend/pkg/storage"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 type MedicalRecordsService struct {
-	db              *pgxpool.Pool
-	cfg             *config.Config
-	historyService  *HistoryService
-	ingestionClient *IngestionClient
+	db             *pgxpool.Pool
+	cfg            *config.Config
+	storage        storage.Storage
+	validator      *filecheck.Validator
+	quotaService   *QuotaService
+	historyService *HistoryService
 }
 
-func NewMedicalRecordsService(db *pgxpool.Pool, cfg *config.Config) *MedicalRecordsService {
+func NewMedicalRecordsService(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *MedicalRecordsService {
 	return &MedicalRecordsService{
-		db:              db,
-		cfg:             cfg,
-		historyService:  NewHistoryService(db),
-		ingestionClient: NewIngestionClient(cfg),
+		db:             db,
+		cfg:            cfg,
+		storage:        store,
+		validator:      filecheck.NewValidator(cfg),
+		quotaService:   NewQuotaService(db, cfg),
+		historyService: NewHistoryService(db),
 	}
 }
 
@@ -75,7 +79,7 @@ func (s *MedicalRecordsService) CreateFolder(fileFolder *models.FileFolder) erro
 	folderPath = filepath.ToSlash(folderPath)
 
 	if err := s.uploadMarkerFile(folderPath); err != nil {
-		return fmt.Errorf("failed to upload marker file to S3: %v", err)
+		return fmt.Errorf("failed to store folder marker: %v", err)
 	}
 
 	conn, err := s.db.Acquire(context.Background())
@@ -104,13 +108,73 @@ func (s *MedicalRecordsService) CreateFolder(fileFolder *models.FileFolder) erro
 	return nil
 }
 
-func (s *MedicalRecordsService) UploadFile(fileInfo *models.FileFolder, file io.Reader, fileSize int64, contentType string) error {
+func (s *MedicalRecordsService) UploadFile(fileInfo *models.FileFolder, file io.ReadSeeker, fileSize int64, contentType string) error {
+	checked, err := s.checkUpload(fileInfo, file, fileSize)
+	if err != nil {
+		return err
+	}
+	fileInfo.Name = checked.Name
+	contentType = checked.ContentType
+
+	if err := s.quotaService.CheckQuota(fileInfo.UserID, fileInfo.UserType, fileSize); err != nil {
+		return err
+	}
+
 	id, _ := uuid.NewRandom()
 	fileInfo.ID = id.String()
 	fileInfo.CreatedAt = time.Now()
 	fileInfo.UpdatedAt = time.Now()
 	fileInfo.Size = fileSize
 
+	if err := s.setPersonalRecordPath(fileInfo); err != nil {
+		return err
+	}
+
+	if isDicomUpload(fileInfo.Ext, contentType) {
+		if parsed, err := dicom.Parse(io.LimitReader(file, dicomHeaderPeekLimit)); err == nil {
+			applyDicomMetadata(fileInfo, parsed)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind upload: %v", err)
+		}
+	}
+	if err := checkClinicalMetadata(fileInfo); err != nil {
+		return err
+	}
+
+	if err := s.putObject(fileInfo.Path, file, fileSize, contentType); err != nil {
+		return fmt.Errorf("failed to store file: %v", err)
+	}
+
+	if err := s.registerUploadedFile(fileInfo); err != nil {
+		if deleteErr := s.deleteObject(fileInfo.Path); deleteErr != nil {
+			log.Printf("Warning: failed to remove unregistered upload %s: %v", fileInfo.Path, deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkUpload runs content validation with the uploader's role, which for
+// clinical documents differs from the owning user's.
+func (s *MedicalRecordsService) checkUpload(fileInfo *models.FileFolder, file io.ReadSeeker, fileSize int64) (*filecheck.Result, error) {
+	role := fileInfo.UserType
+	if fileInfo.UploadedByRole != nil && *fileInfo.UploadedByRole != "" {
+		role = *fileInfo.UploadedByRole
+	}
+
+	return s.validator.Check(context.Background(), filecheck.Upload{
+		Name:    fileInfo.Name,
+		Size:    fileSize,
+		Role:    role,
+		Allowed: filecheck.AllowedForCategory(fileInfo.Category),
+		Content: file,
+	})
+}
+
+// setPersonalRecordPath derives the storage key of a file uploaded into the
+// caller's own records tree from its name and parent folder.
+func (s *MedicalRecordsService) setPersonalRecordPath(fileInfo *models.FileFolder) error {
 	if fileInfo.ParentID != nil && *fileInfo.ParentID != "" {
 		parentFolderPath, err := s.getParentFolderPath(*fileInfo.ParentID)
 		if err != nil {
@@ -122,11 +186,54 @@ func (s *MedicalRecordsService) UploadFile(fileInfo *models.FileFolder, file io.
 	}
 
 	fileInfo.Path = filepath.ToSlash(fileInfo.Path)
+	return nil
+}
+
+// clinicalRecordPath derives the storage key of a clinical document from the
+// patient it belongs to. Clinical documents live in the patient's area, so
+// they are encrypted with the patient's key whoever uploads them.
+func clinicalRecordPath(patientID string, elem ...string) string {
+	return path.Join(append([]string{"records", "medical-records", patientID}, elem...)...)
+}
 
-	if err := s.uploadFileToS3(fileInfo.Path, file, fileSize, contentType); err != nil {
-		return fmt.Errorf("failed to upload file to S3: %v", err)
+// execer is implemented by both the pool and transactions, so rows can be
+// written as part of a caller's transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+// registerUploadedFile inserts the folder_file_info row for a blob that is
+// already stored at fileInfo.Path, within the owner's quota, and records the
+// upload in its history.
+func (s *MedicalRecordsService) registerUploadedFile(fileInfo *models.FileFolder) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
 	}
+	defer tx.Rollback(ctx)
 
+	if err := s.quotaService.reserveQuota(ctx, tx, fileInfo.UserID, fileInfo.UserType, fileInfo.Size); err != nil {
+		return err
+	}
+	if err := insertUploadedFile(ctx, tx, fileInfo); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	s.uploadRegistered(fileInfo)
+	return nil
+}
+
+// insertUploadedFile writes the folder_file_info row of an uploaded file,
+// refusing clinical documents whose metadata breaks the rules of their
+// category. Callers inserting within a transaction call uploadRegistered
+// once it has committed.
+func insertUploadedFile(ctx context.Context, db execer, fileInfo *models.FileFolder) error {
+	if err := checkClinicalMetadata(fileInfo); err != nil {
+		return err
+	}
 	if fileInfo.FolderType == "" {
 		fileInfo.FolderType = models.FolderTypePersonal
 	}
@@ -138,14 +245,25 @@ func (s *MedicalRecordsService) UploadFile(fileInfo *models.FileFolder, file io.
 		fileInfo.PatientID = &fileInfo.UserID
 	}
 
-	fileInfo.IncludedInRAG = (fileInfo.FolderType == models.FolderTypeClinical)
+	// Clinical documents join the RAG index once the ingestion worker has
+	// delivered them.
+	fileInfo.IncludedInRAG = false
 
-	_, err := s.db.Exec(context.Background(),
-		"INSERT INTO folder_file_info (id, name, created_at, updated_at, type, size, extension, user_id, user_type, parent_id, path, folder_type, category, owner_user_id, patient_id, uploaded_by_user_id, uploaded_by_role, included_in_rag) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)",
-		fileInfo.ID, fileInfo.Name, fileInfo.CreatedAt, fileInfo.UpdatedAt, fileInfo.Type, fileInfo.Size, fileInfo.Ext, fileInfo.UserID, fileInfo.UserType, fileInfo.ParentID, fileInfo.Path, fileInfo.FolderType, fileInfo.Category, fileInfo.OwnerUserID, fileInfo.PatientID, fileInfo.UploadedByUserID, fileInfo.UploadedByRole, fileInfo.IncludedInRAG)
+	_, err := db.Exec(ctx,
+		"INSERT INTO folder_file_info (id, name, created_at, updated_at, type, size, extension, user_id, user_type, parent_id, path, folder_type, category, body_part, study_date, doctor_name, owner_user_id, patient_id, uploaded_by_user_id, uploaded_by_role, included_in_rag) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)",
+		fileInfo.ID, fileInfo.Name, fileInfo.CreatedAt, fileInfo.UpdatedAt, fileInfo.Type, fileInfo.Size, fileInfo.Ext, fileInfo.UserID, fileInfo.UserType, fileInfo.ParentID, fileInfo.Path, fileInfo.FolderType, fileInfo.Category, fileInfo.BodyPart, fileInfo.StudyDate, fileInfo.DoctorName, fileInfo.OwnerUserID, fileInfo.PatientID, fileInfo.UploadedByUserID, fileInfo.UploadedByRole, fileInfo.IncludedInRAG)
 	if err != nil {
 		return fmt.Errorf("failed to insert file info: %v", err)
 	}
+	return nil
+}
+
+// uploadRegistered queues the background work for a newly registered file
+// and records its upload in the history.
+func (s *MedicalRecordsService) uploadRegistered(fileInfo *models.FileFolder) {
+	queuePreview(s.db, fileInfo.ID, fileInfo.Name, fileInfo.Ext)
+	queueTextIndex(s.db, fileInfo.ID, fileInfo.Name, fileInfo.Ext)
+	queueIngestion(s.db, fileInfo)
 
 	performedByID := fileInfo.UserID
 	performedByType := fileInfo.UserType
@@ -166,8 +284,6 @@ func (s *MedicalRecordsService) UploadFile(fileInfo *models.FileFolder, file io.
 	if err := s.historyService.AddHistoryEntry(historyEntry); err != nil {
 		log.Printf("Warning: failed to add upload history entry: %v", err)
 	}
-
-	return nil
 }
 
 func (s *MedicalRecordsService) DownloadFile(fileID string) (*models.FileFolder, io.ReadCloser, error) {
@@ -191,21 +307,14 @@ func (s *MedicalRecordsService) DownloadFile(fileID string) (*models.FileFolder,
 	file.Ext = ext
 
 	if file.Type == "folder" {
-		zipFilePath, err := s.createZipFromFolder(file.Path)
-		if err != nil {
-			return nil, nil, fmt.Errorf("could not create zip file: %v", err)
-		}
-
-		zipFile, err := os.Open(zipFilePath)
-		if err != nil {
-			return nil, nil, fmt.Errorf("could not open zip file: %v", err)
-		}
-
-		return &file, zipFile, nil
+		zipReader := pipeZip(s.getObject, func(z *zipStream) error {
+			return s.addFolderToZip(z, file.Path, "")
+		})
+		return &file, zipReader, nil
 	} else {
-		fileReader, err := s.downloadFileFromS3(file.Path)
+		fileReader, err := s.getObject(file.Path)
 		if err != nil {
-			return nil, nil, fmt.Errorf("could not retrieve file from S3: %v", err)
+			return nil, nil, fmt.Errorf("could not retrieve stored file: %v", err)
 		}
 		return &file, fileReader, nil
 	}
@@ -219,6 +328,7 @@ func (s *MedicalRecordsService) DownloadMultipleFiles(fileIDs []string) (io.Read
 	defer conn.Release()
 
 	var files []models.FileFolder
+	var missing []string
 	for _, fileID := range fileIDs {
 		var file models.FileFolder
 		var ext *string
@@ -227,7 +337,7 @@ func (s *MedicalRecordsService) DownloadMultipleFiles(fileIDs []string) (io.Read
 			"SELECT id, name, path, type, extension FROM folder_file_info WHERE id = $1", fileID).Scan(&file.ID, &file.Name, &file.Path, &file.Type, &ext)
 		if err != nil {
 			if err == pgx.ErrNoRows {
-				log.Printf("File not found with ID: %s, skipping", fileID)
+				missing = append(missing, fileID)
 				continue
 			}
 			return nil, fmt.Errorf("could not retrieve file information for ID %s: %v", fileID, err)
@@ -240,17 +350,25 @@ func (s *MedicalRecordsService) DownloadMultipleFiles(fileIDs []string) (io.Read
 		return nil, fmt.Errorf("no valid files found")
 	}
 
-	zipFilePath, err := s.createZipFromMultipleFiles(files)
-	if err != nil {
-		return nil, fmt.Errorf("could not create zip file: %v", err)
-	}
-
-	zipFile, err := os.Open(zipFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("could not open zip file: %v", err)
-	}
+	zipReader := pipeZip(s.getObject, func(z *zipStream) error {
+		for _, fileID := range missing {
+			z.fail(fileID, fmt.Errorf("file not found"))
+		}
+		for _, file := range files {
+			var err error
+			if file.Type == "folder" {
+				err = s.addFolderToZip(z, file.Path, file.Name)
+			} else {
+				err = z.addObject(file.Path, file.Name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 
-	return zipFile, nil
+	return zipReader, nil
 }
 
 func (s *MedicalRecordsService) DeleteFolderAndContents(folderID string) error {
@@ -267,7 +385,7 @@ func (s *MedicalRecordsService) DeleteFolderAndContents(folderID string) error {
 			SELECT fi.id, fi.path, fi.type FROM folder_file_info fi
 			INNER JOIN subfolders s ON s.id = fi.parent_id
 		)
-		SELECT id, path FROM subfolders;
+		SELECT id, path, type FROM subfolders;
 	`
 	rows, err := tx.Query(context.Background(), cteQuery, folderID)
 	if err != nil {
@@ -275,20 +393,28 @@ func (s *MedicalRecordsService) DeleteFolderAndContents(folderID string) error {
 	}
 	defer rows.Close()
 
-	var paths []string
+	var paths, filePaths []string
 	for rows.Next() {
-		var id, path string
-		if err := rows.Scan(&id, &path); err != nil {
+		var id, path, itemType string
+		if err := rows.Scan(&id, &path, &itemType); err != nil {
 			return fmt.Errorf("could not retrieve folder contents: %v", err)
 		}
 		if strings.TrimSpace(path) != "" {
 			paths = append(paths, path)
+			if itemType != "folder" {
+				filePaths = append(filePaths, path)
+			}
 		}
 	}
 
 	for _, path := range paths {
-		if err := s.deleteFileFromS3(path); err != nil {
-			return fmt.Errorf("failed to delete file from S3: %v", err)
+		if err := s.deleteObject(path); err != nil {
+			return fmt.Errorf("failed to delete stored file: %v", err)
+		}
+	}
+	for _, path := range filePaths {
+		if err := s.deleteObject(previewKey(path)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Warning: failed to delete preview of %s: %v", path, err)
 		}
 	}
 
@@ -363,14 +489,23 @@ func (s *MedicalRecordsService) RenameFileOrFolder(id, newName string) error {
 			return fmt.Errorf("could not update nested paths: %v", err)
 		}
 
-		err = s.renameS3Folder(path.Dir(oldPath), path.Dir(newPath))
+		err = s.moveFolderObjects(path.Dir(oldPath), path.Dir(newPath))
 		if err != nil {
-			return fmt.Errorf("could not rename folder in S3: %v", err)
+			return fmt.Errorf("could not rename stored folder: %v", err)
 		}
 	} else {
-		err = s.renameFileInS3(oldPath, newPath)
+		err = s.moveObject(oldPath, newPath)
 		if err != nil {
-			return fmt.Errorf("could not rename file in S3: %v", err)
+			return fmt.Errorf("could not rename stored file: %v", err)
+		}
+
+		var hasPreview bool
+		err = s.db.QueryRow(context.Background(),
+			"SELECT EXISTS(SELECT 1 FROM file_previews WHERE file_id = $1 AND status = $2)", id, models.PreviewReady).Scan(&hasPreview)
+		if err == nil && hasPreview {
+			if err := s.moveObject(previewKey(oldPath), previewKey(newPath)); err != nil {
+				log.Printf("Warning: failed to move preview of %s: %v", oldPath, err)
+			}
 		}
 	}
 
@@ -411,7 +546,7 @@ func (s *MedicalRecordsService) GetFolders(userID, parentID string, isSharedWith
 		if parentID != "" {
 			baseQuery = `
 			SELECT 
-				id, name, created_at, updated_at, type, extension, path,
+				id, name, created_at, updated_at, type, size, extension, path,
 				folder_type, category, owner_user_id, patient_id, 
 				uploaded_by_user_id, uploaded_by_role, included_in_rag
 			FROM 
@@ -425,7 +560,7 @@ func (s *MedicalRecordsService) GetFolders(userID, parentID string, isSharedWith
 		} else {
 			baseQuery = `
 			SELECT 
-				id, name, created_at, updated_at, type, extension, path,
+				id, name, created_at, updated_at, type, size, extension, path,
 				folder_type, category, owner_user_id, patient_id, 
 				uploaded_by_user_id, uploaded_by_role, included_in_rag
 			FROM 
@@ -444,7 +579,7 @@ func (s *MedicalRecordsService) GetFolders(userID, parentID string, isSharedWith
 	} else {
 		baseQuery = `
 		SELECT 
-			id, name, created_at, updated_at, type, extension, path,
+			id, name, created_at, updated_at, type, size, extension, path,
 			folder_type, category, owner_user_id, patient_id, 
 			uploaded_by_user_id, uploaded_by_role, included_in_rag
 		FROM 
@@ -484,7 +619,7 @@ func (s *MedicalRecordsService) GetFolders(userID, parentID string, isSharedWith
 
 		err := rows.Scan(
 			&folder.ID, &folder.Name, &folder.CreatedAt, &folder.UpdatedAt,
-			&folder.Type, &folder.Ext, &path, &folderTypeStr, &categoryStr,
+			&folder.Type, &folder.Size, &folder.Ext, &path, &folderTypeStr, &categoryStr,
 			&folder.OwnerUserID, &folder.PatientID, &folder.UploadedByUserID,
 			&folder.UploadedByRole, &folder.IncludedInRAG)
 		if err != nil {
@@ -510,10 +645,42 @@ func (s *MedicalRecordsService) GetFolders(userID, parentID string, isSharedWith
 
 		folders = append(folders, folder)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning rows: %v", err)
+	}
+
+	if err := s.setFolderSizes(folders); err != nil {
+		return nil, err
+	}
+	if err := attachPreviews(s.db, folders); err != nil {
+		return nil, err
+	}
 
 	return folders, nil
 }
 
+// setFolderSizes replaces the stored size of folders, which is not kept up to
+// date, with the recursive size of their contents.
+func (s *MedicalRecordsService) setFolderSizes(items []models.FileFolder) error {
+	var folderIDs []string
+	for _, item := range items {
+		if item.Type == "folder" {
+			folderIDs = append(folderIDs, item.ID)
+		}
+	}
+
+	usage, err := s.quotaService.FolderUsage(folderIDs)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		if items[i].Type == "folder" {
+			items[i].Size = usage[items[i].ID].Bytes
+		}
+	}
+	return nil
+}
+
 func (s *MedicalRecordsService) GetSubFolders(parentID string) ([]models.FileFolder, error) {
 	conn, err := s.db.Acquire(context.Background())
 	if err != nil {
@@ -767,43 +934,51 @@ func (s *MedicalRecordsService) GetMedicalRecordsByCategory(userID, userType, ca
 
 		records = append(records, record)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning rows: %v", err)
+	}
+
+	if err := attachPreviews(s.db, records); err != nil {
+		return nil, err
+	}
 
 	return records, nil
 }
 
-func (s *MedicalRecordsService) ShareDocumentToPatient(fileInfo *models.FileFolder, file io.Reader, folderName string, fileSize int64, contentType string) error {
+func (s *MedicalRecordsService) ShareDocumentToPatient(fileInfo *models.FileFolder, file io.ReadSeeker, folderName string, fileSize int64, contentType string) error {
+	checked, err := s.checkUpload(fileInfo, file, fileSize)
+	if err != nil {
+		return err
+	}
+	fileInfo.Name = checked.Name
+	contentType = checked.ContentType
+	// Documents from the care team count towards the patient's usage but are
+	// never refused for quota, so a full account cannot block clinical care.
+	if folderName != "" {
+		folderName = filecheck.SanitizeFilename(folderName)
+	}
+
 	id, _ := uuid.NewRandom()
 	fileInfo.ID = id.String()
 	fileInfo.CreatedAt = time.Now()
 	fileInfo.UpdatedAt = time.Now()
 	fileInfo.Size = fileSize
 
-	fileInfo.Path = fmt.Sprintf("records/medical-records/%s/%s/%s", fileInfo.UserID, folderName, fileInfo.Name)
-	fileInfo.Path = filepath.ToSlash(fileInfo.Path)
-
-	if err := s.uploadFileToS3(fileInfo.Path, file, fileSize, contentType); err != nil {
-		return fmt.Errorf("failed to upload file to S3: %v", err)
-	}
-
-	if fileInfo.FolderType == "" {
-		fileInfo.FolderType = models.FolderTypePersonal
+	fileInfo.Path = clinicalRecordPath(fileInfo.UserID, folderName, fileInfo.Name)
+	if err := checkClinicalMetadata(fileInfo); err != nil {
+		return err
 	}
 
-	if fileInfo.OwnerUserID == nil {
-		fileInfo.OwnerUserID = &fileInfo.UserID
-	}
-	if fileInfo.FolderType == models.FolderTypeClinical && fileInfo.PatientID == nil {
-		fileInfo.PatientID = &fileInfo.UserID
+	if err := s.putObject(fileInfo.Path, file, fileSize, contentType); err != nil {
+		return fmt.Errorf("failed to store file: %v", err)
 	}
 
-	fileInfo.IncludedInRAG = (fileInfo.FolderType == models.FolderTypeClinical)
-
-	_, err := s.db.Exec(context.Background(),
-		"INSERT INTO folder_file_info (id, name, created_at, updated_at, type, size, extension, user_id, user_type, parent_id, path, folder_type, category, owner_user_id, patient_id, uploaded_by_user_id, uploaded_by_role, included_in_rag) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)",
-		fileInfo.ID, fileInfo.Name, fileInfo.CreatedAt, fileInfo.UpdatedAt, fileInfo.Type, fileInfo.Size, fileInfo.Ext, fileInfo.UserID, fileInfo.UserType, fileInfo.ParentID, fileInfo.Path, fileInfo.FolderType, fileInfo.Category, fileInfo.OwnerUserID, fileInfo.PatientID, fileInfo.UploadedByUserID, fileInfo.UploadedByRole, fileInfo.IncludedInRAG)
-	if err != nil {
-		return fmt.Errorf("failed to insert file info: %v", err)
+	if err := insertUploadedFile(context.Background(), s.db, fileInfo); err != nil {
+		return err
 	}
+	queuePreview(s.db, fileInfo.ID, fileInfo.Name, fileInfo.Ext)
+	queueTextIndex(s.db, fileInfo.ID, fileInfo.Name, fileInfo.Ext)
+	queueIngestion(s.db, fileInfo)
 
 	_, err = s.db.Exec(context.Background(),
 		`INSERT INTO shared_items (shared_by_id, shared_with_id, shared_at, item_id) 
@@ -813,16 +988,6 @@ func (s *MedicalRecordsService) ShareDocumentToPatient(fileInfo *models.FileFold
 		return fmt.Errorf("could not insert shared item: %v", err)
 	}
 
-	// Trigger async ingestion to Qdrant if this is a clinical record
-	if fileInfo.IncludedInRAG && fileInfo.PatientID != nil && fileInfo.UploadedByUserID != nil {
-		doctorID := *fileInfo.UploadedByUserID
-		patientID := *fileInfo.PatientID
-		s3Keys := []string{fileInfo.Path}
-
-		log.Printf("Triggering medical record ingestion for patient %s, file: %s", patientID, fileInfo.Path)
-		s.ingestionClient.TriggerIngestionAsync(patientID, doctorID, s3Keys)
-	}
-
 	return nil
 }
 
@@ -866,187 +1031,71 @@ func (s *MedicalRecordsService) getParentFolders(folderID string) ([]models.File
 
 func (s *MedicalRecordsService) uploadMarkerFile(folderPath string) error {
 	emptyFile := bytes.NewReader([]byte{})
-	return utils.UploadToS3WithReader(folderPath, emptyFile, 0, "text/plain")
+	return s.storage.Put(context.Background(), folderPath, emptyFile, 0, "text/plain")
 }
 
-func (s *MedicalRecordsService) uploadFileToS3(filePath string, file io.Reader, fileSize int64, contentType string) error {
-	return utils.UploadToS3WithReader(filePath, file, fileSize, contentType)
+func (s *MedicalRecordsService) putObject(filePath string, file io.Reader, fileSize int64, contentType string) error {
+	return s.storage.Put(context.Background(), filePath, file, fileSize, contentType)
 }
 
-func (s *MedicalRecordsService) downloadFileFromS3(filePath string) (io.ReadCloser, error) {
-	return utils.DownloadFromS3(filePath)
+func (s *MedicalRecordsService) getObject(filePath string) (io.ReadCloser, error) {
+	return s.storage.Get(context.Background(), filePath)
 }
 
-func (s *MedicalRecordsService) deleteFileFromS3(filePath string) error {
-	return utils.DeleteFromS3(filePath)
+func (s *MedicalRecordsService) deleteObject(filePath string) error {
+	return s.storage.Delete(context.Background(), filePath)
 }
 
-func (s *MedicalRecordsService) renameFileInS3(oldPath, newPath string) error {
-	if err := utils.CopyS3Object(oldPath, newPath); err != nil {
+func (s *MedicalRecordsService) moveObject(oldPath, newPath string) error {
+	if err := s.storage.Copy(context.Background(), oldPath, newPath); err != nil {
 		return err
 	}
-	return utils.DeleteFromS3(oldPath)
+	return s.storage.Delete(context.Background(), oldPath)
 }
 
-func (s *MedicalRecordsService) renameS3Folder(oldPath, newPath string) error {
-	bucket := os.Getenv("S3_BUCKET_NAME")
-	prefix := oldPath + "/"
-
-	cfg, err := utils.GetAWSConfig()
-	if err != nil {
-		return fmt.Errorf("error getting AWS config: %w", err)
-	}
-
-	client := s3.NewFromConfig(cfg)
-
-	resp, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
-	})
+func (s *MedicalRecordsService) moveFolderObjects(oldPath, newPath string) error {
+	keys, err := s.storage.List(context.Background(), oldPath+"/")
 	if err != nil {
-		return fmt.Errorf("error listing S3 objects: %w", err)
+		return fmt.Errorf("error listing stored objects: %w", err)
 	}
 
-	for _, item := range resp.Contents {
-		oldKey := *item.Key
+	for _, oldKey := range keys {
 		newKey := strings.Replace(oldKey, oldPath, newPath, 1)
 
-		if err := utils.CopyS3Object(oldKey, newKey); err != nil {
-			return fmt.Errorf("error copying S3 object: %w", err)
+		if err := s.storage.Copy(context.Background(), oldKey, newKey); err != nil {
+			return fmt.Errorf("error copying stored object: %w", err)
 		}
 
-		if err := utils.DeleteFromS3(oldKey); err != nil {
-			return fmt.Errorf("error deleting old S3 object: %w", err)
+		if err := s.storage.Delete(context.Background(), oldKey); err != nil {
+			return fmt.Errorf("error deleting old stored object: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func (s *MedicalRecordsService) createZipFromFolder(folderPath string) (string, error) {
-	zipFileName := fmt.Sprintf("%s.zip", uuid.New().String())
-	zipFilePath := filepath.Join(os.TempDir(), zipFileName)
+// addFolderToZip streams every object stored under folderPath into the
+// archive, keeping the folder structure relative to baseInZip.
+func (s *MedicalRecordsService) addFolderToZip(z *zipStream, folderPath, baseInZip string) error {
+	prefix := strings.TrimSuffix(filepath.ToSlash(folderPath), "/marker.txt") + "/"
 
-	newZipFile, err := os.Create(zipFilePath)
+	keys, err := s.storage.List(context.Background(), prefix)
 	if err != nil {
-		return "", err
-	}
-	defer newZipFile.Close()
-
-	zipWriter := zip.NewWriter(newZipFile)
-	defer zipWriter.Close()
-
-	err = s.addFilesToZip(zipWriter, folderPath, "")
-	if err != nil {
-		return "", err
-	}
-
-	return zipFilePath, nil
-}
-
-func (s *MedicalRecordsService) createZipFromMultipleFiles(files []models.FileFolder) (string, error) {
-	zipFileName := fmt.Sprintf("multiple_files_%s.zip", uuid.New().String())
-	zipFilePath := filepath.Join(os.TempDir(), zipFileName)
-
-	newZipFile, err := os.Create(zipFilePath)
-	if err != nil {
-		return "", err
-	}
-	defer newZipFile.Close()
-
-	zipWriter := zip.NewWriter(newZipFile)
-	defer zipWriter.Close()
-
-	for _, file := range files {
-
-		if file.Type == "folder" {
-			err = s.addFilesToZip(zipWriter, file.Path, file.Name)
-			if err != nil {
-				log.Printf("Error adding folder %s to zip: %v", file.Name, err)
-				continue
-			}
-		} else {
-			fileReader, err := s.downloadFileFromS3(file.Path)
-			if err != nil {
-				log.Printf("Error downloading file %s from S3: %v", file.Name, err)
-				continue
-			}
-
-			f, err := zipWriter.Create(file.Name)
-			if err != nil {
-				fileReader.Close()
-				log.Printf("Error creating zip entry for %s: %v", file.Name, err)
-				continue
-			}
-
-			_, err = io.Copy(f, fileReader)
-			fileReader.Close()
-
-			if err != nil {
-				log.Printf("Error writing file %s to zip: %v", file.Name, err)
-				continue
-			}
+		name := baseInZip
+		if name == "" {
+			name = path.Base(strings.TrimSuffix(prefix, "/"))
 		}
+		z.fail(name, fmt.Errorf("could not list folder contents: %v", err))
+		return nil
 	}
 
-	return zipFilePath, nil
-}
-
-func (s *MedicalRecordsService) addFilesToZip(zipWriter *zip.Writer, basePath, baseInZip string) error {
-	bucket := os.Getenv("S3_BUCKET_NAME")
-	normalizedBasePath := filepath.ToSlash(basePath)
-	prefix := strings.TrimSuffix(normalizedBasePath, "/marker.txt")
-
-	cfg, err := utils.GetAWSConfig()
-	if err != nil {
-		return fmt.Errorf("error getting AWS config: %w", err)
-	}
-
-	client := s3.NewFromConfig(cfg)
-
-	resp, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
-	})
-	if err != nil {
-		return fmt.Errorf("error listing S3 objects: %v", err)
-	}
-
-	filesAdded := 0
-	for _, item := range resp.Contents {
-		key := *item.Key
-
-		if strings.HasSuffix(key, "/marker.txt") || strings.HasSuffix(key, "marker.txt") {
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") || path.Base(key) == "marker.txt" || isPreviewKey(key) {
 			continue
 		}
-
-		relativePath := strings.TrimPrefix(key, prefix+"/")
-
-		if strings.HasSuffix(key, "/") {
-			newBaseInZip := filepath.Join(baseInZip, relativePath)
-			err = s.addFilesToZip(zipWriter, key, newBaseInZip)
-			if err != nil {
-				return err
-			}
-		} else {
-			fileReader, err := utils.DownloadFromS3(key)
-			if err != nil {
-				return fmt.Errorf("error getting S3 object: %v", err)
-			}
-
-			f, err := zipWriter.Create(filepath.Join(baseInZip, relativePath))
-			if err != nil {
-				fileReader.Close()
-				return fmt.Errorf("error creating zip entry: %v", err)
-			}
-
-			_, err = io.Copy(f, fileReader)
-			fileReader.Close()
-
-			if err != nil {
-				return fmt.Errorf("error writing to zip entry: %v", err)
-			}
-			filesAdded++
+		relativePath := strings.TrimPrefix(key, prefix)
+		if err := z.addObject(key, path.Join(baseInZip, relativePath)); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -0,0 +1,548 @@
+package medicalrecords
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrItemNotFound     = errors.New("item not found")
+	ErrInvalidTarget    = errors.New("invalid target folder")
+	ErrTargetInsideItem = errors.New("a folder cannot be moved or copied into itself or one of its subfolders")
+	ErrNameConflict     = errors.New("an item with the same name already exists in the target folder")
+	ErrNotMovable       = errors.New("only personal records can be moved")
+)
+
+const maxCopyNameAttempts = 100
+
+// treeItem is a file or folder being moved or copied, with its place in the
+// new location.
+type treeItem struct {
+	id        string
+	name      string
+	itemType  string
+	ext       *string
+	size      int64
+	parentID  *string
+	path      string
+	newID     string
+	newParent *string
+	newPath   string
+}
+
+type objectCopy struct {
+	from, to string
+}
+
+// MoveItem moves a personal file or folder, with everything in it, into
+// targetFolderID or, when that is empty, to the top of the owner's records.
+// Blobs are copied to their new keys before the rows change and the old keys
+// are only removed once the rows are committed, so a failure at any point
+// leaves the item where it was.
+func (s *MedicalRecordsService) MoveItem(itemID, targetFolderID, performedByID, performedByType string) (*models.FileFolder, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	item, err := s.lockItem(ctx, tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if item.FolderType != models.FolderTypePersonal || item.SharedByID != nil {
+		return nil, ErrNotMovable
+	}
+	targetDir, err := s.targetDirectory(ctx, tx, item.UserID, targetFolderID)
+	if err != nil {
+		return nil, err
+	}
+	if targetFolderID != "" && item.Type == "folder" {
+		inside, err := isWithinFolder(ctx, tx, targetFolderID, item.ID)
+		if err != nil {
+			return nil, err
+		}
+		if inside {
+			return nil, ErrTargetInsideItem
+		}
+	}
+
+	oldBase := itemBasePath(item)
+	newBase := path.Join(targetDir, item.Name)
+	if newBase == oldBase {
+		return item, nil
+	}
+	taken, err := pathTaken(ctx, tx, newBase)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrNameConflict
+	}
+
+	items, err := s.subtree(ctx, tx, item.ID, oldBase, newBase)
+	if err != nil {
+		return nil, err
+	}
+	copies, markers, err := s.subtreeObjects(ctx, tx, items, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.copyObjects(ctx, copies, markers); err != nil {
+		return nil, err
+	}
+	newKeys := copiedKeys(copies, markers)
+
+	var newParent *string
+	if targetFolderID != "" {
+		newParent = &targetFolderID
+	}
+	ids := make([]string, len(items))
+	for i, it := range items {
+		ids[i] = it.id
+	}
+	_, err = tx.Exec(ctx,
+		"UPDATE folder_file_info SET parent_id = $1, updated_at = NOW() WHERE id = $2", newParent, item.ID)
+	if err == nil {
+		_, err = tx.Exec(ctx,
+			"UPDATE folder_file_info SET path = $1 || substr(path, $2) WHERE id = ANY($3::uuid[])",
+			newBase, len(oldBase)+1, ids)
+	}
+	if err != nil {
+		s.removeObjects(ctx, newKeys)
+		return nil, fmt.Errorf("could not update item location: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		s.removeObjects(ctx, newKeys)
+		return nil, fmt.Errorf("could not commit transaction: %v", err)
+	}
+
+	var oldKeys []string
+	for _, c := range copies {
+		oldKeys = append(oldKeys, c.from)
+	}
+	for _, it := range items {
+		if it.itemType == "folder" {
+			oldKeys = append(oldKeys, it.path)
+		}
+	}
+	s.removeObjects(ctx, oldKeys)
+
+	oldLocation, newLocation := recordLocation(item.Path), recordLocation(newPathOf(item, newBase))
+	s.addTransferHistory(item.ID, models.ActionTypeMove, performedByID, performedByType, oldLocation, newLocation, nil)
+
+	item.ParentID = newParent
+	item.Path = newPathOf(item, newBase)
+	return item, nil
+}
+
+// CopyItem copies a file or folder, with everything in it, into the owner's
+// personal records under targetFolderID or at the top when that is empty.
+// Copies are personal records of their own: they are not shared and not
+// part of the clinical record. A name already used in the target gets a
+// number appended.
+func (s *MedicalRecordsService) CopyItem(itemID, targetFolderID, performedByID, performedByType string) (*models.FileFolder, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	item, err := s.lockItem(ctx, tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	targetDir, err := s.targetDirectory(ctx, tx, item.UserID, targetFolderID)
+	if err != nil {
+		return nil, err
+	}
+	if targetFolderID != "" && item.Type == "folder" {
+		inside, err := isWithinFolder(ctx, tx, targetFolderID, item.ID)
+		if err != nil {
+			return nil, err
+		}
+		if inside {
+			return nil, ErrTargetInsideItem
+		}
+	}
+
+	name, err := availableName(item.Name, item.Type != "folder", func(candidate string) (bool, error) {
+		return pathTaken(ctx, tx, path.Join(targetDir, candidate))
+	})
+	if err != nil {
+		return nil, err
+	}
+	newBase := path.Join(targetDir, name)
+
+	items, err := s.subtree(ctx, tx, item.ID, itemBasePath(item), newBase)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, it := range items {
+		if it.itemType != "folder" {
+			total += it.size
+		}
+	}
+	if err := s.quotaService.reserveQuota(ctx, tx, item.UserID, item.UserType, total); err != nil {
+		return nil, err
+	}
+
+	// Parents come before their children, so every parent already has its
+	// new ID.
+	newIDs := map[string]string{}
+	for i := range items {
+		items[i].newID = uuid.New().String()
+		newIDs[items[i].id] = items[i].newID
+		if i == 0 {
+			if targetFolderID != "" {
+				items[i].newParent = &targetFolderID
+			}
+		} else if items[i].parentID != nil {
+			parent := newIDs[*items[i].parentID]
+			items[i].newParent = &parent
+		}
+	}
+
+	copies, markers, err := s.subtreeObjects(ctx, tx, items, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.copyObjects(ctx, copies, markers); err != nil {
+		return nil, err
+	}
+	newKeys := copiedKeys(copies, markers)
+
+	oldIDs := make([]string, len(items))
+	ids := make([]string, len(items))
+	parents := make([]string, len(items))
+	paths := make([]string, len(items))
+	for i, it := range items {
+		oldIDs[i], ids[i], paths[i] = it.id, it.newID, it.newPath
+		if it.newParent != nil {
+			parents[i] = *it.newParent
+		}
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO folder_file_info (id, name, created_at, updated_at, type, size, extension, user_id, user_type,
+			parent_id, path, folder_type, category, body_part, study_date, doctor_name, owner_user_id, patient_id,
+			uploaded_by_user_id, uploaded_by_role, included_in_rag)
+		SELECT c.new_id, CASE WHEN f.id = $5 THEN $6 ELSE f.name END, NOW(), NOW(), f.type, f.size, f.extension,
+			f.user_id, f.user_type, NULLIF(c.new_parent, '')::uuid, c.new_path, $7, f.category, f.body_part,
+			f.study_date, f.doctor_name, f.user_id, f.patient_id, f.uploaded_by_user_id, f.uploaded_by_role, false
+		FROM unnest($1::uuid[], $2::uuid[], $3::text[], $4::text[]) AS c(old_id, new_id, new_parent, new_path)
+		JOIN folder_file_info f ON f.id = c.old_id`,
+		oldIDs, ids, parents, paths, item.ID, name, models.FolderTypePersonal)
+	if err != nil {
+		s.removeObjects(ctx, newKeys)
+		return nil, fmt.Errorf("could not insert copied items: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		s.removeObjects(ctx, newKeys)
+		return nil, fmt.Errorf("could not commit transaction: %v", err)
+	}
+
+	for _, it := range items {
+		if it.itemType != "folder" {
+			queuePreview(s.db, it.newID, it.name, it.ext)
+			queueTextIndex(s.db, it.newID, it.name, it.ext)
+		}
+	}
+
+	copied := *item
+	copied.ID = items[0].newID
+	copied.Name = name
+	copied.ParentID = items[0].newParent
+	copied.Path = items[0].newPath
+	copied.FolderType = models.FolderTypePersonal
+	copied.SharedByID = nil
+
+	metadata, _ := json.Marshal(map[string]string{"source_item_id": item.ID})
+	s.addTransferHistory(copied.ID, models.ActionTypeCopy, performedByID, performedByType,
+		recordLocation(item.Path), recordLocation(copied.Path), metadata)
+	return &copied, nil
+}
+
+func (s *MedicalRecordsService) lockItem(ctx context.Context, tx pgx.Tx, itemID string) (*models.FileFolder, error) {
+	var item models.FileFolder
+	var folderType *string
+	err := tx.QueryRow(ctx,
+		`SELECT id, name, type, COALESCE(size, 0), extension, user_id, user_type, parent_id, COALESCE(path, ''), folder_type, shared_by_id
+		FROM folder_file_info WHERE id = $1 FOR UPDATE`, itemID).Scan(
+		&item.ID, &item.Name, &item.Type, &item.Size, &item.Ext, &item.UserID, &item.UserType,
+		&item.ParentID, &item.Path, &folderType, &item.SharedByID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrItemNotFound
+		}
+		return nil, fmt.Errorf("could not fetch item details: %v", err)
+	}
+	if folderType != nil {
+		item.FolderType = models.FolderType(*folderType)
+	}
+	return &item, nil
+}
+
+// targetDirectory returns the storage directory of a target folder, which
+// must be one of the owner's personal folders, or of the owner's personal
+// records root when targetFolderID is empty.
+func (s *MedicalRecordsService) targetDirectory(ctx context.Context, tx pgx.Tx, ownerID, targetFolderID string) (string, error) {
+	root := path.Join("records", "my-records", ownerID)
+	if targetFolderID == "" {
+		return root, nil
+	}
+
+	var userID, itemType, folderPath string
+	var folderType, sharedByID *string
+	err := tx.QueryRow(ctx,
+		"SELECT user_id, type, path, folder_type, shared_by_id FROM folder_file_info WHERE id = $1",
+		targetFolderID).Scan(&userID, &itemType, &folderPath, &folderType, &sharedByID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", ErrInvalidTarget
+		}
+		return "", fmt.Errorf("could not fetch target folder: %v", err)
+	}
+
+	dir := strings.TrimSuffix(folderPath, "/marker.txt")
+	if userID != ownerID || itemType != "folder" || sharedByID != nil ||
+		folderType == nil || models.FolderType(*folderType) != models.FolderTypePersonal ||
+		!strings.HasPrefix(dir, root+"/") {
+		return "", ErrInvalidTarget
+	}
+	return dir, nil
+}
+
+// isWithinFolder reports whether folderID is ancestorID or one of its
+// descendants.
+func isWithinFolder(ctx context.Context, tx pgx.Tx, folderID, ancestorID string) (bool, error) {
+	var inside bool
+	err := tx.QueryRow(ctx, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, 1 AS depth FROM folder_file_info WHERE id = $1
+			UNION ALL
+			SELECT f.id, f.parent_id, a.depth + 1 FROM folder_file_info f
+			INNER JOIN ancestors a ON f.id = a.parent_id
+			WHERE a.depth < 1000
+		)
+		SELECT EXISTS(SELECT 1 FROM ancestors WHERE id = $2)`, folderID, ancestorID).Scan(&inside)
+	if err != nil {
+		return false, fmt.Errorf("could not check folder ancestry: %v", err)
+	}
+	return inside, nil
+}
+
+// pathTaken reports whether a file or folder already lives at base.
+func pathTaken(ctx context.Context, tx pgx.Tx, base string) (bool, error) {
+	var taken bool
+	err := tx.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM folder_file_info WHERE path = $1 OR path = $2)",
+		base, base+"/marker.txt").Scan(&taken)
+	if err != nil {
+		return false, fmt.Errorf("could not check target folder contents: %v", err)
+	}
+	return taken, nil
+}
+
+// subtree loads an item and everything below it, parents first, with the
+// paths they get when oldBase becomes newBase.
+func (s *MedicalRecordsService) subtree(ctx context.Context, tx pgx.Tx, itemID, oldBase, newBase string) ([]treeItem, error) {
+	rows, err := tx.Query(ctx, `
+		WITH RECURSIVE subtree AS (
+			SELECT id, 0 AS depth FROM folder_file_info WHERE id = $1
+			UNION ALL
+			SELECT f.id, t.depth + 1 FROM folder_file_info f
+			INNER JOIN subtree t ON f.parent_id = t.id
+		)
+		SELECT f.id, f.name, f.type, f.extension, COALESCE(f.size, 0), f.parent_id, f.path
+		FROM subtree t JOIN folder_file_info f ON f.id = t.id
+		ORDER BY t.depth
+		FOR UPDATE OF f`, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve folder contents: %v", err)
+	}
+	defer rows.Close()
+
+	var items []treeItem
+	for rows.Next() {
+		var it treeItem
+		if err := rows.Scan(&it.id, &it.name, &it.itemType, &it.ext, &it.size, &it.parentID, &it.path); err != nil {
+			return nil, fmt.Errorf("could not retrieve folder contents: %v", err)
+		}
+		if !strings.HasPrefix(it.path, oldBase) {
+			return nil, fmt.Errorf("item %s is stored outside its folder", it.id)
+		}
+		it.newPath = newBase + strings.TrimPrefix(it.path, oldBase)
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not retrieve folder contents: %v", err)
+	}
+	if len(items) == 0 {
+		return nil, ErrItemNotFound
+	}
+	return items, nil
+}
+
+// subtreeObjects lists the blobs to copy for a subtree and the folder markers
+// to create. Ready previews travel with moved files; copies get their own.
+func (s *MedicalRecordsService) subtreeObjects(ctx context.Context, tx pgx.Tx, items []treeItem, withPreviews bool) ([]objectCopy, []string, error) {
+	var copies []objectCopy
+	var markers, fileIDs []string
+	for _, it := range items {
+		if it.itemType == "folder" {
+			markers = append(markers, it.newPath)
+			continue
+		}
+		copies = append(copies, objectCopy{from: it.path, to: it.newPath})
+		fileIDs = append(fileIDs, it.id)
+	}
+	if !withPreviews || len(fileIDs) == 0 {
+		return copies, markers, nil
+	}
+
+	rows, err := tx.Query(ctx,
+		"SELECT file_id::text FROM file_previews WHERE file_id = ANY($1::uuid[]) AND status = $2",
+		fileIDs, models.PreviewReady)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not retrieve previews: %v", err)
+	}
+	defer rows.Close()
+	ready := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, nil, fmt.Errorf("could not retrieve previews: %v", err)
+		}
+		ready[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("could not retrieve previews: %v", err)
+	}
+
+	for _, it := range items {
+		if ready[it.id] {
+			copies = append(copies, objectCopy{from: previewKey(it.path), to: previewKey(it.newPath)})
+		}
+	}
+	return copies, markers, nil
+}
+
+// copyObjects copies blobs and writes folder markers. If any step fails the
+// objects already written are removed, leaving storage as it was.
+func (s *MedicalRecordsService) copyObjects(ctx context.Context, copies []objectCopy, markers []string) error {
+	var written []string
+	for _, marker := range markers {
+		if err := s.uploadMarkerFile(marker); err != nil {
+			s.removeObjects(ctx, written)
+			return fmt.Errorf("could not create folder %s: %v", path.Dir(marker), err)
+		}
+		written = append(written, marker)
+	}
+	for _, c := range copies {
+		if err := s.storage.Copy(ctx, c.from, c.to); err != nil {
+			s.removeObjects(ctx, written)
+			return fmt.Errorf("could not copy stored file %s: %v", c.from, err)
+		}
+		written = append(written, c.to)
+	}
+	return nil
+}
+
+func copiedKeys(copies []objectCopy, markers []string) []string {
+	keys := append([]string(nil), markers...)
+	for _, c := range copies {
+		keys = append(keys, c.to)
+	}
+	return keys
+}
+
+// removeObjects deletes blobs on a best-effort basis; leftovers are only
+// logged since the rows no longer point at them.
+func (s *MedicalRecordsService) removeObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			log.Printf("Warning: failed to remove stored object %s: %v", key, err)
+		}
+	}
+}
+
+func (s *MedicalRecordsService) addTransferHistory(itemID string, action models.HistoryActionType, performedByID, performedByType, oldLocation, newLocation string, metadata []byte) {
+	entry := models.FileFolderHistory{
+		ItemID:          itemID,
+		ActionType:      action,
+		PerformedByID:   performedByID,
+		PerformedByType: performedByType,
+		OldValue:        &oldLocation,
+		NewValue:        &newLocation,
+		Metadata:        metadata,
+	}
+	if err := s.historyService.AddHistoryEntry(entry); err != nil {
+		log.Printf("Warning: failed to add %s history entry: %v", action, err)
+	}
+}
+
+// itemBasePath is the storage path of a file, or the directory of a folder.
+func itemBasePath(item *models.FileFolder) string {
+	if item.Type == "folder" {
+		return strings.TrimSuffix(item.Path, "/marker.txt")
+	}
+	return item.Path
+}
+
+func newPathOf(item *models.FileFolder, newBase string) string {
+	if item.Type == "folder" {
+		return path.Join(newBase, "marker.txt")
+	}
+	return newBase
+}
+
+// recordLocation turns a storage path into the location shown to users,
+// relative to the records tree it belongs to, e.g. "Labs/2024/cbc.pdf".
+func recordLocation(storagePath string) string {
+	storagePath = strings.TrimSuffix(storagePath, "/marker.txt")
+	parts := strings.SplitN(storagePath, "/", 4)
+	if len(parts) < 4 || parts[0] != "records" {
+		return storagePath
+	}
+	return parts[3]
+}
+
+// availableName returns name, or name with " (n)" appended, before the
+// extension of a file, when taken reports it is already in use.
+func availableName(name string, isFile bool, taken func(string) (bool, error)) (string, error) {
+	stem, ext := name, ""
+	if isFile {
+		ext = path.Ext(name)
+		stem = strings.TrimSuffix(name, ext)
+		if stem == "" {
+			stem, ext = name, ""
+		}
+	}
+	for i := 0; i < maxCopyNameAttempts; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		}
+		inUse, err := taken(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !inUse {
+			return candidate, nil
+		}
+	}
+	return "", ErrNameConflict
+}
@@ -0,0 +1,75 @@
+package medicalrecords
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"healthcare_backend/pkg/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyObjects_RollsBackOnFailure(t *testing.T) {
+	store := storage.NewLocalStorage(t.TempDir())
+	s := &MedicalRecordsService{storage: store}
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "records/my-records/u1/Labs/a.pdf", strings.NewReader("a"), 1, "application/pdf"))
+
+	err := s.copyObjects(ctx, []objectCopy{
+		{from: "records/my-records/u1/Labs/a.pdf", to: "records/my-records/u1/Archive/Labs/a.pdf"},
+		{from: "records/my-records/u1/Labs/missing.pdf", to: "records/my-records/u1/Archive/Labs/missing.pdf"},
+	}, []string{"records/my-records/u1/Archive/Labs/marker.txt"})
+	require.Error(t, err)
+
+	for _, key := range []string{"records/my-records/u1/Archive/Labs/a.pdf", "records/my-records/u1/Archive/Labs/marker.txt"} {
+		_, err := store.Get(ctx, key)
+		assert.ErrorIs(t, err, storage.ErrNotFound, key)
+	}
+	_, err = store.Get(ctx, "records/my-records/u1/Labs/a.pdf")
+	assert.NoError(t, err, "The source is untouched")
+}
+
+func TestCopyObjects_CopiesEverything(t *testing.T) {
+	store := storage.NewLocalStorage(t.TempDir())
+	s := &MedicalRecordsService{storage: store}
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "records/my-records/u1/a.pdf", strings.NewReader("a"), 1, "application/pdf"))
+
+	copies := []objectCopy{{from: "records/my-records/u1/a.pdf", to: "records/my-records/u1/B/a.pdf"}}
+	markers := []string{"records/my-records/u1/B/marker.txt"}
+	require.NoError(t, s.copyObjects(ctx, copies, markers))
+
+	assert.Equal(t, []string{"records/my-records/u1/B/marker.txt", "records/my-records/u1/B/a.pdf"}, copiedKeys(copies, markers))
+	for _, key := range copiedKeys(copies, markers) {
+		_, err := store.Get(ctx, key)
+		assert.NoError(t, err, key)
+	}
+}
+
+func TestAvailableName(t *testing.T) {
+	used := map[string]bool{"report.pdf": true, "report (1).pdf": true, "Labs": true}
+	taken := func(name string) (bool, error) { return used[name], nil }
+
+	name, err := availableName("report.pdf", true, taken)
+	require.NoError(t, err)
+	assert.Equal(t, "report (2).pdf", name)
+
+	name, err = availableName("Labs", false, taken)
+	require.NoError(t, err)
+	assert.Equal(t, "Labs (1)", name)
+
+	name, err = availableName("v1.2", false, taken)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.2", name, "Folder names keep their dots")
+
+	_, err = availableName("x.pdf", true, func(string) (bool, error) { return true, nil })
+	assert.ErrorIs(t, err, ErrNameConflict)
+}
+
+func TestRecordLocation(t *testing.T) {
+	assert.Equal(t, "Labs/2024/cbc.pdf", recordLocation("records/my-records/u1/Labs/2024/cbc.pdf"))
+	assert.Equal(t, "Labs", recordLocation("records/my-records/u1/Labs/marker.txt"))
+	assert.Equal(t, "other/key", recordLocation("other/key"))
+}
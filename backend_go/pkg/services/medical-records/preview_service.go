@@ -0,0 +1,211 @@
+package medicalrecords
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/models"
+	"healthcare_backend/pkg/preview"
+	"healthcare_backend/pkg/storage"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var ErrPreviewNotFound = errors.New("preview not available")
+
+const (
+	// previewDir holds previews next to the blobs they describe, so folder
+	// renames and deletes carry them along.
+	previewDir = ".previews"
+
+	maxPreviewAttempts = 3
+	previewBatchSize   = 5
+	previewStaleAfter  = 10 * time.Minute
+)
+
+// PreviewService generates thumbnails and first-page PDF previews in the
+// background. Jobs live in file_previews; failures are recorded with their
+// reason and only transient ones are retried.
+type PreviewService struct {
+	db           *pgxpool.Pool
+	storage      storage.Storage
+	queue        *fileJobQueue
+	pollInterval time.Duration
+}
+
+func NewPreviewService(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *PreviewService {
+	return &PreviewService{
+		db:           db,
+		storage:      store,
+		queue:        newPreviewQueue(db),
+		pollInterval: time.Duration(cfg.PreviewPollSeconds) * time.Second,
+	}
+}
+
+func newPreviewQueue(db *pgxpool.Pool) *fileJobQueue {
+	return &fileJobQueue{
+		db:          db,
+		table:       "file_previews",
+		kind:        "preview",
+		batchSize:   previewBatchSize,
+		maxAttempts: maxPreviewAttempts,
+		staleAfter:  previewStaleAfter,
+	}
+}
+
+// Run processes queued previews until ctx is cancelled. A non-positive
+// PREVIEW_POLL_SECONDS disables the worker.
+func (s *PreviewService) Run(ctx context.Context) {
+	if s.pollInterval <= 0 {
+		log.Printf("Preview worker disabled")
+		return
+	}
+	s.queue.run(ctx, s.pollInterval, s.process)
+}
+
+// ProcessBatch claims up to previewBatchSize due jobs and generates their
+// previews. It returns how many jobs it claimed.
+func (s *PreviewService) ProcessBatch(ctx context.Context) (int, error) {
+	return s.queue.processBatch(ctx, s.process)
+}
+
+func (s *PreviewService) process(ctx context.Context, job fileJob) {
+	source, err := s.storage.Get(ctx, job.path)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.queue.finish(job, string(models.PreviewFailed), err)
+		} else {
+			s.queue.retryOrFail(job, err, string(models.PreviewFailed))
+		}
+		return
+	}
+	generated, err := preview.Generate(source)
+	source.Close()
+	if err != nil {
+		// Content that cannot be decoded now will not decode later either.
+		status := models.PreviewFailed
+		if errors.Is(err, preview.ErrUnsupported) {
+			status = models.PreviewUnsupported
+		}
+		s.queue.finish(job, string(status), err)
+		return
+	}
+
+	err = s.storage.Put(ctx, previewKey(job.path), bytes.NewReader(generated.Data), int64(len(generated.Data)), "image/jpeg")
+	if err != nil {
+		s.queue.retryOrFail(job, fmt.Errorf("could not store preview: %v", err), string(models.PreviewFailed))
+		return
+	}
+
+	s.queue.complete(ctx, job, string(models.PreviewReady), "width = $3, height = $4", generated.Width, generated.Height)
+}
+
+// GetPreview opens the stored preview of a file once it is ready.
+func (s *PreviewService) GetPreview(fileID string) (io.ReadCloser, error) {
+	var filePath string
+	var status models.PreviewStatus
+	err := s.db.QueryRow(context.Background(),
+		`SELECT f.path, p.status FROM file_previews p JOIN folder_file_info f ON f.id = p.file_id WHERE p.file_id = $1`,
+		fileID).Scan(&filePath, &status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrPreviewNotFound
+		}
+		return nil, fmt.Errorf("could not retrieve preview: %v", err)
+	}
+	if status != models.PreviewReady {
+		return nil, ErrPreviewNotFound
+	}
+
+	reader, err := s.storage.Get(context.Background(), previewKey(filePath))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrPreviewNotFound
+		}
+		return nil, fmt.Errorf("could not retrieve preview: %v", err)
+	}
+	return reader, nil
+}
+
+func previewKey(blobPath string) string {
+	dir, name := path.Split(blobPath)
+	return path.Join(dir, previewDir, name+".jpg")
+}
+
+func isPreviewKey(key string) bool {
+	return strings.Contains("/"+key, "/"+previewDir+"/")
+}
+
+func previewURL(fileID string) string {
+	return fmt.Sprintf("/api/v1/records/files/%s/preview", fileID)
+}
+
+// queuePreview schedules generation of a file's preview, skipping types that
+// can never have one.
+func queuePreview(db *pgxpool.Pool, fileID, name string, ext *string) {
+	if !preview.Supported(fileExtension(name, ext)) {
+		return
+	}
+	newPreviewQueue(db).enqueue(fileID)
+}
+
+func fileExtension(name string, ext *string) string {
+	if ext != nil && *ext != "" {
+		return *ext
+	}
+	return path.Ext(name)
+}
+
+// attachPreviews fills the preview status and URL of the files in a listing.
+func attachPreviews(db *pgxpool.Pool, items []models.FileFolder) error {
+	var fileIDs []string
+	for _, item := range items {
+		if item.Type != "folder" {
+			fileIDs = append(fileIDs, item.ID)
+		}
+	}
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query(context.Background(),
+		"SELECT file_id::text, status FROM file_previews WHERE file_id = ANY($1::uuid[])", fileIDs)
+	if err != nil {
+		return fmt.Errorf("error querying previews: %v", err)
+	}
+	defer rows.Close()
+
+	statuses := map[string]models.PreviewStatus{}
+	for rows.Next() {
+		var fileID string
+		var status models.PreviewStatus
+		if err := rows.Scan(&fileID, &status); err != nil {
+			return fmt.Errorf("error scanning previews: %v", err)
+		}
+		statuses[fileID] = status
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error scanning previews: %v", err)
+	}
+
+	for i := range items {
+		status, ok := statuses[items[i].ID]
+		if !ok {
+			continue
+		}
+		items[i].PreviewStatus = status
+		if status == models.PreviewReady {
+			items[i].PreviewURL = previewURL(items[i].ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package medicalrecords
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewKey_StoredNextToBlob(t *testing.T) {
+	key := previewKey("records/my-records/u1/Labs/blood test.pdf")
+
+	assert.Equal(t, "records/my-records/u1/Labs/.previews/blood test.pdf.jpg", key)
+	assert.True(t, isPreviewKey(key))
+	assert.False(t, isPreviewKey("records/my-records/u1/Labs/blood test.pdf"))
+	assert.False(t, isPreviewKey("records/my-records/u1/my.previews/scan.png"))
+}
+
+func TestPreviewURL(t *testing.T) {
+	assert.Equal(t, "/api/v1/records/files/abc/preview", previewURL("abc"))
+}
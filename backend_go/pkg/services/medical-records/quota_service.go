@@ -0,0 +1,220 @@
+package medicalrecords
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/models"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+const uncategorized = "UNCATEGORIZED"
+
+// QuotaExceededError carries the numbers behind a rejected write so the
+// client can tell the user how much space is left.
+type QuotaExceededError struct {
+	QuotaBytes     int64 `json:"quota_bytes"`
+	UsedBytes      int64 `json:"used_bytes"`
+	RequestedBytes int64 `json:"requested_bytes"`
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d of %d bytes in use, %d more requested", e.UsedBytes, e.QuotaBytes, e.RequestedBytes)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// QuotaService accounts for the bytes each user stores. Everything in a
+// user's records tree counts, including copies shared with them, plus the
+// declared size of chunked uploads that are still in progress.
+type QuotaService struct {
+	db         *pgxpool.Pool
+	roleQuotas map[string]int64
+}
+
+func NewQuotaService(db *pgxpool.Pool, cfg *config.Config) *QuotaService {
+	return &QuotaService{
+		db: db,
+		roleQuotas: map[string]int64{
+			"patient":      int64(cfg.StorageQuotaMBPatient) << 20,
+			"doctor":       int64(cfg.StorageQuotaMBDoctor) << 20,
+			"receptionist": int64(cfg.StorageQuotaMBReceptionist) << 20,
+		},
+	}
+}
+
+// QuotaForRole returns the quota in bytes, or 0 when the role is unlimited.
+func (s *QuotaService) QuotaForRole(role string) int64 {
+	if quota := s.roleQuotas[role]; quota > 0 {
+		return quota
+	}
+	return 0
+}
+
+// CheckQuota returns a *QuotaExceededError when storing additional bytes for
+// the user would go over their role's quota.
+func (s *QuotaService) CheckQuota(userID, userType string, additional int64) error {
+	quota := s.QuotaForRole(userType)
+	if quota == 0 {
+		return nil
+	}
+
+	used, reserved, err := s.usedBytes(context.Background(), s.db, userID)
+	if err != nil {
+		return err
+	}
+	return checkQuota(quota, used+reserved, additional)
+}
+
+// reserveQuota is CheckQuota for a write registered in tx. It locks the
+// user's usage until tx ends, so concurrent writes for the same user are
+// checked one after the other, each against what the previous one stored.
+// Writes that are slow to store may still call CheckQuota first to fail
+// early.
+func (s *QuotaService) reserveQuota(ctx context.Context, tx pgx.Tx, userID, userType string, additional int64) error {
+	quota := s.QuotaForRole(userType)
+	if quota == 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", userID); err != nil {
+		return fmt.Errorf("could not lock storage usage: %v", err)
+	}
+	used, reserved, err := s.usedBytes(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	return checkQuota(quota, used+reserved, additional)
+}
+
+func checkQuota(quota, used, requested int64) error {
+	if quota > 0 && used+requested > quota {
+		return &QuotaExceededError{QuotaBytes: quota, UsedBytes: used, RequestedBytes: requested}
+	}
+	return nil
+}
+
+// Usage reports the user's total usage and a breakdown by record category.
+func (s *QuotaService) Usage(userID, userType string) (*models.StorageUsage, error) {
+	rows, err := s.db.Query(context.Background(),
+		`SELECT COALESCE(category, $2), COUNT(*), COALESCE(SUM(size), 0)
+		FROM folder_file_info
+		WHERE user_id = $1 AND type <> 'folder'
+		GROUP BY 1`, userID, uncategorized)
+	if err != nil {
+		return nil, fmt.Errorf("error querying storage usage: %v", err)
+	}
+	defer rows.Close()
+
+	usage := &models.StorageUsage{
+		UserID:     userID,
+		QuotaBytes: s.QuotaForRole(userType),
+		Categories: []models.CategoryUsage{},
+	}
+	for rows.Next() {
+		var category models.CategoryUsage
+		if err := rows.Scan(&category.Category, &category.FileCount, &category.Bytes); err != nil {
+			return nil, fmt.Errorf("error scanning storage usage: %v", err)
+		}
+		category.DisplayName = categoryDisplayName(category.Category)
+		usage.UsedBytes += category.Bytes
+		usage.FileCount += category.FileCount
+		usage.Categories = append(usage.Categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning storage usage: %v", err)
+	}
+	sort.Slice(usage.Categories, func(i, j int) bool {
+		return usage.Categories[i].Bytes > usage.Categories[j].Bytes
+	})
+
+	_, usage.ReservedBytes, err = s.usedBytes(context.Background(), s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if usage.QuotaBytes > 0 {
+		available := usage.QuotaBytes - usage.UsedBytes - usage.ReservedBytes
+		if available < 0 {
+			available = 0
+		}
+		usage.AvailableBytes = &available
+	}
+
+	return usage, nil
+}
+
+// FolderUsage sums the files below each of the given items, walking
+// subfolders recursively. A file's usage is its own size.
+func (s *QuotaService) FolderUsage(itemIDs []string) (map[string]models.FolderUsage, error) {
+	usage := make(map[string]models.FolderUsage, len(itemIDs))
+	if len(itemIDs) == 0 {
+		return usage, nil
+	}
+
+	rows, err := s.db.Query(context.Background(),
+		`WITH RECURSIVE tree AS (
+			SELECT id AS root_id, id, type, size FROM folder_file_info WHERE id = ANY($1::uuid[])
+			UNION ALL
+			SELECT tree.root_id, f.id, f.type, f.size
+			FROM folder_file_info f
+			JOIN tree ON f.parent_id = tree.id
+		)
+		SELECT root_id::text,
+			COUNT(*) FILTER (WHERE type <> 'folder'),
+			COALESCE(SUM(size) FILTER (WHERE type <> 'folder'), 0)
+		FROM tree
+		GROUP BY root_id`, itemIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error querying folder usage: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var folder models.FolderUsage
+		if err := rows.Scan(&folder.FolderID, &folder.FileCount, &folder.Bytes); err != nil {
+			return nil, fmt.Errorf("error scanning folder usage: %v", err)
+		}
+		usage[folder.FolderID] = folder
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning folder usage: %v", err)
+	}
+
+	return usage, nil
+}
+
+// rowQuerier is implemented by both the pool and transactions.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// usedBytes returns the bytes of stored files and of unexpired chunked
+// uploads that have not been completed yet.
+func (s *QuotaService) usedBytes(ctx context.Context, db rowQuerier, userID string) (int64, int64, error) {
+	var used, reserved int64
+	err := db.QueryRow(ctx,
+		`SELECT
+			(SELECT COALESCE(SUM(size), 0) FROM folder_file_info WHERE user_id = $1 AND type <> 'folder'),
+			(SELECT COALESCE(SUM(total_size), 0) FROM chunked_uploads WHERE user_id = $1 AND status = $2 AND expires_at > NOW())`,
+		userID, models.ChunkedUploadInProgress).Scan(&used, &reserved)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error querying storage usage: %v", err)
+	}
+	return used, reserved, nil
+}
+
+func categoryDisplayName(category string) string {
+	if category == uncategorized {
+		return "Uncategorized"
+	}
+	return models.Category(category).GetDisplayName()
+}
@@ -0,0 +1,50 @@
+package medicalrecords
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"healthcare_backend/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckQuota(t *testing.T) {
+	assert.NoError(t, checkQuota(100, 40, 60), "Filling the quota exactly is allowed")
+	assert.NoError(t, checkQuota(0, 1<<40, 1<<40), "A zero quota is unlimited")
+
+	err := checkQuota(100, 40, 61)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+
+	var quotaErr *QuotaExceededError
+	require.True(t, errors.As(fmt.Errorf("upload failed: %w", err), &quotaErr))
+	assert.Equal(t, int64(100), quotaErr.QuotaBytes)
+	assert.Equal(t, int64(40), quotaErr.UsedBytes)
+	assert.Equal(t, int64(61), quotaErr.RequestedBytes)
+}
+
+func TestCheckQuota_SizesAbove2GB(t *testing.T) {
+	const gb = int64(1) << 30
+	assert.NoError(t, checkQuota(10*gb, 3*gb, 5*gb))
+	assert.Error(t, checkQuota(10*gb, 6*gb, 5*gb))
+}
+
+func TestQuotaForRole(t *testing.T) {
+	s := NewQuotaService(nil, &config.Config{
+		StorageQuotaMBPatient: 2048,
+		StorageQuotaMBDoctor:  51200,
+	})
+
+	assert.Equal(t, int64(2048)<<20, s.QuotaForRole("patient"))
+	assert.Equal(t, int64(51200)<<20, s.QuotaForRole("doctor"))
+	assert.Zero(t, s.QuotaForRole("receptionist"), "Unset quotas are unlimited")
+	assert.Zero(t, s.QuotaForRole("unknown"))
+}
+
+func TestCategoryDisplayName(t *testing.T) {
+	assert.Equal(t, "Uncategorized", categoryDisplayName(uncategorized))
+	assert.Equal(t, "Lab Results", categoryDisplayName("LAB_RESULTS"))
+}
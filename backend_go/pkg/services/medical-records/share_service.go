@@ -10,7 +10,7 @@ import (
 
 	"healthcare_backend/pkg/config"
 	"healthcare_backend/pkg/models"
-	"healthcare_backend/pkg/utils"
+	"healthcare_backend/pkg/storage"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
@@ -20,13 +20,17 @@ import (
 type ShareService struct {
 	db             *pgxpool.Pool
 	cfg            *config.Config
+	storage        storage.Storage
+	quotaService   *QuotaService
 	historyService *HistoryService
 }
 
-func NewShareService(db *pgxpool.Pool, cfg *config.Config) *ShareService {
+func NewShareService(db *pgxpool.Pool, cfg *config.Config, store storage.Storage) *ShareService {
 	return &ShareService{
 		db:             db,
 		cfg:            cfg,
+		storage:        store,
+		quotaService:   NewQuotaService(db, cfg),
 		historyService: NewHistoryService(db),
 	}
 }
@@ -100,6 +104,37 @@ func (s *ShareService) GetDoctorByID(doctorID string) (*models.Doctor, error) {
 }
 
 func (s *ShareService) ShareItems(req models.ShareRequest) error {
+	var sharedWithType string
+	err := s.db.QueryRow(context.Background(),
+		"SELECT CASE WHEN EXISTS(SELECT 1 FROM doctor_info WHERE doctor_id::text = $1) THEN 'doctor' WHEN EXISTS(SELECT 1 FROM patient_info WHERE patient_id::text = $1) THEN 'patient' WHEN EXISTS(SELECT 1 FROM receptionists WHERE receptionist_id::text = $1) THEN 'receptionist' ELSE 'unknown' END",
+		req.SharedWithID).Scan(&sharedWithType)
+	if err != nil {
+		sharedWithType = "unknown"
+	}
+
+	// Shared items are copied into the recipient's records, so the copies
+	// count against the recipient's quota.
+	usage, err := s.quotaService.FolderUsage(req.ItemIDs)
+	if err != nil {
+		return err
+	}
+	var requested int64
+	for _, item := range usage {
+		requested += item.Bytes
+	}
+	// The copies are written outside the transaction, but it holds the
+	// recipient's quota lock until they are, so concurrent writes for the
+	// recipient are checked against them.
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := s.quotaService.reserveQuota(ctx, tx, req.SharedWithID, sharedWithType, requested); err != nil {
+		return err
+	}
+
 	for _, itemID := range req.ItemIDs {
 		var item models.FileFolder
 		err := s.db.QueryRow(context.Background(), "SELECT id, name, type, path, size, extension, user_id, user_type, parent_id, shared_by_id, created_at, updated_at, folder_type, category, owner_user_id, patient_id, uploaded_by_user_id, uploaded_by_role, included_in_rag FROM folder_file_info WHERE id = $1", itemID).
@@ -129,14 +164,6 @@ func (s *ShareService) ShareItems(req models.ShareRequest) error {
 			return fmt.Errorf("unable to insert shared item record: %v", err)
 		}
 
-		var sharedWithType string
-		err = s.db.QueryRow(context.Background(),
-			"SELECT CASE WHEN EXISTS(SELECT 1 FROM doctor_info WHERE doctor_id::text = $1) THEN 'doctor' WHEN EXISTS(SELECT 1 FROM patient_info WHERE patient_id::text = $1) THEN 'patient' WHEN EXISTS(SELECT 1 FROM receptionists WHERE receptionist_id::text = $1) THEN 'receptionist' ELSE 'unknown' END",
-			req.SharedWithID).Scan(&sharedWithType)
-		if err != nil {
-			sharedWithType = "unknown"
-		}
-
 		historyEntry := models.FileFolderHistory{
 			ItemID:          itemID,
 			ActionType:      models.ActionTypeShare,
@@ -191,9 +218,9 @@ func (s *ShareService) copyFileOrFolder(item models.FileFolder, req models.Share
 
 			markerKey := filepath.Join(sharerFolderPath, "marker.txt")
 			emptyFile := bytes.NewReader([]byte{})
-			err = utils.UploadToS3WithReader(markerKey, emptyFile, 0, "text/plain")
+			err = s.storage.Put(context.Background(), markerKey, emptyFile, 0, "text/plain")
 			if err != nil {
-				return fmt.Errorf("error creating sharer's folder in S3: %v", err)
+				return fmt.Errorf("error creating sharer's folder in storage: %v", err)
 			}
 		} else {
 			return fmt.Errorf("error checking sharer's folder: %v", err)
@@ -269,9 +296,9 @@ func (s *ShareService) copyItemRecursively(item models.FileFolder, recipientID s
 	} else {
 		normalizedItemPath := filepath.ToSlash(item.Path)
 
-		err := utils.CopyS3Object(normalizedItemPath, newItemPath)
+		err := s.storage.Copy(context.Background(), normalizedItemPath, newItemPath)
 		if err != nil {
-			return fmt.Errorf("error copying file in S3: %v", err)
+			return fmt.Errorf("error copying stored file: %v", err)
 		}
 
 		_, err = s.db.Exec(context.Background(),
@@ -281,6 +308,8 @@ func (s *ShareService) copyItemRecursively(item models.FileFolder, recipientID s
 		if err != nil {
 			return fmt.Errorf("error inserting file info into database: %v", err)
 		}
+		queuePreview(s.db, newItemID, item.Name, item.Ext)
+		queueTextIndex(s.db, newItemID, item.Name, item.Ext)
 
 		historyEntry := models.FileFolderHistory{
 			ItemID:          newItemID,
@@ -376,6 +405,10 @@ func (s *ShareService) GetSharedWithMe(userID string) ([]models.FileFolder, erro
 		items = append(items, item)
 	}
 
+	if err := attachPreviews(s.db, items); err != nil {
+		return nil, err
+	}
+
 	return items, nil
 }
 
@@ -447,5 +480,9 @@ func (s *ShareService) GetSharedByMe(userID string) ([]models.FileFolder, error)
 		items = append(items, item)
 	}
 
+	if err := attachPreviews(s.db, items); err != nil {
+		return nil, err
+	}
+
 	return items, nil
 }
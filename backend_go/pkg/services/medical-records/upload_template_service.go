@@ -0,0 +1,187 @@
+package medicalrecords
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"healthcare_backend/pkg/models"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var (
+	ErrUploadTemplateNotFound = errors.New("upload template not found")
+	ErrDuplicateTemplateName  = errors.New("an upload template with this name already exists")
+)
+
+const maxTemplateNameLen = 100
+
+const uploadTemplateColumns = `id::text, doctor_id::text, name, category, folder_name, body_part, created_at, updated_at`
+
+// UploadTemplateService manages the upload templates of doctors. The
+// receptionists of a doctor upload with the doctor's templates.
+type UploadTemplateService struct {
+	db *pgxpool.Pool
+}
+
+func NewUploadTemplateService(db *pgxpool.Pool) *UploadTemplateService {
+	return &UploadTemplateService{db: db}
+}
+
+func (s *UploadTemplateService) ListTemplates(doctorID string) ([]models.UploadTemplate, error) {
+	rows, err := s.db.Query(context.Background(),
+		`SELECT `+uploadTemplateColumns+`
+		FROM clinical_upload_templates
+		WHERE doctor_id = $1
+		ORDER BY name`,
+		doctorID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list upload templates: %v", err)
+	}
+	defer rows.Close()
+
+	templates := []models.UploadTemplate{}
+	for rows.Next() {
+		template, err := scanUploadTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read upload template: %v", err)
+		}
+		templates = append(templates, *template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list upload templates: %v", err)
+	}
+	return templates, nil
+}
+
+func (s *UploadTemplateService) CreateTemplate(doctorID string, req models.UploadTemplateRequest) (*models.UploadTemplate, error) {
+	name, category, folderName, bodyPart, err := validateUploadTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	row := s.db.QueryRow(context.Background(),
+		`INSERT INTO clinical_upload_templates (doctor_id, name, category, folder_name, body_part)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (doctor_id, name) DO NOTHING
+		RETURNING `+uploadTemplateColumns,
+		doctorID, name, category, folderName, bodyPart)
+	template, err := scanUploadTemplate(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDuplicateTemplateName
+		}
+		return nil, fmt.Errorf("could not create upload template: %v", err)
+	}
+	return template, nil
+}
+
+func (s *UploadTemplateService) UpdateTemplate(doctorID, templateID string, req models.UploadTemplateRequest) (*models.UploadTemplate, error) {
+	name, category, folderName, bodyPart, err := validateUploadTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var taken bool
+	err = s.db.QueryRow(context.Background(),
+		`SELECT EXISTS(SELECT 1 FROM clinical_upload_templates WHERE doctor_id = $1 AND name = $2 AND id <> $3::uuid)`,
+		doctorID, name, templateID).Scan(&taken)
+	if err != nil {
+		return nil, fmt.Errorf("could not check upload template name: %v", err)
+	}
+	if taken {
+		return nil, ErrDuplicateTemplateName
+	}
+
+	row := s.db.QueryRow(context.Background(),
+		`UPDATE clinical_upload_templates
+		SET name = $3, category = $4, folder_name = $5, body_part = $6, updated_at = $7
+		WHERE id = $1::uuid AND doctor_id = $2
+		RETURNING `+uploadTemplateColumns,
+		templateID, doctorID, name, category, folderName, bodyPart, time.Now())
+	template, err := scanUploadTemplate(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUploadTemplateNotFound
+		}
+		return nil, fmt.Errorf("could not update upload template: %v", err)
+	}
+	return template, nil
+}
+
+func (s *UploadTemplateService) DeleteTemplate(doctorID, templateID string) error {
+	tag, err := s.db.Exec(context.Background(),
+		"DELETE FROM clinical_upload_templates WHERE id = $1::uuid AND doctor_id = $2",
+		templateID, doctorID)
+	if err != nil {
+		return fmt.Errorf("could not delete upload template: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUploadTemplateNotFound
+	}
+	return nil
+}
+
+func validateUploadTemplate(req models.UploadTemplateRequest) (string, models.Category, *string, *string, error) {
+	problems := &MetadataError{}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		problems.add("name", "is required")
+	} else if len(name) > maxTemplateNameLen {
+		problems.add("name", fmt.Sprintf("must be at most %d characters", maxTemplateNameLen))
+	}
+
+	category := models.Category(strings.ToUpper(strings.TrimSpace(req.Category)))
+	if category == "" {
+		problems.add("category", "is required")
+	} else if !category.IsValid() {
+		problems.add("category", fmt.Sprintf("%q is not a known category", req.Category))
+	}
+
+	var folderName *string
+	if req.FolderName != nil {
+		if trimmed := strings.TrimSpace(*req.FolderName); len(trimmed) > maxFolderNameLen {
+			problems.add("folder_name", fmt.Sprintf("must be at most %d characters", maxFolderNameLen))
+		} else if trimmed != "" {
+			folderName = &trimmed
+		}
+	}
+
+	var bodyPart *string
+	if req.BodyPart != nil {
+		if upper := strings.ToUpper(strings.TrimSpace(*req.BodyPart)); upper != "" {
+			if models.BodyPart(upper).IsValid() {
+				bodyPart = &upper
+			} else {
+				problems.add("body_part", fmt.Sprintf("%q is not a known body part", *req.BodyPart))
+			}
+		}
+	}
+
+	if len(problems.Fields) > 0 {
+		return "", "", nil, nil, problems
+	}
+	return name, category, folderName, bodyPart, nil
+}
+
+func scanUploadTemplate(row pgx.Row) (*models.UploadTemplate, error) {
+	var template models.UploadTemplate
+	var category string
+	var bodyPart *string
+	err := row.Scan(&template.ID, &template.DoctorID, &template.Name, &category,
+		&template.FolderName, &bodyPart, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	template.Category = models.Category(category)
+	if bodyPart != nil {
+		part := models.BodyPart(*bodyPart)
+		template.BodyPart = &part
+	}
+	return &template, nil
+}
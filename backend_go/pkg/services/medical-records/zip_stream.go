@@ -0,0 +1,165 @@
+package medicalrecords
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+const zipManifestName = "download_manifest.json"
+
+type zipFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+type zipManifest struct {
+	GeneratedAt   time.Time    `json:"generated_at"`
+	IncludedFiles int          `json:"included_files"`
+	FailedFiles   []zipFailure `json:"failed_files"`
+}
+
+// trackingWriter remembers the first write error so a failing client can be
+// told apart from a failing source object.
+type trackingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// zipStream writes archive entries directly to its destination. Objects that
+// cannot be read are skipped and listed in a manifest entry instead.
+type zipStream struct {
+	out      *trackingWriter
+	zw       *zip.Writer
+	open     func(key string) (io.ReadCloser, error)
+	included int
+	failures []zipFailure
+}
+
+func newZipStream(w io.Writer, open func(key string) (io.ReadCloser, error)) *zipStream {
+	out := &trackingWriter{w: w}
+	return &zipStream{
+		out:  out,
+		zw:   zip.NewWriter(out),
+		open: open,
+	}
+}
+
+// addObject copies one stored object into the archive under name. The
+// object is staged on disk first, so one that fails mid-read is left out
+// rather than written as a truncated entry. It only returns an error when
+// the destination can no longer be written to.
+func (z *zipStream) addObject(key, name string) error {
+	staged, err := z.stage(key)
+	if err != nil {
+		z.fail(name, err)
+		return nil
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	entry, err := z.zw.CreateHeader(&zip.FileHeader{
+		Name:     path.Clean(name),
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		if z.out.err != nil {
+			return z.out.err
+		}
+		z.fail(name, fmt.Errorf("could not create zip entry: %v", err))
+		return nil
+	}
+
+	if _, err := io.Copy(entry, staged); err != nil {
+		if z.out.err != nil {
+			return z.out.err
+		}
+		return fmt.Errorf("could not write %s to the archive: %v", name, err)
+	}
+
+	z.included++
+	return nil
+}
+
+// stage copies the object to a temporary file positioned at its start.
+func (z *zipStream) stage(key string) (*os.File, error) {
+	reader, err := z.open(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch file: %v", err)
+	}
+	defer reader.Close()
+
+	staged, err := os.CreateTemp("", "zip-entry-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %v", err)
+	}
+	_, err = io.Copy(staged, reader)
+	if err == nil {
+		_, err = staged.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		staged.Close()
+		os.Remove(staged.Name())
+		return nil, fmt.Errorf("file was truncated while reading: %v", err)
+	}
+	return staged, nil
+}
+
+func (z *zipStream) fail(name string, err error) {
+	log.Printf("Skipping %s in zip download: %v", name, err)
+	z.failures = append(z.failures, zipFailure{Name: name, Error: err.Error()})
+}
+
+// close appends the manifest when any file failed and finalizes the archive.
+func (z *zipStream) close() error {
+	if len(z.failures) > 0 {
+		entry, err := z.zw.Create(zipManifestName)
+		if err != nil {
+			return fmt.Errorf("could not create manifest entry: %v", err)
+		}
+		manifest := zipManifest{
+			GeneratedAt:   time.Now().UTC(),
+			IncludedFiles: z.included,
+			FailedFiles:   z.failures,
+		}
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(manifest); err != nil {
+			return fmt.Errorf("could not write manifest entry: %v", err)
+		}
+	}
+	return z.zw.Close()
+}
+
+// pipeZip runs build against a zipStream in the background and returns the
+// reading end, so the archive is produced only as fast as it is consumed.
+// Closing the returned reader aborts the build.
+func pipeZip(open func(key string) (io.ReadCloser, error), build func(z *zipStream) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		z := newZipStream(pw, open)
+		err := build(z)
+		if err == nil {
+			err = z.close()
+		}
+		if err != nil {
+			log.Printf("Zip download aborted: %v", err)
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
@@ -0,0 +1,140 @@
+package medicalrecords
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeOpener(objects map[string]string) func(key string) (io.ReadCloser, error) {
+	return func(key string) (io.ReadCloser, error) {
+		content, ok := objects[key]
+		if !ok {
+			return nil, errors.New("NoSuchKey")
+		}
+		return io.NopCloser(strings.NewReader(content)), nil
+	}
+}
+
+func readZip(t *testing.T, r io.Reader) map[string]string {
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		entries[f.Name] = string(content)
+	}
+	return entries
+}
+
+func TestPipeZip_StreamsEntries(t *testing.T) {
+	open := fakeOpener(map[string]string{
+		"records/a.pdf": "first",
+		"records/b.txt": "second",
+	})
+
+	reader := pipeZip(open, func(z *zipStream) error {
+		if err := z.addObject("records/a.pdf", "a.pdf"); err != nil {
+			return err
+		}
+		return z.addObject("records/b.txt", "nested/b.txt")
+	})
+	defer reader.Close()
+
+	entries := readZip(t, reader)
+	assert.Equal(t, "first", entries["a.pdf"])
+	assert.Equal(t, "second", entries["nested/b.txt"])
+	assert.NotContains(t, entries, zipManifestName, "Manifest should only be written when files fail")
+}
+
+func TestPipeZip_ListsFailuresInManifest(t *testing.T) {
+	open := fakeOpener(map[string]string{
+		"records/a.pdf": "first",
+	})
+
+	reader := pipeZip(open, func(z *zipStream) error {
+		z.fail("missing-id", errors.New("file not found"))
+		if err := z.addObject("records/a.pdf", "a.pdf"); err != nil {
+			return err
+		}
+		return z.addObject("records/gone.pdf", "gone.pdf")
+	})
+	defer reader.Close()
+
+	entries := readZip(t, reader)
+	assert.Equal(t, "first", entries["a.pdf"])
+	assert.NotContains(t, entries, "gone.pdf")
+	require.Contains(t, entries, zipManifestName)
+
+	var manifest zipManifest
+	require.NoError(t, json.Unmarshal([]byte(entries[zipManifestName]), &manifest))
+	assert.Equal(t, 1, manifest.IncludedFiles)
+	require.Len(t, manifest.FailedFiles, 2)
+	assert.Equal(t, "missing-id", manifest.FailedFiles[0].Name)
+	assert.Equal(t, "gone.pdf", manifest.FailedFiles[1].Name)
+}
+
+func TestPipeZip_LeavesOutObjectsThatFailMidRead(t *testing.T) {
+	open := func(key string) (io.ReadCloser, error) {
+		if key == "records/broken.pdf" {
+			return io.NopCloser(io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("connection reset")))), nil
+		}
+		return fakeOpener(map[string]string{"records/a.pdf": "first"})(key)
+	}
+
+	reader := pipeZip(open, func(z *zipStream) error {
+		if err := z.addObject("records/broken.pdf", "broken.pdf"); err != nil {
+			return err
+		}
+		return z.addObject("records/a.pdf", "a.pdf")
+	})
+	defer reader.Close()
+
+	entries := readZip(t, reader)
+	assert.Equal(t, "first", entries["a.pdf"])
+	assert.NotContains(t, entries, "broken.pdf", "A truncated object must not appear under its real name")
+
+	var manifest zipManifest
+	require.NoError(t, json.Unmarshal([]byte(entries[zipManifestName]), &manifest))
+	require.Len(t, manifest.FailedFiles, 1)
+	assert.Equal(t, "broken.pdf", manifest.FailedFiles[0].Name)
+}
+
+func TestPipeZip_StopsWhenReaderIsClosed(t *testing.T) {
+	open := func(key string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(bytes.Repeat([]byte("x"), 1<<20))), nil
+	}
+
+	done := make(chan error, 1)
+	reader := pipeZip(open, func(z *zipStream) error {
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			err = z.addObject("records/big.bin", "big.bin")
+		}
+		done <- err
+		return err
+	})
+
+	buf := make([]byte, 512)
+	_, err := reader.Read(buf)
+	require.NoError(t, err)
+	reader.Close()
+
+	assert.ErrorIs(t, <-done, io.ErrClosedPipe)
+}
@@ -388,6 +388,15 @@ func (s *ReceptionistPatientService) GetPatientDetailedInfo(receptionistID, pati
 				apt.MedicalReport = medicalReport
 			}
 
+			if medicalReport != nil {
+				labObservations, err := s.getLabObservationsForReport(ctx, medicalReport.ReportID)
+				if err != nil {
+					log.Printf("Failed to get lab observations for report %s: %v", medicalReport.ReportID, err)
+				} else {
+					medicalReport.LabObservations = labObservations
+				}
+			}
+
 			diagnosisHistory, err := s.getDiagnosisHistoryForAppointment(ctx, apt.AppointmentID)
 			if err != nil {
 				log.Printf("Failed to get diagnosis history for appointment %s: %v", apt.AppointmentID, err)
@@ -638,6 +647,57 @@ func (s *ReceptionistPatientService) getMedicalReportForAppointment(ctx context.
 	return &report, nil
 }
 
+func (s *ReceptionistPatientService) getLabObservationsForReport(ctx context.Context, reportID uuid.UUID) ([]models.LabObservation, error) {
+	query := `
+		SELECT
+			id::text, patient_id::text, doctor_id::text, report_id::text, file_id::text,
+			test_code, test_name, value::float8, value_text, unit,
+			reference_low::float8, reference_high::float8, reference_text, abnormal_flag,
+			COALESCE(abnormal_flag <> 'N', false) AS out_of_range,
+			collected_at, entered_by_id::text, entered_by_role, created_at
+		FROM lab_observations
+		WHERE report_id = $1
+		ORDER BY collected_at ASC, test_code ASC`
+
+	rows, err := s.db.Query(ctx, query, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var observations []models.LabObservation
+	for rows.Next() {
+		var obs models.LabObservation
+		err := rows.Scan(
+			&obs.ID,
+			&obs.PatientID,
+			&obs.DoctorID,
+			&obs.ReportID,
+			&obs.FileID,
+			&obs.TestCode,
+			&obs.TestName,
+			&obs.Value,
+			&obs.ValueText,
+			&obs.Unit,
+			&obs.ReferenceLow,
+			&obs.ReferenceHigh,
+			&obs.ReferenceText,
+			&obs.AbnormalFlag,
+			&obs.OutOfRange,
+			&obs.CollectedAt,
+			&obs.EnteredByID,
+			&obs.EnteredByRole,
+			&obs.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		observations = append(observations, obs)
+	}
+
+	return observations, rows.Err()
+}
+
 func (s *ReceptionistPatientService) getDiagnosisHistoryForAppointment(ctx context.Context, appointmentID uuid.UUID) ([]models.DiagnosisHistory, error) {
 	query := `
 		SELECT 
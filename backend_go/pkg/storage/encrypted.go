@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"healthcare_backend/pkg/encryption"
+
+	"github.com/google/uuid"
+)
+
+// ErrNoOwner is returned for keys that do not belong to a user's records and
+// so have no data key to encrypt with.
+var ErrNoOwner = errors.New("object key has no owner to encrypt for")
+
+// KeySource provides the data key of an owner of records.
+type KeySource interface {
+	DataKey(ctx context.Context, ownerID string) ([]byte, error)
+}
+
+// EncryptedStorage encrypts objects with the data key of their owner before
+// they reach the backend, and decrypts them on the way back. Objects written
+// before encryption was enabled are read as they are.
+type EncryptedStorage struct {
+	backend Storage
+	keys    KeySource
+}
+
+func NewEncryptedStorage(backend Storage, keys KeySource) *EncryptedStorage {
+	return &EncryptedStorage{backend: backend, keys: keys}
+}
+
+// ownerOf returns the user whose records hold the key, the third segment of
+// keys such as "records/my-records/<userID>/..." and
+// "records/shared-with-me/<userID>/...".
+func ownerOf(key string) (string, error) {
+	segments := strings.Split(strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(key)), "/"), "/")
+	if len(segments) < 3 || segments[0] != "records" {
+		return "", fmt.Errorf("%w: %s", ErrNoOwner, key)
+	}
+	if _, err := uuid.Parse(segments[2]); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNoOwner, key)
+	}
+	return segments[2], nil
+}
+
+func (s *EncryptedStorage) dataKey(ctx context.Context, key string) ([]byte, error) {
+	ownerID, err := ownerOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.keys.DataKey(ctx, ownerID)
+}
+
+func (s *EncryptedStorage) Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	dataKey, err := s.dataKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		spooled, spooledSize, err := spool(content)
+		if err != nil {
+			return err
+		}
+		defer closeSpool(spooled)
+		content, size = spooled, spooledSize
+	}
+
+	encrypted, err := encryption.NewEncryptReader(dataKey, content, size)
+	if err != nil {
+		return err
+	}
+	return s.backend.Put(ctx, key, encrypted, encryption.EncryptedSize(size), contentType)
+}
+
+func (s *EncryptedStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.backend.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(object)
+	start, _ := reader.Peek(encryption.HeaderSize)
+	if !encryption.IsEncrypted(start) {
+		return readCloser{reader, object}, nil
+	}
+
+	dataKey, err := s.dataKey(ctx, key)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+	return readCloser{encryption.NewDecryptReader(dataKey, reader), object}, nil
+}
+
+func (s *EncryptedStorage) Delete(ctx context.Context, key string) error {
+	return s.backend.Delete(ctx, key)
+}
+
+// Copy copies the stored bytes when both keys belong to the same owner.
+// Copies to another owner, such as shares, are re-encrypted with the
+// recipient's data key.
+func (s *EncryptedStorage) Copy(ctx context.Context, sourceKey, destKey string) error {
+	sourceOwner, sourceErr := ownerOf(sourceKey)
+	destOwner, destErr := ownerOf(destKey)
+	if destErr != nil {
+		return destErr
+	}
+	if sourceErr == nil && sourceOwner == destOwner {
+		return s.backend.Copy(ctx, sourceKey, destKey)
+	}
+
+	source, err := s.Get(ctx, sourceKey)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	return s.Put(ctx, destKey, source, -1, "")
+}
+
+func (s *EncryptedStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	return s.backend.List(ctx, prefix)
+}
+
+func (s *EncryptedStorage) CreateMultipart(ctx context.Context, key, contentType string) (string, error) {
+	if _, err := ownerOf(key); err != nil {
+		return "", err
+	}
+	return s.backend.CreateMultipart(ctx, key, contentType)
+}
+
+// UploadPart encrypts each part on its own; the assembled object is read
+// back as one stream per part.
+func (s *EncryptedStorage) UploadPart(ctx context.Context, key, uploadID string, number int, content io.Reader, size int64) (string, error) {
+	dataKey, err := s.dataKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := encryption.NewEncryptReader(dataKey, content, size)
+	if err != nil {
+		return "", err
+	}
+	return s.backend.UploadPart(ctx, key, uploadID, number, encrypted, encryption.EncryptedSize(size))
+}
+
+func (s *EncryptedStorage) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	return s.backend.CompleteMultipart(ctx, key, uploadID, parts)
+}
+
+func (s *EncryptedStorage) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	return s.backend.AbortMultipart(ctx, key, uploadID)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// spool buffers content of unknown size in a temporary file, since the
+// ciphertext size must be known before the upload starts.
+func spool(content io.Reader) (*os.File, int64, error) {
+	tmp, err := os.CreateTemp("", "record-spool-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create spool file: %v", err)
+	}
+	size, err := io.Copy(tmp, content)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		closeSpool(tmp)
+		return nil, 0, fmt.Errorf("failed to spool content: %v", err)
+	}
+	return tmp, size, nil
+}
+
+func closeSpool(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
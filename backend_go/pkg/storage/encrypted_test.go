@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"healthcare_backend/pkg/encryption"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	ownerA = "11111111-1111-1111-1111-111111111111"
+	ownerB = "22222222-2222-2222-2222-222222222222"
+)
+
+// derivedKeys gives each owner a fixed key and counts requests.
+type derivedKeys map[string]int
+
+func (k derivedKeys) DataKey(_ context.Context, ownerID string) ([]byte, error) {
+	k[ownerID]++
+	sum := sha256.Sum256([]byte(ownerID))
+	return sum[:], nil
+}
+
+func encryptedLocal(t *testing.T) (*EncryptedStorage, string, derivedKeys) {
+	root := t.TempDir()
+	keys := derivedKeys{}
+	return NewEncryptedStorage(NewLocalStorage(root), keys), root, keys
+}
+
+func rawObject(t *testing.T, root, key string) []byte {
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(key)))
+	require.NoError(t, err)
+	return data
+}
+
+func TestEncryptedStorage_PutGet(t *testing.T) {
+	s, root, _ := encryptedLocal(t)
+	ctx := context.Background()
+	key := "records/my-records/" + ownerA + "/labs/cbc.txt"
+
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("hemoglobin 13.2"), 15, "text/plain"))
+	assert.Equal(t, "hemoglobin 13.2", readObject(t, s, key))
+
+	stored := rawObject(t, root, key)
+	assert.True(t, encryption.IsEncrypted(stored))
+	assert.NotContains(t, string(stored), "hemoglobin")
+	assert.Equal(t, encryption.EncryptedSize(15), int64(len(stored)))
+
+	require.NoError(t, s.Put(ctx, key, strings.NewReader("unknown size"), -1, ""))
+	assert.Equal(t, "unknown size", readObject(t, s, key))
+}
+
+func TestEncryptedStorage_ReadsPlaintextObjects(t *testing.T) {
+	s, root, keys := encryptedLocal(t)
+	key := "records/my-records/" + ownerA + "/old.txt"
+	require.NoError(t, NewLocalStorage(root).Put(context.Background(), key, strings.NewReader("stored before encryption"), -1, ""))
+
+	assert.Equal(t, "stored before encryption", readObject(t, s, key))
+	assert.Zero(t, keys[ownerA], "Plaintext objects need no key")
+}
+
+func TestEncryptedStorage_RefusesKeysWithoutOwner(t *testing.T) {
+	s, _, _ := encryptedLocal(t)
+	err := s.Put(context.Background(), "chat/uploads/x.png", strings.NewReader("x"), 1, "")
+	assert.ErrorIs(t, err, ErrNoOwner)
+	err = s.Put(context.Background(), "records/my-records/not-a-user/x.png", strings.NewReader("x"), 1, "")
+	assert.ErrorIs(t, err, ErrNoOwner)
+}
+
+func TestEncryptedStorage_CopyReencryptsForOtherOwners(t *testing.T) {
+	s, root, keys := encryptedLocal(t)
+	ctx := context.Background()
+	source := "records/my-records/" + ownerA + "/scan.txt"
+	require.NoError(t, s.Put(ctx, source, strings.NewReader("chest x-ray"), 11, ""))
+
+	moved := "records/medical-records/" + ownerA + "/Imaging/scan.txt"
+	require.NoError(t, s.Copy(ctx, source, moved))
+	assert.Equal(t, rawObject(t, root, source), rawObject(t, root, moved), "Same owner copies keep the ciphertext")
+
+	shared := "records/shared-with-me/" + ownerB + "/Dr A/scan.txt"
+	require.NoError(t, s.Copy(ctx, source, shared))
+	assert.NotEqual(t, rawObject(t, root, source), rawObject(t, root, shared))
+	assert.Equal(t, "chest x-ray", readObject(t, s, shared))
+	assert.Positive(t, keys[ownerB])
+
+	// The shared copy cannot be read with the sharer's key.
+	onlyA := NewEncryptedStorage(NewLocalStorage(root), ownerOnly{derivedKeys{}, ownerA})
+	reader, err := onlyA.Get(ctx, shared)
+	require.NoError(t, err)
+	defer reader.Close()
+	_, err = reader.Read(make([]byte, 16))
+	assert.Error(t, err)
+}
+
+// ownerOnly hands out one owner's key for every owner.
+type ownerOnly struct {
+	keys  derivedKeys
+	owner string
+}
+
+func (o ownerOnly) DataKey(ctx context.Context, _ string) ([]byte, error) {
+	return o.keys.DataKey(ctx, o.owner)
+}
+
+func TestEncryptedStorage_Multipart(t *testing.T) {
+	s, _, _ := encryptedLocal(t)
+	ctx := context.Background()
+	key := "records/my-records/" + ownerA + "/big.bin"
+
+	uploadID, err := s.CreateMultipart(ctx, key, "application/octet-stream")
+	require.NoError(t, err)
+	parts := []string{strings.Repeat("a", 70<<10), strings.Repeat("b", 1000), "c"}
+	var uploaded []Part
+	// Upload out of order; the backend assembles by part number.
+	for _, number := range []int{2, 1, 3} {
+		etag, err := s.UploadPart(ctx, key, uploadID, number, strings.NewReader(parts[number-1]), int64(len(parts[number-1])))
+		require.NoError(t, err)
+		uploaded = append(uploaded, Part{Number: number, ETag: etag})
+	}
+	require.NoError(t, s.CompleteMultipart(ctx, key, uploadID, uploaded))
+
+	assert.Equal(t, strings.Join(parts, ""), readObject(t, s, key))
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const multipartDir = ".multipart"
+
+// LocalStorage keeps objects as files under a root directory. It is meant for
+// development and single-node deployments without S3.
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) *LocalStorage {
+	if root == "" {
+		root = "storage"
+	}
+	return &LocalStorage{root: root}
+}
+
+// resolve maps a key to a file path that cannot escape the root directory.
+func (s *LocalStorage) resolve(key string) string {
+	cleaned := path.Clean("/" + filepath.ToSlash(key))
+	return filepath.Join(s.root, filepath.FromSlash(strings.TrimPrefix(cleaned, "/")))
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	target := s.resolve(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return fmt.Errorf("failed to prepare storage directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), target)
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(s.resolve(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to open %s: %v", key, err)
+	}
+	return file, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.resolve(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) Copy(ctx context.Context, sourceKey, destKey string) error {
+	source, err := s.Get(ctx, sourceKey)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return s.Put(ctx, destKey, source, -1, "")
+}
+
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	prefix = strings.TrimPrefix(filepath.ToSlash(prefix), "/")
+
+	// Walk from the parent directory of the prefix, then filter by prefix.
+	walkRoot := s.root
+	if dir := path.Dir(strings.TrimPrefix(path.Clean("/"+prefix), "/")); dir != "." && dir != "" {
+		walkRoot = s.resolve(dir)
+	}
+	var keys []string
+	err := filepath.WalkDir(walkRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == multipartDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) && !strings.HasPrefix(path.Base(key), ".upload-") {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list %s: %v", prefix, err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *LocalStorage) partsDir(uploadID string) string {
+	return filepath.Join(s.root, multipartDir, filepath.Base(uploadID))
+}
+
+func (s *LocalStorage) CreateMultipart(ctx context.Context, key, contentType string) (string, error) {
+	uploadID := uuid.New().String()
+	if err := os.MkdirAll(s.partsDir(uploadID), 0o750); err != nil {
+		return "", fmt.Errorf("failed to prepare multipart directory: %v", err)
+	}
+	return uploadID, nil
+}
+
+func (s *LocalStorage) UploadPart(ctx context.Context, key, uploadID string, number int, content io.Reader, size int64) (string, error) {
+	dir := s.partsDir(uploadID)
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("multipart upload %s not found", uploadID)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create part file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write part %d: %v", number, err)
+	}
+	if size >= 0 && written != size {
+		return "", fmt.Errorf("part %d has %d bytes, expected %d", number, written, size)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, strconv.Itoa(number))); err != nil {
+		return "", fmt.Errorf("failed to store part %d: %v", number, err)
+	}
+	return strconv.Itoa(number), nil
+}
+
+func (s *LocalStorage) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	dir := s.partsDir(uploadID)
+
+	sorted := append([]Part(nil), parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+
+	readers := make([]io.Reader, 0, len(sorted))
+	for _, part := range sorted {
+		file, err := os.Open(filepath.Join(dir, strconv.Itoa(part.Number)))
+		if err != nil {
+			return fmt.Errorf("missing part %d: %v", part.Number, err)
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	if err := s.Put(ctx, key, io.MultiReader(readers...), -1, ""); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func (s *LocalStorage) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	return os.RemoveAll(s.partsDir(uploadID))
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readObject(t *testing.T, s Storage, key string) string {
+	rc, err := s.Get(context.Background(), key)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestLocalStorage_PutGetDelete(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "records/my-records/u1/a.txt", strings.NewReader("hello"), 5, "text/plain"))
+	assert.Equal(t, "hello", readObject(t, s, "records/my-records/u1/a.txt"))
+
+	require.NoError(t, s.Delete(ctx, "records/my-records/u1/a.txt"))
+	_, err := s.Get(ctx, "records/my-records/u1/a.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalStorage_KeysCannotEscapeRoot(t *testing.T) {
+	root := t.TempDir()
+	s := NewLocalStorage(root)
+
+	assert.True(t, strings.HasPrefix(s.resolve("../../etc/passwd"), root))
+}
+
+func TestLocalStorage_ListByPrefix(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+
+	for _, key := range []string{
+		"records/my-records/u1/folder/marker.txt",
+		"records/my-records/u1/folder/scan.dcm",
+		"records/my-records/u1/folder-2/other.pdf",
+		"records/my-records/u2/x.pdf",
+	} {
+		require.NoError(t, s.Put(ctx, key, strings.NewReader(key), -1, ""))
+	}
+
+	keys, err := s.List(ctx, "records/my-records/u1/folder/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"records/my-records/u1/folder/marker.txt",
+		"records/my-records/u1/folder/scan.dcm",
+	}, keys)
+
+	keys, err = s.List(ctx, "records/my-records/missing/")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestLocalStorage_MultipartAssemblesPartsInOrder(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+
+	uploadID, err := s.CreateMultipart(ctx, "records/big.dcm", "application/dicom")
+	require.NoError(t, err)
+
+	// Parts arrive out of order and part 2 is re-sent.
+	_, err = s.UploadPart(ctx, "records/big.dcm", uploadID, 2, strings.NewReader("xxx"), 3)
+	require.NoError(t, err)
+	_, err = s.UploadPart(ctx, "records/big.dcm", uploadID, 1, strings.NewReader("aaa"), 3)
+	require.NoError(t, err)
+	_, err = s.UploadPart(ctx, "records/big.dcm", uploadID, 2, strings.NewReader("bbb"), 3)
+	require.NoError(t, err)
+
+	_, err = s.UploadPart(ctx, "records/big.dcm", uploadID, 3, strings.NewReader("c"), 2)
+	assert.Error(t, err, "Short parts should be rejected")
+	_, err = s.UploadPart(ctx, "records/big.dcm", uploadID, 3, strings.NewReader("c"), 1)
+	require.NoError(t, err)
+
+	require.NoError(t, s.CompleteMultipart(ctx, "records/big.dcm", uploadID, []Part{{Number: 3}, {Number: 1}, {Number: 2}}))
+	assert.Equal(t, "aaabbbc", readObject(t, s, "records/big.dcm"))
+
+	keys, err := s.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"records/big.dcm"}, keys, "Multipart scratch files should not be listed")
+}
+
+func TestLocalStorage_AbortMultipartDiscardsParts(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+	ctx := context.Background()
+
+	uploadID, err := s.CreateMultipart(ctx, "records/big.dcm", "")
+	require.NoError(t, err)
+	_, err = s.UploadPart(ctx, "records/big.dcm", uploadID, 1, strings.NewReader("aaa"), 3)
+	require.NoError(t, err)
+
+	require.NoError(t, s.AbortMultipart(ctx, "records/big.dcm", uploadID))
+
+	_, err = s.UploadPart(ctx, "records/big.dcm", uploadID, 2, strings.NewReader("bbb"), 3)
+	assert.Error(t, err)
+	_, err = s.Get(ctx, "records/big.dcm")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
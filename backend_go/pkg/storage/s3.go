@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"healthcare_backend/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type S3Storage struct {
+	bucket string
+}
+
+func NewS3Storage(bucket string) *S3Storage {
+	return &S3Storage{bucket: bucket}
+}
+
+func (s *S3Storage) client() (*s3.Client, error) {
+	if s.bucket == "" {
+		return nil, fmt.Errorf("S3_BUCKET_NAME environment variable is not set")
+	}
+	cfg, err := utils.GetAWSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("S3 not configured: %v", err)
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	return utils.UploadToS3WithReader(key, content, size, contentType)
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(filepath.ToSlash(key)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("error downloading %s from S3: %v", key, err)
+	}
+	return result.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return utils.DeleteFromS3(key)
+}
+
+func (s *S3Storage) Copy(ctx context.Context, sourceKey, destKey string) error {
+	return utils.CopyS3Object(filepath.ToSlash(sourceKey), filepath.ToSlash(destKey))
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(filepath.ToSlash(prefix)),
+	})
+
+	var keys []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing S3 objects: %w", err)
+		}
+		for _, item := range page.Contents {
+			keys = append(keys, aws.ToString(item.Key))
+		}
+	}
+	return keys, nil
+}
+
+func (s *S3Storage) CreateMultipart(ctx context.Context, key, contentType string) (string, error) {
+	client, err := s.client()
+	if err != nil {
+		return "", err
+	}
+
+	out, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(filepath.ToSlash(key)),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating S3 multipart upload: %v", err)
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+func (s *S3Storage) UploadPart(ctx context.Context, key, uploadID string, number int, content io.Reader, size int64) (string, error) {
+	client, err := s.client()
+	if err != nil {
+		return "", err
+	}
+
+	out, err := client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(filepath.ToSlash(key)),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(int32(number)),
+		Body:          content,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error uploading part %d to S3: %v", number, err)
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+func (s *S3Storage) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	sorted := append([]Part(nil), parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+
+	completed := make([]types.CompletedPart, 0, len(sorted))
+	for _, part := range sorted {
+		completed = append(completed, types.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int32(int32(part.Number)),
+		})
+	}
+
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(filepath.ToSlash(key)),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("error completing S3 multipart upload: %v", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(filepath.ToSlash(key)),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("error aborting S3 multipart upload: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"healthcare_backend/pkg/config"
+	"healthcare_backend/pkg/encryption"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Part identifies one uploaded piece of a multipart upload.
+type Part struct {
+	Number int
+	ETag   string
+}
+
+// Storage is the blob store behind medical records. Keys are slash-separated
+// paths such as "records/my-records/<userID>/scan.dcm".
+type Storage interface {
+	Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	Copy(ctx context.Context, sourceKey, destKey string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// Multipart uploads let large objects arrive in numbered parts over
+	// several requests. Parts may be re-sent; the last write for a number wins.
+	CreateMultipart(ctx context.Context, key, contentType string) (string, error)
+	UploadPart(ctx context.Context, key, uploadID string, number int, content io.Reader, size int64) (string, error)
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+}
+
+// New picks the backend from configuration. Without an explicit
+// STORAGE_BACKEND, S3 is used when a bucket and region are configured and
+// local disk otherwise. Objects are encrypted when RECORD_ENCRYPTION_KEY is
+// set. New is called once at startup and the Storage shared, so every
+// service uses the same Keyring.
+func New(cfg *config.Config, db *pgxpool.Pool) Storage {
+	backend := newBackend(cfg)
+
+	master, err := encryption.ParseMasterKeys(cfg.RecordEncryptionKey, cfg.RecordEncryptionPreviousKeys)
+	if err != nil {
+		log.Fatalf("Record encryption is misconfigured: %v", err)
+	}
+	if master == nil {
+		return backend
+	}
+	return NewEncryptedStorage(backend, encryption.NewKeyring(db, master))
+}
+
+func newBackend(cfg *config.Config) Storage {
+	switch cfg.StorageBackend {
+	case "s3":
+		return NewS3Storage(cfg.S3BucketName)
+	case "local":
+		return NewLocalStorage(cfg.LocalStorageDir)
+	}
+
+	if cfg.S3BucketName != "" && cfg.AWSRegion != "" {
+		return NewS3Storage(cfg.S3BucketName)
+	}
+	return NewLocalStorage(cfg.LocalStorageDir)
+}
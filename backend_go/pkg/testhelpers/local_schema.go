@@ -0,0 +1,54 @@
+package testhelpers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"healthcare_backend/pkg/database"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// SetupMigratedTestDatabase connects to the local test database and builds a
+// schema of its own with the application's migrations, so tests run against
+// the tables production creates rather than a copy of them. Cleanup drops
+// the schema.
+func SetupMigratedTestDatabase(ctx context.Context) (*LocalTestDatabase, error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to name test schema: %w", err)
+	}
+	schema := "tbibi_migrated_" + hex.EncodeToString(suffix)
+
+	config, err := pgxpool.ParseConfig(localTestConnStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse local database config: %w", err)
+	}
+	if config.ConnConfig.RuntimeParams == nil {
+		config.ConnConfig.RuntimeParams = map[string]string{}
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx, "SET search_path TO "+schema+",public")
+		return err
+	}
+
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to local database: %w", err)
+	}
+	if _, err := pool.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to create test schema: %w", err)
+	}
+
+	testDB := &LocalTestDatabase{Pool: pool, ConnStr: localTestConnStr, schema: schema}
+	if err := database.CreateTables(pool); err != nil {
+		testDB.Cleanup(ctx)
+		return nil, fmt.Errorf("failed to migrate test schema: %w", err)
+	}
+	return testDB, nil
+}
@@ -0,0 +1,39 @@
+// Package textsearch holds the pieces of full-text search shared by the
+// record and message search: the multi-language query, the ts_headline
+// options and the formatting of the highlighted snippet it returns.
+package textsearch
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+const (
+	// SnippetStart and SnippetStop mark matches in a headline. They are
+	// plain text so the headline can be escaped before they become tags.
+	SnippetStart = "[[mark]]"
+	SnippetStop  = "[[/mark]]"
+
+	// HeadlineOptions are the ts_headline options snippets are built with.
+	HeadlineOptions = "StartSel=" + SnippetStart + ", StopSel=" + SnippetStop +
+		", MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=\" ... \""
+)
+
+// Query parses the query text in param with every language and ORs the
+// results, so a match is found whichever language the caller typed in.
+func Query(languages []string, param string) string {
+	tsqueries := make([]string, len(languages))
+	for i, language := range languages {
+		tsqueries[i] = fmt.Sprintf("websearch_to_tsquery('%s', %s)", language, param)
+	}
+	return strings.Join(tsqueries, " || ")
+}
+
+// FormatSnippet escapes a headline for HTML and turns the match markers
+// into <mark> tags, so the searched text can never inject markup.
+func FormatSnippet(headline string) string {
+	escaped := html.EscapeString(strings.Join(strings.Fields(headline), " "))
+	escaped = strings.ReplaceAll(escaped, SnippetStart, "<mark>")
+	return strings.ReplaceAll(escaped, SnippetStop, "</mark>")
+}
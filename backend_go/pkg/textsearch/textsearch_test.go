@@ -0,0 +1,17 @@
+package textsearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuery(t *testing.T) {
+	assert.Equal(t, "websearch_to_tsquery('english', $1) || websearch_to_tsquery('simple', $1)",
+		Query([]string{"english", "simple"}, "$1"))
+}
+
+func TestFormatSnippet_EscapesText(t *testing.T) {
+	headline := "result <script>x</script> for " + SnippetStart + "glucose" + SnippetStop + "\n  level"
+	assert.Equal(t, "result &lt;script&gt;x&lt;/script&gt; for <mark>glucose</mark> level", FormatSnippet(headline))
+}
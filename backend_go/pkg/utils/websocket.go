@@ -2,20 +2,40 @@ package utils
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/gorilla/websocket"
 )
 
-var Upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// NewUpgrader returns a WebSocket upgrader that only accepts browser
+// connections from the given origins or from the API's own host.
+func NewUpgrader(allowedOrigins []string) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return OriginAllowed(r, allowedOrigins)
+		},
+	}
 }
 
-type WSClient struct {
-	UserID string
-	Conn   *websocket.Conn
-	Send   chan []byte
+// OriginAllowed reports whether the request's Origin header is one of the
+// allowed origins. Requests without an Origin header do not come from a
+// browser page and are let through; they still need a valid token.
+func OriginAllowed(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(origin, strings.TrimSuffix(allowed, "/")) {
+			return true
+		}
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, r.Host)
 }
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"http://localhost:3000", "https://app.example.com/"}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://localhost:3000", true},
+		{"https://APP.example.com", true},
+		{"http://api.example.com", true},
+		{"http://localhost:3001", false},
+		{"https://evil.example.com", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://api.example.com/api/v1/chats/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		assert.Equal(t, tt.want, OriginAllowed(r, allowed), tt.origin)
+	}
+}
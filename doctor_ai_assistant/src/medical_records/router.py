@@ -1,7 +1,11 @@
 from fastapi import APIRouter, HTTPException
 
 from src.medical_records.service import s3_medical_records_service
-from src.medical_records.schemas import MedicalRecordIngestionResponse, MedicalRecordIngestionRequest
+from src.medical_records.schemas import (
+    MedicalRecordIngestionResponse,
+    MedicalRecordIngestionRequest,
+    MedicalRecordRemovalResponse,
+)
 
 from src.shared.logs import logger
 
@@ -30,7 +34,8 @@ async def ingest_s3_medical_records(
         result = await s3_medical_records_service.ingest_patient_records(
             patient_id=request.patient_id,
             doctor_id=request.doctor_id,
-            s3_keys=request.s3_keys
+            s3_keys=request.s3_keys,
+            download_urls=request.download_urls
         )
         
         return result
@@ -39,4 +44,39 @@ async def ingest_s3_medical_records(
         raise
     except Exception as e:
         logger.exception(f"Error in S3 medical records ingestion: {e}")
-        raise HTTPException(status_code=500, detail=f"Ingestion failed: {str(e)}")
\ No newline at end of file
+        raise HTTPException(status_code=500, detail=f"Ingestion failed: {str(e)}")
+
+
+@router.post("/remove-from-qdrant", response_model=MedicalRecordRemovalResponse)
+async def remove_s3_medical_records(
+    request: MedicalRecordIngestionRequest,
+) -> MedicalRecordRemovalResponse:
+    """
+    Remove ingested medical records of a specific patient from Qdrant.
+    
+    Args:
+        request: Request containing patient_id, doctor_id, and the S3 keys to remove
+        
+    Returns:
+        MedicalRecordRemovalResponse: The records removed
+        
+    Raises:
+        HTTPException: 400 for invalid input, 500 for server errors
+    """
+    try:
+        if not request.patient_id:
+            raise HTTPException(status_code=400, detail="No patient ID provided")
+        
+        result = await s3_medical_records_service.remove_patient_records(
+            patient_id=request.patient_id,
+            doctor_id=request.doctor_id,
+            s3_keys=request.s3_keys
+        )
+        
+        return result
+        
+    except HTTPException:
+        raise
+    except Exception as e:
+        logger.exception(f"Error in medical records removal: {e}")
+        raise HTTPException(status_code=500, detail=f"Removal failed: {str(e)}")
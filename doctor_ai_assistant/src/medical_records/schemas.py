@@ -27,4 +27,15 @@ class MedicalRecordIngestionResponse(BaseModel):
 class MedicalRecordIngestionRequest(BaseModel):
     patient_id: str
     doctor_id: str
-    s3_keys: List[str]
\ No newline at end of file
+    s3_keys: List[str]
+    # Links to download documents from instead of S3, keyed by S3 key. The
+    # backend sends them for records it stores encrypted.
+    download_urls: Optional[Dict[str, str]] = None
+
+
+class MedicalRecordRemovalResponse(BaseModel):
+    patient_id: str
+    doctor_id: str
+    total_files: int
+    removed_files: List[str]
+    timestamp: str
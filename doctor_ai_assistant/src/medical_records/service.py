@@ -1,5 +1,6 @@
 import asyncio
 import boto3
+import urllib.request
 import uuid
 import hashlib
 import tiktoken
@@ -143,6 +144,18 @@ class S3MedicalRecordsService:
             logger.exception(f"Failed to download S3 file {s3_key}: {e}")
             return None
 
+    async def download_linked_file(self, url: str) -> Optional[bytes]:
+        """Download file content from a backend download link"""
+        def fetch() -> bytes:
+            with urllib.request.urlopen(url, timeout=60) as response:
+                return response.read()
+        
+        try:
+            return await asyncio.to_thread(fetch)
+        except Exception as e:
+            logger.exception(f"Failed to download linked file: {e}")
+            return None
+
     def classify_medical_document(self, filename: str, content: str) -> DocumentType:
         """Classify medical document type based on filename and content"""
         filename_lower = filename.lower()
@@ -304,13 +317,21 @@ class S3MedicalRecordsService:
         s3_key: str,
         patient_id: str,
         doctor_id: str,
-        bucket_name: str = None
+        bucket_name: str = None,
+        download_url: str = None
     ) -> Dict[str, Any]:
-        """Ingest a single file from S3 with comprehensive metadata extraction."""
+        """Ingest a single file from S3 with comprehensive metadata extraction.
+        
+        Files with a download URL are read from it instead: the backend
+        stores them encrypted, so the S3 object is not readable here.
+        """
         try:
-            content_bytes = await self.download_s3_file(s3_key, bucket_name)
+            if download_url:
+                content_bytes = await self.download_linked_file(download_url)
+            else:
+                content_bytes = await self.download_s3_file(s3_key, bucket_name)
             if not content_bytes:
-                raise ValueError(f"Failed to download file from S3: {s3_key}")
+                raise ValueError(f"Failed to download file: {s3_key}")
             
             filename = Path(s3_key).name
             text_content = self.extract_text_from_content(content_bytes, filename)
@@ -408,6 +429,7 @@ class S3MedicalRecordsService:
         patient_id: str,
         doctor_id: str,
         s3_keys: List[str],
+        download_urls: Optional[Dict[str, str]] = None,
     ) -> Dict[str, Any]:
         """
         Ingest medical records for a specific patient from S3.
@@ -416,6 +438,7 @@ class S3MedicalRecordsService:
             patient_id: Patient ID
             doctor_id: Doctor ID who is uploading the records
             s3_keys: List of S3 keys for medical record files
+            download_urls: Optional links to download files from, keyed by S3 key
             
         Returns:
             Dictionary with comprehensive ingestion results
@@ -425,7 +448,10 @@ class S3MedicalRecordsService:
             
             async def process_file(s3_key: str):
                 async with semaphore:
-                    return await self._ingest_single_file(s3_key, patient_id, doctor_id, settings.s3_bucket_name)
+                    return await self._ingest_single_file(
+                        s3_key, patient_id, doctor_id, settings.s3_bucket_name,
+                        download_url=(download_urls or {}).get(s3_key)
+                    )
             
             results = await asyncio.gather(
                 *[process_file(s3_key) for s3_key in s3_keys],
@@ -483,6 +509,63 @@ class S3MedicalRecordsService:
             logger.exception(f"Error ingesting records for patient {patient_id}: {e}")
             raise
 
+    async def remove_patient_records(
+        self,
+        patient_id: str,
+        doctor_id: str,
+        s3_keys: List[str],
+    ) -> Dict[str, Any]:
+        """
+        Remove the chunks of medical records from a patient's collection.
+        
+        Records that were never ingested are ignored, so removal can be
+        retried safely.
+        
+        Args:
+            patient_id: Patient ID
+            doctor_id: Doctor ID who requested the removal
+            s3_keys: List of S3 keys for medical record files
+            
+        Returns:
+            Dictionary with the removal results
+        """
+        try:
+            collection_name = f"user_{patient_id}_records"
+            removed_files = []
+            
+            if s3_keys and qdrant_manager.collection_exists(collection_name):
+                operation_info = qdrant_manager.client.delete(
+                    collection_name=collection_name,
+                    points_selector=models.FilterSelector(
+                        filter=models.Filter(
+                            must=[
+                                models.FieldCondition(
+                                    key="chunk_metadata.file_s3_path",
+                                    match=models.MatchAny(any=s3_keys)
+                                )
+                            ]
+                        )
+                    ),
+                    wait=True
+                )
+                if operation_info.status != models.UpdateStatus.COMPLETED:
+                    raise RuntimeError(f"Qdrant removal did not complete: {operation_info}")
+                removed_files = list(s3_keys)
+            
+            logger.info(f"Removed {len(removed_files)} records from {collection_name}")
+            
+            return {
+                "patient_id": patient_id,
+                "doctor_id": doctor_id,
+                "total_files": len(s3_keys),
+                "removed_files": removed_files,
+                "timestamp": datetime.now().isoformat()
+            }
+            
+        except Exception as e:
+            logger.exception(f"Error removing records for patient {patient_id}: {e}")
+            raise
+
     async def search_patient_records(
         self,
         patient_id: str,
@@ -201,7 +201,7 @@ const ChatComponent = ({chatId, toggleSidebar, isSidebarVisible }) => {
     }
   }, [actualCurrentChat?.id]);
 
-  const sendMessage = async (content, key) => {
+  const sendMessage = async (content, attachment) => {
     if (!actualCurrentChat) {
       console.error('[Client] No chat selected.');
       return;
@@ -213,7 +213,8 @@ const ChatComponent = ({chatId, toggleSidebar, isSidebarVisible }) => {
       recipientId: actualCurrentChat.recipientUserId,
       content: content,
       createdAt: new Date().toISOString(),
-      key: key,
+      attachmentId: attachment?.id,
+      attachment: attachment || undefined,
     };
 
     dispatch({ type: 'ADD_MESSAGE', payload: message });
@@ -221,7 +222,7 @@ const ChatComponent = ({chatId, toggleSidebar, isSidebarVisible }) => {
       type: 'UPDATE_LAST_MESSAGE',
       payload: {
         chatId: actualCurrentChat.id,
-        latestMessageContent: content,
+        latestMessageContent: content || attachment?.fileName,
         latestMessageTime: new Date().toISOString(),
       },
     });
@@ -242,21 +242,14 @@ const InputComponent = ({ sendMessage }) => {
                 return;
             }
             
-            const formData = new FormData();
-            formData.append('file', attachedFile);
-            formData.append('userId', userId);
-            formData.append('chatId', currentChat.id);
-            
             try {
-                const response = await chatService.uploadImage(formData);
-                const { presigned_url, s3_key, key } = response || {};
-                const resolvedKey = s3_key ?? key ?? null;
-                if (presigned_url) {
-                    sendMessage(presigned_url, resolvedKey);
+                const attachment = await chatService.uploadAttachment(currentChat.id, attachedFile);
+                if (attachment?.id) {
+                    sendMessage(inputValue.trim(), attachment);
                     setImagePreviewUrl(null);
                     setAttachedFile(null);
                 } else {
-                    console.error('Invalid response from upload:', response);
+                    console.error('Invalid response from upload:', attachment);
                     alert(t('errors.uploadFailed'));
                 }
             } catch (error) {
@@ -277,11 +270,15 @@ const InputComponent = ({ sendMessage }) => {
         if (file) {
             setAttachedFile(file);
 
-            const reader = new FileReader();
-            reader.onloadend = () => {
-                setImagePreviewUrl(reader.result);
-            };
-            reader.readAsDataURL(file);
+            if (file.type.startsWith('image/')) {
+                const reader = new FileReader();
+                reader.onloadend = () => {
+                    setImagePreviewUrl(reader.result);
+                };
+                reader.readAsDataURL(file);
+            } else {
+                setImagePreviewUrl(null);
+            }
         }
     };
 
@@ -297,7 +294,7 @@ const InputComponent = ({ sendMessage }) => {
                     type="file"
                     id="fileInput"
                     style={{ display: 'none' }}
-                    accept="image/*"
+                    accept="image/*,application/pdf,text/plain"
                     onChange={handleFileChange}
                 />
                 <AttachButton htmlFor="fileInput">
@@ -318,6 +315,12 @@ const InputComponent = ({ sendMessage }) => {
                         <RemovePreviewButton onClick={removePreview}>{t('input.removePreview')}</RemovePreviewButton>
                     </ImagePreviewWrapper>
                 )}
+                {attachedFile && !imagePreviewUrl && (
+                    <ImagePreviewWrapper>
+                        <span>{attachedFile.name}</span>
+                        <RemovePreviewButton onClick={removePreview}>{t('input.removePreview')}</RemovePreviewButton>
+                    </ImagePreviewWrapper>
+                )}
                 <UserTextarea
                     placeholder={t('input.placeholder')}
                     value={inputValue}
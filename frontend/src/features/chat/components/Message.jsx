@@ -1,7 +1,8 @@
-import React from 'react'
+import React, { useEffect, useState } from 'react'
 import { useTranslation } from 'react-i18next';
 import styled from 'styled-components'
 import { formatChatTimestamp } from '../utils/chatI18n';
+import chatService from '../services/chatService';
 
 const Message = styled.div`
     display: flex;
@@ -175,6 +176,61 @@ const MessageImage = styled.img`
     }
 `;
 
+const AttachmentLink = styled.button`
+    background: none;
+    border: none;
+    padding: 0;
+    color: inherit;
+    text-decoration: underline;
+    cursor: pointer;
+`;
+
+// Attachments are private: their content is fetched through a short-lived
+// link rather than embedded by URL.
+const AttachmentContent = ({ attachment }) => {
+    const { t } = useTranslation('chat');
+    const [imageUrl, setImageUrl] = useState(null);
+    const isImage = attachment.contentType?.startsWith('image/');
+
+    useEffect(() => {
+        if (!isImage) {
+            return undefined;
+        }
+        let objectUrl = null;
+        let cancelled = false;
+        chatService.fetchAttachment(attachment.chatId, attachment.id)
+            .then((blob) => {
+                if (!cancelled) {
+                    objectUrl = URL.createObjectURL(blob);
+                    setImageUrl(objectUrl);
+                }
+            })
+            .catch(() => {});
+        return () => {
+            cancelled = true;
+            if (objectUrl) {
+                URL.revokeObjectURL(objectUrl);
+            }
+        };
+    }, [attachment.chatId, attachment.id, isImage]);
+
+    const openAttachment = async () => {
+        try {
+            const blob = await chatService.fetchAttachment(attachment.chatId, attachment.id);
+            const objectUrl = URL.createObjectURL(blob);
+            window.open(objectUrl, '_blank', 'noopener');
+            setTimeout(() => URL.revokeObjectURL(objectUrl), 60000);
+        } catch (error) {
+            alert(t('errors.attachmentUnavailable'));
+        }
+    };
+
+    if (isImage) {
+        return imageUrl ? <MessageImage src={imageUrl} alt={attachment.fileName || t('ui.uploadedImage')} onClick={openAttachment} /> : null;
+    }
+    return <AttachmentLink type="button" onClick={openAttachment}>{attachment.fileName}</AttachmentLink>;
+};
+
 const MessageComponent = React.memo(({ message, isOwner, senderImage, recipientImage }) => {
     const { t, i18n } = useTranslation('chat');
 
@@ -197,6 +253,7 @@ const MessageComponent = React.memo(({ message, isOwner, senderImage, recipientI
                 </MessageInfo>
             )}
             <MessageContent className={isOwner ? 'owner' : 'not-owner'}>
+                {message.attachment && <AttachmentContent attachment={{ ...message.attachment, chatId: message.chatId }} />}
                 {renderMessageContent(message.content)}
                 <MessageTime>{formatChatTimestamp(message.createdAt, { t, language: i18n.language })}</MessageTime>
             </MessageContent>
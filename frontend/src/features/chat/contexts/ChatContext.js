@@ -6,6 +6,29 @@ const initialState = {
   chats: [],
   currentChat: null,
   messages: [],
+  // typing maps a chat ID to the IDs of the users typing in it.
+  typing: {},
+  // presence maps a user ID to their status and when they were last seen.
+  presence: {},
+};
+
+// Receipts only move a message forward: sent, then delivered, then read.
+const messageStatusRank = { sent: 0, delivered: 1, read: 2 };
+
+// updateReaction adds or removes userId's emoji on a message's reactions,
+// which group the users who reacted by emoji.
+const updateReaction = (reactions = [], emoji, userId, added) => {
+  const existing = reactions.find((reaction) => reaction.emoji === emoji);
+  if (!existing) {
+    return added ? [...reactions, { emoji, count: 1, userIds: [userId] }] : reactions;
+  }
+  const userIds = existing.userIds.filter((id) => id !== userId);
+  if (added) {
+    userIds.push(userId);
+  }
+  return reactions
+    .map((reaction) => (reaction.emoji === emoji ? { ...reaction, count: userIds.length, userIds } : reaction))
+    .filter((reaction) => reaction.count > 0);
 };
 
 const sortChatsByLatestMessage = (chats) => {
@@ -61,6 +84,82 @@ function reducer(state, action) {
           ...state, 
           messages: Array.isArray(state.messages) ? [...state.messages, action.payload] : [action.payload]
       };
+    case 'UPDATE_MESSAGE_STATUS': {
+      // A receipt covers every message up to upTo that the reader did not send.
+      const { chatId, userId, status, upTo } = action.payload;
+      const receiptTime = new Date(upTo);
+      return {
+        ...state,
+        messages: state.messages.map((message) =>
+          message.chatId === chatId &&
+          message.senderId !== userId &&
+          new Date(message.createdAt) <= receiptTime &&
+          (messageStatusRank[message.status] ?? 0) < messageStatusRank[status]
+            ? { ...message, status }
+            : message
+        ),
+      };
+    }
+    case 'EDIT_MESSAGE':
+      return {
+        ...state,
+        messages: state.messages.map((message) =>
+          message.id === action.payload.id
+            ? { ...message, content: action.payload.content, editedAt: action.payload.editedAt }
+            : message
+        ),
+      };
+    case 'DELETE_MESSAGE':
+      // Deleted for everyone leaves a tombstone; deleted for me disappears.
+      if (action.payload.scope === 'me') {
+        return { ...state, messages: state.messages.filter((message) => message.id !== action.payload.id) };
+      }
+      return {
+        ...state,
+        messages: state.messages.map((message) =>
+          message.id === action.payload.id
+            ? {
+                ...message,
+                content: null,
+                key: null,
+                attachment: undefined,
+                reactions: [],
+                deletedAt: action.payload.deletedAt,
+              }
+            : message
+        ),
+      };
+    case 'UPDATE_REACTION':
+      return {
+        ...state,
+        messages: state.messages.map((message) =>
+          message.id === action.payload.messageId
+            ? {
+                ...message,
+                reactions: updateReaction(message.reactions, action.payload.emoji, action.payload.userId, action.payload.added),
+              }
+            : message
+        ),
+      };
+    case 'SET_TYPING': {
+      const { chatId, userId, isTyping } = action.payload;
+      const typists = (state.typing[chatId] || []).filter((id) => id !== userId);
+      return {
+        ...state,
+        typing: { ...state.typing, [chatId]: isTyping ? [...typists, userId] : typists },
+      };
+    }
+    case 'SET_PRESENCE':
+      return {
+        ...state,
+        presence: {
+          ...state.presence,
+          [action.payload.userId]: {
+            status: action.payload.status,
+            lastSeenAt: action.payload.lastSeenAt,
+          },
+        },
+      };
     case 'UPDATE_LAST_MESSAGE':
       const updatedChatsWithMessage = state.chats.map((chat) =>
           chat.id === action.payload.chatId
@@ -5,6 +5,10 @@ import { AuthContext } from './../../../features/auth/context/AuthContext';
 
 export const WebSocketContext = createContext(null);
 
+// A typist counts as stopped when no typing_start arrives for this long, in
+// case their typing_stop is lost.
+const TYPING_TIMEOUT_MS = 6000;
+
 export const WebSocketProvider = ({children}) => {
     const [websocket, setWebsocket] = useState(null);
     const { dispatch } = useContext(ChatContext);
@@ -17,7 +21,18 @@ export const WebSocketProvider = ({children}) => {
             return;
         }
 
-        const ws = new WebSocket(`ws://localhost:3001/ws?userId=${userId}`);
+        const ws = new WebSocket('ws://localhost:3001/api/v1/chats/ws');
+        const typingTimers = {};
+
+        const setTyping = (chatId, userId, isTyping) => {
+            const timerKey = `${chatId}:${userId}`;
+            clearTimeout(typingTimers[timerKey]);
+            delete typingTimers[timerKey];
+            if (isTyping) {
+                typingTimers[timerKey] = setTimeout(() => setTyping(chatId, userId, false), TYPING_TIMEOUT_MS);
+            }
+            dispatch({ type: 'SET_TYPING', payload: { chatId, userId, isTyping } });
+        };
 
         ws.onopen = () => {
             console.log("[Client] Connected to WebSocket");
@@ -25,17 +40,86 @@ export const WebSocketProvider = ({children}) => {
 
         ws.onmessage = (message) => {
             const msgData = JSON.parse(message.data);
-            if (msgData.type === 'new_message') {
-                dispatch({
-                    type: 'UPDATE_LAST_MESSAGE',
-                    payload: {
-                        chatId: msgData.chatId,
-                        latestMessageContent: msgData.content,
-                        latestMessageTime: msgData.createdAt,
-                    },
-                });
+            switch (msgData.type) {
+                case 'new_message':
+                    dispatch({
+                        type: 'UPDATE_LAST_MESSAGE',
+                        payload: {
+                            chatId: msgData.chat_id,
+                            latestMessageContent: msgData.content,
+                            latestMessageTime: msgData.created_at,
+                        },
+                    });
+                    dispatch({
+                        type: 'ADD_MESSAGE',
+                        payload: {
+                            id: msgData.id,
+                            chatId: msgData.chat_id,
+                            senderId: msgData.sender_id,
+                            content: msgData.content,
+                            createdAt: msgData.created_at,
+                            systemEvent: msgData.system_event,
+                            attachment: msgData.attachment,
+                        },
+                    });
+                    setTyping(msgData.chat_id, msgData.sender_id, false);
+                    break;
+                case 'typing_start':
+                case 'typing_stop':
+                    setTyping(msgData.chat_id, msgData.user_id, msgData.type === 'typing_start');
+                    break;
+                case 'presence':
+                    dispatch({
+                        type: 'SET_PRESENCE',
+                        payload: {
+                            userId: msgData.user_id,
+                            status: msgData.status,
+                            lastSeenAt: msgData.last_seen_at,
+                        },
+                    });
+                    break;
+                case 'chat_read':
+                case 'chat_delivered':
+                    dispatch({
+                        type: 'UPDATE_MESSAGE_STATUS',
+                        payload: {
+                            chatId: msgData.chat_id,
+                            userId: msgData.user_id,
+                            status: msgData.type === 'chat_read' ? 'read' : 'delivered',
+                            upTo: msgData.type === 'chat_read' ? msgData.read_at : msgData.delivered_at,
+                        },
+                    });
+                    break;
+                case 'message_edited':
+                    dispatch({
+                        type: 'EDIT_MESSAGE',
+                        payload: { id: msgData.id, content: msgData.content, editedAt: msgData.edited_at },
+                    });
+                    break;
+                case 'message_deleted':
+                    dispatch({
+                        type: 'DELETE_MESSAGE',
+                        payload: { id: msgData.id, scope: msgData.scope, deletedAt: msgData.deleted_at },
+                    });
+                    break;
+                case 'reaction_added':
+                case 'reaction_removed':
+                    dispatch({
+                        type: 'UPDATE_REACTION',
+                        payload: {
+                            messageId: msgData.message_id,
+                            userId: msgData.user_id,
+                            emoji: msgData.emoji,
+                            added: msgData.type === 'reaction_added',
+                        },
+                    });
+                    break;
+                case 'error':
+                    console.error(`[Client] WebSocket server error: ${msgData.code}`, msgData.message);
+                    break;
+                default:
+                    break;
             }
-            dispatch({ type: 'ADD_MESSAGE', payload: msgData });
         };
 
         ws.onclose = (event) => {  
@@ -50,6 +134,7 @@ export const WebSocketProvider = ({children}) => {
 
         return () => {
             console.log("[Client] Closing WebSocket connection"); 
+            Object.values(typingTimers).forEach(clearTimeout);
             ws.close();
         };
     }, [userId, dispatch]);
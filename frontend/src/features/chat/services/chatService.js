@@ -6,7 +6,7 @@ class ChatService {
       const response = await axios.get('/api/v1/chats', {
         params: { userID: userId }
       });
-      return response.data;
+      return response.data.chats;
     } catch (error) {
       console.error('Failed to fetch chats:', error);
       throw error;
@@ -47,12 +47,27 @@ class ChatService {
     }
   }
 
-  async uploadImage(formData) {
+  async uploadAttachment(chatId, file) {
+    try {
+      const formData = new FormData();
+      formData.append('file', file);
+      const response = await axios.post(`/api/v1/chats/${chatId}/attachments`, formData);
+      return response.data;
+    } catch (error) {
+      console.error('Error uploading attachment:', error);
+      throw error;
+    }
+  }
+
+  // Attachment links expire after a few minutes, so a fresh one is asked for
+  // every time an attachment is opened.
+  async fetchAttachment(chatId, attachmentId) {
     try {
-      const response = await axios.post('/api/v1/chats/upload-image', formData);
+      const link = await axios.get(`/api/v1/chats/${chatId}/attachments/${attachmentId}/link`);
+      const response = await axios.get(link.data.url, { responseType: 'blob' });
       return response.data;
     } catch (error) {
-      console.error('Error uploading image:', error);
+      console.error('Error fetching attachment:', error);
       throw error;
     }
   }
@@ -73,6 +88,30 @@ class ChatService {
     }
   }
 
+  async searchMessages(query, filters = {}) {
+    try {
+      const response = await axios.get('/api/v1/chats/messages/search', {
+        params: { q: query, ...filters }
+      });
+      return response.data.results || [];
+    } catch (error) {
+      console.error('Message search failed:', error);
+      throw error;
+    }
+  }
+
+  async fetchMessagesAround(chatId, messageId, limit) {
+    try {
+      const response = await axios.get(`/api/v1/chats/${chatId}/messages`, {
+        params: { around: messageId, limit }
+      });
+      return response.data.messages;
+    } catch (error) {
+      console.error('Error fetching message context:', error);
+      throw error;
+    }
+  }
+
 
   async findOrCreateChat(currentUserId, selectedUserId, currentUserType, selectedUserType) {
     try {
@@ -9,7 +9,7 @@ class WebSocketService {
     this.connectionHandlers = new Set();
   }
 
-  connect(url = 'ws://localhost:3001/ws') {
+  connect(url = 'ws://localhost:3001/api/v1/chats/ws') {
     try {
       this.ws = new WebSocket(url);
       this.setupEventListeners();